@@ -2,28 +2,54 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"github.com/amargherio/mechanic/internal/admin"
 	"github.com/amargherio/mechanic/internal/appstate"
 	"github.com/amargherio/mechanic/internal/config"
+	"github.com/amargherio/mechanic/internal/events"
+	"github.com/amargherio/mechanic/internal/health"
 	"github.com/amargherio/mechanic/internal/logging"
+	"github.com/amargherio/mechanic/internal/shutdown"
 	"github.com/amargherio/mechanic/internal/tracing"
+	"github.com/amargherio/mechanic/internal/workers"
 	"github.com/amargherio/mechanic/pkg/imds"
+	"github.com/amargherio/mechanic/pkg/leader"
+	"github.com/amargherio/mechanic/pkg/metrics"
 	n "github.com/amargherio/mechanic/pkg/node"
+	"github.com/amargherio/mechanic/pkg/pause"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.opentelemetry.io/otel"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/kubectl/pkg/scheme"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// set by goreleaser at build time
+var (
+	version = "dev"
+	commit  = "none"
 )
 
 func main() {
+	flag.StringVar(&config.NodeNameFlag, "node-name", "", "name of the node mechanic runs on, taking precedence over MECHANIC_NODE_NAME")
+	flag.Parse()
+
 	var logger *zap.Logger
 	var ctx context.Context
 
@@ -34,9 +60,12 @@ func main() {
 		ShouldDrain:       false,
 	}
 
-	// tracing bootstrapping
-	tp, _ := tracing.InitTracer()
-	// todo: should we defer TracerProvider shutdown here?
+	// the tracing flag is read ahead of the rest of the configuration so the trace core below doesn't look for spans
+	// when tracing is disabled. otherwise the global tracer delegates to the provider installed by tracing.InitTracer
+	// once configuration has been read.
+	if !config.TracingEnabled() {
+		tracing.InitTracer(false, config.TracingConfig{})
+	}
 	tracer := otel.Tracer("github.com/amargherio/mechanic")
 
 	// initial log bootstrapping
@@ -50,7 +79,7 @@ func main() {
 		zapcore.NewJSONEncoder(enc),
 		os.Stdout,
 		defaultLevel)
-	traceCore := logging.NewTraceCore(baseCore, &ctx, tp)
+	traceCore := logging.NewTraceCore(baseCore, &ctx, otel.GetTracerProvider())
 	logger = zap.New(traceCore)
 	defer logger.Sync()
 	log := logger.Sugar()
@@ -70,9 +99,70 @@ func main() {
 	}
 
 	// adjust the log level based on the config value
-	if cfg.RuntimeEnv != "prod" {
-		defaultLevel.SetLevel(zap.DebugLevel)
+	level, err := logging.ResolveLevel(cfg.LogLevel, cfg.RuntimeEnv)
+	if err != nil {
+		log.Errorw("Invalid log level", "level", cfg.LogLevel, "error", err)
+		return
+	}
+	defaultLevel.SetLevel(level)
+
+	if err := imds.ConfigureSeverities(cfg.DrainConditions); err != nil {
+		log.Errorw("Invalid scheduled event severity configuration", "error", err)
+		return
+	}
+
+	// the operating mode is fixed at startup and labels every metric and, once the loggers are final, every log line
+	mode := cfg.OperatingMode()
+	if err := metrics.Register(prometheus.DefaultRegisterer, mode); err != nil {
+		log.Errorw("Failed to register metrics", "error", err)
+		return
+	}
+
+	// subsystems register with the shutdown manager as they start and are stopped in reverse order on SIGTERM
+	shutdowns := shutdown.NewManager(cfg.ShutdownTimeout)
+	signalCtx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+
+	// tracing bootstrapping
+	tp, err := tracing.InitTracer(cfg.EnableTracing, cfg.Tracing)
+	if err != nil {
+		log.Errorw("Failed to initialize tracing", "exporter", cfg.Tracing.Exporter, "error", err)
+		return
+	}
+	// the noop provider used when tracing is disabled has nothing to flush
+	if sdkProvider, ok := tp.(interface{ Shutdown(context.Context) error }); ok {
+		shutdowns.Register("tracer provider", sdkProvider.Shutdown)
+	}
+	log.Debugw("Initialized tracing", "enabled", cfg.EnableTracing, "exporter", cfg.Tracing.Exporter)
+
+	// rebuild the logger in the configured format. if a log file is configured, write to it alongside stdout. the trace
+	// core wraps both so trace info lands in each.
+	core, err := logging.NewCore(cfg.LogFormat, enc, os.Stdout, defaultLevel)
+	if err != nil {
+		log.Errorw("Invalid log format", "format", cfg.LogFormat, "error", err)
+		return
 	}
+	if cfg.LogFilePath != "" {
+		fileCore, err := logging.NewFileCore(cfg.LogFormat, enc, cfg.LogFilePath, cfg.LogMaxSizeMB, defaultLevel)
+		if err != nil {
+			log.Errorw("Invalid log format", "format", cfg.LogFormat, "error", err)
+			return
+		}
+		core = zapcore.NewTee(core, fileCore)
+	}
+	traceCore = logging.NewTraceCore(core, &ctx, tp)
+	logger = zap.New(traceCore)
+	defer logger.Sync()
+	log = logger.Sugar()
+	vals.Logger = log
+	log.Debugw("Configured logging", "format", cfg.LogFormat, "level", defaultLevel.Level())
+	if cfg.LogFilePath != "" {
+		log.Infow("Writing logs to file in addition to stdout", "path", cfg.LogFilePath, "maxSizeMB", cfg.LogMaxSizeMB)
+	}
+
+	log = log.With("mode", mode)
+	vals.Logger = log
+	log.Infow("Operating mode determined", "pollingInterval", cfg.PollingInterval, "alsoPollIMDS", cfg.AlsoPollIMDS)
 
 	// get our kubernetes client and start an informer on our node
 	log.Info("Building the Kubernetes clientset")
@@ -84,12 +174,31 @@ func main() {
 	// set up our event recorder and add it to the context values.
 	broadcaster := record.NewBroadcaster()
 	broadcaster.StartLogging(log.Infof)
-	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: clientset.CoreV1().Events("")})
-	recorder := broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: "mechanic"})
+	// the sink is wrapped so rejected event writes show up in our logs and metrics
+	broadcaster.StartRecordingToSink(events.NewMonitoredSink(&typedcorev1.EventSinkImpl{Interface: clientset.CoreV1().Events("")}, log))
+	// events below the configured level are dropped before they reach the broadcaster. logs still capture everything.
+	recorder, err := events.NewLevelFilteredRecorder(
+		broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: "mechanic"}),
+		cfg.MinEventLevel)
+	if err != nil {
+		log.Errorw("Invalid minimum event level", "level", cfg.MinEventLevel, "error", err)
+		broadcaster.Shutdown()
+		return
+	}
+	vals.Recorder = recorder
+	shutdowns.Register("event broadcaster", func(ctx context.Context) error {
+		broadcaster.Shutdown()
+		return nil
+	})
 
 	// create the IMDS client
 	log.Debugw("Getting the IMDS client object")
-	ic := imds.IMDSClient{}
+	ic := &imds.IMDSClient{
+		Timeout:              cfg.IMDSTimeout,
+		APIVersion:           cfg.IMDSAPIVersion,
+		NegotiateAPIVersion:  cfg.NegotiateIMDSAPIVersion,
+		MaxEventsPerResponse: cfg.MaxEventsPerResponse,
+	}
 
 	// sync app state with current node status
 	node, err := clientset.CoreV1().Nodes().Get(ctx, cfg.NodeName, metav1.GetOptions{})
@@ -98,120 +207,297 @@ func main() {
 		return
 	}
 
-	state.IsCordoned = node.Spec.Unschedulable
+	state.ObserveNode(node.UID)
+	state.SetCordoned(node.Spec.Unschedulable)
+	// a restart in the middle of maintenance picks up the drain state the previous run persisted
+	if n.RestoreState(ctx, clientset, ic, node, cfg, &state) {
+		log.Infow("Restored persisted state", "node", node.Name, "store", cfg.StateStore.Backend, "drained", state.Drained(), "shouldDrain", state.DrainRequired())
+	}
+
+	stop := make(chan struct{})
+	shutdowns.Register("informers", func(ctx context.Context) error {
+		close(stop)
+		return nil
+	})
+
+	// the pause ConfigMap lets operators stop all mechanic actions cluster-wide without touching our config file
+	var pauseWatcher *pause.Watcher
+	if cfg.Pause.Name != "" {
+		pauseWatcher = pause.NewWatcher(cfg.Pause)
+		if err := pauseWatcher.Start(ctx, clientset, stop); err != nil {
+			log.Errorw("Failed to start the pause ConfigMap watcher", "namespace", cfg.Pause.Namespace, "name", cfg.Pause.Name, "error", err)
+			return
+		}
+	}
+
+	// with leader election on, only the instance holding the node's Lease acts on the node. it's registered before the
+	// reconcile workers so the Lease is only released once they've stopped.
+	var elector *leader.Elector
+	if cfg.LeaderElection.Enabled {
+		identity, err := os.Hostname()
+		if err != nil {
+			log.Errorw("Failed to get the hostname to use as the leader election identity", "error", err)
+			return
+		}
+		elector = leader.NewElector(cfg.LeaderElection, cfg.NodeName, identity)
+		electionCtx, stopElection := context.WithCancel(ctx)
+		electionDone := make(chan struct{})
+		go func() {
+			defer close(electionDone)
+			if err := elector.Run(electionCtx, clientset); err != nil {
+				log.Errorw("Leader election stopped, mechanic won't act on the node", "node", cfg.NodeName, "error", err)
+			}
+		}()
+		shutdowns.Register("leader election", func(ctx context.Context) error {
+			stopElection()
+			select {
+			case <-electionDone:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}
 
 	log.Info("Building the informer factory for our node informer client.")
+	// the selector escapes the name, and the name was validated when the config was read
+	nodeSelector := fields.OneTermEqualSelector("metadata.name", cfg.NodeName).String()
 	factory := informers.NewSharedInformerFactoryWithOptions(
 		clientset,
 		0,
 		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
-			options.FieldSelector = fmt.Sprintf("metadata.name=%s", cfg.NodeName)
+			options.FieldSelector = nodeSelector
 		}),
 	)
+	log.Infow("Watching the node", "node", cfg.NodeName, "fieldSelector", nodeSelector)
 
 	ni := factory.Core().V1().Nodes().Informer()
-	ni.AddEventHandler(cache.ResourceEventHandlerDetailedFuncs{
-		UpdateFunc: func(old, new interface{}) {
-			ctx, span := tracer.Start(ctx, "nodeUpdateHandler")
-			defer span.End()
-			// lock the state object so we know we have it exclusively for this function
-			// if we can't get the lock, then we skip processing this node update because we're already processing another one
-			//
-			// todo: this may need cleanup - there's no reads to state outside of processing an node update but it would be good to
-			// 	 ensure that we don't end up needing a RWMutex instead.
-			didLock := state.Lock.TryLock()
-			if !didLock {
-				log.Warnw("Failed to lock state object, skipping update",
-					"node", cfg.NodeName,
-					"traceCtx", ctx)
-				return
-			}
-			log.Debugw("Locked state object", "node", cfg.NodeName,
+
+	// the drain and reconcile settings can change while we run, so reconciles read them from the store
+	store := config.NewStore(cfg)
+
+	// node updates are queued by name and reconciled by the worker pool. a node is only reconciled by one worker at a
+	// time, and updates that arrive while it's being reconciled collapse into a single reconcile once it finishes. failed
+	// reconciles are retried with backoff without waiting for another update.
+	retry := workers.RetryPolicy{
+		MaxRetries: cfg.ReconcileMaxRetries,
+		BaseDelay:  cfg.ReconcileRetryBaseDelay,
+		MaxDelay:   cfg.ReconcileRetryMaxDelay,
+	}
+	var pool *workers.Pool
+	pool = workers.NewPool(cfg.ReconcileWorkers, retry, func(ctx context.Context, nodeName string) error {
+		ctx, span := tracer.Start(ctx, "reconcileWorker")
+		defer span.End()
+
+		obj, exists, err := ni.GetStore().GetByKey(nodeName)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			log.Debugw("Node no longer in the informer cache, skipping reconcile", "node", nodeName, "traceCtx", ctx)
+			return nil
+		}
+
+		// the state object belongs to the one node we watch, and the pool never hands a node to two workers at once,
+		// so workers don't contend for the lock. it's only held elsewhere by the config reload, which queues the node
+		// again once it's done, and by the uncordon on shutdown, so an update that finds it held is skipped.
+		if !state.Lock.TryLock() {
+			log.Warnw("Failed to lock state object, skipping update",
+				"node", nodeName,
+				"traceCtx", ctx)
+			return nil
+		}
+		log.Debugw("Locked state object", "node", nodeName,
+			"state", &state,
+			"traceCtx", ctx)
+		defer func() {
+			state.Lock.Unlock()
+			log.Debugw("Unlocked state object",
+				"node", nodeName,
 				"state", &state,
 				"traceCtx", ctx)
-			defer func() {
-				state.Lock.Unlock()
-				log.Debugw("Unlocked state object",
-					"node", cfg.NodeName,
-					"state", &state,
-					"traceCtx", ctx)
-			}()
-
-			node := new.(*v1.Node)
-			log.Infow("Node updated, checking for updated conditions",
-				"node", node.Name,
+		}()
+
+		if pauseWatcher != nil && pauseWatcher.Paused() {
+			log.Infow("Mechanic is paused by the pause ConfigMap, skipping update",
+				"node", nodeName,
+				"namespace", cfg.Pause.Namespace,
+				"name", cfg.Pause.Name,
 				"traceCtx", ctx)
+			return nil
+		}
 
-			state.HasEventScheduled = n.CheckNodeConditions(ctx, node, cfg.DrainConditions)
+		if elector != nil && !elector.IsLeader() {
+			log.Infow("Another mechanic instance holds the node lease, skipping update",
+				"node", nodeName,
+				"lease", elector.LeaseName(),
+				"traceCtx", ctx)
+			return nil
+		}
 
-			log.Infow("Finished checking node conditions and current state.", "node", node.Name, "state", &state, "traceCtx", ctx)
+		// the reconcile logs its own failures. a node name that can't be matched to scheduled events won't be fixed by
+		// retrying, so only the other failures are retried.
+		err = n.ReconcileNode(ctx, clientset, ic, store.Get(), &state, recorder, obj.(*v1.Node))
+		// an event further out than the drain lead time is acted on once it's within it, without waiting for a node
+		// update or the next poll
+		if opensAt := state.LeadTimeOpensAt(); opensAt.After(time.Now()) {
+			log.Debugw("Scheduled event is outside the drain lead time, reconciling again when it's within it", "node", nodeName, "at", opensAt.UTC(), "traceCtx", ctx)
+			pool.EnqueueAfter(nodeName, time.Until(opensAt))
+		}
+		if errors.Is(err, imds.ErrInvalidNodeName) {
+			return nil
+		}
+		return err
+	})
+	pool.Start(ctx)
+	// the cordon is released once the workers have stopped, so a reconcile in progress can't cordon the node again
+	// behind us. it's skipped while another instance or the pause ConfigMap means we shouldn't be acting on the node.
+	shutdowns.Register("uncordon on shutdown", func(ctx context.Context) error {
+		if !store.Get().UncordonOnShutdown {
+			return nil
+		}
+		if (pauseWatcher != nil && pauseWatcher.Paused()) || (elector != nil && !elector.IsLeader()) {
+			log.Infow("Not acting on the node, leaving any cordon in place on shutdown", "node", cfg.NodeName)
+			return nil
+		}
+		state.Lock.Lock()
+		defer state.Lock.Unlock()
+		_, err := n.UncordonOnShutdown(ctx, clientset, ic, cfg.NodeName, store.Get(), recorder)
+		return err
+	})
+	// shutting the pool down stops it taking new nodes, so updates the informers deliver while reconciles in progress
+	// finish are dropped
+	shutdowns.Register("reconcile workers", pool.Shutdown)
 
-			if state.HasEventScheduled {
-				// early return if the node is already cordoned and drained
-				if state.IsCordoned && state.IsDrained {
-					log.Infow("Node is already cordoned and drained, no action required", "node", node.Name, "state", &state, "traceCtx", ctx)
-					return
-				}
+	ni.AddEventHandler(cache.ResourceEventHandlerDetailedFuncs{
+		UpdateFunc: func(old, new interface{}) {
+			pool.Enqueue(new.(*v1.Node).Name)
+		},
+	})
 
-				// query IMDS for more information on the scheduled event
-				b, err := imds.CheckIfDrainRequired(ctx, ic, node, &cfg.DrainConditions)
-				if err != nil {
-					log.Errorw("Failed to query IMDS for scheduled event information. Unable to determine if drain is required.", "error", err, "state", &state, "traceCtx", ctx)
-					return
-				}
-				state.ShouldDrain = b
-
-				if state.ShouldDrain {
-					// cordon the node, then drain
-					log.Infow("A drain has been determined as appropriate for the node", "node", node.Name, "state", &state, "traceCtx", ctx)
-
-					// check state and attempt to cordon if required
-					if state.IsCordoned {
-						log.Infow("Node is already cordoned, skipping cordon", "node", node.Name, "state", &state, "traceCtx", ctx)
-						recorder.Eventf(node, v1.EventTypeNormal, "CordonNode", "Node %s is already cordoned, no need to attempt a cordon.", node.Name)
-					} else {
-						b, err := n.CordonNode(ctx, clientset, node)
-						if err != nil {
-							log.Errorw("Failed to cordon node", "node", node.Name, "error", err, "traceCtx", ctx)
-							recorder.Eventf(node, v1.EventTypeWarning, "CordonNode", "Failed to cordon node %s", node.Name)
-						} else {
-							state.IsCordoned = b
-							log.Infow("Node cordoned", "node", node.Name, "state", &state, "traceCtx", ctx)
-							recorder.Eventf(node, v1.EventTypeNormal, "CordonNode", "Node %s cordoned by mechanic", node.Name)
-						}
-					}
-
-					if state.IsDrained {
-						log.Infow("Node is already drained, skipping drain", "node", node.Name, "traceCtx", ctx)
-					} else {
-						b, err := n.DrainNode(ctx, clientset, node)
-						if err != nil {
-							log.Errorw("Failed to drain node", "node", node.Name, "error", err, "traceCtx", ctx)
-							recorder.Eventf(node, v1.EventTypeWarning, "DrainNode", "Failed to drain node %s", node.Name)
-						} else {
-							state.IsDrained = b
-							log.Infow("Node drain completed", "node", node.Name, "state", &state, "traceCtx", ctx)
-							recorder.Eventf(node, v1.EventTypeNormal, "DrainNode", "Node %s drained by mechanic", node.Name)
-						}
-					}
+	// the interval is read on every poll so a changed configuration takes effect without a restart
+	go workers.Poll(signalCtx, cfg.PollingStartupJitter, func() time.Duration { return store.Get().PollingInterval }, func() {
+		pool.Enqueue(cfg.NodeName)
+	})
+
+	// with IMDS polling, the node is also reconciled on its own interval so scheduled events no node condition reports
+	// are found. the reconciles are queued like any other, so they never run alongside one for a node update.
+	go workers.Poll(signalCtx, cfg.PollingStartupJitter, func() time.Duration {
+		if current := store.Get(); current.AlsoPollIMDS {
+			return current.IMDSPollInterval
+		}
+		return 0
+	}, func() {
+		pool.Enqueue(cfg.NodeName)
+	})
+
+	// when the config file changes, a cordon for an event type we no longer drain for is released right away and the
+	// node is reconciled against the new settings
+	config.EnableHotReload(ctx, store, func(old, new config.Config) {
+		// the severities are kept by the imds package rather than read from the store, so they're applied here
+		if err := imds.ConfigureSeverities(new.DrainConditions); err != nil {
+			log.Warnw("Invalid scheduled event severity configuration after reload, keeping the previous severities", "error", err)
+		}
+
+		// a paused agent, or one that isn't the leader, leaves the node alone, and the reconcile queued below skips it
+		// the same way
+		if (pauseWatcher != nil && pauseWatcher.Paused()) || (elector != nil && !elector.IsLeader()) {
+			log.Infow("Mechanic is paused or isn't the leader, not re-evaluating the cordon after the reload", "node", cfg.NodeName)
+			pool.Enqueue(cfg.NodeName)
+			return
+		}
+
+		obj, exists, err := ni.GetStore().GetByKey(cfg.NodeName)
+		if err != nil || !exists {
+			return
+		}
+		// like the workers, don't wait on a reconcile in progress. the one queued below picks up the new settings.
+		if state.Lock.TryLock() {
+			// failures are logged and reported by ReevaluateCordon, the reconcile below retries the release
+			n.ReevaluateCordon(ctx, clientset, obj.(*v1.Node), new, recorder)
+			state.Lock.Unlock()
+		} else {
+			log.Infow("Node is being reconciled, leaving the cordon to the next reconcile after the reload", "node", cfg.NodeName)
+		}
+		pool.Enqueue(cfg.NodeName)
+	})
+
+	// the admin server exposes the agent's status, its configuration, and the last IMDS response for debugging and is only started when an
+	// address is configured
+	if cfg.AdminListenAddress != "" {
+		adminCtx, stopAdmin := context.WithCancel(ctx)
+		shutdowns.Register("admin server", func(ctx context.Context) error {
+			stopAdmin()
+			return nil
+		})
+		sources := admin.StatusSources{
+			Version:        version,
+			Commit:         commit,
+			InformerSynced: ni.HasSynced,
+			ConfigReloads:  store.Reloads,
+			Config:         store.Get,
+			PromoteSafeMode: func() error {
+				if err := n.PromoteSafeMode(adminCtx, clientset, cfg.NodeName, store.Get()); err != nil {
+					return err
 				}
+				pool.Enqueue(cfg.NodeName)
+				return nil
+			},
+		}
+		go func() {
+			if err := admin.Serve(adminCtx, cfg.AdminListenAddress, admin.NewHandler(&state, cfg.IMDSSnapshotStaleAfter, sources)); err != nil {
+				log.Errorw("Admin server stopped", "address", cfg.AdminListenAddress, "error", err)
 			}
-			// finished the event checking, cordon, and drain logic. checking for unneeded cordons now. grab an updated
-			// node object that should reflect all of our changes and use that for the ValidateCordon
-			log.Infow("Checking for unneeded cordon", "node", node.Name, "state", &state, "traceCtx", ctx)
-			updated, err := clientset.CoreV1().Nodes().Get(ctx, node.Name, metav1.GetOptions{})
-			if err != nil {
-				log.Errorw("Failed to get updated node object", "node", node.Name, "error", err, "state", &state, "traceCtx", ctx)
-				return
-			}
-			n.ValidateCordon(ctx, clientset, updated, recorder)
+		}()
+	}
 
-			log.Infow("Finished processing node update", "node", node.Name, "state", &state, "traceCtx", ctx)
-		},
-	})
+	// the metrics endpoint serves the counters and histograms registered by the metrics package
+	if cfg.MetricsPort != 0 {
+		metricsCtx, stopMetrics := context.WithCancel(ctx)
+		shutdowns.Register("metrics server", func(ctx context.Context) error {
+			stopMetrics()
+			return nil
+		})
+		metricsAddr := fmt.Sprintf(":%d", cfg.MetricsPort)
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.Handler())
+		go func() {
+			if err := admin.Serve(metricsCtx, metricsAddr, mux); err != nil {
+				log.Errorw("Metrics server stopped", "address", metricsAddr, "error", err)
+			}
+		}()
+	}
 
-	stop := make(chan struct{})
-	defer close(stop)
+	// the probe endpoints let the kubelet restart a wedged agent and hold traffic until the informer has synced. with
+	// IMDS polling on, the agent also stops being ready once the polls stop succeeding.
+	if cfg.HealthPort != 0 {
+		pollingStarted := time.Now().Add(cfg.PollingStartupJitter)
+		ready := func() bool {
+			if !ni.HasSynced() {
+				return false
+			}
+			current := store.Get()
+			if !current.AlsoPollIMDS {
+				return true
+			}
+			lastPoll := pollingStarted
+			if snapshot, ok := state.LastIMDSResponse(); ok {
+				lastPoll = snapshot.FetchedAt
+			}
+			return health.IMDSPollFresh(lastPoll, current.IMDSPollInterval, time.Now())
+		}
+		healthCtx, stopHealth := context.WithCancel(ctx)
+		shutdowns.Register("health server", func(ctx context.Context) error {
+			stopHealth()
+			return nil
+		})
+		healthAddr := fmt.Sprintf(":%d", cfg.HealthPort)
+		go func() {
+			if err := admin.Serve(healthCtx, healthAddr, health.NewHandler(ready)); err != nil {
+				log.Errorw("Health server stopped", "address", healthAddr, "error", err)
+			}
+		}()
+	}
 
 	// start the informer
 	log.Infow("Starting the informer", "node", cfg.NodeName)
@@ -222,6 +508,10 @@ func main() {
 		log.Errorw("Failed to sync informer caches")
 	}
 
-	// block main process
-	<-stop
+	// block main process until we're asked to stop
+	<-signalCtx.Done()
+	log.Infow("Received shutdown signal, shutting down", "timeout", cfg.ShutdownTimeout)
+	if err := shutdowns.Shutdown(ctx); err != nil {
+		log.Warnw("Shutdown did not complete cleanly", "error", err)
+	}
 }
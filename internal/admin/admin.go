@@ -0,0 +1,224 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/amargherio/mechanic/internal/appstate"
+	"github.com/amargherio/mechanic/internal/config"
+	"go.opentelemetry.io/otel"
+)
+
+const (
+	// ScheduledEventsPath is where the last IMDS scheduled events response is served
+	ScheduledEventsPath = "/debug/scheduledevents"
+	// StatusPath is where the health summary of the agent is served
+	StatusPath = "/status"
+	// PromoteSafeModePath promotes safe mode for the node so the drains it's holding back run. Only requests from the
+	// pod's loopback interface, such as through kubectl port-forward, are accepted.
+	PromoteSafeModePath = "/safemode/promote"
+	// ConfigPath is where the configuration mechanic is running with is served
+	ConfigPath = "/config"
+)
+
+// StatusSources are the parts of the status summary that don't come from the app state. Leave a func nil when the
+// subsystem behind it isn't running and its field is reported as null.
+type StatusSources struct {
+	Version string
+	Commit  string
+	// InformerSynced reports whether the node informer cache has synced
+	InformerSynced func() bool
+	// ConfigReloads reports how many times the configuration has been reloaded
+	ConfigReloads func() int
+	// Config returns the configuration mechanic is running with, including reloaded settings
+	Config func() config.Config
+	// PromoteSafeMode records the safe mode promotion for the node and queues it for a reconcile
+	PromoteSafeMode func() error
+}
+
+// status is the JSON body returned by the status endpoint. Pointer fields are null when the information isn't
+// available yet or the subsystem isn't enabled.
+type status struct {
+	Version        string      `json:"version"`
+	Commit         string      `json:"commit"`
+	LastIMDSQuery  *time.Time  `json:"lastIMDSQuery"`
+	IMDSStale      bool        `json:"imdsStale"`
+	InformerSynced *bool       `json:"informerSynced"`
+	LastReconcile  *time.Time  `json:"lastReconcile"`
+	Node           *nodeStatus `json:"node"`
+	ConfigReloads  *int        `json:"configReloads"`
+}
+
+// nodeStatus is the node's scheduled event, cordon, and drain state as of the last reconcile
+type nodeStatus struct {
+	EventScheduled bool `json:"eventScheduled"`
+	Cordoned       bool `json:"cordoned"`
+	Drained        bool `json:"drained"`
+}
+
+// scheduledEventsSnapshot is the JSON body returned by the scheduled events endpoint. Stale is set when the response
+// is older than the configured threshold, since IMDS is only queried when the node object changes.
+type scheduledEventsSnapshot struct {
+	FetchedAt  time.Time   `json:"fetchedAt"`
+	AgeSeconds float64     `json:"ageSeconds"`
+	Stale      bool        `json:"stale"`
+	Response   interface{} `json:"response"`
+}
+
+// effectiveConfig is the JSON body returned by the config endpoint: the configuration in use, without credentials, and
+// the settings it was built from with where each one came from
+type effectiveConfig struct {
+	Config   config.Config    `json:"config"`
+	Settings []config.Setting `json:"settings"`
+}
+
+// NewHandler returns the admin HTTP handler serving debugging endpoints backed by the app state
+func NewHandler(state *appstate.State, staleAfter time.Duration, sources StatusSources) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(StatusPath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		writeJSON(w, buildStatus(state, staleAfter, sources))
+	})
+	mux.HandleFunc(ScheduledEventsPath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		snapshot, ok := state.LastIMDSResponse()
+		if !ok {
+			http.Error(w, "IMDS has not been queried yet", http.StatusNotFound)
+			return
+		}
+
+		age := time.Since(snapshot.FetchedAt)
+		body := scheduledEventsSnapshot{
+			FetchedAt:  snapshot.FetchedAt,
+			AgeSeconds: age.Seconds(),
+			Stale:      staleAfter > 0 && age > staleAfter,
+			Response:   snapshot.Response,
+		}
+
+		writeJSON(w, body)
+	})
+	mux.HandleFunc(PromoteSafeModePath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		// promoting lets drains run, so it isn't open to everything that can reach the admin address
+		if !fromLoopback(r) {
+			http.Error(w, "safe mode can only be promoted from the pod's loopback interface", http.StatusForbidden)
+			return
+		}
+		if sources.PromoteSafeMode == nil {
+			http.Error(w, "safe mode promotion is not available", http.StatusNotFound)
+			return
+		}
+
+		if err := sources.PromoteSafeMode(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc(ConfigPath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if sources.Config == nil {
+			http.Error(w, "configuration is not available", http.StatusNotFound)
+			return
+		}
+
+		cfg := sources.Config()
+		writeJSON(w, effectiveConfig{Config: cfg.Redacted(), Settings: cfg.Settings})
+	})
+	return mux
+}
+
+// buildStatus gathers the status summary from the app state and the status sources
+func buildStatus(state *appstate.State, staleAfter time.Duration, sources StatusSources) status {
+	st := status{
+		Version: sources.Version,
+		Commit:  sources.Commit,
+	}
+
+	if snapshot, ok := state.LastIMDSResponse(); ok {
+		st.LastIMDSQuery = &snapshot.FetchedAt
+		st.IMDSStale = staleAfter > 0 && time.Since(snapshot.FetchedAt) > staleAfter
+	}
+	if reconcile, ok := state.LastReconcile(); ok {
+		st.LastReconcile = &reconcile.FinishedAt
+		st.Node = &nodeStatus{
+			EventScheduled: reconcile.HasEventScheduled,
+			Cordoned:       reconcile.IsCordoned,
+			Drained:        reconcile.IsDrained,
+		}
+	}
+	if sources.InformerSynced != nil {
+		synced := sources.InformerSynced()
+		st.InformerSynced = &synced
+	}
+	if sources.ConfigReloads != nil {
+		reloads := sources.ConfigReloads()
+		st.ConfigReloads = &reloads
+	}
+	return st
+}
+
+// fromLoopback reports whether the request came from a loopback address
+func fromLoopback(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func writeJSON(w http.ResponseWriter, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// Serve runs an HTTP server for the handler on the given address until the context is cancelled. It serves the admin
+// endpoints and the metrics endpoint.
+func Serve(ctx context.Context, addr string, handler http.Handler) error {
+	tracer := otel.Tracer("github.com/amargherio/mechanic/internal/admin")
+	ctx, span := tracer.Start(ctx, "Serve")
+	defer span.End()
+
+	vals := ctx.Value("values").(*config.ContextValues)
+	log := vals.Logger
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	log.Infow("Starting HTTP server", "address", addr, "traceCtx", ctx)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,245 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/amargherio/mechanic/internal/appstate"
+	"github.com/amargherio/mechanic/internal/config"
+	"github.com/amargherio/mechanic/pkg/imds"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+)
+
+type stubIMDS struct {
+	resp imds.ScheduledEventsResponse
+}
+
+func (s *stubIMDS) QueryIMDS(ctx context.Context) (imds.ScheduledEventsResponse, error) {
+	return s.resp, nil
+}
+
+func (s *stubIMDS) AckEvent(ctx context.Context, eventID string) error {
+	return nil
+}
+
+func getSnapshot(t *testing.T, handler http.Handler) (int, map[string]interface{}) {
+	return getJSON(t, handler, ScheduledEventsPath)
+}
+
+func getJSON(t *testing.T, handler http.Handler, path string) (int, map[string]interface{}) {
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+
+	var body map[string]interface{}
+	if rec.Code == http.StatusOK {
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	}
+	return rec.Code, body
+}
+
+func TestScheduledEventsEndpoint(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+
+	state := &appstate.State{}
+	vals := config.ContextValues{
+		Logger: logger.Sugar(),
+		State:  state,
+	}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+	handler := NewHandler(state, time.Minute, StatusSources{})
+
+	code, _ := getSnapshot(t, handler)
+	assert.Equal(t, http.StatusNotFound, code, "no snapshot should be served before IMDS is queried")
+
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "aks-nodepool1-12345678-vmss00000a"}}
+	ic := &stubIMDS{}
+	for i, eventID := range []string{"first", "second"} {
+		ic.resp = imds.ScheduledEventsResponse{
+			IncarnationID: float64(i + 1),
+			Events: []imds.ScheduledEvent{
+				{EventId: eventID, Type: imds.Reboot, Resources: []string{"aks-nodepool1-12345678-vmss_10"}},
+			},
+		}
+		_, _, err := imds.CheckIfDrainRequired(ctx, ic, node, &config.DrainConditions{DrainOnReboot: true}, config.IMDSRetryConfig{})
+		require.NoError(t, err)
+	}
+
+	code, body := getSnapshot(t, handler)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, false, body["stale"])
+
+	resp := body["response"].(map[string]interface{})
+	assert.Equal(t, float64(2), resp["DocumentIncarnation"], "snapshot should reflect the last IMDS query")
+	events := resp["Events"].([]interface{})
+	require.Len(t, events, 1)
+	assert.Equal(t, "second", events[0].(map[string]interface{})["EventId"])
+
+	// an old snapshot is labeled as stale
+	state.RecordIMDSResponse(ic.resp, time.Now().Add(-2*time.Minute))
+	code, body = getSnapshot(t, handler)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, true, body["stale"])
+	assert.GreaterOrEqual(t, body["ageSeconds"].(float64), float64(120))
+}
+
+func TestStatusEndpoint(t *testing.T) {
+	state := &appstate.State{}
+	synced := false
+	handler := NewHandler(state, time.Minute, StatusSources{
+		Version:        "v2025.1",
+		Commit:         "abc123",
+		InformerSynced: func() bool { return synced },
+	})
+
+	// before anything has happened, only the version and informer status are known
+	code, body := getJSON(t, handler, StatusPath)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, map[string]interface{}{
+		"version":        "v2025.1",
+		"commit":         "abc123",
+		"lastIMDSQuery":  nil,
+		"imdsStale":      false,
+		"informerSynced": false,
+		"lastReconcile":  nil,
+		"node":           nil,
+		"configReloads":  nil,
+	}, body)
+
+	queried := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	reconciled := queried.Add(time.Second)
+	state.RecordIMDSResponse(imds.ScheduledEventsResponse{IncarnationID: 1}, queried)
+	state.SetEventScheduled(true)
+	state.SetCordoned(true)
+	state.RecordReconcile(reconciled)
+	synced = true
+
+	code, body = getJSON(t, handler, StatusPath)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, map[string]interface{}{
+		"version":        "v2025.1",
+		"commit":         "abc123",
+		"lastIMDSQuery":  "2025-01-02T03:04:05Z",
+		"imdsStale":      true,
+		"informerSynced": true,
+		"lastReconcile":  "2025-01-02T03:04:06Z",
+		"node": map[string]interface{}{
+			"eventScheduled": true,
+			"cordoned":       true,
+			"drained":        false,
+		},
+		"configReloads": nil,
+	}, body)
+
+	// state changes after the reconcile aren't reported until the next one finishes
+	state.SetDrained(true)
+	_, body = getJSON(t, handler, StatusPath)
+	assert.Equal(t, false, body["node"].(map[string]interface{})["drained"])
+}
+
+func TestStatusEndpointConfigReloads(t *testing.T) {
+	handler := NewHandler(&appstate.State{}, time.Minute, StatusSources{ConfigReloads: func() int { return 3 }})
+
+	code, body := getJSON(t, handler, StatusPath)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, float64(3), body["configReloads"])
+	assert.Nil(t, body["informerSynced"])
+}
+
+func TestPromoteSafeModeEndpoint(t *testing.T) {
+	promotions := 0
+	handler := NewHandler(&appstate.State{}, time.Minute, StatusSources{PromoteSafeMode: func() error {
+		promotions++
+		return nil
+	}})
+	post := func(remoteAddr string) int {
+		req := httptest.NewRequest(http.MethodPost, PromoteSafeModePath, nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// promoting changes state, so it isn't allowed with a GET
+	code, _ := getJSON(t, handler, PromoteSafeModePath)
+	assert.Equal(t, http.StatusMethodNotAllowed, code)
+
+	// only requests from the loopback interface can promote
+	assert.Equal(t, http.StatusForbidden, post("10.244.1.7:51234"))
+	assert.Zero(t, promotions)
+
+	assert.Equal(t, http.StatusNoContent, post("127.0.0.1:51234"))
+	assert.Equal(t, http.StatusNoContent, post("[::1]:51234"))
+	assert.Equal(t, 2, promotions)
+
+	// a failed promotion is reported
+	handler = NewHandler(&appstate.State{}, time.Minute, StatusSources{PromoteSafeMode: func() error {
+		return errors.New("node update failed")
+	}})
+	assert.Equal(t, http.StatusInternalServerError, post("127.0.0.1:51234"))
+
+	// not available without a way to promote
+	handler = NewHandler(&appstate.State{}, time.Minute, StatusSources{})
+	assert.Equal(t, http.StatusNotFound, post("127.0.0.1:51234"))
+}
+
+func TestConfigEndpoint(t *testing.T) {
+	// not available until a config source is set
+	code, _ := getJSON(t, NewHandler(&appstate.State{}, time.Minute, StatusSources{}), ConfigPath)
+	assert.Equal(t, http.StatusNotFound, code)
+
+	cfg := config.Config{
+		NodeName:        "test-vmss000001",
+		KubeConfig:      &rest.Config{Host: "https://10.0.0.1", BearerToken: "secret"},
+		DrainConditions: config.DrainConditions{DrainOnReboot: true, DrainOnPreempt: true},
+		Settings: []config.Setting{
+			{Key: "DRAIN_ON_PREEMPT", Value: true, Source: config.SettingSourceDefault},
+			{Key: "DRAIN_ON_REBOOT", Value: true, Source: config.SettingSourceFile},
+		},
+	}
+	handler := NewHandler(&appstate.State{}, time.Minute, StatusSources{Config: func() config.Config { return cfg }})
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, ConfigPath, nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.NotContains(t, rec.Body.String(), "secret", "credentials are redacted")
+
+	var body map[string]interface{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	effective := body["config"].(map[string]interface{})
+	assert.Equal(t, "test-vmss000001", effective["NodeName"])
+	assert.Nil(t, effective["KubeConfig"])
+	assert.NotContains(t, effective, "Settings")
+	conditions := effective["DrainConditions"].(map[string]interface{})
+	assert.Equal(t, true, conditions["DrainOnReboot"], "the override from the config file is in effect")
+	assert.Equal(t, true, conditions["DrainOnPreempt"])
+
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"key": "DRAIN_ON_PREEMPT", "value": true, "source": "default"},
+		map[string]interface{}{"key": "DRAIN_ON_REBOOT", "value": true, "source": "file"},
+	}, body["settings"])
+
+	// reloaded settings are served on the next request
+	cfg.DrainConditions.DrainOnReboot = false
+	cfg.Settings = []config.Setting{
+		{Key: "DRAIN_ON_PREEMPT", Value: true, Source: config.SettingSourceDefault},
+		{Key: "DRAIN_ON_REBOOT", Value: false, Source: config.SettingSourceFile},
+	}
+	_, body = getJSON(t, handler, ConfigPath)
+	assert.Equal(t, false, body["config"].(map[string]interface{})["DrainConditions"].(map[string]interface{})["DrainOnReboot"])
+	assert.Contains(t, body["settings"], map[string]interface{}{"key": "DRAIN_ON_REBOOT", "value": false, "source": "file"})
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, ConfigPath, nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
@@ -1,13 +1,242 @@
 package appstate
 
-import "sync"
+import (
+	"sync"
+	"time"
 
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// IMDSSnapshot is the last scheduled events response returned by IMDS and when it was fetched. The response is the
+// imds.ScheduledEventsResponse, held as an interface to keep appstate free of package dependencies.
+type IMDSSnapshot struct {
+	Response  interface{}
+	FetchedAt time.Time
+}
+
+// ReconcileSnapshot is the node's cordon and drain state as of the end of the last reconcile
+type ReconcileSnapshot struct {
+	FinishedAt        time.Time
+	HasEventScheduled bool
+	IsCordoned        bool
+	IsDrained         bool
+}
+
+// State is the agent's view of the node. Lock is held for the length of a reconcile so only one update is processed at
+// a time.
 type State struct {
-	Lock              sync.Mutex
+	Lock sync.Mutex
+
+	// the fields below are read and written with their accessors, which take flagsLock, so they can be read while a
+	// reconcile is running. the fields are only set directly when building a State, before it's shared.
+	flagsLock         sync.RWMutex
+	NodeUID           types.UID
 	HasEventScheduled bool
 	IsCordoned        bool
 	IsDrained         bool
 	ShouldDrain       bool
+
+	// EventDetectedAt is when the current scheduled event or condition was first observed on the node. It's zero when
+	// there's nothing scheduled.
+	EventDetectedAt time.Time
+
+	// StartupValidated is set once the node's conditions have been checked against IMDS on the first reconcile
+	StartupValidated bool
+
+	// NodeNameErrorReported is set once we've logged that the node name can't be decoded to a VMSS instance
+	NodeNameErrorReported bool
+
+	// ReportedFreezes tracks the IDs of non-LM freeze events we've already emitted an informational event for, along
+	// with each event's NotBefore. Entries are pruned once the freeze is long past.
+	ReportedFreezes map[string]time.Time
+	// SkippedFreezeID and SkippedFreezeNotBefore describe the most recent freeze event we decided not to drain for, so
+	// it can be recorded on the node
+	SkippedFreezeID        string
+	SkippedFreezeNotBefore time.Time
+
+	// DrainFailures counts the drains that have failed in a row for the current event, and DrainFailingSince is when
+	// the first of them failed. They drive the drain escalation steps and are reset once the node is drained or the
+	// event clears.
+	DrainFailures     int
+	DrainFailingSince time.Time
+	// DrainStarts are when the drains of the node started within the drain budget window, oldest first
+	DrainStarts []time.Time
+	// DrainFailurePaged is set once the page escalation step has fired for the current run of failures
+	DrainFailurePaged bool
+	// DrainedVerifiedAt is when the node was last confirmed to still be cordoned while the state has it cordoned and
+	// drained
+	DrainedVerifiedAt time.Time
+	// leadTimeOpensAt is when the earliest event held back by the drain lead time comes within it, zero when none are
+	leadTimeOpensAt time.Time
+
+	// lastIMDSResponse and lastReconcile are read by the admin endpoints while updates are processed, so they have
+	// their own lock rather than relying on Lock, which is held for the length of an update
+	snapshotLock     sync.RWMutex
+	lastIMDSResponse *IMDSSnapshot
+	lastReconcile    *ReconcileSnapshot
+}
+
+// EventScheduled reports whether the node has a scheduled event or condition we act on
+func (s *State) EventScheduled() bool {
+	s.flagsLock.RLock()
+	defer s.flagsLock.RUnlock()
+	return s.HasEventScheduled
+}
+
+// SetEventScheduled records whether the node has a scheduled event or condition we act on
+func (s *State) SetEventScheduled(scheduled bool) {
+	s.flagsLock.Lock()
+	defer s.flagsLock.Unlock()
+	s.HasEventScheduled = scheduled
+}
+
+// Cordoned reports whether the node is cordoned
+func (s *State) Cordoned() bool {
+	s.flagsLock.RLock()
+	defer s.flagsLock.RUnlock()
+	return s.IsCordoned
+}
+
+// SetCordoned records whether the node is cordoned
+func (s *State) SetCordoned(cordoned bool) {
+	s.flagsLock.Lock()
+	defer s.flagsLock.Unlock()
+	s.IsCordoned = cordoned
+}
+
+// Drained reports whether mechanic has drained the node
+func (s *State) Drained() bool {
+	s.flagsLock.RLock()
+	defer s.flagsLock.RUnlock()
+	return s.IsDrained
+}
+
+// SetDrained records whether mechanic has drained the node
+func (s *State) SetDrained(drained bool) {
+	s.flagsLock.Lock()
+	defer s.flagsLock.Unlock()
+	s.IsDrained = drained
+}
+
+// DrainRequired reports whether the last evaluation decided the node should be drained
+func (s *State) DrainRequired() bool {
+	s.flagsLock.RLock()
+	defer s.flagsLock.RUnlock()
+	return s.ShouldDrain
+}
+
+// SetDrainRequired records whether the node should be drained
+func (s *State) SetDrainRequired(required bool) {
+	s.flagsLock.Lock()
+	defer s.flagsLock.Unlock()
+	s.ShouldDrain = required
+}
+
+// LeadTimeOpensAt returns when the earliest scheduled event held back by the drain lead time comes within it, as of the
+// last IMDS check. It's zero when no event is held back.
+func (s *State) LeadTimeOpensAt() time.Time {
+	s.flagsLock.RLock()
+	defer s.flagsLock.RUnlock()
+	return s.leadTimeOpensAt
+}
+
+// SetLeadTimeOpensAt records when the earliest scheduled event held back by the drain lead time comes within it. Zero
+// means no event is held back.
+func (s *State) SetLeadTimeOpensAt(opensAt time.Time) {
+	s.flagsLock.Lock()
+	defer s.flagsLock.Unlock()
+	s.leadTimeOpensAt = opensAt
+}
+
+// DetectedAt returns when the current scheduled event or condition was first observed on the node, zero when there's
+// nothing scheduled
+func (s *State) DetectedAt() time.Time {
+	s.flagsLock.RLock()
+	defer s.flagsLock.RUnlock()
+	return s.EventDetectedAt
+}
+
+// StartupConditionsValidated reports whether the node's conditions have been checked against IMDS on the first
+// reconcile
+func (s *State) StartupConditionsValidated() bool {
+	s.flagsLock.RLock()
+	defer s.flagsLock.RUnlock()
+	return s.StartupValidated
+}
+
+// MarkStartupConditionsValidated records that the node's conditions have been checked against IMDS
+func (s *State) MarkStartupConditionsValidated() {
+	s.flagsLock.Lock()
+	defer s.flagsLock.Unlock()
+	s.StartupValidated = true
+}
+
+// MarkNodeNameErrorReported records that the node name failing to decode has been reported. It returns true the first
+// time it's called and false on every call after that.
+func (s *State) MarkNodeNameErrorReported() bool {
+	s.flagsLock.Lock()
+	defer s.flagsLock.Unlock()
+	if s.NodeNameErrorReported {
+		return false
+	}
+	s.NodeNameErrorReported = true
+	return true
+}
+
+// SkippedFreeze returns the ID and NotBefore of the freeze event we most recently decided not to drain for. The ID is
+// empty when there hasn't been one.
+func (s *State) SkippedFreeze() (string, time.Time) {
+	s.flagsLock.RLock()
+	defer s.flagsLock.RUnlock()
+	return s.SkippedFreezeID, s.SkippedFreezeNotBefore
+}
+
+// DrainFailureRun returns how many drains have failed in a row for the current event and when the first of them
+// failed. The count is zero when the last drain didn't fail.
+func (s *State) DrainFailureRun() (int, time.Time) {
+	s.flagsLock.RLock()
+	defer s.flagsLock.RUnlock()
+	return s.DrainFailures, s.DrainFailingSince
+}
+
+// DrainFailurePageSent reports whether the page escalation step has fired for the current run of drain failures
+func (s *State) DrainFailurePageSent() bool {
+	s.flagsLock.RLock()
+	defer s.flagsLock.RUnlock()
+	return s.DrainFailurePaged
+}
+
+// MarkDrainFailurePaged records that the page escalation step has fired for the current run of drain failures
+func (s *State) MarkDrainFailurePaged() {
+	s.flagsLock.Lock()
+	defer s.flagsLock.Unlock()
+	s.DrainFailurePaged = true
+}
+
+// OldestDrainStart returns when the oldest drain still remembered started, as of the last DrainStartsSince. The second
+// return value is false when none are.
+func (s *State) OldestDrainStart() (time.Time, bool) {
+	s.flagsLock.RLock()
+	defer s.flagsLock.RUnlock()
+	if len(s.DrainStarts) == 0 {
+		return time.Time{}, false
+	}
+	return s.DrainStarts[0], true
+}
+
+// DrainedVerified returns when the node was last confirmed to still be cordoned after it was drained
+func (s *State) DrainedVerified() time.Time {
+	s.flagsLock.RLock()
+	defer s.flagsLock.RUnlock()
+	return s.DrainedVerifiedAt
+}
+
+// SetDrainedVerified records when the node was last confirmed to still be cordoned after it was drained. Zero means it
+// has to be checked again on the next reconcile.
+func (s *State) SetDrainedVerified(verifiedAt time.Time) {
+	s.flagsLock.Lock()
+	defer s.flagsLock.Unlock()
+	s.DrainedVerifiedAt = verifiedAt
 }
 
 func (s *State) LockState() {
@@ -17,3 +246,182 @@ func (s *State) LockState() {
 func (s *State) UnlockState() {
 	s.Lock.Unlock()
 }
+
+// reportedFreezeRetention is how long past its NotBefore a reported freeze is remembered. A freeze is over in seconds,
+// but IMDS keeps listing it as Started for a while, and forgetting it then would report it again.
+const reportedFreezeRetention = time.Hour
+
+// MarkFreezeReported records that a skipped freeze event has been reported. It returns true the first time it's called
+// for a given event ID and false on every call after that. Freezes whose NotBefore is more than
+// reportedFreezeRetention ago are forgotten. A freeze that had already started when it was reported has no NotBefore,
+// so it's remembered from when it was reported.
+func (s *State) MarkFreezeReported(eventID string, notBefore time.Time) bool {
+	s.flagsLock.Lock()
+	defer s.flagsLock.Unlock()
+
+	now := time.Now()
+	if s.ReportedFreezes == nil {
+		s.ReportedFreezes = make(map[string]time.Time)
+	}
+	for id, reportedNotBefore := range s.ReportedFreezes {
+		if id != eventID && now.Sub(reportedNotBefore) > reportedFreezeRetention {
+			delete(s.ReportedFreezes, id)
+		}
+	}
+	if _, ok := s.ReportedFreezes[eventID]; ok {
+		return false
+	}
+	if notBefore.IsZero() {
+		notBefore = now
+	}
+	s.ReportedFreezes[eventID] = notBefore
+	return true
+}
+
+// RecordSkippedFreeze records the freeze event we most recently decided not to drain for
+func (s *State) RecordSkippedFreeze(eventID string, notBefore time.Time) {
+	s.flagsLock.Lock()
+	defer s.flagsLock.Unlock()
+	s.SkippedFreezeID = eventID
+	s.SkippedFreezeNotBefore = notBefore
+}
+
+// ObserveNode records the UID of the node being processed. If it differs from the UID we've seen before, the node was
+// deleted and recreated with the same name (e.g. a VMSS reimage) and the cordon and drain state we hold describes the
+// old node, so it's reset, and the new node's conditions are checked against IMDS like they are on startup. It returns
+// true when the state was reset.
+func (s *State) ObserveNode(uid types.UID) bool {
+	s.flagsLock.Lock()
+	defer s.flagsLock.Unlock()
+
+	if s.NodeUID == uid {
+		return false
+	}
+
+	previous := s.NodeUID
+	s.NodeUID = uid
+	if previous == "" {
+		// first time we've seen the node, nothing to reset
+		return false
+	}
+
+	s.HasEventScheduled = false
+	s.IsCordoned = false
+	s.IsDrained = false
+	s.ShouldDrain = false
+	s.leadTimeOpensAt = time.Time{}
+	s.EventDetectedAt = time.Time{}
+	s.StartupValidated = false
+	s.ReportedFreezes = nil
+	s.SkippedFreezeID = ""
+	s.SkippedFreezeNotBefore = time.Time{}
+	s.DrainStarts = nil
+	s.DrainedVerifiedAt = time.Time{}
+	s.resetDrainFailures()
+	return true
+}
+
+// RecordDrainFailure counts a failed drain at now, starting a new run of failures if there isn't one
+func (s *State) RecordDrainFailure(now time.Time) {
+	s.flagsLock.Lock()
+	defer s.flagsLock.Unlock()
+	if s.DrainFailures == 0 {
+		s.DrainFailingSince = now
+	}
+	s.DrainFailures++
+}
+
+// RecordDrainStart records that a drain of the node started at now
+func (s *State) RecordDrainStart(now time.Time) {
+	s.flagsLock.Lock()
+	defer s.flagsLock.Unlock()
+	s.DrainStarts = append(s.DrainStarts, now)
+}
+
+// DrainStartsSince returns how many drains started at or after since, forgetting the ones that started before it
+func (s *State) DrainStartsSince(since time.Time) int {
+	s.flagsLock.Lock()
+	defer s.flagsLock.Unlock()
+
+	kept := s.DrainStarts[:0]
+	for _, start := range s.DrainStarts {
+		if !start.Before(since) {
+			kept = append(kept, start)
+		}
+	}
+	s.DrainStarts = kept
+	return len(kept)
+}
+
+// ResetDrainFailures ends the current run of drain failures
+func (s *State) ResetDrainFailures() {
+	s.flagsLock.Lock()
+	defer s.flagsLock.Unlock()
+	s.resetDrainFailures()
+}
+
+// resetDrainFailures ends the current run of drain failures. It must be called by the holder of flagsLock.
+func (s *State) resetDrainFailures() {
+	s.DrainFailures = 0
+	s.DrainFailingSince = time.Time{}
+	s.DrainFailurePaged = false
+}
+
+// ObserveEventScheduled keeps EventDetectedAt in step with whether the node has a scheduled event or condition. The
+// first time one is seen, the detection time is set to now and kept until the node no longer has one.
+func (s *State) ObserveEventScheduled(scheduled bool, now time.Time) {
+	s.flagsLock.Lock()
+	defer s.flagsLock.Unlock()
+
+	if !scheduled {
+		s.EventDetectedAt = time.Time{}
+		return
+	}
+	if s.EventDetectedAt.IsZero() {
+		s.EventDetectedAt = now
+	}
+}
+
+// RecordIMDSResponse stores the latest scheduled events response returned by IMDS so it can be inspected later
+func (s *State) RecordIMDSResponse(resp interface{}, fetchedAt time.Time) {
+	s.snapshotLock.Lock()
+	defer s.snapshotLock.Unlock()
+	s.lastIMDSResponse = &IMDSSnapshot{Response: resp, FetchedAt: fetchedAt}
+}
+
+// LastIMDSResponse returns the most recently recorded IMDS response. The second return value is false if IMDS hasn't
+// been queried yet.
+func (s *State) LastIMDSResponse() (IMDSSnapshot, bool) {
+	s.snapshotLock.RLock()
+	defer s.snapshotLock.RUnlock()
+	if s.lastIMDSResponse == nil {
+		return IMDSSnapshot{}, false
+	}
+	return *s.lastIMDSResponse, true
+}
+
+// RecordReconcile stores a snapshot of the cordon and drain state once a reconcile finishes. It must be called by the
+// holder of Lock.
+func (s *State) RecordReconcile(finishedAt time.Time) {
+	snapshot := &ReconcileSnapshot{
+		FinishedAt:        finishedAt,
+		HasEventScheduled: s.EventScheduled(),
+		IsCordoned:        s.Cordoned(),
+		IsDrained:         s.Drained(),
+	}
+
+	s.snapshotLock.Lock()
+	defer s.snapshotLock.Unlock()
+	s.lastReconcile = snapshot
+}
+
+// LastReconcile returns the snapshot recorded by the most recent reconcile. The second return value is false if no
+// reconcile has finished yet.
+func (s *State) LastReconcile() (ReconcileSnapshot, bool) {
+	s.snapshotLock.RLock()
+	defer s.snapshotLock.RUnlock()
+	if s.lastReconcile == nil {
+		return ReconcileSnapshot{}, false
+	}
+	return *s.lastReconcile, true
+}
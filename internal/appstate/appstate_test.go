@@ -0,0 +1,142 @@
+package appstate
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestObserveNode(t *testing.T) {
+	original := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-vmss000001", UID: "11111111-1111-1111-1111-111111111111"}}
+	recreated := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-vmss000001", UID: "22222222-2222-2222-2222-222222222222"}}
+
+	state := &State{}
+
+	// first observation only records the UID
+	assert.False(t, state.ObserveNode(original.UID))
+	assert.Equal(t, original.UID, state.NodeUID)
+
+	state.SetEventScheduled(true)
+	state.SetCordoned(true)
+	state.SetDrained(true)
+	state.SetDrainRequired(true)
+	state.MarkFreezeReported("freeze", time.Now())
+	state.RecordDrainFailure(time.Now())
+	state.MarkStartupConditionsValidated()
+
+	// same node again keeps the state
+	assert.False(t, state.ObserveNode(original.UID))
+	assert.True(t, state.Cordoned())
+	assert.True(t, state.Drained())
+
+	// the node was recreated under the same name, so the state is reset
+	assert.True(t, state.ObserveNode(recreated.UID))
+	assert.Equal(t, recreated.UID, state.NodeUID)
+	assert.False(t, state.EventScheduled())
+	assert.False(t, state.Cordoned())
+	assert.False(t, state.Drained())
+	assert.False(t, state.DrainRequired())
+	assert.True(t, state.MarkFreezeReported("freeze", time.Now()), "reported freezes should be cleared on reset")
+	assert.Zero(t, state.DrainFailures, "drain failures should be cleared on reset")
+	assert.False(t, state.StartupConditionsValidated(), "the new node's conditions should be validated again")
+}
+
+func TestObserveEventScheduled(t *testing.T) {
+	state := &State{}
+	first := time.Now().Add(-time.Minute)
+
+	// the detection time is set the first time an event is seen and kept while it stays scheduled
+	state.ObserveEventScheduled(true, first)
+	assert.Equal(t, first, state.DetectedAt())
+	state.ObserveEventScheduled(true, time.Now())
+	assert.Equal(t, first, state.DetectedAt())
+
+	// it's cleared once the event is gone
+	state.ObserveEventScheduled(false, time.Now())
+	assert.True(t, state.DetectedAt().IsZero())
+
+	// and reset when the node is recreated
+	state.ObserveNode("11111111-1111-1111-1111-111111111111")
+	state.ObserveEventScheduled(true, first)
+	state.ObserveNode("22222222-2222-2222-2222-222222222222")
+	assert.True(t, state.DetectedAt().IsZero())
+}
+
+func TestStateFlagsConcurrentAccess(t *testing.T) {
+	state := &State{}
+
+	// readers such as the admin server and decision log read the flags while a reconcile is updating them, run with
+	// -race to catch unguarded access
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				state.SetEventScheduled(j%2 == 0)
+				state.SetCordoned(j%2 == 0)
+				state.SetDrained(j%3 == 0)
+				state.SetDrainRequired(j%5 == 0)
+				state.ObserveEventScheduled(j%2 == 0, time.Now())
+				state.RecordDrainFailure(time.Now())
+				state.SetDrainedVerified(time.Now())
+				state.ObserveNode(types.UID(fmt.Sprint(j % 2)))
+				state.RecordReconcile(time.Now())
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				_ = state.EventScheduled()
+				_ = state.Cordoned()
+				_ = state.Drained()
+				_ = state.DrainRequired()
+				_ = state.DetectedAt()
+				_, _ = state.DrainFailureRun()
+				_ = state.DrainedVerified()
+				_ = state.StartupConditionsValidated()
+				_, _ = state.LastReconcile()
+			}
+		}()
+	}
+	wg.Wait()
+
+	state.SetCordoned(true)
+	state.SetDrained(false)
+	assert.True(t, state.Cordoned())
+	assert.False(t, state.Drained())
+}
+
+func TestDrainStartsSince(t *testing.T) {
+	now := time.Now()
+	state := &State{}
+	state.RecordDrainStart(now.Add(-90 * time.Minute))
+	state.RecordDrainStart(now.Add(-30 * time.Minute))
+	state.RecordDrainStart(now)
+
+	assert.Equal(t, 2, state.DrainStartsSince(now.Add(-time.Hour)))
+	assert.Equal(t, []time.Time{now.Add(-30 * time.Minute), now}, state.DrainStarts, "drains before the window are forgotten")
+	assert.Equal(t, 1, state.DrainStartsSince(now))
+}
+
+func TestMarkFreezeReportedPrunesPastFreezes(t *testing.T) {
+	now := time.Now()
+	state := &State{}
+
+	assert.True(t, state.MarkFreezeReported("past", now.Add(-2*time.Hour)))
+	assert.True(t, state.MarkFreezeReported("upcoming", now.Add(10*time.Minute)))
+	assert.True(t, state.MarkFreezeReported("started", time.Time{}))
+	assert.False(t, state.MarkFreezeReported("upcoming", now.Add(10*time.Minute)))
+	assert.False(t, state.MarkFreezeReported("started", time.Time{}), "a started freeze is remembered from when it was reported")
+
+	// reporting another freeze prunes the ones long past their NotBefore
+	assert.True(t, state.MarkFreezeReported("next", now.Add(time.Hour)))
+	assert.NotContains(t, state.ReportedFreezes, "past")
+	assert.Len(t, state.ReportedFreezes, 3)
+}
@@ -2,12 +2,44 @@ package config
 
 import (
 	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/amargherio/mechanic/internal/appstate"
+	"github.com/amargherio/mechanic/pkg/consts"
 	"github.com/spf13/viper"
 	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+)
+
+// the ways a drain can handle pods using local PersistentVolumes
+const (
+	LocalStoragePolicyForce = "force"
+	LocalStoragePolicySkip  = "skip"
+	LocalStoragePolicyDefer = "defer"
+)
+
+// the backends the drain state can be persisted to so it survives a restart
+const (
+	StateStoreNode      = "node"
+	StateStoreFile      = "file"
+	StateStoreConfigMap = "configmap"
+	StateStoreNone      = "none"
+)
+
+// the operating modes mechanic reports in its logs and metrics. Every mode watches the node with an informer, and the
+// hybrid mode also polls it, or IMDS, on the configured interval.
+const (
+	OperatingModeInformer = "informer"
+	OperatingModeHybrid   = "hybrid"
 )
 
 // DrainConditions is a struct that holds the VM scheduled event types that would trigger a drain
@@ -17,22 +49,379 @@ type DrainConditions struct {
 	DrainOnRedeploy  bool
 	DrainOnPreempt   bool
 	DrainOnTerminate bool
+
+	// ConditionOverrides maps a scheduled event type to the node condition type NPD reports it with, for NPD
+	// configurations that don't use the default <EventType>Scheduled names. Keys are lowercase.
+	ConditionOverrides map[string]string
+
+	// IgnoreStartedEvents skips events IMDS reports as already Started. By the time a short notice event like a spot
+	// Preempt has started, the VM is going away and there's nothing to gain from cordoning and draining it.
+	IgnoreStartedEvents bool
+
+	// LeadTime is how far ahead of an event's NotBefore time the node is drained. Events further out are left until
+	// they're inside the window. Zero drains as soon as an event is found.
+	LeadTime time.Duration
+
+	// ImpactingResourceTypes are the scheduled event resource types checked against the node. Empty uses
+	// DefaultImpactingResourceTypes.
+	ImpactingResourceTypes []string
+
+	// MatchResourcesOfAnyType makes a scheduled event that lists the node's instance in its Resources impact the node
+	// even when its resource type isn't one of ImpactingResourceTypes, like a resource type added in a newer api-version
+	MatchResourcesOfAnyType bool
+
+	// TreatEmptyResourcesAsImpacting makes scheduled events with an empty Resources list, such as region-wide notices,
+	// impact every node instead of none
+	TreatEmptyResourcesAsImpacting bool
+
+	// Severities overrides the severity (low, medium, or high) of scheduled event types. Keys are lowercase event types,
+	// plus livemigration for memory-preserving live migration freezes.
+	Severities map[string]string
+
+	// LeadTimeExemptSeverity is the lowest event severity that's drained for right away, ignoring LeadTime. Empty
+	// applies the lead time to every event.
+	LeadTimeExemptSeverity string
+
+	// LiveMigrationPatterns are the regular expressions matched case-insensitively against a Freeze event's
+	// description to recognize a memory-preserving live migration. Empty uses DefaultLiveMigrationPatterns.
+	LiveMigrationPatterns []string
+}
+
+// DrainConfig is a struct that holds the settings used when draining a node
+type DrainConfig struct {
+	// Timeout is the default amount of time a drain can take before it's abandoned. Zero means no timeout.
+	Timeout time.Duration
+	// TimeoutsByReason overrides Timeout for specific event types or node conditions. Keys are lowercase.
+	TimeoutsByReason map[string]time.Duration
+	// ScaleDownSelector is a label selector for Deployments that are scaled to zero before the node is drained. Empty
+	// disables the pre-drain scale down.
+	ScaleDownSelector string
+	// ScaleDownWait is the longest we'll wait for scaled down pods to leave the node before draining
+	ScaleDownWait time.Duration
+	// EndpointDrainTimeout is the longest we'll wait, before draining, for the pods on the node to stop being ready
+	// endpoints of their Services so traffic has moved away from them. The wait counts against the drain's Timeout.
+	// Zero skips the wait.
+	EndpointDrainTimeout time.Duration
+	// EvictionRatePerSecond caps how many pods are evicted per second during a drain. Zero means no limit.
+	EvictionRatePerSecond float64
+	// ProtectedEmptyDirSelector is a label selector for pods whose emptyDir data must not be deleted. Matching pods, and
+	// pods annotated with mechanic.io/protect-emptydir=true, are skipped by the drain.
+	ProtectedEmptyDirSelector string
+	// LocalStoragePolicy is what a drain does with pods using local PersistentVolumes, which can't follow the pod to
+	// another node: LocalStoragePolicyForce evicts them, LocalStoragePolicySkip leaves them on the node, and
+	// LocalStoragePolicyDefer holds the whole drain back while there are any
+	LocalStoragePolicy string
+	// EventOnEvictedPods records an event on each pod evicted by a drain so app teams watching their pods can see why
+	EventOnEvictedPods bool
+	// ReportOwners groups the pods removed by a drain by the controller that owns them and reports the groups in a
+	// summary event on the node and the log
+	ReportOwners bool
+	// MinSchedulableNodes is the number of schedulable, Ready nodes that must remain once the node is drained. Drains
+	// that aren't urgent are held back, leaving the node cordoned, when they'd drop the cluster below it. Zero disables
+	// the check.
+	MinSchedulableNodes int
+	// MaxDrainsPerHour caps how many drains of the node are started in any hour, so a reconcile loop that keeps
+	// draining the node can't disrupt its workloads over and over. Drains past the budget are skipped, leaving the node
+	// cordoned, until the oldest drain in the window ages out. Zero disables the budget.
+	MaxDrainsPerHour int
+	// Force evicts pods that aren't managed by a controller. Without it, the drain fails when the node has one.
+	Force bool
+	// DeleteEmptyDirData evicts pods using emptyDir volumes, deleting their data. Without it, the drain fails when the
+	// node has one.
+	DeleteEmptyDirData bool
+	// IgnoreAllDaemonSets skips DaemonSet managed pods. Without it, the drain fails when the node has one.
+	IgnoreAllDaemonSets bool
+	// RespectPDBs reports the PodDisruptionBudgets and pods holding up a drain that times out on blocked evictions,
+	// calling out budgets that can never be satisfied. Drains of nodes with pods covered by a budget that can never
+	// allow a disruption fail right away instead of waiting out the timeout.
+	RespectPDBs bool
+	// MaxRetries is how many times a drain that failed on a transient API error, like a timeout or conflict, is retried.
+	// The retries share the drain's timeout. A drain that still fails doesn't fail the reconcile, so the reconcile
+	// retries don't repeat it on top of these.
+	MaxRetries int
+	// BaseDelay is the wait before the first drain retry. It doubles with each retry after that.
+	BaseDelay time.Duration
+	// VerifyTimeout is how long pods still on the node after a drain, like ones that are slow to terminate, are waited
+	// for before the drain is reported as incomplete. The wait is cut short when the drain's timeout runs out first.
+	// Zero skips the check.
+	VerifyTimeout time.Duration
+	// VerifyRetries is how many times listing the pods left on the node can fail during the check before it gives up
+	VerifyRetries int
+	// DisableEviction deletes pods instead of evicting them, bypassing PodDisruptionBudgets
+	DisableEviction bool
+}
+
+// EscalationStep is when a drain escalation step is taken for a node whose drains keep failing. The step is taken once
+// either threshold is reached. A zero threshold is never reached, so a step with both at zero is disabled.
+type EscalationStep struct {
+	// AfterFailures is how many drains in a row have to fail
+	AfterFailures int
+	// After is how long the drains have to have been failing for, from the first failure
+	After time.Duration
+}
+
+// Reached reports whether the step's thresholds are met by failures drains failing over failingFor
+func (s EscalationStep) Reached(failures int, failingFor time.Duration) bool {
+	if failures == 0 {
+		return false
+	}
+	return (s.AfterFailures > 0 && failures >= s.AfterFailures) || (s.After > 0 && failingFor >= s.After)
+}
+
+// DrainEscalationConfig is a struct that holds the escalation steps taken when a node stays cordoned because its drains
+// keep failing
+type DrainEscalationConfig struct {
+	// Force drains with the forced drain settings, deleting pods instead of evicting them so PodDisruptionBudgets
+	// can't hold the drain up
+	Force EscalationStep
+	// Page reports the stuck drain once with a warning event and a metric for alerting to page on
+	Page EscalationStep
+}
+
+// GPUHealthConfig is a struct that holds the GPU health node conditions we drain for
+type GPUHealthConfig struct {
+	// Conditions are the node condition types, usually set by NPD, that report an unhealthy GPU
+	Conditions []string
+	// SustainedFor is how long a condition has to stay true before the node is drained, so transient errors like
+	// corrected ECC errors don't trigger evictions
+	SustainedFor time.Duration
+}
+
+// MaintenanceTaint matches a taint added to the node by an external maintenance operator. An empty Value or Effect
+// matches any value or effect.
+type MaintenanceTaint struct {
+	Key    string
+	Value  string
+	Effect string
+}
+
+// Matches reports whether a taint with the given key, value, and effect is matched
+func (mt MaintenanceTaint) Matches(key, value, effect string) bool {
+	return mt.Key == key && (mt.Value == "" || mt.Value == value) && (mt.Effect == "" || mt.Effect == effect)
+}
+
+// String formats the taint the way it's configured, key[=value][:effect]
+func (mt MaintenanceTaint) String() string {
+	s := mt.Key
+	if mt.Value != "" {
+		s += "=" + mt.Value
+	}
+	if mt.Effect != "" {
+		s += ":" + mt.Effect
+	}
+	return s
+}
+
+// PauseConfig is a struct that holds the location of the ConfigMap used to pause mechanic cluster-wide
+type PauseConfig struct {
+	// Name of the pause ConfigMap. Empty disables the pause ConfigMap.
+	Name      string
+	Namespace string
+	// Key is the ConfigMap key that, when present, decides whether the ConfigMap pauses mechanic
+	Key string
+}
+
+// UpgradeSignalConfig is a struct that holds the signals showing a cluster upgrade is in progress. Another controller
+// drains nodes during an upgrade, so mechanic holds its own drains back while any of them is present.
+type UpgradeSignalConfig struct {
+	// NodeLabels are node labels, written as key or key=value, that mark the node as being upgraded
+	NodeLabels []string
+	// NodeAnnotations are node annotations, written as key or key=value, that mark the node as being upgraded
+	NodeAnnotations []string
+	// ConfigMapName is a ConfigMap whose existence marks the cluster as being upgraded. Empty disables the ConfigMap.
+	ConfigMapName      string
+	ConfigMapNamespace string
+}
+
+// IMDSRetryConfig is a struct that holds how IMDS scheduled events queries are retried when IMDS closes the connection
+// without a response
+type IMDSRetryConfig struct {
+	// Attempts is how many times a query is tried before giving up, including the first try
+	Attempts int
+	// BaseDelay is the wait before the first retry. It doubles with each retry after that, up to MaxDelay.
+	BaseDelay time.Duration
+	// MaxDelay caps the wait between retries. Zero leaves it uncapped.
+	MaxDelay time.Duration
+}
+
+// NodeUpdateRetryConfig is a struct that holds how node updates made by cordons and uncordons are retried on
+// conflicts and transient API server errors
+type NodeUpdateRetryConfig struct {
+	// Attempts is how many times an update is tried before giving up, including the first try
+	Attempts int
+	// BaseDelay is the wait before the first retry. It doubles with each retry after that, with jitter, up to MaxDelay.
+	BaseDelay time.Duration
+	// MaxDelay caps the wait between retries
+	MaxDelay time.Duration
+}
+
+// CordonConfig is a struct that holds how mechanic marks the cordons it owns
+type CordonConfig struct {
+	// LabelKey is the node label that marks a cordon as mechanic's. Its presence is what mechanic uses to tell its own
+	// cordons from anyone else's.
+	LabelKey string
+	// ApplyTaint adds a NoSchedule taint with TaintKey alongside the cordon, for schedulers and tools that look at
+	// taints rather than spec.unschedulable
+	ApplyTaint bool
+	// TaintKey is the key of the taint added when ApplyTaint is set
+	TaintKey string
+}
+
+// StateStoreConfig is a struct that holds where the drain state is persisted so an agent restarted in the middle of
+// maintenance picks up where the last one left off
+type StateStoreConfig struct {
+	// Backend is one of StateStoreNode, which keeps the state in node annotations, StateStoreFile, StateStoreConfigMap,
+	// or StateStoreNone, which doesn't persist it at all
+	Backend string
+	// FilePath is where the file backend writes the state, usually on a hostPath volume so it outlives the pod
+	FilePath string
+	// ConfigMapNamespace is where the configmap backend keeps a ConfigMap per node
+	ConfigMapNamespace string
+}
+
+// LeaderElectionConfig is a struct that holds the settings for the per-node Lease that keeps more than one mechanic
+// instance from acting on a node
+type LeaderElectionConfig struct {
+	// Enabled turns on leader election. Without it every instance acts on its node.
+	Enabled bool
+	// Namespace is where the node Leases are created
+	Namespace string
+	// LeaseDuration is how long other instances wait before taking over a Lease that hasn't been renewed
+	LeaseDuration time.Duration
+	// RenewDeadline is how long the holder keeps trying to renew the Lease before giving it up
+	RenewDeadline time.Duration
+	// RetryPeriod is how often the Lease is acquired or renewed
+	RetryPeriod time.Duration
+}
+
+// TracingConfig is a struct that holds the trace exporter selection and its exporter specific settings
+type TracingConfig struct {
+	// Exporter is one of none, stdout, otlp, or file
+	Exporter string
+	// OTLPEndpoint is the host:port of the OTLP collector, used by the otlp exporter
+	OTLPEndpoint string
+	// OTLPInsecure disables TLS when sending to the OTLP collector
+	OTLPInsecure bool
+	// OTLPProtocol is how spans are sent to the OTLP collector, http or grpc
+	OTLPProtocol string
+	// FilePath is where spans are written by the file exporter
+	FilePath string
 }
 
 // ContextValues is a struct that holds the logger and state of the application for use in the shared application context
 type ContextValues struct {
-	Logger *zap.SugaredLogger
-	State  *appstate.State
-	Tracer *trace.Tracer
+	Logger   *zap.SugaredLogger
+	State    *appstate.State
+	Tracer   *trace.Tracer
+	Recorder record.EventRecorder
 }
 
 // Config is a struct that holds the configuration for the application
 type Config struct {
 	RuntimeEnv      string
 	DrainConditions DrainConditions
+	Drain           DrainConfig
+	DrainEscalation DrainEscalationConfig
+	GPUHealth       GPUHealthConfig
+	Pause           PauseConfig
+	UpgradeSignal   UpgradeSignalConfig
+	LeaderElection  LeaderElectionConfig
+	NodeUpdateRetry NodeUpdateRetryConfig
+	IMDSRetry       IMDSRetryConfig
+	StateStore      StateStoreConfig
+	Cordon          CordonConfig
 	KubeConfig      *rest.Config
 	NodeName        string
 	EnableTracing   bool
+	Tracing         TracingConfig
+	LogFilePath     string
+	LogMaxSizeMB    int
+	// LogFormat is the format log entries are written in, json or console
+	LogFormat string
+	// LogLevel is the minimum level logged. When it's empty, the level follows RuntimeEnv.
+	LogLevel      string
+	MinEventLevel string
+	// ValidateStartupConditions cross-checks scheduled event conditions against IMDS on the first reconcile so a stale
+	// condition left over from before mechanic started doesn't trigger a cordon
+	ValidateStartupConditions bool
+	// ReconcileCordonMarkers fixes disagreements between the mechanic cordon label and annotations and the node's
+	// spec.unschedulable before the cordon is validated
+	ReconcileCordonMarkers bool
+	// AdminListenAddress is the address the admin HTTP server listens on. Empty disables the server.
+	AdminListenAddress string
+	// IMDSSnapshotStaleAfter is how old the stored IMDS response can get before the admin endpoint labels it stale
+	IMDSSnapshotStaleAfter time.Duration
+	// MaxIMDSDataAge is how old the IMDS data behind a scheduled event trigger can be when the node is cordoned and
+	// drained. Older data is refreshed first, and the action is deferred when the refresh doesn't confirm the event.
+	// Zero disables the check.
+	MaxIMDSDataAge time.Duration
+	// IMDSTimeout bounds each IMDS scheduled events request. Zero means no timeout.
+	IMDSTimeout time.Duration
+	// IMDSAPIVersion is the scheduled events api-version used when negotiation is off or fails
+	IMDSAPIVersion string
+	// NegotiateIMDSAPIVersion uses the newest api-version IMDS advertises instead of IMDSAPIVersion
+	NegotiateIMDSAPIVersion bool
+	// MaxEventsPerResponse caps how many events are parsed from an IMDS response. Zero means no cap.
+	MaxEventsPerResponse int
+	// AckEventAfterDrain approves the scheduled event with IMDS once the node is drained so the maintenance can start
+	// early
+	AckEventAfterDrain bool
+	// RequireAPIConnectivityBeforeAction checks the apiserver can be reached before cordoning or draining, deferring
+	// the action when it can't rather than starting one that can only be half completed
+	RequireAPIConnectivityBeforeAction bool
+	// ShutdownTimeout bounds how long mechanic waits for its subsystems to stop on shutdown. Zero means no timeout.
+	ShutdownTimeout time.Duration
+	// UncordonOnShutdown releases mechanic's cordon when the agent shuts down and the node no longer has anything we'd
+	// drain for, so a cordon for an event that never happened isn't left behind when mechanic is removed
+	UncordonOnShutdown bool
+	// LogDecisions writes a single log line at the end of each reconcile with everything the decision was based on
+	LogDecisions bool
+	// MaintenanceTaints are taints that trigger a cordon and drain like a scheduled event does. Mechanic's cordon is
+	// released once none of them are left on the node.
+	MaintenanceTaints []MaintenanceTaint
+	// ReconcileWorkers is how many nodes are reconciled at once. A node is only ever reconciled by one worker at a time,
+	// so while the agent only watches its own node, workers past the first sit idle.
+	ReconcileWorkers int
+	// ReconcileMaxRetries is how many times a failed reconcile is retried without waiting for the next node update
+	ReconcileMaxRetries int
+	// ReconcileRetryBaseDelay is the wait before the first reconcile retry. It doubles with each retry after that, up to
+	// ReconcileRetryMaxDelay.
+	ReconcileRetryBaseDelay time.Duration
+	// ReconcileRetryMaxDelay caps the wait between reconcile retries
+	ReconcileRetryMaxDelay time.Duration
+	// SafeMode cordons nodes and records the drains mechanic would make without running them, until it's promoted for
+	// the node with the mechanic.io/safe-mode-promoted annotation, set directly or through the admin endpoint, or turned
+	// off
+	SafeMode bool
+	// MetricsPort is the port the Prometheus /metrics endpoint is served on. Zero disables the endpoint.
+	MetricsPort int
+	// HealthPort is the port the /healthz and /readyz probe endpoints are served on. Zero disables the endpoints.
+	HealthPort int
+	// AnnotateMaintenanceDescription records the platform's description of the scheduled event behind a cordon in the
+	// mechanic.io/maintenance-description node annotation
+	AnnotateMaintenanceDescription bool
+	// CombineTriggers checks IMDS for a scheduled event even when a GPU health condition or maintenance taint already
+	// calls for a drain, so the more urgent of the node's triggers drives the drain and the others are recorded with it
+	CombineTriggers bool
+	// Settings are the settings as last resolved, at startup or by a reload, before they were built into the rest of the
+	// configuration. After a reload, settings that need a restart show the value they'll take after it.
+	Settings []Setting `json:"-"`
+	// PollingInterval is how often the node is reconciled when nothing on it has changed, so scheduled events are picked
+	// up without waiting for a node update. Each wait is jittered. Zero only reconciles on node updates.
+	PollingInterval time.Duration
+	// PollingStartupJitter is the most the polling loop's first wait is lengthened by, a random amount each start, so
+	// agents restarted together by a rollout don't all query IMDS at once. Zero starts polling without a delay.
+	PollingStartupJitter time.Duration
+	// DrainedVerifyInterval is how often a node we've cordoned and drained is checked against the apiserver to make
+	// sure it's still cordoned, instead of trusting our state. A node uncordoned by someone else is cordoned and drained
+	// again. Zero always trusts the state.
+	DrainedVerifyInterval time.Duration
+	// AlsoPollIMDS checks IMDS for scheduled events targeting the node on every reconcile, even when no node condition
+	// reports one, so events are drained for on clusters where nothing publishes the scheduled event conditions
+	AlsoPollIMDS bool
+	// IMDSPollInterval is how often the node is reconciled to check IMDS when AlsoPollIMDS is set. Each wait is
+	// jittered.
+	IMDSPollInterval time.Duration
 }
 
 func ReadConfiguration(ctx context.Context) (Config, error) {
@@ -42,26 +431,31 @@ func ReadConfiguration(ctx context.Context) (Config, error) {
 	log.Debugw("Generating app config")
 
 	config := viper.New()
-
-	// set defaults for the config
-	config.SetDefault("DRAIN_ON_FREEZE", false)
-	config.SetDefault("DRAIN_ON_REBOOT", false)
-	config.SetDefault("DRAIN_ON_REDEPLOY", true)
-	config.SetDefault("DRAIN_ON_PREEMPT", true)
-	config.SetDefault("DRAIN_ON_TERMINATE", true)
-	config.SetDefault("ENABLE_TRACING", true)
-	config.SetDefault("RUNTIME_ENV", "prod")
+	setDefaults(config)
 
 	// set viper to watch for a mounted config file and read it in, handling the error gracefully if it's missing
-	config.SetConfigName("mechanic")
-	config.AddConfigPath("/etc/mechanic")
-	config.SetConfigType("yaml")
+	addConfigFile(config)
+	// every setting has a default, so anything else in the file is a typo or a setting we don't support
+	known := append(config.AllKeys(), "node_name")
 	if err := config.ReadInConfig(); err != nil {
 		log.Warnw("Failed to read in config file, proceeding with default values and environment variables", "error", err)
+	} else if unknown := unknownKeys(config, known); len(unknown) > 0 {
+		if config.GetBool("STRICT_CONFIG") {
+			log.Errorw("Config file has unrecognized settings", "file", config.ConfigFileUsed(), "settings", unknown)
+			return Config{}, fmt.Errorf("config file %s has unrecognized settings: %s", config.ConfigFileUsed(), strings.Join(unknown, ", "))
+		}
+		log.Warnw("Config file has unrecognized settings, they're ignored. Check them for typos.", "file", config.ConfigFileUsed(), "settings", unknown)
 	}
 
-	config.SetEnvPrefix("MECHANIC")
-	config.BindEnv("NODE_NAME")
+	bindEnv(config)
+
+	// without a valid node name there's nothing to watch, so it's checked before anything else
+	nodeName, source, err := resolveNodeName(config.GetStringSlice("NODE_NAME_SOURCES"), newNodeNameLookup(config))
+	if err != nil {
+		log.Errorw("Failed to determine the node name", "error", err)
+		return Config{}, err
+	}
+	log.Infow("Resolved the node name", "node", nodeName, "source", source)
 
 	kc, err := rest.InClusterConfig()
 	if err != nil {
@@ -70,54 +464,569 @@ func ReadConfiguration(ctx context.Context) (Config, error) {
 	}
 
 	// build our config for handling different drain conditions
-	drainConfig := buildDrainConditions(config)
+	drainConditions := buildDrainConditions(config)
+	if err := validateLiveMigrationPatterns(drainConditions.LiveMigrationPatterns); err != nil {
+		log.Errorw("Invalid live migration pattern configuration", "error", err)
+		return Config{}, err
+	}
+	drainConfig, err := buildDrainConfig(config)
+	if err != nil {
+		log.Errorw("Invalid drain configuration", "error", err)
+		return Config{}, err
+	}
+	cordon, err := buildCordonConfig(config)
+	if err != nil {
+		log.Errorw("Invalid cordon label or taint configuration", "error", err)
+		return Config{}, err
+	}
+	stateStore, err := buildStateStoreConfig(config)
+	if err != nil {
+		log.Errorw("Invalid state store configuration", "error", err)
+		return Config{}, err
+	}
 
 	log.Debugw("Successfully read configuration", "config", config.AllSettings())
 
 	return Config{
-		DrainConditions: drainConfig,
+		DrainConditions: drainConditions,
+		Drain:           drainConfig,
+		DrainEscalation: buildDrainEscalationConfig(config),
+		GPUHealth:       buildGPUHealthConfig(config),
+		Pause:           buildPauseConfig(config),
+		UpgradeSignal:   buildUpgradeSignalConfig(config),
+		LeaderElection:  buildLeaderElectionConfig(config),
+		NodeUpdateRetry: buildNodeUpdateRetryConfig(config),
+		IMDSRetry:       buildIMDSRetryConfig(config),
+		StateStore:      stateStore,
+		Cordon:          cordon,
 		KubeConfig:      kc,
-		NodeName:        config.Get("NODE_NAME").(string),
+		NodeName:        nodeName,
 		EnableTracing:   config.GetBool("ENABLE_TRACING"),
+		Tracing:         buildTracingConfig(config),
 		RuntimeEnv:      config.Get("RUNTIME_ENV").(string),
+		LogFilePath:     config.GetString("LOG_FILE_PATH"),
+		LogMaxSizeMB:    config.GetInt("LOG_MAX_SIZE_MB"),
+		LogFormat:       config.GetString("LOG_FORMAT"),
+		LogLevel:        config.GetString("LOG_LEVEL"),
+		MinEventLevel:   config.GetString("MIN_EVENT_LEVEL"),
+
+		ValidateStartupConditions: config.GetBool("VALIDATE_STARTUP_CONDITIONS"),
+		ReconcileCordonMarkers:    config.GetBool("RECONCILE_CORDON_MARKERS"),
+		AdminListenAddress:        config.GetString("ADMIN_LISTEN_ADDRESS"),
+		IMDSSnapshotStaleAfter:    time.Duration(config.GetInt("IMDS_SNAPSHOT_STALE_SECONDS")) * time.Second,
+		MaxIMDSDataAge:            time.Duration(config.GetInt("MAX_IMDS_DATA_AGE_SECONDS")) * time.Second,
+		IMDSTimeout:               time.Duration(config.GetInt("IMDS_TIMEOUT_SECONDS")) * time.Second,
+		IMDSAPIVersion:            config.GetString("IMDS_API_VERSION"),
+		NegotiateIMDSAPIVersion:   config.GetBool("NEGOTIATE_IMDS_API_VERSION"),
+		MaxEventsPerResponse:      config.GetInt("MAX_EVENTS_PER_RESPONSE"),
+		ShutdownTimeout:           time.Duration(config.GetInt("SHUTDOWN_TIMEOUT_SECONDS")) * time.Second,
+		UncordonOnShutdown:        config.GetBool("UNCORDON_ON_SHUTDOWN"),
+
+		RequireAPIConnectivityBeforeAction: config.GetBool("REQUIRE_API_CONNECTIVITY_BEFORE_ACTION"),
+		AckEventAfterDrain:                 config.GetBool("ACK_EVENT_AFTER_DRAIN"),
+		LogDecisions:                       config.GetBool("LOG_DECISIONS"),
+		MaintenanceTaints:                  buildMaintenanceTaints(config),
+		ReconcileWorkers:                   config.GetInt("RECONCILE_WORKERS"),
+		ReconcileMaxRetries:                config.GetInt("RECONCILE_MAX_RETRIES"),
+		ReconcileRetryBaseDelay:            time.Duration(config.GetInt("RECONCILE_RETRY_BASE_DELAY_SECONDS")) * time.Second,
+		ReconcileRetryMaxDelay:             time.Duration(config.GetInt("RECONCILE_RETRY_MAX_DELAY_SECONDS")) * time.Second,
+		SafeMode:                           config.GetBool("SAFE_MODE"),
+		MetricsPort:                        config.GetInt("METRICS_PORT"),
+		HealthPort:                         config.GetInt("HEALTH_PORT"),
+		PollingInterval:                    time.Duration(config.GetInt("POLLING_INTERVAL_SECONDS")) * time.Second,
+		PollingStartupJitter:               time.Duration(config.GetInt("POLLING_STARTUP_JITTER_SECONDS")) * time.Second,
+		AnnotateMaintenanceDescription:     config.GetBool("ANNOTATE_MAINTENANCE_DESCRIPTION"),
+		CombineTriggers:                    config.GetBool("COMBINE_TRIGGERS"),
+		AlsoPollIMDS:                       config.GetBool("ALSO_POLL_IMDS"),
+		DrainedVerifyInterval:              time.Duration(config.GetInt("DRAINED_VERIFY_INTERVAL_SECONDS")) * time.Second,
+		IMDSPollInterval:                   time.Duration(config.GetInt("IMDS_POLL_INTERVAL_SECONDS")) * time.Second,
+		Settings:                           effectiveSettings(config),
 	}, nil
 }
 
+// OperatingMode returns how the configuration has mechanic find out about changes to the node, OperatingModeHybrid when
+// polling or IMDS polling is enabled and OperatingModeInformer otherwise
+func (c Config) OperatingMode() string {
+	if c.PollingInterval > 0 || c.AlsoPollIMDS {
+		return OperatingModeHybrid
+	}
+	return OperatingModeInformer
+}
+
+// setDefaults sets the default for every setting. Settings missing from the config file and environment use these.
+func setDefaults(config *viper.Viper) {
+	config.SetDefault("DRAIN_ON_FREEZE", false)
+	config.SetDefault("DRAIN_ON_REBOOT", false)
+	config.SetDefault("DRAIN_ON_REDEPLOY", true)
+	config.SetDefault("DRAIN_ON_PREEMPT", true)
+	config.SetDefault("DRAIN_ON_TERMINATE", true)
+	config.SetDefault("TREAT_EMPTY_RESOURCES_AS_IMPACTING", false)
+	config.SetDefault("IGNORE_STARTED_EVENTS", false)
+	config.SetDefault("IMPACTING_RESOURCE_TYPES", DefaultImpactingResourceTypes)
+	config.SetDefault("MATCH_RESOURCES_OF_ANY_TYPE", true)
+	config.SetDefault("SCHEDULED_EVENTS_LEAD_TIME_SECONDS", 0)
+	config.SetDefault("LEAD_TIME_EXEMPT_SEVERITY", "")
+	config.SetDefault("EVENT_SEVERITIES", map[string]string{})
+	config.SetDefault("LIVE_MIGRATION_PATTERNS", DefaultLiveMigrationPatterns)
+	config.SetDefault("EVENT_CONDITION_OVERRIDES", map[string]string{})
+	config.SetDefault("DRAIN_TIMEOUT_SECONDS", 300)
+	config.SetDefault("DRAIN_TIMEOUTS_BY_REASON", map[string]int{})
+	config.SetDefault("DRAIN_SCALE_DOWN_SELECTOR", "")
+	config.SetDefault("DRAIN_SCALE_DOWN_WAIT_SECONDS", 60)
+	config.SetDefault("DRAIN_ENDPOINT_TIMEOUT_SECONDS", 0)
+	config.SetDefault("EVICTION_RATE_PER_SECOND", 0)
+	config.SetDefault("DRAIN_PROTECTED_EMPTYDIR_SELECTOR", "")
+	config.SetDefault("DRAIN_LOCAL_STORAGE_POLICY", LocalStoragePolicyForce)
+	config.SetDefault("EVENT_ON_EVICTED_PODS", false)
+	config.SetDefault("DRAIN_REPORT_OWNERS", false)
+	config.SetDefault("MIN_SCHEDULABLE_NODES", 0)
+	config.SetDefault("DRAIN_MAX_PER_HOUR", 0)
+	config.SetDefault("DRAIN_FORCE", true)
+	config.SetDefault("DRAIN_DELETE_EMPTYDIR_DATA", true)
+	config.SetDefault("DRAIN_IGNORE_ALL_DAEMONSETS", true)
+	config.SetDefault("DRAIN_RESPECT_PDBS", false)
+	config.SetDefault("DRAIN_MAX_RETRIES", 3)
+	config.SetDefault("DRAIN_BASE_DELAY_SECONDS", 2)
+	config.SetDefault("DRAIN_VERIFY_TIMEOUT_SECONDS", 30)
+	config.SetDefault("DRAIN_VERIFY_RETRIES", 3)
+	config.SetDefault("DRAIN_DISABLE_EVICTION", false)
+	config.SetDefault("DRAIN_ESCALATION_FORCE_AFTER_FAILURES", 0)
+	config.SetDefault("DRAIN_ESCALATION_FORCE_AFTER_SECONDS", 0)
+	config.SetDefault("DRAIN_ESCALATION_PAGE_AFTER_FAILURES", 0)
+	config.SetDefault("DRAIN_ESCALATION_PAGE_AFTER_SECONDS", 0)
+	config.SetDefault("GPU_HEALTH_CONDITIONS", []string{})
+	config.SetDefault("GPU_HEALTH_SUSTAINED_SECONDS", 300)
+	config.SetDefault("MAINTENANCE_TAINTS", []string{})
+	config.SetDefault("PAUSE_CONFIGMAP_NAME", "")
+	config.SetDefault("PAUSE_CONFIGMAP_NAMESPACE", "mechanic")
+	config.SetDefault("PAUSE_CONFIGMAP_KEY", "paused")
+	config.SetDefault("UPGRADE_SIGNAL_NODE_LABELS", []string{})
+	config.SetDefault("UPGRADE_SIGNAL_NODE_ANNOTATIONS", []string{})
+	config.SetDefault("UPGRADE_SIGNAL_CONFIGMAP_NAME", "")
+	config.SetDefault("UPGRADE_SIGNAL_CONFIGMAP_NAMESPACE", "kube-system")
+	config.SetDefault("NODE_UPDATE_RETRY_ATTEMPTS", 5)
+	config.SetDefault("NODE_UPDATE_RETRY_BASE_DELAY_MS", 200)
+	config.SetDefault("NODE_UPDATE_RETRY_MAX_DELAY_SECONDS", 10)
+	config.SetDefault("IMDS_RETRY_ATTEMPTS", 3)
+	config.SetDefault("IMDS_RETRY_BASE_DELAY_MS", 2000)
+	config.SetDefault("IMDS_RETRY_MAX_DELAY_SECONDS", 10)
+	config.SetDefault("CORDON_LABEL_KEY", "mechanic.cordoned")
+	config.SetDefault("CORDON_APPLY_TAINT", false)
+	config.SetDefault("CORDON_TAINT_KEY", "mechanic.io/cordoned")
+	config.SetDefault("STATE_STORE", StateStoreNode)
+	config.SetDefault("STATE_STORE_FILE_PATH", "/var/lib/mechanic/state.json")
+	config.SetDefault("STATE_STORE_CONFIGMAP_NAMESPACE", "mechanic")
+	config.SetDefault("ENABLE_LEADER_ELECTION", false)
+	config.SetDefault("LEADER_ELECTION_NAMESPACE", "mechanic")
+	config.SetDefault("LEADER_ELECTION_LEASE_DURATION_SECONDS", 15)
+	config.SetDefault("LEADER_ELECTION_RENEW_DEADLINE_SECONDS", 10)
+	config.SetDefault("LEADER_ELECTION_RETRY_PERIOD_SECONDS", 2)
+	config.SetDefault("ENABLE_TRACING", true)
+	config.SetDefault("TRACING_EXPORTER", "none")
+	config.SetDefault("TRACING_OTLP_ENDPOINT", "")
+	config.SetDefault("TRACING_OTLP_INSECURE", false)
+	config.SetDefault("TRACING_OTLP_PROTOCOL", "http")
+	config.SetDefault("TRACING_FILE_PATH", "")
+	config.SetDefault("RUNTIME_ENV", "prod")
+	// a container's hostname is the pod name, not the node name, so it's only tried when configured
+	config.SetDefault("NODE_NAME_SOURCES", []string{NodeNameSourceFlag, NodeNameSourceEnv, NodeNameSourceFile})
+	config.SetDefault("NODE_NAME_FILE", "")
+	config.SetDefault("STRICT_CONFIG", false)
+	config.SetDefault("LOG_FILE_PATH", "")
+	config.SetDefault("LOG_MAX_SIZE_MB", 100)
+	config.SetDefault("LOG_FORMAT", "json")
+	config.SetDefault("LOG_LEVEL", "")
+	config.SetDefault("MIN_EVENT_LEVEL", "normal")
+	config.SetDefault("VALIDATE_STARTUP_CONDITIONS", true)
+	config.SetDefault("RECONCILE_CORDON_MARKERS", true)
+	config.SetDefault("ADMIN_LISTEN_ADDRESS", "")
+	config.SetDefault("IMDS_SNAPSHOT_STALE_SECONDS", 300)
+	config.SetDefault("MAX_IMDS_DATA_AGE_SECONDS", 60)
+	config.SetDefault("IMDS_TIMEOUT_SECONDS", 5)
+	config.SetDefault("IMDS_API_VERSION", "2020-07-01")
+	config.SetDefault("NEGOTIATE_IMDS_API_VERSION", true)
+	config.SetDefault("MAX_EVENTS_PER_RESPONSE", 100)
+	config.SetDefault("SHUTDOWN_TIMEOUT_SECONDS", 30)
+	config.SetDefault("UNCORDON_ON_SHUTDOWN", false)
+	config.SetDefault("REQUIRE_API_CONNECTIVITY_BEFORE_ACTION", false)
+	config.SetDefault("ACK_EVENT_AFTER_DRAIN", false)
+	config.SetDefault("LOG_DECISIONS", true)
+	config.SetDefault("RECONCILE_WORKERS", 1)
+	config.SetDefault("RECONCILE_MAX_RETRIES", 5)
+	config.SetDefault("RECONCILE_RETRY_BASE_DELAY_SECONDS", 1)
+	config.SetDefault("RECONCILE_RETRY_MAX_DELAY_SECONDS", 60)
+	config.SetDefault("SAFE_MODE", false)
+	config.SetDefault("METRICS_PORT", 9090)
+	config.SetDefault("HEALTH_PORT", 8080)
+	config.SetDefault("POLLING_INTERVAL_SECONDS", 0)
+	config.SetDefault("POLLING_STARTUP_JITTER_SECONDS", 0)
+	config.SetDefault("ALSO_POLL_IMDS", false)
+	config.SetDefault("IMDS_POLL_INTERVAL_SECONDS", 60)
+	config.SetDefault("DRAINED_VERIFY_INTERVAL_SECONDS", 300)
+	config.SetDefault("ANNOTATE_MAINTENANCE_DESCRIPTION", true)
+	config.SetDefault("COMBINE_TRIGGERS", true)
+}
+
+// TracingEnabled reads just the ENABLE_TRACING setting, from MECHANIC_ENABLE_TRACING or the config file, defaulting to
+// true. Tracing is set up before the rest of the configuration is read so nothing is traced when it's disabled.
+func TracingEnabled() bool {
+	config := viper.New()
+	config.SetDefault("ENABLE_TRACING", true)
+	addConfigFile(config)
+	// a missing or unreadable file is reported when the full configuration is read
+	_ = config.ReadInConfig()
+	config.SetEnvPrefix("MECHANIC")
+	config.BindEnv("ENABLE_TRACING")
+	return config.GetBool("ENABLE_TRACING")
+}
+
+// addConfigFile points viper at the config file mounted from the mechanic config map
+func addConfigFile(config *viper.Viper) {
+	config.SetConfigName("mechanic")
+	config.AddConfigPath("/etc/mechanic")
+	config.SetConfigType("yaml")
+}
+
+// unknownKeys returns the top level settings in the config that aren't in known, sorted. Map settings like
+// EVENT_CONDITION_OVERRIDES are flattened by viper, so only the part of each key before the first dot is checked.
+func unknownKeys(config *viper.Viper, known []string) []string {
+	knownKeys := make(map[string]bool, len(known))
+	for _, key := range known {
+		knownKeys[strings.ToLower(key)] = true
+	}
+
+	seen := make(map[string]bool)
+	var unknown []string
+	for _, key := range config.AllKeys() {
+		top, _, _ := strings.Cut(key, ".")
+		if knownKeys[top] || seen[top] {
+			continue
+		}
+		seen[top] = true
+		unknown = append(unknown, top)
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
 // buildDrainConditions is a helper function that builds the DrainConditions struct from the mechanic config map in the cluster.
 // if no config is found, it will return a struct with default values that match the behavior indicated at
 // https://learn.microsoft.com/en-us/azure/aks/node-auto-repair#node-auto-drain
 func buildDrainConditions(config *viper.Viper) DrainConditions {
+	overrides := make(map[string]string)
+	for eventType, condition := range config.GetStringMapString("EVENT_CONDITION_OVERRIDES") {
+		if condition == "" {
+			continue
+		}
+		overrides[strings.ToLower(eventType)] = condition
+	}
+	severities := make(map[string]string)
+	for eventType, severity := range config.GetStringMapString("EVENT_SEVERITIES") {
+		severities[strings.ToLower(eventType)] = severity
+	}
+
 	return DrainConditions{
 		DrainOnFreeze:    config.GetBool("DRAIN_ON_FREEZE"),
 		DrainOnReboot:    config.GetBool("DRAIN_ON_REBOOT"),
 		DrainOnRedeploy:  config.GetBool("DRAIN_ON_REDEPLOY"),
 		DrainOnPreempt:   config.GetBool("DRAIN_ON_PREEMPT"),
 		DrainOnTerminate: config.GetBool("DRAIN_ON_TERMINATE"),
+
+		ConditionOverrides:             overrides,
+		IgnoreStartedEvents:            config.GetBool("IGNORE_STARTED_EVENTS"),
+		ImpactingResourceTypes:         config.GetStringSlice("IMPACTING_RESOURCE_TYPES"),
+		MatchResourcesOfAnyType:        config.GetBool("MATCH_RESOURCES_OF_ANY_TYPE"),
+		LeadTime:                       time.Duration(config.GetInt("SCHEDULED_EVENTS_LEAD_TIME_SECONDS")) * time.Second,
+		TreatEmptyResourcesAsImpacting: config.GetBool("TREAT_EMPTY_RESOURCES_AS_IMPACTING"),
+		Severities:                     severities,
+		LeadTimeExemptSeverity:         config.GetString("LEAD_TIME_EXEMPT_SEVERITY"),
+		LiveMigrationPatterns:          config.GetStringSlice("LIVE_MIGRATION_PATTERNS"),
+	}
+}
+
+// buildDrainConfig builds the DrainConfig struct from the mechanic config. A per-reason timeout that isn't a
+// non-negative number of seconds is an error naming the reason, as is an unknown local storage policy.
+func buildDrainConfig(config *viper.Viper) (DrainConfig, error) {
+	// an unset policy drains like force
+	localStoragePolicy := strings.ToLower(config.GetString("DRAIN_LOCAL_STORAGE_POLICY"))
+	switch localStoragePolicy {
+	case "", LocalStoragePolicyForce, LocalStoragePolicySkip, LocalStoragePolicyDefer:
+	default:
+		return DrainConfig{}, fmt.Errorf("DRAIN_LOCAL_STORAGE_POLICY %q is unknown, expected %s, %s, or %s", localStoragePolicy, LocalStoragePolicyForce, LocalStoragePolicySkip, LocalStoragePolicyDefer)
+	}
+
+	timeouts := make(map[string]time.Duration)
+	for reason, value := range config.GetStringMapString("DRAIN_TIMEOUTS_BY_REASON") {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 0 {
+			return DrainConfig{}, fmt.Errorf("DRAIN_TIMEOUTS_BY_REASON entry %s has timeout %q, expected a non-negative number of seconds", reason, value)
+		}
+		timeouts[strings.ToLower(reason)] = time.Duration(seconds) * time.Second
+	}
+
+	return DrainConfig{
+		Timeout:           time.Duration(config.GetInt("DRAIN_TIMEOUT_SECONDS")) * time.Second,
+		TimeoutsByReason:  timeouts,
+		ScaleDownSelector: config.GetString("DRAIN_SCALE_DOWN_SELECTOR"),
+		ScaleDownWait:     time.Duration(config.GetInt("DRAIN_SCALE_DOWN_WAIT_SECONDS")) * time.Second,
+
+		EndpointDrainTimeout:      time.Duration(config.GetInt("DRAIN_ENDPOINT_TIMEOUT_SECONDS")) * time.Second,
+		EvictionRatePerSecond:     config.GetFloat64("EVICTION_RATE_PER_SECOND"),
+		ProtectedEmptyDirSelector: config.GetString("DRAIN_PROTECTED_EMPTYDIR_SELECTOR"),
+		LocalStoragePolicy:        localStoragePolicy,
+		EventOnEvictedPods:        config.GetBool("EVENT_ON_EVICTED_PODS"),
+		ReportOwners:              config.GetBool("DRAIN_REPORT_OWNERS"),
+		MinSchedulableNodes:       config.GetInt("MIN_SCHEDULABLE_NODES"),
+		MaxDrainsPerHour:          config.GetInt("DRAIN_MAX_PER_HOUR"),
+		Force:                     config.GetBool("DRAIN_FORCE"),
+		DeleteEmptyDirData:        config.GetBool("DRAIN_DELETE_EMPTYDIR_DATA"),
+		IgnoreAllDaemonSets:       config.GetBool("DRAIN_IGNORE_ALL_DAEMONSETS"),
+		RespectPDBs:               config.GetBool("DRAIN_RESPECT_PDBS"),
+		MaxRetries:                config.GetInt("DRAIN_MAX_RETRIES"),
+		BaseDelay:                 time.Duration(config.GetInt("DRAIN_BASE_DELAY_SECONDS")) * time.Second,
+		VerifyTimeout:             time.Duration(config.GetInt("DRAIN_VERIFY_TIMEOUT_SECONDS")) * time.Second,
+		VerifyRetries:             config.GetInt("DRAIN_VERIFY_RETRIES"),
+		DisableEviction:           config.GetBool("DRAIN_DISABLE_EVICTION"),
+	}, nil
+}
+
+// buildDrainEscalationConfig reads the drain escalation steps from the viper config
+func buildDrainEscalationConfig(v *viper.Viper) DrainEscalationConfig {
+	return DrainEscalationConfig{
+		Force: EscalationStep{
+			AfterFailures: v.GetInt("DRAIN_ESCALATION_FORCE_AFTER_FAILURES"),
+			After:         time.Duration(v.GetInt("DRAIN_ESCALATION_FORCE_AFTER_SECONDS")) * time.Second,
+		},
+		Page: EscalationStep{
+			AfterFailures: v.GetInt("DRAIN_ESCALATION_PAGE_AFTER_FAILURES"),
+			After:         time.Duration(v.GetInt("DRAIN_ESCALATION_PAGE_AFTER_SECONDS")) * time.Second,
+		},
+	}
+}
+
+// TimeoutFor returns the drain timeout for the given reason (an event type like "Reboot" or a node condition type),
+// falling back to the global drain timeout when no override is configured.
+func (dc DrainConfig) TimeoutFor(reason string) time.Duration {
+	if timeout, ok := dc.TimeoutsByReason[strings.ToLower(reason)]; ok {
+		return timeout
+	}
+	return dc.Timeout
+}
+
+// DefaultImpactingResourceTypes are the scheduled event resource types that can target a node when none are configured
+var DefaultImpactingResourceTypes = []string{"VirtualMachine"}
+
+// DefaultLiveMigrationPatterns match the description IMDS gives Freeze events for memory-preserving live migrations when
+// no patterns are configured
+var DefaultLiveMigrationPatterns = []string{"memory-preserving Live Migration"}
+
+// validateLiveMigrationPatterns checks that each live migration pattern is a valid regular expression
+func validateLiveMigrationPatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("LIVE_MIGRATION_PATTERNS entry %q is invalid: %w", pattern, err)
+		}
 	}
+	return nil
 }
 
+// IsImpactingResourceType reports whether events for the given resource type are checked against the node. Resource
+// types are matched case-insensitively.
+func (dc *DrainConditions) IsImpactingResourceType(resourceType string) bool {
+	resourceTypes := dc.ImpactingResourceTypes
+	if len(resourceTypes) == 0 {
+		resourceTypes = DefaultImpactingResourceTypes
+	}
+	for _, rt := range resourceTypes {
+		if strings.EqualFold(rt, resourceType) {
+			return true
+		}
+	}
+	return false
+}
+
+// ConditionFor returns the node condition type that signals a scheduled event of the given type, using the configured
+// override when there is one.
+func (dc *DrainConditions) ConditionFor(eventType string) string {
+	if condition, ok := dc.ConditionOverrides[strings.ToLower(eventType)]; ok {
+		return condition
+	}
+	return eventType + "Scheduled"
+}
+
+// DrainsFor reports whether scheduled events of the given type are configured to trigger a drain. Event types are
+// matched case-insensitively and unknown types are never drained for.
+func (dc *DrainConditions) DrainsFor(eventType string) bool {
+	switch strings.ToLower(eventType) {
+	case "freeze":
+		return dc.DrainOnFreeze
+	case "reboot":
+		return dc.DrainOnReboot
+	case "redeploy":
+		return dc.DrainOnRedeploy
+	case "preempt":
+		return dc.DrainOnPreempt
+	case "terminate":
+		return dc.DrainOnTerminate
+	default:
+		return false
+	}
+}
+
+// DrainableConditions returns the node condition types that signal a scheduled event we drain for. The generic
+// VMEventScheduled condition is always included.
 func (dc *DrainConditions) DrainableConditions() []string {
-	drainableConditions := []string{}
+	drainableConditions := []string{string(consts.VMEvent)}
 
 	if dc.DrainOnFreeze {
-		drainableConditions = append(drainableConditions, "Freeze")
+		drainableConditions = append(drainableConditions, dc.ConditionFor("Freeze"))
 	}
 
 	if dc.DrainOnReboot {
-		drainableConditions = append(drainableConditions, "Reboot")
+		drainableConditions = append(drainableConditions, dc.ConditionFor("Reboot"))
 	}
 
 	if dc.DrainOnRedeploy {
-		drainableConditions = append(drainableConditions, "Redeploy")
+		drainableConditions = append(drainableConditions, dc.ConditionFor("Redeploy"))
 	}
 
 	if dc.DrainOnPreempt {
-		drainableConditions = append(drainableConditions, "Preempt")
+		drainableConditions = append(drainableConditions, dc.ConditionFor("Preempt"))
 	}
 
 	if dc.DrainOnTerminate {
-		drainableConditions = append(drainableConditions, "Terminate")
+		drainableConditions = append(drainableConditions, dc.ConditionFor("Terminate"))
 	}
 
 	return drainableConditions
 }
+
+// buildGPUHealthConfig reads the GPU health conditions and the sustained duration gate from the viper config
+func buildGPUHealthConfig(v *viper.Viper) GPUHealthConfig {
+	return GPUHealthConfig{
+		Conditions:   v.GetStringSlice("GPU_HEALTH_CONDITIONS"),
+		SustainedFor: time.Duration(v.GetInt("GPU_HEALTH_SUSTAINED_SECONDS")) * time.Second,
+	}
+}
+
+// buildMaintenanceTaints parses the maintenance taints, each written as key[=value][:effect] like kubectl taint takes
+// them. Entries without a key are skipped.
+func buildMaintenanceTaints(v *viper.Viper) []MaintenanceTaint {
+	var taints []MaintenanceTaint
+	for _, entry := range v.GetStringSlice("MAINTENANCE_TAINTS") {
+		entry = strings.TrimSpace(entry)
+		var taint MaintenanceTaint
+		if i := strings.LastIndex(entry, ":"); i >= 0 {
+			taint.Effect = entry[i+1:]
+			entry = entry[:i]
+		}
+		taint.Key, taint.Value, _ = strings.Cut(entry, "=")
+		if taint.Key == "" {
+			continue
+		}
+		taints = append(taints, taint)
+	}
+	return taints
+}
+
+// buildPauseConfig reads the location of the pause ConfigMap from the viper config
+func buildPauseConfig(v *viper.Viper) PauseConfig {
+	return PauseConfig{
+		Name:      v.GetString("PAUSE_CONFIGMAP_NAME"),
+		Namespace: v.GetString("PAUSE_CONFIGMAP_NAMESPACE"),
+		Key:       v.GetString("PAUSE_CONFIGMAP_KEY"),
+	}
+}
+
+// buildUpgradeSignalConfig reads the cluster upgrade signals from the viper config
+func buildUpgradeSignalConfig(v *viper.Viper) UpgradeSignalConfig {
+	return UpgradeSignalConfig{
+		NodeLabels:         v.GetStringSlice("UPGRADE_SIGNAL_NODE_LABELS"),
+		NodeAnnotations:    v.GetStringSlice("UPGRADE_SIGNAL_NODE_ANNOTATIONS"),
+		ConfigMapName:      v.GetString("UPGRADE_SIGNAL_CONFIGMAP_NAME"),
+		ConfigMapNamespace: v.GetString("UPGRADE_SIGNAL_CONFIGMAP_NAMESPACE"),
+	}
+}
+
+// buildIMDSRetryConfig reads the IMDS query retry settings from the viper config
+func buildIMDSRetryConfig(v *viper.Viper) IMDSRetryConfig {
+	return IMDSRetryConfig{
+		Attempts:  v.GetInt("IMDS_RETRY_ATTEMPTS"),
+		BaseDelay: time.Duration(v.GetInt("IMDS_RETRY_BASE_DELAY_MS")) * time.Millisecond,
+		MaxDelay:  time.Duration(v.GetInt("IMDS_RETRY_MAX_DELAY_SECONDS")) * time.Second,
+	}
+}
+
+// buildNodeUpdateRetryConfig reads the node update retry settings from the viper config
+func buildNodeUpdateRetryConfig(v *viper.Viper) NodeUpdateRetryConfig {
+	return NodeUpdateRetryConfig{
+		Attempts:  v.GetInt("NODE_UPDATE_RETRY_ATTEMPTS"),
+		BaseDelay: time.Duration(v.GetInt("NODE_UPDATE_RETRY_BASE_DELAY_MS")) * time.Millisecond,
+		MaxDelay:  time.Duration(v.GetInt("NODE_UPDATE_RETRY_MAX_DELAY_SECONDS")) * time.Second,
+	}
+}
+
+// buildCordonConfig reads how mechanic marks its cordons from the viper config. A label key, or a taint key when the
+// taint is applied, that isn't a valid qualified name is an error.
+func buildCordonConfig(v *viper.Viper) (CordonConfig, error) {
+	cfg := CordonConfig{
+		LabelKey:   strings.TrimSpace(v.GetString("CORDON_LABEL_KEY")),
+		ApplyTaint: v.GetBool("CORDON_APPLY_TAINT"),
+		TaintKey:   strings.TrimSpace(v.GetString("CORDON_TAINT_KEY")),
+	}
+	if errs := validation.IsQualifiedName(cfg.LabelKey); len(errs) > 0 {
+		return CordonConfig{}, fmt.Errorf("CORDON_LABEL_KEY %q is invalid: %s", cfg.LabelKey, strings.Join(errs, ", "))
+	}
+	if cfg.ApplyTaint {
+		if errs := validation.IsQualifiedName(cfg.TaintKey); len(errs) > 0 {
+			return CordonConfig{}, fmt.Errorf("CORDON_TAINT_KEY %q is invalid: %s", cfg.TaintKey, strings.Join(errs, ", "))
+		}
+	}
+	return cfg, nil
+}
+
+// buildStateStoreConfig reads the state persistence settings from the viper config. An unknown backend, or a backend
+// missing the setting it needs, is an error.
+func buildStateStoreConfig(v *viper.Viper) (StateStoreConfig, error) {
+	cfg := StateStoreConfig{
+		Backend:            strings.ToLower(v.GetString("STATE_STORE")),
+		FilePath:           v.GetString("STATE_STORE_FILE_PATH"),
+		ConfigMapNamespace: v.GetString("STATE_STORE_CONFIGMAP_NAMESPACE"),
+	}
+	switch cfg.Backend {
+	case StateStoreNode, StateStoreNone, "":
+	case StateStoreFile:
+		if cfg.FilePath == "" {
+			return StateStoreConfig{}, fmt.Errorf("STATE_STORE %s needs STATE_STORE_FILE_PATH", cfg.Backend)
+		}
+	case StateStoreConfigMap:
+		if cfg.ConfigMapNamespace == "" {
+			return StateStoreConfig{}, fmt.Errorf("STATE_STORE %s needs STATE_STORE_CONFIGMAP_NAMESPACE", cfg.Backend)
+		}
+	default:
+		return StateStoreConfig{}, fmt.Errorf("STATE_STORE %q is unknown, expected %s, %s, %s, or %s", cfg.Backend, StateStoreNode, StateStoreFile, StateStoreConfigMap, StateStoreNone)
+	}
+	return cfg, nil
+}
+
+// buildLeaderElectionConfig reads the leader election settings from the viper config
+func buildLeaderElectionConfig(v *viper.Viper) LeaderElectionConfig {
+	return LeaderElectionConfig{
+		Enabled:       v.GetBool("ENABLE_LEADER_ELECTION"),
+		Namespace:     v.GetString("LEADER_ELECTION_NAMESPACE"),
+		LeaseDuration: time.Duration(v.GetInt("LEADER_ELECTION_LEASE_DURATION_SECONDS")) * time.Second,
+		RenewDeadline: time.Duration(v.GetInt("LEADER_ELECTION_RENEW_DEADLINE_SECONDS")) * time.Second,
+		RetryPeriod:   time.Duration(v.GetInt("LEADER_ELECTION_RETRY_PERIOD_SECONDS")) * time.Second,
+	}
+}
+
+// buildTracingConfig reads the trace exporter selection and its settings from the viper config
+func buildTracingConfig(v *viper.Viper) TracingConfig {
+	return TracingConfig{
+		Exporter:     strings.ToLower(v.GetString("TRACING_EXPORTER")),
+		OTLPEndpoint: v.GetString("TRACING_OTLP_ENDPOINT"),
+		OTLPInsecure: v.GetBool("TRACING_OTLP_INSECURE"),
+		OTLPProtocol: strings.ToLower(v.GetString("TRACING_OTLP_PROTOCOL")),
+		FilePath:     v.GetString("TRACING_FILE_PATH"),
+	}
+}
@@ -0,0 +1,324 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDrainConfigTimeoutFor(t *testing.T) {
+	v := viper.New()
+	v.Set("DRAIN_TIMEOUT_SECONDS", 300)
+	v.Set("DRAIN_TIMEOUTS_BY_REASON", map[string]interface{}{
+		"Reboot":  900,
+		"preempt": 30,
+	})
+	dc, err := buildDrainConfig(v)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		reason   string
+		expected time.Duration
+	}{
+		{name: "override for reboot", reason: "Reboot", expected: 900 * time.Second},
+		{name: "override matched case-insensitively", reason: "Preempt", expected: 30 * time.Second},
+		{name: "no override uses global", reason: "Terminate", expected: 300 * time.Second},
+		{name: "empty reason uses global", reason: "", expected: 300 * time.Second},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, dc.TimeoutFor(tc.reason))
+		})
+	}
+}
+
+func TestBuildDrainConfigInvalidTimeoutByReason(t *testing.T) {
+	for _, value := range []interface{}{"not-a-number", -30} {
+		v := viper.New()
+		v.Set("DRAIN_TIMEOUTS_BY_REASON", map[string]interface{}{"Reboot": 900, "Freeze": value})
+
+		_, err := buildDrainConfig(v)
+		assert.ErrorContains(t, err, "DRAIN_TIMEOUTS_BY_REASON entry freeze")
+	}
+}
+
+func TestBuildDrainConfigLocalStoragePolicy(t *testing.T) {
+	for _, policy := range []string{"force", "Skip", "defer"} {
+		v := viper.New()
+		v.Set("DRAIN_LOCAL_STORAGE_POLICY", policy)
+
+		dc, err := buildDrainConfig(v)
+		require.NoError(t, err)
+		assert.Equal(t, strings.ToLower(policy), dc.LocalStoragePolicy)
+	}
+
+	v := viper.New()
+	v.Set("DRAIN_LOCAL_STORAGE_POLICY", "deffer")
+	_, err := buildDrainConfig(v)
+	assert.ErrorContains(t, err, `DRAIN_LOCAL_STORAGE_POLICY "deffer" is unknown`)
+}
+
+func TestDrainableConditions(t *testing.T) {
+	v := viper.New()
+	v.Set("DRAIN_ON_FREEZE", true)
+	v.Set("DRAIN_ON_PREEMPT", true)
+	v.Set("DRAIN_ON_TERMINATE", true)
+	v.Set("EVENT_CONDITION_OVERRIDES", map[string]interface{}{
+		"Preempt":   "SpotEvictionScheduled",
+		"terminate": "",
+		"Reboot":    "HostRebootScheduled",
+	})
+	dc := buildDrainConditions(v)
+
+	assert.Equal(t, "SpotEvictionScheduled", dc.ConditionFor("Preempt"))
+	assert.Equal(t, "TerminateScheduled", dc.ConditionFor("Terminate"), "empty overrides are ignored")
+	assert.Equal(t, "HostRebootScheduled", dc.ConditionFor("Reboot"))
+	assert.Equal(t, []string{"VMEventScheduled", "FreezeScheduled", "SpotEvictionScheduled", "TerminateScheduled"}, dc.DrainableConditions())
+
+	// without overrides, every event type maps to its default condition
+	defaults := DrainConditions{DrainOnReboot: true, DrainOnRedeploy: true}
+	assert.Equal(t, []string{"VMEventScheduled", "RebootScheduled", "RedeployScheduled"}, defaults.DrainableConditions())
+}
+
+func TestDrainsFor(t *testing.T) {
+	dc := DrainConditions{DrainOnReboot: true, DrainOnTerminate: true}
+
+	assert.True(t, dc.DrainsFor("Reboot"))
+	assert.True(t, dc.DrainsFor("terminate"))
+	assert.False(t, dc.DrainsFor("Freeze"))
+	assert.False(t, dc.DrainsFor("Redeploy"))
+	assert.False(t, dc.DrainsFor("Unknown"))
+}
+
+func TestImpactingResourceTypes(t *testing.T) {
+	v := viper.New()
+	v.SetDefault("IMPACTING_RESOURCE_TYPES", DefaultImpactingResourceTypes)
+	dc := buildDrainConditions(v)
+	assert.Equal(t, []string{"VirtualMachine"}, dc.ImpactingResourceTypes)
+	assert.True(t, dc.IsImpactingResourceType("VirtualMachine"))
+	assert.False(t, dc.IsImpactingResourceType("VirtualMachineScaleSet"))
+
+	v.Set("IMPACTING_RESOURCE_TYPES", []string{"VirtualMachine", "VirtualMachineScaleSet"})
+	dc = buildDrainConditions(v)
+	assert.True(t, dc.IsImpactingResourceType("virtualmachinescaleset"))
+	assert.False(t, dc.IsImpactingResourceType("Host"))
+
+	// events naming the instance under other resource types are matched unless turned off
+	v = viper.New()
+	setDefaults(v)
+	assert.True(t, buildDrainConditions(v).MatchResourcesOfAnyType)
+	v.Set("MATCH_RESOURCES_OF_ANY_TYPE", false)
+	assert.False(t, buildDrainConditions(v).MatchResourcesOfAnyType)
+}
+
+func TestBuildTracingConfig(t *testing.T) {
+	v := viper.New()
+	v.Set("TRACING_EXPORTER", "OTLP")
+	v.Set("TRACING_OTLP_ENDPOINT", "otel-collector:4318")
+	v.Set("TRACING_OTLP_INSECURE", true)
+
+	tc := buildTracingConfig(v)
+
+	assert.Equal(t, "otlp", tc.Exporter)
+	assert.Equal(t, "otel-collector:4318", tc.OTLPEndpoint)
+	assert.True(t, tc.OTLPInsecure)
+	assert.Empty(t, tc.FilePath)
+}
+
+func TestBuildMaintenanceTaints(t *testing.T) {
+	v := viper.New()
+	v.Set("MAINTENANCE_TAINTS", []string{
+		"maintenance=true:NoSchedule",
+		"example.com/upgrade:NoExecute",
+		"drain-me",
+		" node.example.com/maintenance=scheduled ",
+		"=true:NoSchedule",
+	})
+
+	taints := buildMaintenanceTaints(v)
+
+	assert.Equal(t, []MaintenanceTaint{
+		{Key: "maintenance", Value: "true", Effect: "NoSchedule"},
+		{Key: "example.com/upgrade", Effect: "NoExecute"},
+		{Key: "drain-me"},
+		{Key: "node.example.com/maintenance", Value: "scheduled"},
+	}, taints)
+	assert.Equal(t, "maintenance=true:NoSchedule", taints[0].String())
+	assert.Equal(t, "example.com/upgrade:NoExecute", taints[1].String())
+}
+
+func TestMaintenanceTaintMatches(t *testing.T) {
+	tests := []struct {
+		name     string
+		taint    MaintenanceTaint
+		key      string
+		value    string
+		effect   string
+		expected bool
+	}{
+		{name: "exact match", taint: MaintenanceTaint{Key: "maintenance", Value: "true", Effect: "NoSchedule"}, key: "maintenance", value: "true", effect: "NoSchedule", expected: true},
+		{name: "different value", taint: MaintenanceTaint{Key: "maintenance", Value: "true", Effect: "NoSchedule"}, key: "maintenance", value: "false", effect: "NoSchedule", expected: false},
+		{name: "different effect", taint: MaintenanceTaint{Key: "maintenance", Value: "true", Effect: "NoSchedule"}, key: "maintenance", value: "true", effect: "NoExecute", expected: false},
+		{name: "different key", taint: MaintenanceTaint{Key: "maintenance"}, key: "upgrade", value: "true", effect: "NoSchedule", expected: false},
+		{name: "any value and effect", taint: MaintenanceTaint{Key: "maintenance"}, key: "maintenance", value: "anything", effect: "PreferNoSchedule", expected: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.taint.Matches(tc.key, tc.value, tc.effect))
+		})
+	}
+}
+
+func TestBuildDrainConfigOptions(t *testing.T) {
+	v := viper.New()
+	v.Set("DRAIN_FORCE", false)
+	v.Set("DRAIN_DELETE_EMPTYDIR_DATA", false)
+	v.Set("DRAIN_IGNORE_ALL_DAEMONSETS", true)
+
+	dc, err := buildDrainConfig(v)
+	require.NoError(t, err)
+
+	assert.False(t, dc.Force)
+	assert.False(t, dc.DeleteEmptyDirData)
+	assert.True(t, dc.IgnoreAllDaemonSets)
+}
+
+func TestBuildDrainEscalationConfig(t *testing.T) {
+	v := viper.New()
+	v.Set("DRAIN_ESCALATION_FORCE_AFTER_FAILURES", 3)
+	v.Set("DRAIN_ESCALATION_PAGE_AFTER_SECONDS", 600)
+
+	ec := buildDrainEscalationConfig(v)
+
+	assert.Equal(t, EscalationStep{AfterFailures: 3}, ec.Force)
+	assert.Equal(t, EscalationStep{After: 10 * time.Minute}, ec.Page)
+}
+
+func TestEscalationStepReached(t *testing.T) {
+	tests := []struct {
+		name       string
+		step       EscalationStep
+		failures   int
+		failingFor time.Duration
+		expected   bool
+	}{
+		{name: "disabled step", step: EscalationStep{}, failures: 100, failingFor: time.Hour, expected: false},
+		{name: "failure count reached", step: EscalationStep{AfterFailures: 3}, failures: 3, expected: true},
+		{name: "failure count not reached", step: EscalationStep{AfterFailures: 3}, failures: 2, failingFor: time.Hour, expected: false},
+		{name: "duration reached", step: EscalationStep{After: time.Minute}, failures: 1, failingFor: time.Minute, expected: true},
+		{name: "either threshold", step: EscalationStep{AfterFailures: 5, After: time.Minute}, failures: 1, failingFor: 2 * time.Minute, expected: true},
+		{name: "no failures", step: EscalationStep{After: time.Minute}, failures: 0, failingFor: time.Hour, expected: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.step.Reached(tc.failures, tc.failingFor))
+		})
+	}
+}
+
+func TestUnknownKeys(t *testing.T) {
+	v := viper.New()
+	v.SetDefault("DRAIN_ON_REBOOT", false)
+	v.SetDefault("EVENT_CONDITION_OVERRIDES", map[string]string{})
+	v.SetDefault("DRAIN_TIMEOUTS_BY_REASON", map[string]int{})
+	known := append(v.AllKeys(), "node_name")
+
+	v.SetConfigType("yaml")
+	require.NoError(t, v.ReadConfig(strings.NewReader(`
+DRAIN_ON_REBOOT: true
+DRAIN_ON_REBOT: true
+NODE_NAME: aks-nodepool1-12345678-vmss000001
+EVENT_CONDITION_OVERRIDES:
+  reboot: CustomRebootScheduled
+SCHEDULEDEVENT:
+  leadTime: 60
+`)))
+
+	assert.Equal(t, []string{"drain_on_rebot", "scheduledevent"}, unknownKeys(v, known))
+}
+
+func TestUnknownKeysNone(t *testing.T) {
+	v := viper.New()
+	v.SetDefault("DRAIN_ON_REBOOT", false)
+	known := v.AllKeys()
+
+	v.SetConfigType("yaml")
+	require.NoError(t, v.ReadConfig(strings.NewReader("DRAIN_ON_REBOOT: true\n")))
+
+	assert.Empty(t, unknownKeys(v, known))
+}
+
+func TestBuildCordonConfig(t *testing.T) {
+	tests := []struct {
+		name       string
+		labelKey   string
+		applyTaint bool
+		taintKey   string
+		expectErr  bool
+	}{
+		{name: "default keys", labelKey: "mechanic.cordoned", applyTaint: true, taintKey: "mechanic.io/cordoned"},
+		{name: "prefixed label key", labelKey: "platform.example.com/mechanic-cordoned"},
+		{name: "empty label key", labelKey: "", expectErr: true},
+		{name: "invalid label key", labelKey: "not a label", expectErr: true},
+		{name: "invalid taint key", labelKey: "mechanic.cordoned", applyTaint: true, taintKey: "bad/key/here", expectErr: true},
+		{name: "taint key unused without the taint", labelKey: "mechanic.cordoned", taintKey: "bad/key/here"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			v := viper.New()
+			v.Set("CORDON_LABEL_KEY", tc.labelKey)
+			v.Set("CORDON_APPLY_TAINT", tc.applyTaint)
+			v.Set("CORDON_TAINT_KEY", tc.taintKey)
+
+			cfg, err := buildCordonConfig(v)
+			if tc.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, CordonConfig{LabelKey: tc.labelKey, ApplyTaint: tc.applyTaint, TaintKey: tc.taintKey}, cfg)
+		})
+	}
+}
+
+func TestBuildStateStoreConfig(t *testing.T) {
+	tests := []struct {
+		name      string
+		settings  map[string]string
+		expectErr bool
+	}{
+		{name: "node", settings: map[string]string{"STATE_STORE": "node"}},
+		{name: "backend matched case-insensitively", settings: map[string]string{"STATE_STORE": "None"}},
+		{name: "file", settings: map[string]string{"STATE_STORE": "file", "STATE_STORE_FILE_PATH": "/var/lib/mechanic/state.json"}},
+		{name: "file without a path", settings: map[string]string{"STATE_STORE": "file"}, expectErr: true},
+		{name: "configmap", settings: map[string]string{"STATE_STORE": "configmap", "STATE_STORE_CONFIGMAP_NAMESPACE": "mechanic"}},
+		{name: "configmap without a namespace", settings: map[string]string{"STATE_STORE": "configmap"}, expectErr: true},
+		{name: "unknown backend", settings: map[string]string{"STATE_STORE": "etcd"}, expectErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			v := viper.New()
+			for key, value := range tc.settings {
+				v.Set(key, value)
+			}
+
+			cfg, err := buildStateStoreConfig(v)
+			if tc.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, strings.ToLower(tc.settings["STATE_STORE"]), cfg.Backend)
+		})
+	}
+}
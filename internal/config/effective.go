@@ -0,0 +1,71 @@
+package config
+
+import (
+	"os"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// where a setting's value came from, from highest to lowest precedence
+const (
+	SettingSourceEnv     = "env"
+	SettingSourceFile    = "file"
+	SettingSourceDefault = "default"
+)
+
+// envPrefix is prepended to a setting's key to get the environment variable it's read from
+const envPrefix = "MECHANIC"
+
+// envKeys are the settings that can be set from the environment as well as the config file
+var envKeys = []string{"NODE_NAME", "ENABLE_TRACING"}
+
+// Setting is one setting as resolved from the environment, config file, and defaults, before it's built into the
+// Config. Map settings like EVENT_SEVERITIES are reported as a single setting.
+type Setting struct {
+	Key    string      `json:"key"`
+	Value  interface{} `json:"value"`
+	Source string      `json:"source"`
+}
+
+// bindEnv lets the settings in envKeys be set from MECHANIC_ prefixed environment variables
+func bindEnv(v *viper.Viper) {
+	v.SetEnvPrefix(envPrefix)
+	for _, key := range envKeys {
+		v.BindEnv(key)
+	}
+}
+
+// effectiveSettings returns every setting v resolved and where its value came from, sorted by key
+func effectiveSettings(v *viper.Viper) []Setting {
+	all := v.AllSettings()
+	settings := make([]Setting, 0, len(all))
+	for key, value := range all {
+		settings = append(settings, Setting{Key: strings.ToUpper(key), Value: value, Source: settingSource(v, key)})
+	}
+	sort.Slice(settings, func(i, j int) bool { return settings[i].Key < settings[j].Key })
+	return settings
+}
+
+// settingSource reports whether the setting's value came from the environment, the config file, or its default
+func settingSource(v *viper.Viper, key string) string {
+	upper := strings.ToUpper(key)
+	if slices.Contains(envKeys, upper) {
+		if _, ok := os.LookupEnv(envPrefix + "_" + upper); ok {
+			return SettingSourceEnv
+		}
+	}
+	if v.InConfig(key) {
+		return SettingSourceFile
+	}
+	return SettingSourceDefault
+}
+
+// Redacted returns a copy of the configuration that is safe to serve or log. The Kubernetes client configuration holds
+// credentials, so it's left out.
+func (c Config) Redacted() Config {
+	c.KubeConfig = nil
+	return c
+}
@@ -0,0 +1,54 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/rest"
+)
+
+func TestEffectiveSettings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mechanic.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("DRAIN_ON_REBOOT: true\nEVENT_SEVERITIES:\n  reboot: high\n"), 0o644))
+	t.Setenv("MECHANIC_ENABLE_TRACING", "false")
+	t.Setenv("MECHANIC_NODE_NAME", "test-vmss000001")
+	// only the settings in envKeys are read from the environment
+	t.Setenv("MECHANIC_DRAIN_ON_FREEZE", "true")
+
+	v := viper.New()
+	setDefaults(v)
+	v.SetConfigFile(path)
+	require.NoError(t, v.ReadInConfig())
+	bindEnv(v)
+
+	settings := make(map[string]Setting)
+	for _, setting := range effectiveSettings(v) {
+		settings[setting.Key] = setting
+	}
+
+	assert.Equal(t, Setting{Key: "DRAIN_ON_REBOOT", Value: true, Source: SettingSourceFile}, settings["DRAIN_ON_REBOOT"])
+	assert.Equal(t, Setting{Key: "EVENT_SEVERITIES", Value: map[string]interface{}{"reboot": "high"}, Source: SettingSourceFile}, settings["EVENT_SEVERITIES"])
+	assert.Equal(t, Setting{Key: "ENABLE_TRACING", Value: "false", Source: SettingSourceEnv}, settings["ENABLE_TRACING"])
+	assert.Equal(t, Setting{Key: "NODE_NAME", Value: "test-vmss000001", Source: SettingSourceEnv}, settings["NODE_NAME"])
+	assert.Equal(t, Setting{Key: "DRAIN_ON_FREEZE", Value: false, Source: SettingSourceDefault}, settings["DRAIN_ON_FREEZE"])
+	assert.Equal(t, Setting{Key: "DRAIN_TIMEOUT_SECONDS", Value: 300, Source: SettingSourceDefault}, settings["DRAIN_TIMEOUT_SECONDS"])
+	assert.NotContains(t, settings, "EVENT_SEVERITIES.REBOOT", "map settings are reported as one setting")
+}
+
+func TestRedacted(t *testing.T) {
+	cfg := Config{NodeName: "test-vmss000001", KubeConfig: &rest.Config{Host: "https://10.0.0.1", BearerToken: "secret"}}
+
+	redacted := cfg.Redacted()
+	assert.Nil(t, redacted.KubeConfig)
+	assert.Equal(t, "test-vmss000001", redacted.NodeName)
+	assert.NotNil(t, cfg.KubeConfig, "the original configuration is left alone")
+
+	body, err := json.Marshal(redacted)
+	require.NoError(t, err)
+	assert.NotContains(t, string(body), "secret")
+}
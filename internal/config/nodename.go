@@ -0,0 +1,112 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/viper"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// the places the node name can be read from, tried in this order
+const (
+	NodeNameSourceFlag     = "flag"
+	NodeNameSourceEnv      = "env"
+	NodeNameSourceFile     = "file"
+	NodeNameSourceHostname = "hostname"
+)
+
+// nodeNameSourceOrder is the precedence of the node name sources. The configured sources only pick which of them are
+// tried, not their order.
+var nodeNameSourceOrder = []string{NodeNameSourceFlag, NodeNameSourceEnv, NodeNameSourceFile, NodeNameSourceHostname}
+
+// NodeNameFlag is the node name passed on the command line with --node-name. It's set by main before the
+// configuration is read.
+var NodeNameFlag string
+
+// nodeNameLookup reads the node name from each source. Each func returns an empty string when its source doesn't have
+// a node name.
+type nodeNameLookup struct {
+	flag     func() string
+	env      func() string
+	file     func() (string, error)
+	hostname func() (string, error)
+}
+
+// newNodeNameLookup returns the lookup used by ReadConfiguration. The env source is the NODE_NAME config value, set by
+// MECHANIC_NODE_NAME or the config file, and the file source reads the file at NODE_NAME_FILE, usually a downward API
+// volume.
+func newNodeNameLookup(v *viper.Viper) nodeNameLookup {
+	return nodeNameLookup{
+		flag: func() string { return NodeNameFlag },
+		env:  func() string { return v.GetString("NODE_NAME") },
+		file: func() (string, error) {
+			path := v.GetString("NODE_NAME_FILE")
+			if path == "" {
+				return "", nil
+			}
+			b, err := os.ReadFile(path)
+			return string(b), err
+		},
+		hostname: os.Hostname,
+	}
+}
+
+// resolveNodeName returns the node name from the first allowed source that has one, along with the source it came from.
+// Sources that fail to read are skipped, and their errors are returned with the error when no source has a name.
+func resolveNodeName(allowed []string, lookup nodeNameLookup) (string, string, error) {
+	var errs []string
+	for _, source := range nodeNameSourceOrder {
+		if !containsFold(allowed, source) {
+			continue
+		}
+
+		var name string
+		var err error
+		switch source {
+		case NodeNameSourceFlag:
+			name = lookup.flag()
+		case NodeNameSourceEnv:
+			name = lookup.env()
+		case NodeNameSourceFile:
+			name, err = lookup.file()
+		case NodeNameSourceHostname:
+			name, err = lookup.hostname()
+		}
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", source, err))
+			continue
+		}
+		if name = strings.TrimSpace(name); name != "" {
+			if err := validateNodeName(name); err != nil {
+				return "", "", fmt.Errorf("node name %q from the %s source is invalid: %w", name, source, err)
+			}
+			return name, source, nil
+		}
+	}
+
+	if len(errs) > 0 {
+		return "", "", fmt.Errorf("no node name found in sources %v: %s", allowed, strings.Join(errs, "; "))
+	}
+	return "", "", fmt.Errorf("no node name found in sources %v", allowed)
+}
+
+// validateNodeName checks the name is one Kubernetes accepts for a node. The name is used as is in the informer's field
+// selector and in API paths, so a mistyped name is caught here instead of silently matching no node.
+func validateNodeName(name string) error {
+	if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(strings.TrimSpace(v), value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,97 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestResolveNodeName(t *testing.T) {
+	all := []string{NodeNameSourceFlag, NodeNameSourceEnv, NodeNameSourceFile, NodeNameSourceHostname}
+
+	tests := []struct {
+		name           string
+		allowed        []string
+		flag           string
+		env            string
+		file           string
+		fileErr        error
+		hostname       string
+		expectedName   string
+		expectedSource string
+		expectError    bool
+	}{
+		{name: "flag wins over everything", allowed: all, flag: "from-flag", env: "from-env", file: "from-file", hostname: "from-hostname", expectedName: "from-flag", expectedSource: NodeNameSourceFlag},
+		{name: "env used without a flag", allowed: all, env: "from-env", file: "from-file", hostname: "from-hostname", expectedName: "from-env", expectedSource: NodeNameSourceEnv},
+		{name: "file used without a flag or env", allowed: all, file: "from-file\n", hostname: "from-hostname", expectedName: "from-file", expectedSource: NodeNameSourceFile},
+		{name: "hostname is the last resort", allowed: all, hostname: "from-hostname", expectedName: "from-hostname", expectedSource: NodeNameSourceHostname},
+		{name: "unreadable file falls through", allowed: all, fileErr: errors.New("permission denied"), hostname: "from-hostname", expectedName: "from-hostname", expectedSource: NodeNameSourceHostname},
+		{name: "sources not allowed are skipped", allowed: []string{NodeNameSourceFile, NodeNameSourceHostname}, flag: "from-flag", env: "from-env", file: "from-file", expectedName: "from-file", expectedSource: NodeNameSourceFile},
+		{name: "configured order doesn't change precedence", allowed: []string{"Hostname", "ENV"}, env: "from-env", hostname: "from-hostname", expectedName: "from-env", expectedSource: NodeNameSourceEnv},
+		{name: "no source has a name", allowed: []string{NodeNameSourceFlag, NodeNameSourceEnv}, hostname: "from-hostname", expectError: true},
+		{name: "read errors are reported", allowed: []string{NodeNameSourceFile}, fileErr: errors.New("permission denied"), expectError: true},
+		{name: "an invalid name fails instead of falling through", allowed: all, env: "node,name=other", hostname: "from-hostname", expectError: true},
+		{name: "uppercase names are invalid", allowed: all, flag: "Node-1", expectError: true},
+		{name: "dotted names are valid", allowed: all, env: "node-1.example.internal", expectedName: "node-1.example.internal", expectedSource: NodeNameSourceEnv},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			lookup := nodeNameLookup{
+				flag:     func() string { return tc.flag },
+				env:      func() string { return tc.env },
+				file:     func() (string, error) { return tc.file, tc.fileErr },
+				hostname: func() (string, error) { return tc.hostname, nil },
+			}
+
+			name, source, err := resolveNodeName(tc.allowed, lookup)
+			if tc.expectError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedName, name)
+			assert.Equal(t, tc.expectedSource, source)
+		})
+	}
+}
+
+func TestReadConfigurationInvalidNodeName(t *testing.T) {
+	vals := ContextValues{Logger: zaptest.NewLogger(t).Sugar()}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	tests := []struct {
+		name     string
+		nodeName string
+	}{
+		{name: "separators that would change the field selector", nodeName: "node-1,metadata.name=node-2"},
+		{name: "whitespace inside the name", nodeName: "node 1"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("MECHANIC_NODE_NAME", tc.nodeName)
+
+			// the node name is checked before the in cluster config, so this fails on the name outside a cluster too
+			_, err := ReadConfiguration(ctx)
+			require.Error(t, err)
+			assert.ErrorContains(t, err, "node name")
+		})
+	}
+}
+
+func TestReadConfigurationNodeNameUnset(t *testing.T) {
+	vals := ContextValues{Logger: zaptest.NewLogger(t).Sugar()}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	// the hostname is always set, so this only fails if it isn't one of the default sources
+	t.Setenv("MECHANIC_NODE_NAME", "")
+
+	_, err := ReadConfiguration(ctx)
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "no node name found")
+}
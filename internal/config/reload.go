@@ -0,0 +1,151 @@
+package config
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+)
+
+// ReloadFunc is called after the configuration is reloaded with the configuration from before and after the reload
+type ReloadFunc func(old, new Config)
+
+// Store holds the running configuration. Code that should pick up a reloaded configuration reads it from the store each
+// time instead of holding on to a copy.
+type Store struct {
+	lock    sync.RWMutex
+	cfg     Config
+	reloads atomic.Int64
+}
+
+// NewStore returns a Store holding cfg
+func NewStore(cfg Config) *Store {
+	return &Store{cfg: cfg}
+}
+
+// Get returns the current configuration
+func (s *Store) Get() Config {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.cfg
+}
+
+// Reloads returns how many times the configuration has been reloaded
+func (s *Store) Reloads() int {
+	return int(s.reloads.Load())
+}
+
+// reload replaces the settings that can change without a restart with the ones in v. Settings used to set up clients,
+// servers, logging, and tracing at startup are kept, and so are the cordon markers and state store, since the cordons
+// and drain state already recorded under them would be orphaned. The configuration from before and after the reload is returned.
+// An invalid configuration is an error and leaves the running configuration in place.
+func (s *Store) reload(v *viper.Viper) (Config, Config, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	old := s.cfg
+	drainConditions := buildDrainConditions(v)
+	if err := validateLiveMigrationPatterns(drainConditions.LiveMigrationPatterns); err != nil {
+		return old, old, err
+	}
+	drain, err := buildDrainConfig(v)
+	if err != nil {
+		return old, old, err
+	}
+	updated := old
+	updated.DrainConditions = drainConditions
+	updated.Drain = drain
+	updated.DrainEscalation = buildDrainEscalationConfig(v)
+	updated.GPUHealth = buildGPUHealthConfig(v)
+	updated.UpgradeSignal = buildUpgradeSignalConfig(v)
+	updated.MaintenanceTaints = buildMaintenanceTaints(v)
+	updated.NodeUpdateRetry = buildNodeUpdateRetryConfig(v)
+	updated.IMDSRetry = buildIMDSRetryConfig(v)
+	updated.SafeMode = v.GetBool("SAFE_MODE")
+	updated.PollingInterval = time.Duration(v.GetInt("POLLING_INTERVAL_SECONDS")) * time.Second
+	updated.AlsoPollIMDS = v.GetBool("ALSO_POLL_IMDS")
+	updated.IMDSPollInterval = time.Duration(v.GetInt("IMDS_POLL_INTERVAL_SECONDS")) * time.Second
+	updated.DrainedVerifyInterval = time.Duration(v.GetInt("DRAINED_VERIFY_INTERVAL_SECONDS")) * time.Second
+	updated.ValidateStartupConditions = v.GetBool("VALIDATE_STARTUP_CONDITIONS")
+	updated.ReconcileCordonMarkers = v.GetBool("RECONCILE_CORDON_MARKERS")
+	updated.RequireAPIConnectivityBeforeAction = v.GetBool("REQUIRE_API_CONNECTIVITY_BEFORE_ACTION")
+	updated.AckEventAfterDrain = v.GetBool("ACK_EVENT_AFTER_DRAIN")
+	updated.LogDecisions = v.GetBool("LOG_DECISIONS")
+	updated.AnnotateMaintenanceDescription = v.GetBool("ANNOTATE_MAINTENANCE_DESCRIPTION")
+	updated.CombineTriggers = v.GetBool("COMBINE_TRIGGERS")
+	updated.MaxIMDSDataAge = time.Duration(v.GetInt("MAX_IMDS_DATA_AGE_SECONDS")) * time.Second
+	updated.UncordonOnShutdown = v.GetBool("UNCORDON_ON_SHUTDOWN")
+	updated.Settings = effectiveSettings(v)
+
+	s.cfg = updated
+	s.reloads.Add(1)
+	return old, updated, nil
+}
+
+// restartOnlyChanges returns the keys of the cordon marker and state store settings in v that differ from the ones cfg
+// is running with. They're only applied on a restart.
+func restartOnlyChanges(v *viper.Viper, cfg Config) []string {
+	settings := []struct {
+		key      string
+		running  interface{}
+		reloaded interface{}
+	}{
+		{"CORDON_LABEL_KEY", cfg.Cordon.LabelKey, strings.TrimSpace(v.GetString("CORDON_LABEL_KEY"))},
+		{"CORDON_APPLY_TAINT", cfg.Cordon.ApplyTaint, v.GetBool("CORDON_APPLY_TAINT")},
+		{"CORDON_TAINT_KEY", cfg.Cordon.TaintKey, strings.TrimSpace(v.GetString("CORDON_TAINT_KEY"))},
+		{"STATE_STORE", cfg.StateStore.Backend, strings.ToLower(v.GetString("STATE_STORE"))},
+		{"STATE_STORE_FILE_PATH", cfg.StateStore.FilePath, v.GetString("STATE_STORE_FILE_PATH")},
+		{"STATE_STORE_CONFIGMAP_NAMESPACE", cfg.StateStore.ConfigMapNamespace, v.GetString("STATE_STORE_CONFIGMAP_NAMESPACE")},
+	}
+
+	var changed []string
+	for _, setting := range settings {
+		if setting.running != setting.reloaded {
+			changed = append(changed, setting.key)
+		}
+	}
+	return changed
+}
+
+// EnableHotReload watches the config file and reloads the store when it changes, calling onReload after each reload.
+// Only the drain and reconcile settings are reloaded, the rest need a restart. Nothing is watched when the config file
+// can't be read.
+func EnableHotReload(ctx context.Context, store *Store, onReload ReloadFunc) {
+	v := viper.New()
+	setDefaults(v)
+	addConfigFile(v)
+	bindEnv(v)
+	watchConfig(ctx, v, store, onReload)
+}
+
+// watchConfig reads the config file v points at and starts watching it
+func watchConfig(ctx context.Context, v *viper.Viper, store *Store, onReload ReloadFunc) {
+	vals := ctx.Value("values").(*ContextValues)
+	log := vals.Logger
+
+	if err := v.ReadInConfig(); err != nil {
+		log.Warnw("Failed to read in config file, hot reload is disabled", "error", err)
+		return
+	}
+
+	v.OnConfigChange(func(e fsnotify.Event) {
+		old, updated, err := store.reload(v)
+		if err != nil {
+			log.Errorw("Rejected the reloaded configuration, keeping the running configuration", "file", e.Name, "error", err)
+			return
+		}
+		log.Infow("Reloaded configuration", "file", e.Name, "reloads", store.Reloads())
+		if changed := restartOnlyChanges(v, updated); len(changed) > 0 {
+			log.Warnw("Settings changed that only take effect after a restart, keeping the running values", "file", e.Name, "settings", changed)
+		}
+		if onReload != nil {
+			onReload(old, updated)
+		}
+	})
+	v.WatchConfig()
+	log.Infow("Watching config file for changes", "file", v.ConfigFileUsed())
+}
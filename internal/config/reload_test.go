@@ -0,0 +1,185 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestStoreReload(t *testing.T) {
+	store := NewStore(Config{NodeName: "test-node", MetricsPort: 9090, DrainConditions: DrainConditions{DrainOnReboot: true}})
+
+	v := viper.New()
+	setDefaults(v)
+	v.Set("DRAIN_ON_REBOOT", false)
+	v.Set("DRAIN_ON_FREEZE", true)
+	v.Set("METRICS_PORT", 9999)
+	v.Set("POLLING_INTERVAL_SECONDS", 30)
+
+	old, updated, err := store.reload(v)
+	require.NoError(t, err)
+	assert.True(t, old.DrainConditions.DrainOnReboot)
+	assert.False(t, updated.DrainConditions.DrainOnReboot)
+	assert.True(t, updated.DrainConditions.DrainOnFreeze)
+	assert.Equal(t, 30*time.Second, updated.PollingInterval)
+	assert.Equal(t, "test-node", updated.NodeName, "settings that need a restart are kept")
+	assert.Equal(t, 9090, updated.MetricsPort, "settings that need a restart are kept")
+	assert.Equal(t, updated, store.Get())
+	assert.Equal(t, 1, store.Reloads())
+}
+
+func TestStoreReloadRejectsInvalidConfig(t *testing.T) {
+	store := NewStore(Config{DrainConditions: DrainConditions{DrainOnReboot: true}})
+
+	v := viper.New()
+	setDefaults(v)
+	v.Set("DRAIN_ON_REBOOT", false)
+	v.Set("DRAIN_TIMEOUTS_BY_REASON", map[string]interface{}{"Reboot": "soon"})
+
+	_, _, err := store.reload(v)
+	assert.Error(t, err)
+	assert.True(t, store.Get().DrainConditions.DrainOnReboot, "the running configuration is kept")
+	assert.Zero(t, store.Reloads())
+}
+
+func TestStoreReloadCordonStateStoreAndRetry(t *testing.T) {
+	v := viper.New()
+	setDefaults(v)
+	cordon, err := buildCordonConfig(v)
+	require.NoError(t, err)
+	stateStore, err := buildStateStoreConfig(v)
+	require.NoError(t, err)
+	running := Config{Cordon: cordon, StateStore: stateStore}
+	store := NewStore(running)
+
+	v.Set("NODE_UPDATE_RETRY_ATTEMPTS", 2)
+	v.Set("IMDS_RETRY_ATTEMPTS", 7)
+	_, updated, err := store.reload(v)
+	require.NoError(t, err)
+	assert.Equal(t, 2, updated.NodeUpdateRetry.Attempts)
+	assert.Equal(t, 7, updated.IMDSRetry.Attempts)
+	assert.Empty(t, restartOnlyChanges(v, updated))
+
+	// cordons and drain state already recorded under the running markers and store would be orphaned, so those settings
+	// wait for a restart
+	v.Set("CORDON_LABEL_KEY", "platform.example.com/cordoned")
+	v.Set("CORDON_APPLY_TAINT", true)
+	v.Set("STATE_STORE", "ConfigMap")
+	_, updated, err = store.reload(v)
+	require.NoError(t, err)
+	assert.Equal(t, running.Cordon, updated.Cordon)
+	assert.Equal(t, running.StateStore, updated.StateStore)
+	assert.Equal(t, []string{"CORDON_LABEL_KEY", "CORDON_APPLY_TAINT", "STATE_STORE"}, restartOnlyChanges(v, updated))
+}
+
+func TestStoreReloadRejectsInvalidLiveMigrationPattern(t *testing.T) {
+	store := NewStore(Config{DrainConditions: DrainConditions{LiveMigrationPatterns: DefaultLiveMigrationPatterns}})
+
+	v := viper.New()
+	setDefaults(v)
+	v.Set("LIVE_MIGRATION_PATTERNS", []string{"live (migration"})
+
+	_, _, err := store.reload(v)
+	assert.ErrorContains(t, err, "LIVE_MIGRATION_PATTERNS")
+	assert.Equal(t, DefaultLiveMigrationPatterns, store.Get().DrainConditions.LiveMigrationPatterns, "the running patterns are kept")
+}
+
+func TestWatchConfigReloadsDrainConditions(t *testing.T) {
+	vals := ContextValues{Logger: zaptest.NewLogger(t).Sugar()}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	path := filepath.Join(t.TempDir(), "mechanic.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("DRAIN_ON_REBOOT: true\n"), 0o644))
+
+	v := viper.New()
+	setDefaults(v)
+	v.SetConfigFile(path)
+	store := NewStore(buildConfigForTest(t, path))
+	require.True(t, store.Get().DrainConditions.DrainOnReboot)
+
+	reloaded := make(chan Config, 1)
+	watchConfig(ctx, v, store, func(old, new Config) {
+		reloaded <- new
+	})
+
+	// disable the event type mid-run, the reconciles after the reload stop draining for it
+	require.NoError(t, os.WriteFile(path, []byte("DRAIN_ON_REBOOT: false\n"), 0o644))
+	select {
+	case cfg := <-reloaded:
+		assert.False(t, cfg.DrainConditions.DrainsFor("Reboot"))
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the config to reload")
+	}
+	assert.False(t, store.Get().DrainConditions.DrainOnReboot)
+}
+
+func TestWatchConfigReloadsSettings(t *testing.T) {
+	vals := ContextValues{Logger: zaptest.NewLogger(t).Sugar()}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	path := filepath.Join(t.TempDir(), "mechanic.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("DRAIN_ON_REBOOT: true\n"), 0o644))
+
+	v := viper.New()
+	setDefaults(v)
+	v.SetConfigFile(path)
+	cfg := buildConfigForTest(t, path)
+	cfg.Settings = []Setting{{Key: "DRAIN_ON_REBOOT", Value: true, Source: SettingSourceFile}}
+	store := NewStore(cfg)
+
+	reloaded := make(chan Config, 1)
+	watchConfig(ctx, v, store, func(old, new Config) {
+		reloaded <- new
+	})
+
+	// the settings served by the admin /config endpoint follow the reload
+	require.NoError(t, os.WriteFile(path, []byte("DRAIN_ON_REBOOT: false\nPOLLING_INTERVAL_SECONDS: 45\n"), 0o644))
+	select {
+	case <-reloaded:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the config to reload")
+	}
+	settings := store.Get().Settings
+	assert.Contains(t, settings, Setting{Key: "DRAIN_ON_REBOOT", Value: false, Source: SettingSourceFile})
+	assert.Contains(t, settings, Setting{Key: "POLLING_INTERVAL_SECONDS", Value: 45, Source: SettingSourceFile})
+	assert.Contains(t, settings, Setting{Key: "DRAIN_ON_FREEZE", Value: false, Source: SettingSourceDefault})
+}
+
+// buildConfigForTest builds the drain conditions the way ReadConfiguration does from the file at path
+func buildConfigForTest(t *testing.T, path string) Config {
+	v := viper.New()
+	setDefaults(v)
+	v.SetConfigFile(path)
+	require.NoError(t, v.ReadInConfig())
+	return Config{DrainConditions: buildDrainConditions(v)}
+}
+
+func TestStoreConcurrentReloadAndGet(t *testing.T) {
+	store := NewStore(Config{})
+
+	v := viper.New()
+	setDefaults(v)
+
+	// run with -race to check reloads don't race with reconciles reading the config
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			store.reload(v)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		cfg := store.Get()
+		_ = cfg.DrainConditions.DrainableConditions()
+		_ = store.Reloads()
+	}
+	<-done
+	assert.Equal(t, 100, store.Reloads())
+}
@@ -0,0 +1,51 @@
+package events
+
+import (
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+)
+
+// LevelFilteredRecorder wraps an EventRecorder and drops events below the configured minimum level. Kubernetes only has
+// Normal and Warning event types, so filtering at the warning level drops all Normal events.
+type LevelFilteredRecorder struct {
+	recorder     record.EventRecorder
+	warningsOnly bool
+}
+
+// NewLevelFilteredRecorder returns a recorder that only emits events at or above minLevel ("normal" or "warning").
+// An empty level emits everything, and any other level is an error.
+func NewLevelFilteredRecorder(recorder record.EventRecorder, minLevel string) (*LevelFilteredRecorder, error) {
+	if minLevel != "" && !strings.EqualFold(minLevel, v1.EventTypeNormal) && !strings.EqualFold(minLevel, v1.EventTypeWarning) {
+		return nil, fmt.Errorf("unsupported minimum event level %q, expected normal or warning", minLevel)
+	}
+	return &LevelFilteredRecorder{
+		recorder:     recorder,
+		warningsOnly: strings.EqualFold(minLevel, v1.EventTypeWarning),
+	}, nil
+}
+
+func (r *LevelFilteredRecorder) allowed(eventtype string) bool {
+	return !r.warningsOnly || eventtype == v1.EventTypeWarning
+}
+
+func (r *LevelFilteredRecorder) Event(object runtime.Object, eventtype, reason, message string) {
+	if r.allowed(eventtype) {
+		r.recorder.Event(object, eventtype, reason, message)
+	}
+}
+
+func (r *LevelFilteredRecorder) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	if r.allowed(eventtype) {
+		r.recorder.Eventf(object, eventtype, reason, messageFmt, args...)
+	}
+}
+
+func (r *LevelFilteredRecorder) AnnotatedEventf(object runtime.Object, annotations map[string]string, eventtype, reason, messageFmt string, args ...interface{}) {
+	if r.allowed(eventtype) {
+		r.recorder.AnnotatedEventf(object, annotations, eventtype, reason, messageFmt, args...)
+	}
+}
@@ -0,0 +1,68 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestLevelFilteredRecorder(t *testing.T) {
+	tests := []struct {
+		name           string
+		minLevel       string
+		expectedEvents []string
+	}{
+		{
+			name:     "normal level emits everything",
+			minLevel: "normal",
+			expectedEvents: []string{
+				"Normal CordonNode cordoned",
+				"Warning DrainNode drain failed",
+				"Normal UncordonNode uncordoned map[key:value]",
+			},
+		},
+		{
+			name:     "warning level suppresses normal events",
+			minLevel: "warning",
+			expectedEvents: []string{
+				"Warning DrainNode drain failed",
+			},
+		},
+		{
+			name:     "levels are case insensitive",
+			minLevel: "Warning",
+			expectedEvents: []string{
+				"Warning DrainNode drain failed",
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := record.NewFakeRecorder(10)
+			recorder, err := NewLevelFilteredRecorder(fake, tc.minLevel)
+			require.NoError(t, err)
+			node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-node"}}
+
+			recorder.Event(node, v1.EventTypeNormal, "CordonNode", "cordoned")
+			recorder.Eventf(node, v1.EventTypeWarning, "DrainNode", "drain %s", "failed")
+			recorder.AnnotatedEventf(node, map[string]string{"key": "value"}, v1.EventTypeNormal, "UncordonNode", "uncordoned")
+			close(fake.Events)
+
+			var events []string
+			for e := range fake.Events {
+				events = append(events, e)
+			}
+			assert.Equal(t, tc.expectedEvents, events)
+		})
+	}
+}
+
+func TestLevelFilteredRecorderUnknownLevel(t *testing.T) {
+	_, err := NewLevelFilteredRecorder(record.NewFakeRecorder(1), "verbose")
+	assert.ErrorContains(t, err, `unsupported minimum event level "verbose"`)
+}
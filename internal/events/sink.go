@@ -0,0 +1,59 @@
+package events
+
+import (
+	"github.com/amargherio/mechanic/pkg/metrics"
+	"go.uber.org/zap"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/tools/record"
+)
+
+// MonitoredSink wraps the EventSink the broadcaster writes to and reports the writes the API server rejects. The
+// broadcaster only logs those failures itself, so without this nothing says events have stopped being recorded, e.g.
+// when RBAC doesn't allow creating them or the API server is rate limiting us.
+type MonitoredSink struct {
+	sink record.EventSink
+	log  *zap.SugaredLogger
+}
+
+// NewMonitoredSink returns a sink that writes to sink, counting and logging each failed write
+func NewMonitoredSink(sink record.EventSink, log *zap.SugaredLogger) *MonitoredSink {
+	return &MonitoredSink{sink: sink, log: log}
+}
+
+func (s *MonitoredSink) Create(event *v1.Event) (*v1.Event, error) {
+	e, err := s.sink.Create(event)
+	s.observe("create", event, err)
+	return e, err
+}
+
+func (s *MonitoredSink) Update(event *v1.Event) (*v1.Event, error) {
+	e, err := s.sink.Update(event)
+	s.observe("update", event, err)
+	return e, err
+}
+
+func (s *MonitoredSink) Patch(oldEvent *v1.Event, data []byte) (*v1.Event, error) {
+	e, err := s.sink.Patch(oldEvent, data)
+	s.observe("patch", oldEvent, err)
+	return e, err
+}
+
+// observe records a failed write, labeled with the reason the API server gave for rejecting it
+func (s *MonitoredSink) observe(operation string, event *v1.Event, err error) {
+	if err == nil {
+		return
+	}
+
+	reason := string(apierrors.ReasonForError(err))
+	if reason == "" {
+		reason = "Unknown"
+	}
+	metrics.EventSinkFailures.WithLabelValues(operation, reason).Inc()
+	s.log.Warnw("Failed to record event, events may not be visible on the node",
+		"operation", operation,
+		"reason", event.Reason,
+		"object", event.InvolvedObject.Name,
+		"failureReason", reason,
+		"error", err)
+}
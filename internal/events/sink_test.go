@@ -0,0 +1,92 @@
+package events
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/amargherio/mechanic/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap/zaptest"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// failingSink is an EventSink that returns err from every write
+type failingSink struct {
+	err    error
+	writes int
+}
+
+func (s *failingSink) Create(event *v1.Event) (*v1.Event, error) {
+	s.writes++
+	return event, s.err
+}
+
+func (s *failingSink) Update(event *v1.Event) (*v1.Event, error) {
+	s.writes++
+	return event, s.err
+}
+
+func (s *failingSink) Patch(oldEvent *v1.Event, data []byte) (*v1.Event, error) {
+	s.writes++
+	return oldEvent, s.err
+}
+
+func TestMonitoredSink(t *testing.T) {
+	events := schema.GroupResource{Resource: "events"}
+
+	tests := []struct {
+		name           string
+		err            error
+		expectedReason string
+	}{
+		{name: "write succeeds"},
+		{name: "missing RBAC", err: apierrors.NewForbidden(events, "", errors.New("cannot create resource")), expectedReason: "Forbidden"},
+		{name: "rate limited", err: apierrors.NewTooManyRequests("too many requests", 1), expectedReason: "TooManyRequests"},
+		{name: "apiserver unreachable", err: errors.New("connection refused"), expectedReason: "Unknown"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			inner := &failingSink{err: tc.err}
+			sink := NewMonitoredSink(inner, zaptest.NewLogger(t).Sugar())
+			event := &v1.Event{
+				Reason:         "CordonNode",
+				InvolvedObject: v1.ObjectReference{Kind: "Node", Name: "test-node"},
+				ObjectMeta:     metav1.ObjectMeta{Name: "test-node.1"},
+			}
+
+			var before float64
+			if tc.expectedReason != "" {
+				before = testutil.ToFloat64(metrics.EventSinkFailures.WithLabelValues("create", tc.expectedReason))
+			}
+
+			_, err := sink.Create(event)
+			assert.Equal(t, tc.err, err, "the error is passed back so the broadcaster can retry")
+			assert.Equal(t, 1, inner.writes)
+			if tc.expectedReason != "" {
+				assert.Equal(t, float64(1), testutil.ToFloat64(metrics.EventSinkFailures.WithLabelValues("create", tc.expectedReason))-before)
+			}
+		})
+	}
+}
+
+func TestMonitoredSinkUpdateAndPatch(t *testing.T) {
+	inner := &failingSink{err: apierrors.NewTooManyRequests("too many requests", 1)}
+	sink := NewMonitoredSink(inner, zaptest.NewLogger(t).Sugar())
+	event := &v1.Event{Reason: "DrainNode", InvolvedObject: v1.ObjectReference{Kind: "Node", Name: "test-node"}}
+
+	updates := testutil.ToFloat64(metrics.EventSinkFailures.WithLabelValues("update", "TooManyRequests"))
+	patches := testutil.ToFloat64(metrics.EventSinkFailures.WithLabelValues("patch", "TooManyRequests"))
+
+	_, err := sink.Update(event)
+	assert.Error(t, err)
+	_, err = sink.Patch(event, []byte(`{"count": 2}`))
+	assert.Error(t, err)
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.EventSinkFailures.WithLabelValues("update", "TooManyRequests"))-updates)
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.EventSinkFailures.WithLabelValues("patch", "TooManyRequests"))-patches)
+}
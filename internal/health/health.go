@@ -0,0 +1,37 @@
+package health
+
+import (
+	"net/http"
+	"time"
+)
+
+const (
+	// LivenessPath answers as long as the process is serving requests
+	LivenessPath = "/healthz"
+	// ReadinessPath answers once mechanic is watching the node and, when IMDS is polled, while the polls succeed
+	ReadinessPath = "/readyz"
+)
+
+// NewHandler returns the HTTP handler for the liveness and readiness probes. The readiness probe fails until ready
+// returns true, which for the node informer is once its cache has synced.
+func NewHandler(ready func() bool) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(LivenessPath, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	mux.HandleFunc(ReadinessPath, func(w http.ResponseWriter, r *http.Request) {
+		if ready == nil || !ready() {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+	return mux
+}
+
+// IMDSPollFresh reports whether IMDS was last polled successfully within twice the polling interval, so a readiness
+// check fails once polls have stopped succeeding. Before the first poll, the time polling started stands in for it. A
+// zero interval means polling is off, which is always fresh.
+func IMDSPollFresh(lastPoll time.Time, interval time.Duration, now time.Time) bool {
+	return interval <= 0 || now.Sub(lastPoll) <= 2*interval
+}
@@ -0,0 +1,68 @@
+package health
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func probe(handler http.Handler, path string) int {
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	return rec.Code
+}
+
+func TestHealthHandler(t *testing.T) {
+	synced := false
+	handler := NewHandler(func() bool { return synced })
+
+	assert.Equal(t, http.StatusOK, probe(handler, LivenessPath))
+	assert.Equal(t, http.StatusServiceUnavailable, probe(handler, ReadinessPath), "not ready until the informer has synced")
+
+	synced = true
+	assert.Equal(t, http.StatusOK, probe(handler, LivenessPath))
+	assert.Equal(t, http.StatusOK, probe(handler, ReadinessPath))
+}
+
+func TestHealthHandlerWithoutReadinessCheck(t *testing.T) {
+	handler := NewHandler(nil)
+
+	assert.Equal(t, http.StatusOK, probe(handler, LivenessPath))
+	assert.Equal(t, http.StatusServiceUnavailable, probe(handler, ReadinessPath))
+}
+
+func TestIMDSPollFresh(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name     string
+		lastPoll time.Time
+		interval time.Duration
+		expected bool
+	}{
+		{name: "polled within the interval", lastPoll: now.Add(-30 * time.Second), interval: time.Minute, expected: true},
+		{name: "one poll missed", lastPoll: now.Add(-90 * time.Second), interval: time.Minute, expected: true},
+		{name: "polls stopped succeeding", lastPoll: now.Add(-3 * time.Minute), interval: time.Minute, expected: false},
+		{name: "polling off", lastPoll: now.Add(-time.Hour), interval: 0, expected: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, IMDSPollFresh(tc.lastPoll, tc.interval, now))
+		})
+	}
+}
+
+func TestHealthHandlerIMDSPolling(t *testing.T) {
+	lastPoll := time.Now()
+	handler := NewHandler(func() bool { return IMDSPollFresh(lastPoll, time.Minute, time.Now()) })
+
+	assert.Equal(t, http.StatusOK, probe(handler, ReadinessPath))
+
+	lastPoll = time.Now().Add(-5 * time.Minute)
+	assert.Equal(t, http.StatusOK, probe(handler, LivenessPath))
+	assert.Equal(t, http.StatusServiceUnavailable, probe(handler, ReadinessPath), "not ready once IMDS polls stop succeeding")
+}
@@ -0,0 +1,48 @@
+package logging
+
+import (
+	"fmt"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// the formats log entries can be written in
+const (
+	FormatJSON    = "json"
+	FormatConsole = "console"
+)
+
+// NewEncoder returns the encoder for format. The console format is meant for reading logs by hand, so levels are
+// written in capitals to stand out from the message.
+func NewEncoder(format string, enc zapcore.EncoderConfig) (zapcore.Encoder, error) {
+	switch format {
+	case FormatJSON:
+		return zapcore.NewJSONEncoder(enc), nil
+	case FormatConsole:
+		enc.EncodeLevel = zapcore.CapitalLevelEncoder
+		return zapcore.NewConsoleEncoder(enc), nil
+	default:
+		return nil, fmt.Errorf("unsupported log format %q, expected %q or %q", format, FormatJSON, FormatConsole)
+	}
+}
+
+// NewCore returns a Core that writes entries encoded in format to ws
+func NewCore(format string, enc zapcore.EncoderConfig, ws zapcore.WriteSyncer, level zapcore.LevelEnabler) (zapcore.Core, error) {
+	encoder, err := NewEncoder(format, enc)
+	if err != nil {
+		return nil, err
+	}
+	return zapcore.NewCore(encoder, ws, level), nil
+}
+
+// ResolveLevel returns the configured log level. Without one, the level follows the runtime environment: info in prod
+// and debug everywhere else.
+func ResolveLevel(level string, runtimeEnv string) (zapcore.Level, error) {
+	if level == "" {
+		if runtimeEnv == "prod" {
+			return zapcore.InfoLevel, nil
+		}
+		return zapcore.DebugLevel, nil
+	}
+	return zapcore.ParseLevel(level)
+}
@@ -0,0 +1,81 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestNewCore(t *testing.T) {
+	tests := []struct {
+		format string
+		level  zapcore.Level
+	}{
+		{format: FormatJSON, level: zapcore.InfoLevel},
+		{format: FormatConsole, level: zapcore.DebugLevel},
+		{format: FormatConsole, level: zapcore.WarnLevel},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.format+"/"+tc.level.String(), func(t *testing.T) {
+			var buf bytes.Buffer
+			core, err := NewCore(tc.format, zap.NewProductionEncoderConfig(), zapcore.AddSync(&buf), zap.NewAtomicLevelAt(tc.level))
+			require.NoError(t, err)
+
+			assert.True(t, core.Enabled(tc.level))
+			assert.False(t, core.Enabled(tc.level-1))
+
+			zap.New(core).Sugar().Errorw("written in the configured format", "node", "test-node")
+			line := buf.String()
+			var entry map[string]interface{}
+			if tc.format == FormatJSON {
+				require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+				assert.Equal(t, "error", entry["level"])
+				assert.Equal(t, "written in the configured format", entry["msg"])
+				return
+			}
+			assert.Error(t, json.Unmarshal(buf.Bytes(), &entry))
+			assert.Contains(t, line, "\tERROR\t")
+			assert.Contains(t, line, `written in the configured format	{"node": "test-node"}`)
+		})
+	}
+}
+
+func TestNewCoreUnsupportedFormat(t *testing.T) {
+	_, err := NewCore("text", zap.NewProductionEncoderConfig(), zapcore.AddSync(&bytes.Buffer{}), zap.NewAtomicLevel())
+	assert.ErrorContains(t, err, `unsupported log format "text"`)
+}
+
+func TestResolveLevel(t *testing.T) {
+	tests := []struct {
+		name        string
+		level       string
+		runtimeEnv  string
+		expected    zapcore.Level
+		expectError bool
+	}{
+		{name: "prod defaults to info", runtimeEnv: "prod", expected: zapcore.InfoLevel},
+		{name: "other environments default to debug", runtimeEnv: "dev", expected: zapcore.DebugLevel},
+		{name: "a configured level wins in prod", level: "debug", runtimeEnv: "prod", expected: zapcore.DebugLevel},
+		{name: "a configured level wins elsewhere", level: "warn", runtimeEnv: "dev", expected: zapcore.WarnLevel},
+		{name: "levels are case insensitive", level: "ERROR", runtimeEnv: "prod", expected: zapcore.ErrorLevel},
+		{name: "unknown levels are an error", level: "verbose", runtimeEnv: "prod", expectError: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			level, err := ResolveLevel(tc.level, tc.runtimeEnv)
+			if tc.expectError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, level)
+		})
+	}
+}
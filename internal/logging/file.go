@@ -0,0 +1,21 @@
+package logging
+
+import (
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// defaultMaxBackups is the number of rotated log files kept alongside the active log file
+const defaultMaxBackups = 3
+
+// NewFileCore returns a Core that writes entries encoded in format to the file at path, rotating the file once it
+// reaches maxSizeMB megabytes.
+func NewFileCore(format string, enc zapcore.EncoderConfig, path string, maxSizeMB int, level zapcore.LevelEnabler) (zapcore.Core, error) {
+	writer := &lumberjack.Logger{
+		Filename:   path,
+		MaxSize:    maxSizeMB,
+		MaxBackups: defaultMaxBackups,
+	}
+
+	return NewCore(format, enc, zapcore.AddSync(writer), level)
+}
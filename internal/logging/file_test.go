@@ -0,0 +1,34 @@
+package logging
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/trace/noop"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestFileCoreWritesThroughTraceCore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mechanic.log")
+
+	enc := zap.NewProductionEncoderConfig()
+	fileCore, err := NewFileCore(FormatJSON, enc, path, 1, zap.NewAtomicLevelAt(zap.InfoLevel))
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	logger := zap.New(NewTraceCore(zapcore.NewTee(zapcore.NewNopCore(), fileCore), &ctx, noop.NewTracerProvider()))
+	logger.Sugar().Infow("written to the log file", "node", "test-node", "traceCtx", ctx)
+	logger.Sugar().Debugw("below the configured level")
+	assert.NoError(t, logger.Sync())
+
+	contents, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Contains(t, string(contents), "written to the log file")
+	assert.Contains(t, string(contents), `"node":"test-node"`)
+	assert.NotContains(t, string(contents), "traceCtx")
+	assert.NotContains(t, string(contents), "below the configured level")
+}
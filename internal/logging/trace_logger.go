@@ -4,6 +4,7 @@ import (
 	"context"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
@@ -12,11 +13,15 @@ type TraceCore struct {
 	ioCore zapcore.Core
 	Ctx    *context.Context
 	tp     trace.TracerProvider
+	// tracing is false when tp is the no-op provider, so there are never spans to look up
+	tracing bool
 }
 
-// NewTraceCore Returns a new Core that adds tracing information to the log entry
+// NewTraceCore Returns a new Core that adds tracing information to the log entry. When tp is the no-op provider used
+// while tracing is disabled, entries are written without looking for a span.
 func NewTraceCore(c zapcore.Core, ctx *context.Context, tp trace.TracerProvider) *TraceCore {
-	return &TraceCore{c, ctx, tp}
+	_, disabled := tp.(noop.TracerProvider)
+	return &TraceCore{ioCore: c, Ctx: ctx, tp: tp, tracing: !disabled}
 }
 
 func (c *TraceCore) Enabled(lvl zapcore.Level) bool {
@@ -42,13 +47,15 @@ func (c *TraceCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
 	// 3. using the span, add the trace ID, span ID, and name to the logged fields
 	// 4. write the entry to the core
 
+	if !c.tracing {
+		return c.ioCore.Write(entry, dropTraceCtx(fields))
+	}
+
 	// Get the current span from the context, if there is one
 	var sc context.Context
 	var activeSpan trace.Span
-	var ctxIndex int
-	for idx, field := range fields {
+	for _, field := range fields {
 		if field.Key == "traceCtx" {
-			ctxIndex = idx
 			sc = field.Interface.(context.Context)
 			break
 		}
@@ -78,16 +85,17 @@ func (c *TraceCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
 		}
 	}
 	// drop our context from the fields slice prior to logging
-	switch ctxIndex {
-	case 0:
-		fields = fields[1:]
-	case len(fields) - 1:
-		fields = fields[:len(fields)-1]
-	default:
-		fields = append(fields[:ctxIndex], fields[ctxIndex+1:]...)
+	return c.ioCore.Write(entry, dropTraceCtx(fields))
+}
+
+// dropTraceCtx returns the fields without the traceCtx field, which is only there for us to find the span
+func dropTraceCtx(fields []zapcore.Field) []zapcore.Field {
+	for idx, field := range fields {
+		if field.Key == "traceCtx" {
+			return append(fields[:idx:idx], fields[idx+1:]...)
+		}
 	}
-	
-	return c.ioCore.Write(entry, fields)
+	return fields
 }
 
 func (c *TraceCore) Sync() error {
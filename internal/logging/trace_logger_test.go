@@ -0,0 +1,54 @@
+package logging
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestTraceCoreAddsSpanFields(t *testing.T) {
+	tp := sdktrace.NewTracerProvider()
+	defer tp.Shutdown(context.Background())
+	ctx, span := tp.Tracer("test").Start(context.Background(), "reconcile")
+	defer span.End()
+
+	observed, logs := observer.New(zap.InfoLevel)
+	logger := zap.New(NewTraceCore(observed, &ctx, tp))
+	logger.Sugar().Infow("traced entry", "node", "test-node", "traceCtx", ctx)
+
+	fields := logs.All()[0].ContextMap()
+	assert.Equal(t, span.SpanContext().TraceID().String(), fields["traceID"])
+	assert.Equal(t, "reconcile", fields["spanName"])
+	assert.Equal(t, "test-node", fields["node"])
+	assert.NotContains(t, fields, "traceCtx")
+}
+
+func TestTraceCoreTracingDisabled(t *testing.T) {
+	tp := noop.NewTracerProvider()
+	ctx, span := tp.Tracer("test").Start(context.Background(), "reconcile")
+	defer span.End()
+
+	observed, logs := observer.New(zap.InfoLevel)
+	core := NewTraceCore(observed, &ctx, tp)
+	assert.False(t, core.tracing)
+
+	zap.New(core).Sugar().Infow("untraced entry", "traceCtx", ctx, "node", "test-node")
+
+	fields := logs.All()[0].ContextMap()
+	assert.Equal(t, map[string]interface{}{"node": "test-node"}, fields)
+}
+
+func TestDropTraceCtx(t *testing.T) {
+	ctx := context.Background()
+	fields := []zapcore.Field{zap.String("node", "test-node"), zap.Any("traceCtx", ctx), zap.Int("attempt", 1)}
+
+	dropped := dropTraceCtx(fields)
+	assert.Equal(t, []zapcore.Field{zap.String("node", "test-node"), zap.Int("attempt", 1)}, dropped)
+	assert.Equal(t, "traceCtx", fields[1].Key, "the caller's fields are left untouched")
+}
@@ -0,0 +1,92 @@
+package shutdown
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/amargherio/mechanic/internal/config"
+	"go.opentelemetry.io/otel"
+)
+
+// CloseFunc stops a subsystem. It should return once the subsystem is stopped or the context is done.
+type CloseFunc func(ctx context.Context) error
+
+type closer struct {
+	name  string
+	close CloseFunc
+}
+
+// Manager shuts subsystems down in the reverse of the order they were registered in, so a subsystem is stopped before
+// the ones it depends on. The whole shutdown is bounded by a timeout.
+type Manager struct {
+	lock    sync.Mutex
+	timeout time.Duration
+	closers []closer
+}
+
+// NewManager returns a Manager that gives up on closers still running once timeout has passed. Zero means no timeout.
+func NewManager(timeout time.Duration) *Manager {
+	return &Manager{timeout: timeout}
+}
+
+// Register adds a subsystem to be closed on shutdown. Subsystems registered later are closed first.
+func (m *Manager) Register(name string, close CloseFunc) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.closers = append(m.closers, closer{name: name, close: close})
+}
+
+// Shutdown runs the registered closers one at a time, last registered first. A closer that fails doesn't stop the rest
+// from running, but once the timeout passes the closers that haven't finished are abandoned. The errors from every
+// closer that failed or was abandoned are returned together.
+func (m *Manager) Shutdown(ctx context.Context) error {
+	tracer := otel.Tracer("github.com/amargherio/mechanic/internal/shutdown")
+	ctx, span := tracer.Start(ctx, "Shutdown")
+	defer span.End()
+
+	vals := ctx.Value("values").(*config.ContextValues)
+	log := vals.Logger
+
+	m.lock.Lock()
+	closers := make([]closer, len(m.closers))
+	copy(closers, m.closers)
+	m.lock.Unlock()
+
+	if m.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.timeout)
+		defer cancel()
+	}
+
+	var errs []error
+	for i := len(closers) - 1; i >= 0; i-- {
+		c := closers[i]
+		if ctx.Err() != nil {
+			log.Warnw("Shutdown timed out, skipping subsystem", "subsystem", c.name, "traceCtx", ctx)
+			errs = append(errs, fmt.Errorf("%s: skipped: %w", c.name, ctx.Err()))
+			continue
+		}
+
+		log.Infow("Shutting down subsystem", "subsystem", c.name, "traceCtx", ctx)
+		done := make(chan error, 1)
+		go func() {
+			done <- c.close(ctx)
+		}()
+
+		select {
+		case err := <-done:
+			if err != nil {
+				log.Errorw("Failed to shut down subsystem", "subsystem", c.name, "error", err, "traceCtx", ctx)
+				errs = append(errs, fmt.Errorf("%s: %w", c.name, err))
+			}
+		case <-ctx.Done():
+			log.Warnw("Shutdown timed out waiting for subsystem", "subsystem", c.name, "traceCtx", ctx)
+			errs = append(errs, fmt.Errorf("%s: %w", c.name, ctx.Err()))
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,78 @@
+package shutdown
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/amargherio/mechanic/internal/config"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap/zaptest"
+)
+
+func testContext(t *testing.T) context.Context {
+	vals := config.ContextValues{Logger: zaptest.NewLogger(t).Sugar()}
+	return context.WithValue(context.Background(), "values", &vals)
+}
+
+func TestShutdownOrder(t *testing.T) {
+	ctx := testContext(t)
+	m := NewManager(time.Second)
+
+	var order []string
+	for _, name := range []string{"tracer", "broadcaster", "reconcile", "informer"} {
+		name := name
+		m.Register(name, func(ctx context.Context) error {
+			order = append(order, name)
+			return nil
+		})
+	}
+
+	assert.NoError(t, m.Shutdown(ctx))
+	assert.Equal(t, []string{"informer", "reconcile", "broadcaster", "tracer"}, order)
+}
+
+func TestShutdownContinuesAfterError(t *testing.T) {
+	ctx := testContext(t)
+	m := NewManager(time.Second)
+
+	closed := false
+	m.Register("first", func(ctx context.Context) error {
+		closed = true
+		return nil
+	})
+	m.Register("failing", func(ctx context.Context) error {
+		return errors.New("boom")
+	})
+
+	err := m.Shutdown(ctx)
+	assert.ErrorContains(t, err, "failing: boom")
+	assert.True(t, closed, "closers after a failure should still run")
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	ctx := testContext(t)
+	m := NewManager(50 * time.Millisecond)
+
+	skipped := true
+	m.Register("skipped", func(ctx context.Context) error {
+		skipped = false
+		return nil
+	})
+	// the slow closer ignores its context, so only the manager's timeout can bound it
+	release := make(chan struct{})
+	defer close(release)
+	m.Register("slow", func(ctx context.Context) error {
+		<-release
+		return nil
+	})
+
+	start := time.Now()
+	err := m.Shutdown(ctx)
+	assert.Less(t, time.Since(start), time.Second, "shutdown should be bounded by the timeout")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.ErrorContains(t, err, "slow")
+	assert.ErrorContains(t, err, "skipped: ")
+	assert.True(t, skipped, "closers after the timeout shouldn't run")
+}
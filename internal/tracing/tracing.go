@@ -1,29 +1,129 @@
 package tracing
 
 import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/amargherio/mechanic/internal/config"
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
 	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
 	"go.opentelemetry.io/otel/sdk/resource"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	"go.opentelemetry.io/otel/trace"
-	"io"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterOTLP   = "otlp"
+	ExporterFile   = "file"
+)
+
+// protocols the otlp exporter can send spans with
+const (
+	OTLPProtocolHTTP = "http"
+	OTLPProtocolGRPC = "grpc"
 )
 
-func InitTracer() (trace.TracerProvider, error) {
-	// configure the stdout exporter
-	// todo: add support for additional exporters
-	options := stdouttrace.WithWriter(io.Discard)
-	exporter, err := stdouttrace.New(options)
-	//exporter, err := stdouttrace.New()
+// InitTracer builds the TracerProvider for the configured exporter and sets it as the global provider. When tracing is
+// disabled, a no-op provider is returned. The "none" exporter still records spans so trace and span IDs are added to
+// log entries, but nothing is exported.
+func InitTracer(enabled bool, tc config.TracingConfig) (trace.TracerProvider, error) {
+	if !enabled {
+		tp := noop.NewTracerProvider()
+		otel.SetTracerProvider(tp)
+		return tp, nil
+	}
+
+	exporter, err := newExporter(tc)
 	if err != nil {
 		return nil, err
 	}
 
-	tp := sdktrace.NewTracerProvider(
-		sdktrace.WithBatcher(exporter),
+	options := []sdktrace.TracerProviderOption{
 		sdktrace.WithResource(resource.Default()),
-	)
+	}
+	if exporter != nil {
+		options = append(options, sdktrace.WithBatcher(exporter))
+	}
+
+	tp := sdktrace.NewTracerProvider(options...)
 
 	otel.SetTracerProvider(tp)
 	return tp, nil
 }
+
+// newExporter returns the span exporter for the configured exporter name. The none exporter returns a nil exporter.
+func newExporter(tc config.TracingConfig) (sdktrace.SpanExporter, error) {
+	switch tc.Exporter {
+	case ExporterNone, "":
+		return nil, nil
+	case ExporterStdout:
+		return stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case ExporterFile:
+		if tc.FilePath == "" {
+			return nil, fmt.Errorf("tracing exporter %q requires a file path", tc.Exporter)
+		}
+		f, err := os.OpenFile(tc.FilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open trace file: %w", err)
+		}
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(io.Writer(f)))
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return &fileExporter{SpanExporter: exporter, file: f}, nil
+	case ExporterOTLP:
+		return newOTLPExporter(tc)
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q, expected one of %s, %s, %s, or %s", tc.Exporter, ExporterNone, ExporterStdout, ExporterOTLP, ExporterFile)
+	}
+}
+
+// fileExporter writes spans to a file and closes the file when it's shut down. The tracer provider shuts its exporter
+// down, so the file is closed with the provider by the shutdown manager.
+type fileExporter struct {
+	sdktrace.SpanExporter
+	file *os.File
+}
+
+// Shutdown flushes the spans still buffered and closes the file
+func (e *fileExporter) Shutdown(ctx context.Context) error {
+	err := e.SpanExporter.Shutdown(ctx)
+	if closeErr := e.file.Close(); closeErr != nil && err == nil {
+		err = fmt.Errorf("failed to close trace file: %w", closeErr)
+	}
+	return err
+}
+
+// newOTLPExporter returns the OTLP span exporter for the configured protocol, defaulting to HTTP
+func newOTLPExporter(tc config.TracingConfig) (sdktrace.SpanExporter, error) {
+	switch tc.OTLPProtocol {
+	case OTLPProtocolHTTP, "":
+		options := []otlptracehttp.Option{}
+		if tc.OTLPEndpoint != "" {
+			options = append(options, otlptracehttp.WithEndpoint(tc.OTLPEndpoint))
+		}
+		if tc.OTLPInsecure {
+			options = append(options, otlptracehttp.WithInsecure())
+		}
+		return otlptracehttp.New(context.Background(), options...)
+	case OTLPProtocolGRPC:
+		options := []otlptracegrpc.Option{}
+		if tc.OTLPEndpoint != "" {
+			options = append(options, otlptracegrpc.WithEndpoint(tc.OTLPEndpoint))
+		}
+		if tc.OTLPInsecure {
+			options = append(options, otlptracegrpc.WithInsecure())
+		}
+		return otlptracegrpc.New(context.Background(), options...)
+	default:
+		return nil, fmt.Errorf("unknown OTLP protocol %q, expected %s or %s", tc.OTLPProtocol, OTLPProtocolHTTP, OTLPProtocolGRPC)
+	}
+}
@@ -0,0 +1,131 @@
+package tracing
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/amargherio/mechanic/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func TestInitTracer(t *testing.T) {
+	tests := []struct {
+		name        string
+		enabled     bool
+		tc          config.TracingConfig
+		expectNoop  bool
+		expectError bool
+	}{
+		{
+			name:       "tracing disabled",
+			enabled:    false,
+			tc:         config.TracingConfig{Exporter: ExporterStdout},
+			expectNoop: true,
+		},
+		{
+			name:    "none exporter",
+			enabled: true,
+			tc:      config.TracingConfig{Exporter: ExporterNone},
+		},
+		{
+			name:    "stdout exporter",
+			enabled: true,
+			tc:      config.TracingConfig{Exporter: ExporterStdout},
+		},
+		{
+			name:    "file exporter",
+			enabled: true,
+			tc:      config.TracingConfig{Exporter: ExporterFile, FilePath: filepath.Join(t.TempDir(), "traces.json")},
+		},
+		{
+			name:        "file exporter without a path",
+			enabled:     true,
+			tc:          config.TracingConfig{Exporter: ExporterFile},
+			expectError: true,
+		},
+		{
+			name:    "otlp exporter",
+			enabled: true,
+			tc:      config.TracingConfig{Exporter: ExporterOTLP, OTLPEndpoint: "localhost:4318", OTLPInsecure: true},
+		},
+		{
+			name:    "otlp exporter over grpc",
+			enabled: true,
+			tc:      config.TracingConfig{Exporter: ExporterOTLP, OTLPProtocol: OTLPProtocolGRPC, OTLPEndpoint: "localhost:4317", OTLPInsecure: true},
+		},
+		{
+			name:        "otlp exporter with an unknown protocol",
+			enabled:     true,
+			tc:          config.TracingConfig{Exporter: ExporterOTLP, OTLPProtocol: "thrift"},
+			expectError: true,
+		},
+		{
+			name:        "unknown exporter",
+			enabled:     true,
+			tc:          config.TracingConfig{Exporter: "zipkin"},
+			expectError: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tp, err := InitTracer(tc.enabled, tc.tc)
+			if tc.expectError {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			if tc.expectNoop {
+				assert.IsType(t, noop.TracerProvider{}, tp)
+				return
+			}
+
+			sdkTP, ok := tp.(*sdktrace.TracerProvider)
+			require.True(t, ok, "expected an SDK tracer provider, got %T", tp)
+			assert.NoError(t, sdkTP.Shutdown(context.Background()))
+		})
+	}
+}
+
+func TestInitTracerDisabledProducesNoSpans(t *testing.T) {
+	tp, err := InitTracer(false, config.TracingConfig{Exporter: ExporterStdout})
+	require.NoError(t, err)
+
+	_, span := tp.Tracer("test").Start(context.Background(), "reconcile")
+	defer span.End()
+	assert.False(t, span.IsRecording())
+	assert.False(t, span.SpanContext().IsValid())
+
+	// the global provider is the one the rest of mechanic starts its spans from
+	_, span = otel.Tracer("test").Start(context.Background(), "reconcile")
+	defer span.End()
+	assert.False(t, span.IsRecording())
+}
+
+func TestFileExporterClosedOnShutdown(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.json")
+	tp, err := InitTracer(true, config.TracingConfig{Exporter: ExporterFile, FilePath: path})
+	require.NoError(t, err)
+
+	_, span := tp.Tracer("test").Start(context.Background(), "written-on-shutdown")
+	span.End()
+	require.NoError(t, tp.(*sdktrace.TracerProvider).Shutdown(context.Background()))
+
+	contents, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(contents), "written-on-shutdown")
+
+	// the exporter's file is closed along with the provider
+	exporter, err := newExporter(config.TracingConfig{Exporter: ExporterFile, FilePath: path})
+	require.NoError(t, err)
+	require.NoError(t, exporter.Shutdown(context.Background()))
+	_, err = exporter.(*fileExporter).file.Write([]byte("{}"))
+	assert.ErrorIs(t, err, os.ErrClosed)
+}
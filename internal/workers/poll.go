@@ -0,0 +1,55 @@
+package workers
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// pollJitter is the fraction of the polling interval each wait is randomly moved by, in either direction, so agents
+// started together don't all query IMDS at the same moment
+const pollJitter = 0.1
+
+// Poll calls fn every interval until ctx is done. The interval is read again before every wait, so a changed interval
+// takes effect from the next wait. Polling pauses, rechecking every second, while interval returns zero or less. The
+// first wait is lengthened by a random amount below startupJitter.
+func Poll(ctx context.Context, startupJitter time.Duration, interval func() time.Duration, fn func()) {
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	if delay := startupDelay(startupJitter, r); delay > 0 {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+	for {
+		wait := time.Second
+		current := interval()
+		if current > 0 {
+			wait = jitteredInterval(current, r)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		if current > 0 {
+			fn()
+		}
+	}
+}
+
+// startupDelay returns a random delay of at least zero and less than jitter, or zero when jitter is zero or less
+func startupDelay(jitter time.Duration, r *rand.Rand) time.Duration {
+	if jitter <= 0 {
+		return 0
+	}
+	return time.Duration(r.Int63n(int64(jitter)))
+}
+
+// jitteredInterval returns interval moved by a random amount of up to pollJitter of itself in either direction
+func jitteredInterval(interval time.Duration, r *rand.Rand) time.Duration {
+	jitter := time.Duration(float64(interval) * pollJitter * (2*r.Float64() - 1))
+	return interval + jitter
+}
@@ -0,0 +1,84 @@
+package workers
+
+import (
+	"context"
+	"math/rand"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJitteredIntervalBounds(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, interval := range []time.Duration{time.Second, 10 * time.Second, time.Minute} {
+		low := interval - time.Duration(float64(interval)*pollJitter)
+		high := interval + time.Duration(float64(interval)*pollJitter)
+		for i := 0; i < 1000; i++ {
+			got := jitteredInterval(interval, r)
+			assert.GreaterOrEqual(t, got, low)
+			assert.LessOrEqual(t, got, high)
+		}
+	}
+}
+
+func TestPollPicksUpChangedInterval(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// start with polling disabled, then turn it on the way a config change would
+	var interval atomic.Int64
+	polled := make(chan struct{}, 10)
+	go Poll(ctx, 0, func() time.Duration { return time.Duration(interval.Load()) }, func() { polled <- struct{}{} })
+
+	select {
+	case <-polled:
+		t.Fatal("polled while the interval was zero")
+	case <-time.After(1500 * time.Millisecond):
+	}
+
+	interval.Store(int64(20 * time.Millisecond))
+	for i := 0; i < 3; i++ {
+		select {
+		case <-polled:
+		case <-time.After(3 * time.Second):
+			t.Fatal("timed out waiting for a poll after the interval changed")
+		}
+	}
+}
+
+func TestStartupDelayBounds(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	assert.Zero(t, startupDelay(0, r))
+
+	jitter := 30 * time.Second
+	seen := map[time.Duration]bool{}
+	for i := 0; i < 1000; i++ {
+		got := startupDelay(jitter, r)
+		assert.GreaterOrEqual(t, got, time.Duration(0))
+		assert.Less(t, got, jitter)
+		seen[got] = true
+	}
+	assert.Greater(t, len(seen), 1, "the delay should vary between starts")
+}
+
+func TestPollDelaysFirstPoll(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	interval := 10 * time.Millisecond
+	jitter := 300 * time.Millisecond
+	start := time.Now()
+	polled := make(chan time.Time, 10)
+	go Poll(ctx, jitter, func() time.Duration { return interval }, func() { polled <- time.Now() })
+
+	select {
+	case first := <-polled:
+		// the first poll waits one jittered interval plus the startup delay, which is below the startup jitter
+		assert.GreaterOrEqual(t, first.Sub(start), interval-time.Duration(float64(interval)*pollJitter))
+		assert.Less(t, first.Sub(start), jitter+interval+time.Duration(float64(interval)*pollJitter)+200*time.Millisecond)
+	case <-time.After(3 * time.Second):
+		t.Fatal("timed out waiting for the first poll")
+	}
+}
@@ -0,0 +1,129 @@
+package workers
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/amargherio/mechanic/internal/config"
+	"go.opentelemetry.io/otel"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// ReconcileFunc reconciles the node with the given name
+type ReconcileFunc func(ctx context.Context, nodeName string) error
+
+// RetryPolicy is how a failed reconcile is retried. The wait before each retry starts at BaseDelay and doubles, up to
+// MaxDelay. Zero MaxRetries leaves failed reconciles to the next node update.
+type RetryPolicy struct {
+	MaxRetries int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+}
+
+// Pool reconciles queued nodes on a fixed number of workers. Different nodes are reconciled concurrently, but a node is
+// only ever handled by one worker at a time. A node queued again while it's being reconciled is reconciled once more
+// after the current reconcile finishes, however many times it was queued in the meantime. A failed reconcile is queued
+// again with backoff, so it's retried even when the node isn't updated.
+type Pool struct {
+	workers   int
+	retry     RetryPolicy
+	reconcile ReconcileFunc
+	queue     workqueue.TypedRateLimitingInterface[string]
+	wg        sync.WaitGroup
+}
+
+// NewPool returns a Pool that runs reconcile on the given number of workers, retrying failed reconciles with retry.
+// Fewer than one worker is treated as one.
+func NewPool(workers int, retry RetryPolicy, reconcile ReconcileFunc) *Pool {
+	if workers < 1 {
+		workers = 1
+	}
+	return &Pool{
+		workers:   workers,
+		retry:     retry,
+		reconcile: reconcile,
+		queue: workqueue.NewTypedRateLimitingQueueWithConfig(
+			workqueue.NewTypedItemExponentialFailureRateLimiter[string](retry.BaseDelay, retry.MaxDelay),
+			workqueue.TypedRateLimitingQueueConfig[string]{Name: "mechanic-reconcile"},
+		),
+	}
+}
+
+// Enqueue queues the node to be reconciled
+func (p *Pool) Enqueue(nodeName string) {
+	p.queue.Add(nodeName)
+}
+
+// EnqueueAfter queues the node to be reconciled once delay has passed
+func (p *Pool) EnqueueAfter(nodeName string, delay time.Duration) {
+	p.queue.AddAfter(nodeName, delay)
+}
+
+// Start starts the workers. They run until Shutdown is called.
+func (p *Pool) Start(ctx context.Context) {
+	for i := 0; i < p.workers; i++ {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for p.processNext(ctx) {
+			}
+		}()
+	}
+}
+
+// Shutdown stops accepting nodes and waits for the reconciles in progress to finish, or for ctx to be done. Nodes
+// still queued, or waiting to be retried, are dropped.
+func (p *Pool) Shutdown(ctx context.Context) error {
+	p.queue.ShutDown()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *Pool) processNext(ctx context.Context) bool {
+	nodeName, shutdown := p.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer p.queue.Done(nodeName)
+
+	tracer := otel.Tracer("github.com/amargherio/mechanic/internal/workers")
+	ctx, span := tracer.Start(ctx, "processNext")
+	defer span.End()
+
+	vals := ctx.Value("values").(*config.ContextValues)
+	log := vals.Logger
+
+	err := p.reconcile(ctx, nodeName)
+	if err == nil {
+		p.queue.Forget(nodeName)
+		return true
+	}
+
+	// a reconcile can fail partway through, like after the cordon but before the drain, so retry it rather than
+	// leaving the node half handled until it's next updated
+	retries := p.queue.NumRequeues(nodeName)
+	if retries < p.retry.MaxRetries {
+		log.Debugw("Node reconcile ended early, retrying", "node", nodeName, "error", err, "retry", retries+1, "traceCtx", ctx)
+		p.queue.AddRateLimited(nodeName)
+		return true
+	}
+	// out of retries, the next update to the node retries it like it always has
+	if p.retry.MaxRetries > 0 {
+		log.Warnw("Node reconcile failed after retrying, waiting for the next node update", "node", nodeName, "error", err, "retries", retries, "traceCtx", ctx)
+	} else {
+		log.Debugw("Node reconcile ended early", "node", nodeName, "error", err, "traceCtx", ctx)
+	}
+	p.queue.Forget(nodeName)
+	return true
+}
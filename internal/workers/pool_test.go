@@ -0,0 +1,214 @@
+package workers
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/amargherio/mechanic/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+func testContext(t *testing.T) context.Context {
+	vals := config.ContextValues{Logger: zaptest.NewLogger(t).Sugar()}
+	return context.WithValue(context.Background(), "values", &vals)
+}
+
+func TestPoolReconcilesNodesConcurrently(t *testing.T) {
+	ctx := testContext(t)
+
+	// each reconcile waits until every node has started, which only happens if they run at the same time
+	nodes := []string{"node-1", "node-2", "node-3"}
+	var started sync.WaitGroup
+	started.Add(len(nodes))
+	allStarted := make(chan struct{})
+	go func() {
+		started.Wait()
+		close(allStarted)
+	}()
+
+	var lock sync.Mutex
+	reconciled := map[string]int{}
+	pool := NewPool(len(nodes), RetryPolicy{}, func(ctx context.Context, nodeName string) error {
+		started.Done()
+		select {
+		case <-allStarted:
+		case <-time.After(5 * time.Second):
+			t.Errorf("reconcile of %s timed out waiting for the other nodes", nodeName)
+		}
+		lock.Lock()
+		reconciled[nodeName]++
+		lock.Unlock()
+		return nil
+	})
+	pool.Start(ctx)
+	for _, node := range nodes {
+		pool.Enqueue(node)
+	}
+
+	select {
+	case <-allStarted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("nodes weren't reconciled concurrently")
+	}
+	require.NoError(t, pool.Shutdown(ctx))
+	assert.Equal(t, map[string]int{"node-1": 1, "node-2": 1, "node-3": 1}, reconciled)
+}
+
+func TestPoolSerializesNode(t *testing.T) {
+	ctx := testContext(t)
+
+	var lock sync.Mutex
+	active, maxActive, reconciles := 0, 0, 0
+	release := make(chan struct{})
+	first := make(chan struct{}, 1)
+	pool := NewPool(4, RetryPolicy{}, func(ctx context.Context, nodeName string) error {
+		lock.Lock()
+		active++
+		reconciles++
+		if active > maxActive {
+			maxActive = active
+		}
+		lock.Unlock()
+
+		select {
+		case first <- struct{}{}:
+			// hold the first reconcile until the node has been queued again
+			<-release
+		default:
+		}
+
+		lock.Lock()
+		active--
+		lock.Unlock()
+		return nil
+	})
+	pool.Start(ctx)
+
+	pool.Enqueue("node-1")
+	<-first
+	// queued while the first reconcile is running, these collapse into one more reconcile once it finishes
+	for i := 0; i < 10; i++ {
+		pool.Enqueue("node-1")
+	}
+	close(release)
+
+	assert.Eventually(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return reconciles == 2 && active == 0
+	}, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, pool.Shutdown(ctx))
+
+	assert.Equal(t, 1, maxActive, "a node should only be reconciled by one worker at a time")
+	assert.Equal(t, 2, reconciles)
+}
+
+func TestPoolShutdownTimeout(t *testing.T) {
+	ctx := testContext(t)
+
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+	pool := NewPool(1, RetryPolicy{}, func(ctx context.Context, nodeName string) error {
+		close(started)
+		<-release
+		return nil
+	})
+	pool.Start(ctx)
+	pool.Enqueue("node-1")
+	<-started
+
+	shutdownCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, pool.Shutdown(shutdownCtx), context.DeadlineExceeded)
+}
+
+func TestPoolRetriesFailedReconcile(t *testing.T) {
+	ctx := testContext(t)
+
+	// the first two reconciles fail, like an apiserver hiccup between the cordon and the drain
+	var lock sync.Mutex
+	attempts := 0
+	succeeded := make(chan struct{})
+	pool := NewPool(1, RetryPolicy{MaxRetries: 5, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}, func(ctx context.Context, nodeName string) error {
+		lock.Lock()
+		defer lock.Unlock()
+		attempts++
+		if attempts < 3 {
+			return errors.New("transient failure")
+		}
+		close(succeeded)
+		return nil
+	})
+	pool.Start(ctx)
+	pool.Enqueue("node-1")
+
+	select {
+	case <-succeeded:
+	case <-time.After(5 * time.Second):
+		t.Fatal("failed reconcile wasn't retried")
+	}
+	require.NoError(t, pool.Shutdown(ctx))
+	assert.Equal(t, 3, attempts)
+	assert.Zero(t, pool.queue.NumRequeues("node-1"), "a successful reconcile resets the backoff")
+}
+
+func TestPoolStopsRetryingAfterMaxRetries(t *testing.T) {
+	ctx := testContext(t)
+
+	var lock sync.Mutex
+	attempts := 0
+	pool := NewPool(1, RetryPolicy{MaxRetries: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}, func(ctx context.Context, nodeName string) error {
+		lock.Lock()
+		defer lock.Unlock()
+		attempts++
+		return errors.New("persistent failure")
+	})
+	pool.Start(ctx)
+	pool.Enqueue("node-1")
+
+	// the first attempt and two retries, then nothing until the node is queued again
+	assert.Eventually(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return attempts == 3
+	}, 5*time.Second, time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	lock.Lock()
+	assert.Equal(t, 3, attempts)
+	lock.Unlock()
+
+	pool.Enqueue("node-1")
+	assert.Eventually(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return attempts == 6
+	}, 5*time.Second, time.Millisecond, "a node update after giving up gets a fresh set of retries")
+	require.NoError(t, pool.Shutdown(ctx))
+}
+
+func TestPoolEnqueueAfter(t *testing.T) {
+	ctx := testContext(t)
+
+	reconciled := make(chan time.Time, 1)
+	pool := NewPool(1, RetryPolicy{}, func(ctx context.Context, nodeName string) error {
+		reconciled <- time.Now()
+		return nil
+	})
+	pool.Start(ctx)
+	queued := time.Now()
+	pool.EnqueueAfter("node-1", 100*time.Millisecond)
+
+	select {
+	case at := <-reconciled:
+		assert.GreaterOrEqual(t, at.Sub(queued), 100*time.Millisecond)
+	case <-time.After(5 * time.Second):
+		t.Fatal("node wasn't reconciled after the delay")
+	}
+	require.NoError(t, pool.Shutdown(ctx))
+}
@@ -1,6 +1,7 @@
 package consts
 
 const IMDS_SCHEDULED_EVENTS_API_ENDPOINT = "http://169.254.169.254/metadata/scheduledevents"
+const IMDS_VERSIONS_API_ENDPOINT = "http://169.254.169.254/metadata/versions"
 
 type NodeCondition string
 
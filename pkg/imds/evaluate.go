@@ -0,0 +1,114 @@
+package imds
+
+import (
+	"context"
+	"time"
+
+	"github.com/amargherio/mechanic/internal/config"
+	v1 "k8s.io/api/core/v1"
+)
+
+// DrainDecision is the outcome of evaluating scheduled events against a node
+type DrainDecision struct {
+	// Drain is whether any of the events requires draining the node
+	Drain bool
+	// Event is the most urgent of the events requiring a drain. It's nil when none do.
+	Event *ScheduledEvent
+	// Impacting are the events that target the node, whether or not they require a drain
+	Impacting []ScheduledEvent
+	// SkippedFreezes are the freezes targeting the node that aren't drained for, because freezes aren't drained for
+	// and they aren't live migrations
+	SkippedFreezes []ScheduledEvent
+	// LeadTimeOpensAt is when the earliest event that's further out than the drain lead time comes within it, so the
+	// node can be looked at again then. It's zero when no event is held back by the lead time.
+	LeadTimeOpensAt time.Time
+}
+
+// EvaluateEvents decides whether the scheduled events require draining the node. It doesn't query IMDS, record
+// anything in the state, or emit events, so the decision can be made for any set of events. Events that target the node
+// require a drain when their type is drained for, they're within the lead time, and they haven't started when started
+// events are ignored. When several events require a drain, the one with the earliest NotBefore is picked, and the most
+// severe of those due at the same time. ErrInvalidNodeName is returned when the node name can't be matched to the
+// events' resources, and an error when the drain conditions' live migration patterns don't compile.
+func EvaluateEvents(ctx context.Context, events []ScheduledEvent, node *v1.Node, drainConditions *config.DrainConditions) (DrainDecision, error) {
+	vals := ctx.Value("values").(*config.ContextValues)
+	log := vals.Logger
+
+	liveMigrationPatterns, err := LiveMigrationPatterns(*drainConditions)
+	if err != nil {
+		return DrainDecision{}, err
+	}
+
+	// drainable conditions is a map of boolean values for each node condition
+	drainableConditions := map[ScheduledEventType]bool{
+		Reboot:    drainConditions.DrainOnReboot,
+		Redeploy:  drainConditions.DrainOnRedeploy,
+		Preempt:   drainConditions.DrainOnPreempt,
+		Terminate: drainConditions.DrainOnTerminate,
+		Freeze:    drainConditions.DrainOnFreeze,
+	}
+
+	// for each event, check if the event is for the current instance and collect the ones that require a drain. when
+	// there's more than one, the most urgent one is the trigger.
+	decision := DrainDecision{}
+	var drainable []ScheduledEvent
+	for _, event := range events {
+		impacted, err := isNodeImpacted(ctx, node, event, drainConditions)
+		if err != nil {
+			return DrainDecision{}, err
+		}
+		if !impacted {
+			continue
+		}
+		decision.Impacting = append(decision.Impacting, event)
+
+		if event.EventStatus == Started && drainConditions.IgnoreStartedEvents {
+			log.Infow("Found an event that targets current node but has already started, ignoring it", "event", event, "eventId", event.EventId, "traceCtx", ctx)
+			continue
+		}
+		if !exemptFromLeadTime(event, drainConditions.LeadTimeExemptSeverity, liveMigrationPatterns) && !withinLeadTime(event, drainConditions.LeadTime, time.Now()) {
+			log.Infow("Found an event that targets current node but it's further out than the drain lead time, waiting",
+				"event", event,
+				"eventId", event.EventId,
+				"notBefore", event.NotBefore.UTC(),
+				"leadTime", drainConditions.LeadTime,
+				"traceCtx", ctx)
+			opensAt := event.NotBefore.Add(-drainConditions.LeadTime)
+			if decision.LeadTimeOpensAt.IsZero() || opensAt.Before(decision.LeadTimeOpensAt) {
+				decision.LeadTimeOpensAt = opensAt
+			}
+			continue
+		}
+		if event.Type == Freeze && !drainableConditions[event.Type] && !isLiveMigration(event, liveMigrationPatterns) {
+			// not draining for this type of freeze. live migrations, recognized by the configured description
+			// patterns, are drained for even when freezes aren't.
+			log.Debugw("Found a freeze event that does not require draining", "event", event, "eventId", event.EventId, "traceCtx", ctx)
+			decision.SkippedFreezes = append(decision.SkippedFreezes, event)
+			continue
+		}
+		if event.Type != Freeze && !drainableConditions[event.Type] {
+			log.Debugw("Found an event that targets current node, but does not require draining", "event", event, "eventId", event.EventId, "traceCtx", ctx)
+			continue
+		}
+
+		log.Infow("Found event that requires draining the node", "event", event, "eventId", event.EventId, "severity", event.Severity(liveMigrationPatterns), "traceCtx", ctx)
+		drainable = append(drainable, event)
+	}
+
+	if len(drainable) > 0 {
+		sortByUrgency(drainable, liveMigrationPatterns)
+		selected := drainable[0]
+		log.Infow("Selected the most urgent event requiring a drain",
+			"node", node.Name,
+			"eventId", selected.EventId,
+			"eventType", selected.Type,
+			"notBefore", selected.NotBefore.UTC(),
+			"severity", selected.Severity(liveMigrationPatterns),
+			"impactingEvents", len(decision.Impacting),
+			"drainableEvents", len(drainable),
+			"traceCtx", ctx)
+		decision.Drain = true
+		decision.Event = &selected
+	}
+	return decision, nil
+}
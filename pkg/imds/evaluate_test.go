@@ -0,0 +1,193 @@
+package imds
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/amargherio/mechanic/internal/appstate"
+	"github.com/amargherio/mechanic/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestEvaluateEvents(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	vals := config.ContextValues{Logger: logger.Sugar(), State: &appstate.State{}}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	event := func(id string, eventType ScheduledEventType, notBefore time.Duration) ScheduledEvent {
+		return ScheduledEvent{
+			EventId:      id,
+			Type:         eventType,
+			ResourceType: "VirtualMachine",
+			Resources:    []string{"test-vmss_1"},
+			EventStatus:  Scheduled,
+			NotBefore:    time.Now().Add(notBefore),
+			EventSource:  Platform,
+		}
+	}
+	otherVM := event("other", Reboot, time.Hour)
+	otherVM.Resources = []string{"test-vmss_2"}
+	started := event("started", Reboot, 0)
+	started.EventStatus = Started
+	started.NotBefore = time.Time{}
+	liveMigration := event("live-migration", Freeze, time.Minute)
+	liveMigration.Description = "Host is undergoing memory-preserving Live Migration"
+
+	tests := []struct {
+		name                 string
+		events               []ScheduledEvent
+		dc                   config.DrainConditions
+		expectDrain          bool
+		expectEventID        string
+		expectImpacting      int
+		expectSkippedFreezes int
+	}{
+		{
+			name: "no events",
+			dc:   config.DrainConditions{DrainOnReboot: true},
+		},
+		{
+			name:   "events for other VMs",
+			events: []ScheduledEvent{otherVM},
+			dc:     config.DrainConditions{DrainOnReboot: true},
+		},
+		{
+			name:            "drainable event",
+			events:          []ScheduledEvent{otherVM, event("reboot", Reboot, time.Hour)},
+			dc:              config.DrainConditions{DrainOnReboot: true},
+			expectDrain:     true,
+			expectEventID:   "reboot",
+			expectImpacting: 1,
+		},
+		{
+			name:            "event type not drained for",
+			events:          []ScheduledEvent{event("reboot", Reboot, time.Hour)},
+			dc:              config.DrainConditions{DrainOnRedeploy: true},
+			expectImpacting: 1,
+		},
+		{
+			name:            "started event ignored",
+			events:          []ScheduledEvent{started},
+			dc:              config.DrainConditions{DrainOnReboot: true, IgnoreStartedEvents: true},
+			expectImpacting: 1,
+		},
+		{
+			name:            "started event drained for by default",
+			events:          []ScheduledEvent{started},
+			dc:              config.DrainConditions{DrainOnReboot: true},
+			expectDrain:     true,
+			expectEventID:   "started",
+			expectImpacting: 1,
+		},
+		{
+			name:            "event beyond the lead time",
+			events:          []ScheduledEvent{event("reboot", Reboot, time.Hour)},
+			dc:              config.DrainConditions{DrainOnReboot: true, LeadTime: 10 * time.Minute},
+			expectImpacting: 1,
+		},
+		{
+			name:                 "freeze not drained for",
+			events:               []ScheduledEvent{event("freeze", Freeze, time.Minute)},
+			dc:                   config.DrainConditions{},
+			expectImpacting:      1,
+			expectSkippedFreezes: 1,
+		},
+		{
+			name:            "live migration drained for when freezes aren't",
+			events:          []ScheduledEvent{liveMigration},
+			dc:              config.DrainConditions{},
+			expectDrain:     true,
+			expectEventID:   "live-migration",
+			expectImpacting: 1,
+		},
+		{
+			name:                 "live migration patterns come from the drain conditions",
+			events:               []ScheduledEvent{liveMigration},
+			dc:                   config.DrainConditions{LiveMigrationPatterns: []string{"live[- ]migrating"}},
+			expectImpacting:      1,
+			expectSkippedFreezes: 1,
+		},
+		{
+			name:            "earliest drainable event picked",
+			events:          []ScheduledEvent{event("later", Reboot, time.Hour), event("sooner", Redeploy, 10*time.Minute)},
+			dc:              config.DrainConditions{DrainOnReboot: true, DrainOnRedeploy: true},
+			expectDrain:     true,
+			expectEventID:   "sooner",
+			expectImpacting: 2,
+		},
+	}
+
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-vmss000001"}}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			decision, err := EvaluateEvents(ctx, tc.events, node, &tc.dc)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectDrain, decision.Drain)
+			if tc.expectEventID != "" {
+				require.NotNil(t, decision.Event)
+				assert.Equal(t, tc.expectEventID, decision.Event.EventId)
+			} else {
+				assert.Nil(t, decision.Event)
+			}
+			assert.Len(t, decision.Impacting, tc.expectImpacting)
+			assert.Len(t, decision.SkippedFreezes, tc.expectSkippedFreezes)
+		})
+	}
+}
+
+func TestEvaluateEventsInvalidNodeName(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	vals := config.ContextValues{Logger: logger.Sugar(), State: &appstate.State{}}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "aks-node"}}
+	events := []ScheduledEvent{{EventId: "reboot", Type: Reboot, ResourceType: "VirtualMachine", Resources: []string{"test-vmss_1"}}}
+
+	_, err := EvaluateEvents(ctx, events, node, &config.DrainConditions{DrainOnReboot: true})
+	assert.ErrorIs(t, err, ErrInvalidNodeName)
+	assert.False(t, vals.State.NodeNameErrorReported, "reporting the invalid name is left to the caller")
+}
+
+func TestEvaluateEventsInvalidLiveMigrationPattern(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	vals := config.ContextValues{Logger: logger.Sugar(), State: &appstate.State{}}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-vmss000001"}}
+	_, err := EvaluateEvents(ctx, nil, node, &config.DrainConditions{LiveMigrationPatterns: []string{"live (migration"}})
+	assert.Error(t, err)
+}
+
+func TestEvaluateEventsLeadTimeOpensAt(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	vals := config.ContextValues{Logger: logger.Sugar(), State: &appstate.State{}}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	notBefore := time.Now().Add(2 * time.Hour).Truncate(time.Second)
+	event := func(id string, notBefore time.Time) ScheduledEvent {
+		return ScheduledEvent{EventId: id, Type: Reboot, ResourceType: "VirtualMachine", Resources: []string{"test-vmss_1"}, EventStatus: Scheduled, NotBefore: notBefore}
+	}
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-vmss000001"}}
+	dc := &config.DrainConditions{DrainOnReboot: true, LeadTime: 30 * time.Minute}
+
+	// the earliest of the held back events decides when to look again
+	decision, err := EvaluateEvents(ctx, []ScheduledEvent{event("later", notBefore.Add(time.Hour)), event("sooner", notBefore)}, node, dc)
+	require.NoError(t, err)
+	assert.False(t, decision.Drain)
+	assert.Equal(t, notBefore.Add(-30*time.Minute), decision.LeadTimeOpensAt)
+
+	// an event within the lead time isn't held back
+	decision, err = EvaluateEvents(ctx, []ScheduledEvent{event("soon", time.Now().Add(10*time.Minute))}, node, dc)
+	require.NoError(t, err)
+	assert.True(t, decision.Drain)
+	assert.True(t, decision.LeadTimeOpensAt.IsZero())
+}
@@ -1,18 +1,24 @@
 package imds
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"go.opentelemetry.io/otel"
-	"io"
 	"net/http"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/amargherio/mechanic/internal/appstate"
 	"github.com/amargherio/mechanic/internal/config"
 	"github.com/amargherio/mechanic/pkg/consts"
+	"github.com/amargherio/mechanic/pkg/metrics"
 	v1 "k8s.io/api/core/v1"
 )
 
@@ -28,6 +34,7 @@ const (
 	Terminate ScheduledEventType   = "Terminate"
 	Scheduled ScheduledEventStatus = "Scheduled"
 	Started   ScheduledEventStatus = "Started"
+	Completed ScheduledEventStatus = "Completed"
 	Platform  ScheduledEventSource = "Platform"
 	User      ScheduledEventSource = "User"
 )
@@ -53,14 +60,62 @@ type ScheduledEventsResponse struct {
 	Events        []ScheduledEvent `json:"Events"`
 }
 
+// Results recorded in the scheduled event checks metric for each scheduled events response checked for a drain
+const (
+	EventCheckNoEvents     = "no_events"
+	EventCheckNotImpacting = "not_impacting"
+	EventCheckImpacting    = "impacting"
+)
+
+// Results recorded in the IMDS queries metric for each scheduled events query
+const (
+	QueryResultSuccess = "success"
+	QueryResultError   = "error"
+)
+
+// vmssInstanceSuffixLength is the number of base36 characters VMSS appends to the scale set name to name an instance
+const vmssInstanceSuffixLength = 6
+
+// ErrInvalidNodeName is returned when the node name can't be decoded into a VMSS instance name
+var ErrInvalidNodeName = errors.New("node name does not follow the VMSS naming convention")
+
+// ErrMalformedResponse is returned when the scheduled events response doesn't have the documented top-level shape
+var ErrMalformedResponse = errors.New("malformed IMDS scheduled events response")
+
 type IMDS interface {
 	QueryIMDS(ctx context.Context) (ScheduledEventsResponse, error)
+	// AckEvent approves a scheduled event so the platform can start the maintenance before its NotBefore time
+	AckEvent(ctx context.Context, eventID string) error
 }
 
-type IMDSClient struct{}
+// IMDSClient queries the scheduled events API on the local IMDS endpoint
+type IMDSClient struct {
+	// Timeout bounds each request to IMDS. Zero means no timeout.
+	Timeout time.Duration
+	// Endpoint overrides the scheduled events API URL. Empty uses the IMDS endpoint.
+	Endpoint string
+	// VersionsEndpoint overrides the IMDS api-version listing URL. Empty uses the IMDS endpoint.
+	VersionsEndpoint string
+	// APIVersion is the scheduled events api-version used when negotiation is off or fails. Empty uses
+	// DefaultAPIVersion.
+	APIVersion string
+	// NegotiateAPIVersion picks the newest api-version IMDS advertises instead of always using APIVersion
+	NegotiateAPIVersion bool
+	// MaxEventsPerResponse caps how many events are parsed from a single response. Zero means no cap.
+	MaxEventsPerResponse int
+
+	// versionLock guards resolvedVersion, the api-version settled on by the first query
+	versionLock     sync.Mutex
+	resolvedVersion string
+}
 
-// CheckIfDrainRequired checks if the node should be drained based on scheduled events from IMDS.
-func CheckIfDrainRequired(ctx context.Context, ic IMDS, node *v1.Node, drainConditions *config.DrainConditions) (bool, error) {
+// CheckIfDrainRequired checks if the node should be drained based on scheduled events from IMDS. When a drain is
+// required, the event that triggered it is returned alongside the decision. When several events require a drain, the
+// one with the earliest NotBefore triggers it, and the most severe of those due at the same time. The events are
+// evaluated by EvaluateEvents, and the events that started since the last check and the freezes that aren't drained
+// for are reported. When an event is held back by the drain lead time, the time it comes within it is recorded in the
+// state. Failed queries are retried under the retry policy.
+func CheckIfDrainRequired(ctx context.Context, ic IMDS, node *v1.Node, drainConditions *config.DrainConditions, retry config.IMDSRetryConfig) (bool, *ScheduledEvent, error) {
 	tracer := otel.Tracer("github.com/amargherio/mechanic/pkg/imds")
 	ctx, span := tracer.Start(ctx, "CheckIfDrainRequired")
 	defer span.End()
@@ -69,89 +124,202 @@ func CheckIfDrainRequired(ctx context.Context, ic IMDS, node *v1.Node, drainCond
 	log := vals.Logger
 
 	log.Infow("Checking if drain is required for node", "node", node.Name, "traceCtx", ctx)
-	shouldDrain := false // setting the default drain response to false
 
 	// query IMDS to get scheduled event data
-	var resp ScheduledEventsResponse
-	var err error
-	maxRetries := 3
-	baseDelay := 2 * time.Second
-	maxDelay := 10 * time.Second
-
-	for i := 0; i < maxRetries; i++ {
-		resp, err = ic.QueryIMDS(ctx)
-		if err == nil {
-			break
+	resp, err := QueryWithRetry(ctx, ic, retry)
+	if err != nil {
+		return false, nil, err
+	}
+	// the last response is kept so events that have moved from Scheduled to Started since then can be told apart
+	previousStatuses := previousEventStatuses(vals.State)
+	vals.State.RecordIMDSResponse(resp, time.Now())
+
+	if len(resp.Events) == 0 {
+		log.Debugw("No scheduled events found", "traceCtx", ctx)
+		metrics.ScheduledEventChecks.WithLabelValues(EventCheckNoEvents).Inc()
+		vals.State.SetLeadTimeOpensAt(time.Time{})
+		return false, nil, nil
+	}
+
+	decision, err := EvaluateEvents(ctx, resp.Events, node, drainConditions)
+	if err != nil {
+		if errors.Is(err, ErrInvalidNodeName) {
+			reportInvalidNodeName(ctx, node, err)
 		}
-		if err == io.EOF {
-			delay := baseDelay * (1 << i) // exponential backoff
-			if delay > maxDelay {
-				delay = maxDelay
+		return false, nil, err
+	}
+	vals.State.SetLeadTimeOpensAt(decision.LeadTimeOpensAt)
+
+	for _, event := range decision.Impacting {
+		if event.EventStatus == Started && previousStatuses[event.EventId] == Scheduled {
+			reportEventStarted(ctx, node, event)
+		}
+	}
+	for _, event := range decision.SkippedFreezes {
+		reportSkippedFreeze(ctx, node, event)
+	}
+
+	if len(decision.Impacting) > 0 {
+		metrics.ScheduledEventChecks.WithLabelValues(EventCheckImpacting).Inc()
+	} else {
+		// events for other VMs or for other resource types (e.g. host-level notices) show up here. call them out so a
+		// missed drain can be told apart from IMDS having nothing scheduled.
+		log.Debugw("IMDS returned scheduled events but none target this node",
+			"node", node.Name,
+			"eventCount", len(resp.Events),
+			"events", EventSummaries(resp.Events),
+			"traceCtx", ctx)
+		metrics.ScheduledEventChecks.WithLabelValues(EventCheckNotImpacting).Inc()
+	}
+	if !decision.Drain {
+		log.Infow("Did not find any events that require draining the node", "node", node.Name, "traceCtx", ctx)
+	}
+	return decision.Drain, decision.Event, nil
+}
+
+// sortByUrgency orders events so the one to act on first comes first: the earliest NotBefore, with events that have
+// no NotBefore treated as due now, and the most severe of events due at the same time. Events that tie on both keep
+// their order. Live migrations are recognized with the given patterns.
+func sortByUrgency(events []ScheduledEvent, liveMigrationPatterns []*regexp.Regexp) {
+	sort.SliceStable(events, func(i, j int) bool {
+		a, b := events[i].NotBefore, events[j].NotBefore
+		if !a.Equal(b) {
+			if a.IsZero() || b.IsZero() {
+				return a.IsZero()
 			}
-			log.Warnw("Received io.EOF error, retrying...", "attempt", i+1, "delay", delay, "traceCtx", ctx)
-			time.Sleep(delay)
-			continue
+			return a.Before(b)
 		}
-		log.Errorw("Failed to query IMDS", "error", err, "traceCtx", ctx)
-		return shouldDrain, err
+		return events[i].Severity(liveMigrationPatterns) > events[j].Severity(liveMigrationPatterns)
+	})
+}
+
+// EventSummaries describes each event by its ID, type, and the resources it targets for logging
+func EventSummaries(events []ScheduledEvent) []string {
+	summaries := make([]string, 0, len(events))
+	for _, event := range events {
+		summaries = append(summaries, fmt.Sprintf("%s %s %s %v", event.EventId, event.Type, event.ResourceType, event.Resources))
 	}
+	return summaries
+}
 
-	if len(resp.Events) == 0 {
-		log.Debugw("No scheduled events found", "traceCtx", ctx)
-		return shouldDrain, err
+// withinLeadTime reports whether the event's NotBefore time is close enough to act on. Events without a NotBefore time
+// and events whose NotBefore has passed are always within it, as is every event when leadTime is zero.
+func withinLeadTime(event ScheduledEvent, leadTime time.Duration, now time.Time) bool {
+	if leadTime <= 0 || event.NotBefore.IsZero() {
+		return true
 	}
+	return !event.NotBefore.UTC().After(now.UTC().Add(leadTime))
+}
 
-	// drainable conditions is a map of boolean values for each node condition
-	drainableConditions := map[ScheduledEventType]bool{
-		Reboot:    drainConditions.DrainOnReboot,
-		Redeploy:  drainConditions.DrainOnRedeploy,
-		Preempt:   drainConditions.DrainOnPreempt,
-		Terminate: drainConditions.DrainOnTerminate,
-		Freeze:    drainConditions.DrainOnFreeze,
+// HasImpactingEvents queries IMDS and reports whether any scheduled event currently targets the node, regardless of
+// whether we're configured to drain for it. It's used to confirm a node condition still reflects a real event. Failed
+// queries are retried under the retry policy.
+func HasImpactingEvents(ctx context.Context, ic IMDS, node *v1.Node, drainConditions *config.DrainConditions, retry config.IMDSRetryConfig) (bool, error) {
+	tracer := otel.Tracer("github.com/amargherio/mechanic/pkg/imds")
+	ctx, span := tracer.Start(ctx, "HasImpactingEvents")
+	defer span.End()
+
+	vals := ctx.Value("values").(*config.ContextValues)
+	log := vals.Logger
+
+	resp, err := QueryWithRetry(ctx, ic, retry)
+	if err != nil {
+		return false, err
 	}
+	vals.State.RecordIMDSResponse(resp, time.Now())
 
-	// for each event in the scheduled events response, check if the event is for the current instance
 	for _, event := range resp.Events {
-		impacted, err := isNodeImpacted(ctx, node, event)
+		impacted, err := isNodeImpacted(ctx, node, event, drainConditions)
 		if err != nil {
-			return shouldDrain, err
+			return false, err
 		}
-
 		if impacted {
-			if event.Type != Freeze && drainableConditions[event.Type] {
-				// this is all non-freeze event types since we need to do special things with freezes
-				log.Infow("Found event that requires draining the node", "event", event, "eventId", event.EventId, "traceCtx", ctx)
-				shouldDrain = true
-				return shouldDrain, nil
-			} else if event.Type == Freeze {
-				if !drainableConditions[event.Type] {
-					// check if it's an LM and not a regular freeze. if so, proceed with the drain
-					// TODO: Freeze event types also indicate an LM which could be critical...how do we differentiate? using description is a poor workaround
-					if strings.Contains(event.Description, "memory-preserving Live Migration") {
-						log.Infow("Found event that requires draining the node", "event", event, "eventId", event.EventId, "traceCtx", ctx)
-						shouldDrain = true
-						return shouldDrain, nil
-					} else {
-						// not draining for this type of freeze
-						log.Debugw("Found a freeze event that does not require draining", "event", event, "eventId", event.EventId, "traceCtx", ctx)
-						continue
-					}
-				} else {
-					// the customer wants to be drained for freeze events, so why not!
-					log.Infow("Found event that requires draining the node", "event", event, "eventId", event.EventId, "traceCtx", ctx)
-					shouldDrain = true
-					return shouldDrain, nil
-				}
-			} else {
-				log.Debugw("Found an event that targets current node, but does not require draining", "event", event, "eventId", event.EventId, "traceCtx", ctx)
-			}
+			return true, nil
 		}
 	}
-	log.Infow("Did not find any events that require draining the node", "node", node.Name, "traceCtx", ctx)
-	return shouldDrain, nil
+
+	log.Debugw("No scheduled events in IMDS impact the node", "node", node.Name, "eventCount", len(resp.Events), "traceCtx", ctx)
+	return false, nil
 }
 
-func isNodeImpacted(ctx context.Context, node *v1.Node, event ScheduledEvent) (bool, error) {
+// previousEventStatuses returns the status of each event in the IMDS response recorded before this check, by EventId.
+// It's empty when no response has been recorded yet.
+func previousEventStatuses(state *appstate.State) map[string]ScheduledEventStatus {
+	statuses := make(map[string]ScheduledEventStatus)
+	snapshot, ok := state.LastIMDSResponse()
+	if !ok {
+		return statuses
+	}
+	previous, ok := snapshot.Response.(ScheduledEventsResponse)
+	if !ok {
+		return statuses
+	}
+	for _, event := range previous.Events {
+		statuses[event.EventId] = event.EventStatus
+	}
+	return statuses
+}
+
+// reportEventStarted logs, counts, and emits an event on the node for an impacting event that was Scheduled in the
+// previous IMDS response and has now Started, marking when the platform began the maintenance. Since the previous
+// response then has the event as Started, each transition is only reported once.
+func reportEventStarted(ctx context.Context, node *v1.Node, event ScheduledEvent) {
+	vals := ctx.Value("values").(*config.ContextValues)
+	log := vals.Logger
+
+	log.Infow("Scheduled event targeting the node has started", "node", node.Name, "eventId", event.EventId, "eventType", event.Type, "notBefore", event.NotBefore.UTC(), "traceCtx", ctx)
+	metrics.ScheduledEventsStarted.WithLabelValues(string(event.Type)).Inc()
+	if vals.Recorder != nil {
+		vals.Recorder.Eventf(node, v1.EventTypeNormal, "ScheduledEventStarted", "Scheduled %s event %s has started", event.Type, event.EventId)
+	}
+}
+
+// reportSkippedFreeze emits an informational event on the node when a freeze that isn't a live migration is found and
+// we're not configured to drain for it, so brief freezes can be correlated with latency seen on the node. The freeze is
+// also recorded in the state for the caller to annotate the node with. It's only reported once per EventId.
+func reportSkippedFreeze(ctx context.Context, node *v1.Node, event ScheduledEvent) {
+	vals := ctx.Value("values").(*config.ContextValues)
+	if !vals.State.MarkFreezeReported(event.EventId, event.NotBefore) {
+		return
+	}
+
+	vals.State.RecordSkippedFreeze(event.EventId, event.NotBefore)
+	if vals.Recorder != nil {
+		vals.Recorder.Eventf(node, v1.EventTypeNormal, "FreezeSkipped", "Freeze event %s detected but not a live migration; not draining (notBefore: %s, description: %q)", event.EventId, notBeforeString(event.NotBefore), event.Description)
+	}
+}
+
+// notBeforeString formats an event's NotBefore for messages. Events that have started have no NotBefore.
+func notBeforeString(notBefore time.Time) string {
+	if notBefore.IsZero() {
+		return "none"
+	}
+	return notBefore.UTC().Format(time.RFC3339)
+}
+
+// reportInvalidNodeName logs guidance the first time the node name fails to decode and counts every failure. The node
+// name won't change for the life of the process, so repeating the error every check only adds noise.
+func reportInvalidNodeName(ctx context.Context, node *v1.Node, err error) {
+	vals := ctx.Value("values").(*config.ContextValues)
+	log := vals.Logger
+
+	metrics.NodeNameParseErrors.Inc()
+
+	if !vals.State.MarkNodeNameErrorReported() {
+		log.Debugw("Node name could not be decoded into a VMSS instance name", "node", node.Name, "error", err, "traceCtx", ctx)
+		return
+	}
+	log.Errorw("Node name could not be decoded into a VMSS instance name. Mechanic matches scheduled events using the "+
+		"AKS VMSS node naming convention (<scale set name><6 character base36 instance ID>), so scheduled events can't be "+
+		"evaluated for this node. Verify the node belongs to a VMSS-backed node pool. This error is only logged once.",
+		"node", node.Name, "error", err, "traceCtx", ctx)
+}
+
+// isNodeImpacted reports whether the event targets the node. Events for the configured impacting resource types are
+// matched against the node, as are events of any other resource type when MatchResourcesOfAnyType is set. Events with
+// no resources listed impact every node when TreatEmptyResourcesAsImpacting is set, and no node otherwise. Completed
+// events impact no node.
+func isNodeImpacted(ctx context.Context, node *v1.Node, event ScheduledEvent, drainConditions *config.DrainConditions) (bool, error) {
 	tracer := otel.Tracer("github.com/amargherio/mechanic/pkg/imds")
 	ctx, span := tracer.Start(ctx, "isNodeImpacted")
 	defer span.End()
@@ -166,13 +334,36 @@ func isNodeImpacted(ctx context.Context, node *v1.Node, event ScheduledEvent) (b
 		return false, err
 	}
 
-	// check if the event impacts the node
-	if event.ResourceType == "VirtualMachine" {
-		for _, value := range event.Resources {
-			if value == instance || strings.Contains(value, instance) {
-				log.Infow("Node is impacted by event", "node", node.Name, "event", event.EventId, "traceCtx", ctx)
-				return true, nil
+	// the maintenance behind a completed event is over, so it no longer impacts the node
+	if event.EventStatus == Completed {
+		log.Debugw("Event has completed", "node", node.Name, "event", event.EventId, "traceCtx", ctx)
+		return false, nil
+	}
+
+	emptyResourcesImpacting := drainConditions.TreatEmptyResourcesAsImpacting
+	if len(event.Resources) == 0 {
+		log.Debugw("Event does not list any resources", "node", node.Name, "event", event.EventId, "impacting", emptyResourcesImpacting, "traceCtx", ctx)
+		if emptyResourcesImpacting {
+			log.Infow("Node is impacted by event with no resources listed", "node", node.Name, "event", event.EventId, "traceCtx", ctx)
+		}
+		return emptyResourcesImpacting, nil
+	}
+
+	// check if the event impacts the node. an event naming the instance under a resource type we don't check for is
+	// still treated as impacting when configured to, since IMDS can report the same VM under other resource types.
+	impactingType := drainConditions.IsImpactingResourceType(event.ResourceType)
+	if !impactingType && !drainConditions.MatchResourcesOfAnyType {
+		log.Debugw("Node is not impacted by event", "node", node.Name, "event", event.EventId, "resourceType", event.ResourceType, "traceCtx", ctx)
+		return false, nil
+	}
+	// names are compared whole, so instance vmss_1 isn't matched by an event for vmss_10
+	for _, value := range event.Resources {
+		if normalizeInstanceName(value) == normalizeInstanceName(instance) {
+			if !impactingType {
+				log.Debugw("Event names the node's instance under an unexpected resource type, treating it as impacting", "node", node.Name, "event", event.EventId, "resourceType", event.ResourceType, "traceCtx", ctx)
 			}
+			log.Infow("Node is impacted by event", "node", node.Name, "event", event.EventId, "traceCtx", ctx)
+			return true, nil
 		}
 	}
 
@@ -180,6 +371,18 @@ func isNodeImpacted(ctx context.Context, node *v1.Node, event ScheduledEvent) (b
 	return false, nil
 }
 
+// normalizeInstanceName reduces a VM name listed in an event's resources, or a full resource ID ending in one, to the
+// lower case name so it can be compared to the node's instance name. Azure lists VMSS instances as _<scale set>_<id>,
+// so a single leading underscore is dropped, and Azure resource names aren't case-sensitive.
+func normalizeInstanceName(name string) string {
+	name = strings.TrimSpace(name)
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.TrimPrefix(name, "_")
+	return strings.ToLower(name)
+}
+
 func getInstanceName(ctx context.Context, node *v1.Node) (string, error) {
 	tracer := otel.Tracer("github.com/amargherio/pkg/mechanic")
 	ctx, span := tracer.Start(ctx, "getInstanceName")
@@ -189,15 +392,29 @@ func getInstanceName(ctx context.Context, node *v1.Node) (string, error) {
 	log := vals.Logger
 	log.Debugw("Getting instance name for node", "node", node.Name, "traceCtx", ctx)
 
+	// VMSS node names are the scale set name followed by the instance number as six base36 characters. anything
+	// shorter can't hold both, and would panic when sliced below.
+	if len(node.Name) <= vmssInstanceSuffixLength {
+		log.Debugw("Node name is too short to contain a VMSS instance suffix", "node", node.Name, "traceCtx", ctx)
+		return "", fmt.Errorf("%w: %q is shorter than a scale set name and %d character instance suffix", ErrInvalidNodeName, node.Name, vmssInstanceSuffixLength)
+	}
+
 	// get the last six characters of the node name
-	instanceName := node.Name[len(node.Name)-6:]
-	vm := node.Name[:len(node.Name)-6]
+	instanceName := node.Name[len(node.Name)-vmssInstanceSuffixLength:]
+	vm := node.Name[:len(node.Name)-vmssInstanceSuffixLength]
+
+	// ParseInt also accepts signs and upper case letters, neither of which appear in a VMSS instance suffix. letting
+	// them through would decode names that aren't VMSS names into an instance that doesn't exist.
+	if !isVMSSInstanceSuffix(instanceName) {
+		log.Debugw("Node name doesn't end in a VMSS instance suffix", "node", node.Name, "suffix", instanceName, "traceCtx", ctx)
+		return "", fmt.Errorf("%w: %q doesn't end in a base36 instance suffix", ErrInvalidNodeName, node.Name)
+	}
 
 	// base36 decode the instanceName to get the VMSS instance number
 	decoded, err := strconv.ParseInt(instanceName, 36, 64)
 	if err != nil {
-		log.Errorw("Failed to decode instance name", "error", err, "traceCtx", ctx)
-		return "", err
+		log.Debugw("Failed to decode instance name", "error", err, "traceCtx", ctx)
+		return "", fmt.Errorf("%w: %s", ErrInvalidNodeName, err)
 	}
 
 	decodedInstanceName := fmt.Sprintf("%s_%d", vm, decoded)
@@ -205,9 +422,19 @@ func getInstanceName(ctx context.Context, node *v1.Node) (string, error) {
 	return decodedInstanceName, nil
 }
 
+// isVMSSInstanceSuffix reports whether suffix only contains the lower case base36 digits VMSS uses for instance numbers
+func isVMSSInstanceSuffix(suffix string) bool {
+	for _, c := range suffix {
+		if !(c >= '0' && c <= '9') && !(c >= 'a' && c <= 'z') {
+			return false
+		}
+	}
+	return true
+}
+
 // QueryIMDS queries the Instance Metadata Service (IMDS) for scheduled events.
 // It returns a ScheduledEventsResponse containing the events and an error if any occurred during the query.
-func (ic IMDSClient) QueryIMDS(ctx context.Context) (ScheduledEventsResponse, error) {
+func (ic *IMDSClient) QueryIMDS(ctx context.Context) (ScheduledEventsResponse, error) {
 	tracer := otel.Tracer("github.com/amargherio/mechanic/pkg/imds")
 	ctx, span := tracer.Start(ctx, "QueryIMDS")
 	defer span.End()
@@ -216,42 +443,155 @@ func (ic IMDSClient) QueryIMDS(ctx context.Context) (ScheduledEventsResponse, er
 	log := vals.Logger
 	log.Debugw("Querying IMDS for scheduled event data", "traceCtx", ctx)
 
-	// query IMDS for scheduled events
-	var eventResponse ScheduledEventsResponse
+	// query IMDS for scheduled events. the timeout is applied to the request context as well as the client so the
+	// request and its span end together when IMDS hangs.
 	client := http.Client{
 		Transport: &http.Transport{Proxy: nil},
+		Timeout:   ic.Timeout,
+	}
+	if ic.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, ic.Timeout)
+		defer cancel()
 	}
 
-	req, _ := http.NewRequest("GET", consts.IMDS_SCHEDULED_EVENTS_API_ENDPOINT, nil)
+	version := ic.resolveAPIVersion(ctx, &client)
+	eventResponse, status, err := ic.queryScheduledEvents(ctx, &client, version)
+	if status == http.StatusBadRequest && version != ic.defaultAPIVersion() {
+		// IMDS advertises api-versions for all of its APIs, and the scheduled events API may not support the newest
+		log.Warnw("IMDS rejected the negotiated api-version, falling back to the configured version",
+			"apiVersion", version,
+			"fallback", ic.defaultAPIVersion(),
+			"traceCtx", ctx)
+		ic.rejectAPIVersion(version)
+		eventResponse, _, err = ic.queryScheduledEvents(ctx, &client, ic.defaultAPIVersion())
+	}
+	if err != nil {
+		metrics.IMDSQueries.WithLabelValues(QueryResultError).Inc()
+		return ScheduledEventsResponse{}, err
+	}
+	metrics.IMDSQueries.WithLabelValues(QueryResultSuccess).Inc()
+
+	return eventResponse, nil
+}
+
+// queryScheduledEvents requests the scheduled events API with the given api-version. The HTTP status is returned
+// alongside any error so the caller can tell a rejected api-version apart from other failures.
+func (ic *IMDSClient) queryScheduledEvents(ctx context.Context, client *http.Client, version string) (ScheduledEventsResponse, int, error) {
+	vals := ctx.Value("values").(*config.ContextValues)
+	log := vals.Logger
+
+	endpoint := ic.Endpoint
+	if endpoint == "" {
+		endpoint = consts.IMDS_SCHEDULED_EVENTS_API_ENDPOINT
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		log.Errorw("Failed to build IMDS request", "error", err, "traceCtx", ctx)
+		return ScheduledEventsResponse{}, 0, err
+	}
 	req.Header.Add("Metadata", "true")
 	q := req.URL.Query()
-	q.Add("api-version", "2020-07-01")
+	q.Add("api-version", version)
 
 	req.URL.RawQuery = q.Encode()
 
 	resp, err := client.Do(req)
 	if err != nil {
 		log.Errorw("Failed to query IMDS", "error", err, "traceCtx", ctx)
-		return ScheduledEventsResponse{}, err
+		return ScheduledEventsResponse{}, 0, err
 	}
 
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		log.Errorw("IMDS returned an error response", "status", resp.Status, "apiVersion", version, "traceCtx", ctx)
+		return ScheduledEventsResponse{}, resp.StatusCode, fmt.Errorf("IMDS returned %s for api-version %s", resp.Status, version)
+	}
+
 	// decode the JSON response and handle an EOF response
 	var generic map[string]interface{}
 	if err := json.NewDecoder(resp.Body).Decode(&generic); err != nil {
 		log.Errorw("Failed to decode IMDS response", "error", err, "traceCtx", ctx)
-		return ScheduledEventsResponse{}, err
+		return ScheduledEventsResponse{}, resp.StatusCode, err
 	}
 	log.Debugw("IMDS response", "status", resp.Status, "json", generic, "traceCtx", ctx)
 
-	eventResponse = ScheduledEventsResponse{}
-	buildEventResponse(ctx, generic, &eventResponse)
+	eventResponse := ScheduledEventsResponse{}
+	if err := buildEventResponse(ctx, generic, &eventResponse, ic.MaxEventsPerResponse); err != nil {
+		log.Errorw("Failed to parse IMDS response", "error", err, "traceCtx", ctx)
+		return ScheduledEventsResponse{}, resp.StatusCode, err
+	}
+
+	return eventResponse, resp.StatusCode, nil
+}
 
-	return eventResponse, nil
+// startRequests is the body POSTed to the scheduled events API to approve events
+type startRequests struct {
+	StartRequests []startRequest `json:"StartRequests"`
+}
+
+type startRequest struct {
+	EventId string `json:"EventId"`
+}
+
+// AckEvent approves the scheduled event with the given ID by POSTing a start request to the scheduled events API. Once
+// every VM the event targets has approved it, the platform starts the maintenance without waiting for NotBefore.
+func (ic *IMDSClient) AckEvent(ctx context.Context, eventID string) error {
+	tracer := otel.Tracer("github.com/amargherio/mechanic/pkg/imds")
+	ctx, span := tracer.Start(ctx, "AckEvent")
+	defer span.End()
+
+	vals := ctx.Value("values").(*config.ContextValues)
+	log := vals.Logger
+	log.Debugw("Acknowledging scheduled event", "eventId", eventID, "traceCtx", ctx)
+
+	client := http.Client{
+		Transport: &http.Transport{Proxy: nil},
+		Timeout:   ic.Timeout,
+	}
+	if ic.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, ic.Timeout)
+		defer cancel()
+	}
+
+	body, err := json.Marshal(startRequests{StartRequests: []startRequest{{EventId: eventID}}})
+	if err != nil {
+		return err
+	}
+
+	endpoint := ic.Endpoint
+	if endpoint == "" {
+		endpoint = consts.IMDS_SCHEDULED_EVENTS_API_ENDPOINT
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Metadata", "true")
+	req.Header.Add("Content-Type", "application/json")
+	q := req.URL.Query()
+	q.Add("api-version", ic.resolveAPIVersion(ctx, &client))
+	req.URL.RawQuery = q.Encode()
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("IMDS returned %s acknowledging event %s", resp.Status, eventID)
+	}
+
+	log.Infow("Acknowledged scheduled event", "eventId", eventID, "traceCtx", ctx)
+	return nil
 }
 
-func buildEventResponse(ctx context.Context, generic map[string]interface{}, eventResponse *ScheduledEventsResponse) {
+// buildEventResponse fills the event response from the decoded JSON body. An error is returned when the top-level shape
+// is wrong, while malformed events are logged and skipped.
+func buildEventResponse(ctx context.Context, generic map[string]interface{}, eventResponse *ScheduledEventsResponse, maxEvents int) error {
 	tracer := otel.Tracer("github.com/amargherio/mechanic/pkg/imds")
 	ctx, span := tracer.Start(ctx, "buildEventResponse")
 	defer span.End()
@@ -260,36 +600,31 @@ func buildEventResponse(ctx context.Context, generic map[string]interface{}, eve
 	log := vals.Logger
 	log.Debugw("Creating event response from IMDS response", "response", generic, "traceCtx", ctx)
 
-	eventResponse.IncarnationID = generic["DocumentIncarnation"].(float64)
-	events := generic["Events"].([]interface{})
-	for _, e := range events {
-		event := ScheduledEvent{}
-		eventMap := e.(map[string]interface{})
-
-		event.EventId = eventMap["EventId"].(string)
-		event.Type = ScheduledEventType(eventMap["EventType"].(string))
-		event.ResourceType = eventMap["ResourceType"].(string)
-		event.EventStatus = ScheduledEventStatus(eventMap["EventStatus"].(string))
-		event.Description = eventMap["Description"].(string)
-		event.EventSource = ScheduledEventSource(eventMap["EventSource"].(string))
-
-		// "resources" is going to be initially typed as []interface{} so we have to do special things to convert it to
-		// []string
-		event.Resources = make([]string, len(eventMap["Resources"].([]interface{})))
-		for i, v := range eventMap["Resources"].([]interface{}) {
-			event.Resources[i] = v.(string)
-		}
-
-		// handle time and duration parsing
-		if eventMap["NotBefore"] != nil || eventMap["DurationInSeconds"] != "" {
-			parsed, err := time.Parse("Mon, 02 Jan 2006 15:04:05 GMT", eventMap["NotBefore"].(string))
-			if err != nil {
-				log.Warnw("Failed to parse NotBefore time", "error", err)
-			}
-			event.NotBefore = parsed
-			event.Duration = time.Duration(eventMap["DurationInSeconds"].(float64)) * time.Second
-		} else {
-			log.Debug("No NotBefore or DurationInSeconds found in event details from IMDS", "traceCtx", ctx)
+	incarnation, ok := generic["DocumentIncarnation"].(float64)
+	if !ok {
+		return fmt.Errorf("%w: %w", ErrMalformedResponse, fieldError("DocumentIncarnation", generic["DocumentIncarnation"], "a number"))
+	}
+	events, ok := generic["Events"].([]interface{})
+	if !ok {
+		return fmt.Errorf("%w: %w", ErrMalformedResponse, fieldError("Events", generic["Events"], "an array"))
+	}
+	eventResponse.IncarnationID = incarnation
+	metrics.EventsPerResponse.Observe(float64(len(events)))
+	// a node only ever has a handful of events scheduled, so a response with far more is malformed. parse what fits
+	// under the cap rather than spending the reconcile on the rest.
+	if maxEvents > 0 && len(events) > maxEvents {
+		log.Warnw("IMDS response has more events than the cap, ignoring the rest",
+			"eventCount", len(events),
+			"maxEvents", maxEvents,
+			"traceCtx", ctx)
+		events = events[:maxEvents]
+	}
+	for i, e := range events {
+		// one malformed event shouldn't hide the others, so it's skipped rather than failing the response
+		event, err := parseEvent(ctx, e)
+		if err != nil {
+			log.Warnw("Skipping malformed event in IMDS response", "index", i, "error", err, "event", e, "traceCtx", ctx)
+			continue
 		}
 
 		log.Debugw("Adding parsed event to event slice", "event", event, "traceCtx", ctx)
@@ -298,4 +633,107 @@ func buildEventResponse(ctx context.Context, generic map[string]interface{}, eve
 	}
 
 	log.Debugw(fmt.Sprintf("Returning an event response with %d events", len(eventResponse.Events)), "eventCount", len(eventResponse.Events), "eventId", eventResponse.IncarnationID, "traceCtx", ctx)
+	return nil
+}
+
+// parseEvent converts one entry of the response's Events array into a ScheduledEvent. EventId, EventType, and
+// Resources are required, the other fields are left empty when missing, and any field of the wrong type is an error.
+func parseEvent(ctx context.Context, e interface{}) (ScheduledEvent, error) {
+	vals := ctx.Value("values").(*config.ContextValues)
+	log := vals.Logger
+
+	eventMap, ok := e.(map[string]interface{})
+	if !ok {
+		return ScheduledEvent{}, fieldError("event", e, "an object")
+	}
+
+	event := ScheduledEvent{}
+	var err error
+	if event.EventId, err = stringField(eventMap, "EventId", true); err != nil {
+		return ScheduledEvent{}, err
+	}
+	eventType, err := stringField(eventMap, "EventType", true)
+	if err != nil {
+		return ScheduledEvent{}, err
+	}
+	event.Type = ScheduledEventType(eventType)
+	if event.ResourceType, err = stringField(eventMap, "ResourceType", false); err != nil {
+		return ScheduledEvent{}, err
+	}
+	status, err := stringField(eventMap, "EventStatus", false)
+	if err != nil {
+		return ScheduledEvent{}, err
+	}
+	event.EventStatus = ScheduledEventStatus(status)
+	if event.Description, err = stringField(eventMap, "Description", false); err != nil {
+		return ScheduledEvent{}, err
+	}
+	source, err := stringField(eventMap, "EventSource", false)
+	if err != nil {
+		return ScheduledEvent{}, err
+	}
+	event.EventSource = ScheduledEventSource(source)
+
+	// "resources" is going to be initially typed as []interface{} so we have to do special things to convert it to
+	// []string
+	resources, ok := eventMap["Resources"].([]interface{})
+	if !ok {
+		return ScheduledEvent{}, fieldError("Resources", eventMap["Resources"], "an array")
+	}
+	event.Resources = make([]string, len(resources))
+	for i, v := range resources {
+		resource, ok := v.(string)
+		if !ok {
+			return ScheduledEvent{}, fieldError(fmt.Sprintf("Resources[%d]", i), v, "a string")
+		}
+		event.Resources[i] = resource
+	}
+
+	// handle time and duration parsing. NotBefore is empty once the event has started.
+	notBefore, err := stringField(eventMap, "NotBefore", false)
+	if err != nil {
+		return ScheduledEvent{}, err
+	}
+	if notBefore != "" {
+		parsed, err := time.Parse("Mon, 02 Jan 2006 15:04:05 GMT", notBefore)
+		if err != nil {
+			log.Warnw("Failed to parse NotBefore time", "error", err, "traceCtx", ctx)
+		}
+		event.NotBefore = parsed
+	} else {
+		log.Debugw("No NotBefore found in event details from IMDS", "eventId", event.EventId, "traceCtx", ctx)
+	}
+	if duration, present := eventMap["DurationInSeconds"]; present && duration != nil {
+		seconds, ok := duration.(float64)
+		if !ok {
+			return ScheduledEvent{}, fieldError("DurationInSeconds", duration, "a number")
+		}
+		event.Duration = time.Duration(seconds) * time.Second
+	}
+
+	return event, nil
+}
+
+// stringField returns the named string field of an event. A missing field is an error only when it's required.
+func stringField(eventMap map[string]interface{}, name string, required bool) (string, error) {
+	value, present := eventMap[name]
+	if !present || value == nil {
+		if required {
+			return "", fieldError(name, nil, "a string")
+		}
+		return "", nil
+	}
+	s, ok := value.(string)
+	if !ok {
+		return "", fieldError(name, value, "a string")
+	}
+	return s, nil
+}
+
+// fieldError describes a field of the IMDS response that is missing or of an unexpected type
+func fieldError(name string, value interface{}, expected string) error {
+	if value == nil {
+		return fmt.Errorf("%s is missing, expected %s", name, expected)
+	}
+	return fmt.Errorf("%s is a %T, expected %s", name, value, expected)
 }
@@ -39,6 +39,20 @@ func (m *MockIMDS) EXPECT() *MockIMDSMockRecorder {
 	return m.recorder
 }
 
+// AckEvent mocks base method.
+func (m *MockIMDS) AckEvent(ctx context.Context, eventID string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AckEvent", ctx, eventID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AckEvent indicates an expected call of AckEvent.
+func (mr *MockIMDSMockRecorder) AckEvent(ctx, eventID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AckEvent", reflect.TypeOf((*MockIMDS)(nil).AckEvent), ctx, eventID)
+}
+
 // QueryIMDS mocks base method.
 func (m *MockIMDS) QueryIMDS(ctx context.Context) (ScheduledEventsResponse, error) {
 	m.ctrl.T.Helper()
@@ -2,17 +2,30 @@ package imds
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
+	"go.uber.org/zap"
 	"go.uber.org/zap/zaptest"
+	"go.uber.org/zap/zaptest/observer"
 
 	"github.com/amargherio/mechanic/internal/appstate"
 	"github.com/amargherio/mechanic/internal/config"
+	"github.com/amargherio/mechanic/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.uber.org/mock/gomock"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
 )
 
 type TestCase struct {
@@ -201,6 +214,85 @@ func TestCheckIfDrainRequired(t *testing.T) {
 				DrainOnTerminate: false,
 			},
 		},
+		{
+			name: "started event drains by default",
+			mockResponse: ScheduledEventsResponse{
+				IncarnationID: 1,
+				Events: []ScheduledEvent{
+					{
+						EventId:      "started",
+						Type:         Preempt,
+						ResourceType: "VirtualMachine",
+						Resources:    []string{"test-vmss_1"},
+						EventStatus:  Started,
+						NotBefore:    time.Now(),
+						Description:  "test",
+						EventSource:  Platform,
+					},
+				},
+			},
+			expectedResult: true,
+			drainConditions: config.DrainConditions{
+				DrainOnPreempt: true,
+			},
+		},
+		{
+			name: "started event ignored when configured",
+			mockResponse: ScheduledEventsResponse{
+				IncarnationID: 1,
+				Events: []ScheduledEvent{
+					{
+						EventId:      "started",
+						Type:         Preempt,
+						ResourceType: "VirtualMachine",
+						Resources:    []string{"test-vmss_1"},
+						EventStatus:  Started,
+						NotBefore:    time.Now(),
+						Description:  "test",
+						EventSource:  Platform,
+					},
+				},
+			},
+			expectedResult: false,
+			drainConditions: config.DrainConditions{
+				DrainOnPreempt:      true,
+				IgnoreStartedEvents: true,
+			},
+		},
+		{
+			name: "scheduled event still drains when started events are ignored",
+			mockResponse: ScheduledEventsResponse{
+				IncarnationID: 1,
+				Events: []ScheduledEvent{
+					{
+						EventId:      "started",
+						Type:         Preempt,
+						ResourceType: "VirtualMachine",
+						Resources:    []string{"test-vmss_1"},
+						EventStatus:  Started,
+						NotBefore:    time.Now(),
+						Description:  "test",
+						EventSource:  Platform,
+					},
+					{
+						EventId:      "scheduled",
+						Type:         Reboot,
+						ResourceType: "VirtualMachine",
+						Resources:    []string{"test-vmss_1"},
+						EventStatus:  Scheduled,
+						NotBefore:    time.Now().Add(1 * time.Hour),
+						Description:  "test",
+						EventSource:  Platform,
+					},
+				},
+			},
+			expectedResult: true,
+			drainConditions: config.DrainConditions{
+				DrainOnPreempt:      true,
+				DrainOnReboot:       true,
+				IgnoreStartedEvents: true,
+			},
+		},
 	}
 
 	logger := zaptest.NewLogger(t)
@@ -231,13 +323,14 @@ func TestCheckIfDrainRequired(t *testing.T) {
 				ObjectMeta: metav1.ObjectMeta{Name: "test-vmss000001"},
 			}
 
-			b, err := CheckIfDrainRequired(ctx, mockIMDS, node, &tc.drainConditions)
+			b, event, err := CheckIfDrainRequired(ctx, mockIMDS, node, &tc.drainConditions, config.IMDSRetryConfig{})
 			if err != nil {
 				t.Errorf("Unexpected error: %v", err)
 			}
-			state.ShouldDrain = b
+			state.SetDrainRequired(b)
 
-			assert.Equal(t, tc.expectedResult, state.ShouldDrain)
+			assert.Equal(t, tc.expectedResult, state.DrainRequired())
+			assert.Equal(t, tc.expectedResult, event != nil, "Expected the triggering event to be returned only when a drain is required")
 		})
 	}
 }
@@ -251,3 +344,1073 @@ func configureMocks(t TestCase, ctrl *gomock.Controller) *MockIMDS {
 
 	return mock
 }
+
+func TestCheckIfDrainRequiredReportsSkippedFreeze(t *testing.T) {
+	notBefore := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name           string
+		eventType      ScheduledEventType
+		description    string
+		expectedEvents int
+	}{
+		{
+			name:           "non-LM freeze reports a single event",
+			description:    "freeze maintenance",
+			expectedEvents: 1,
+		},
+		{
+			name:           "other event types we don't drain for are not reported",
+			eventType:      Reboot,
+			description:    "reboot maintenance",
+			expectedEvents: 0,
+		},
+		{
+			name:           "live migration does not report a skipped freeze",
+			description:    "Virtual machine is being paused because of a memory-preserving Live Migration operation.",
+			expectedEvents: 0,
+		},
+	}
+
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	sugar := logger.Sugar()
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			recorder := record.NewFakeRecorder(10)
+			state := &appstate.State{}
+			eventType := tc.eventType
+			if eventType == "" {
+				eventType = Freeze
+			}
+
+			vals := config.ContextValues{
+				Logger:   sugar,
+				State:    state,
+				Recorder: recorder,
+			}
+			ctx := context.WithValue(context.Background(), "values", &vals)
+
+			mockIMDS := NewMockIMDS(ctrl)
+			mockIMDS.
+				EXPECT().
+				QueryIMDS(gomock.Any()).
+				Return(ScheduledEventsResponse{
+					IncarnationID: 1,
+					Events: []ScheduledEvent{
+						{
+							Description:  tc.description,
+							EventId:      "73578921-FFE4-4A5B-95C7-FEB9BBBB3B09",
+							EventSource:  Platform,
+							EventStatus:  Scheduled,
+							Type:         eventType,
+							NotBefore:    notBefore,
+							ResourceType: "VirtualMachine",
+							Resources:    []string{"test-vmss_1"},
+						},
+					},
+				}, nil).
+				Times(2)
+
+			node := &v1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-vmss000001"},
+			}
+
+			// run the check twice to make sure the event is only emitted once per event ID
+			for i := 0; i < 2; i++ {
+				_, _, err := CheckIfDrainRequired(ctx, mockIMDS, node, &config.DrainConditions{}, config.IMDSRetryConfig{})
+				if err != nil {
+					t.Errorf("Unexpected error: %v", err)
+				}
+			}
+
+			assert.Len(t, recorder.Events, tc.expectedEvents)
+			if tc.expectedEvents > 0 {
+				assert.Equal(t, `Normal FreezeSkipped Freeze event 73578921-FFE4-4A5B-95C7-FEB9BBBB3B09 detected but not a live migration; not draining (notBefore: 2030-01-02T03:04:05Z, description: "freeze maintenance")`, <-recorder.Events)
+				freezeID, freezeNotBefore := state.SkippedFreeze()
+				assert.Equal(t, "73578921-FFE4-4A5B-95C7-FEB9BBBB3B09", freezeID)
+				assert.Equal(t, notBefore, freezeNotBefore)
+			} else {
+				freezeID, _ := state.SkippedFreeze()
+				assert.Empty(t, freezeID)
+			}
+		})
+	}
+}
+
+func TestCheckIfDrainRequiredInvalidNodeName(t *testing.T) {
+	core, logs := observer.New(zap.DebugLevel)
+	sugar := zap.New(core).Sugar()
+
+	ctrl := gomock.NewController(t)
+	vals := config.ContextValues{
+		Logger: sugar,
+		State:  &appstate.State{},
+	}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	mockIMDS := NewMockIMDS(ctrl)
+	mockIMDS.
+		EXPECT().
+		QueryIMDS(gomock.Any()).
+		Return(ScheduledEventsResponse{
+			IncarnationID: 1,
+			Events: []ScheduledEvent{
+				{
+					EventId:      "test",
+					Type:         Reboot,
+					ResourceType: "VirtualMachine",
+					Resources:    []string{"test-vmss_1"},
+					EventStatus:  Scheduled,
+					NotBefore:    time.Now().Add(1 * time.Hour),
+					Description:  "test",
+					EventSource:  Platform,
+				},
+			},
+		}, nil).
+		Times(3)
+
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "not-a-vmss-node!"},
+	}
+
+	before := testutil.ToFloat64(metrics.NodeNameParseErrors)
+	for i := 0; i < 3; i++ {
+		_, _, err := CheckIfDrainRequired(ctx, mockIMDS, node, &config.DrainConditions{DrainOnReboot: true}, config.IMDSRetryConfig{})
+		assert.ErrorIs(t, err, ErrInvalidNodeName)
+	}
+
+	assert.Equal(t, 1, logs.FilterLevelExact(zap.ErrorLevel).Len(), "expected the node name error to be logged exactly once")
+	assert.Equal(t, float64(3), testutil.ToFloat64(metrics.NodeNameParseErrors)-before)
+}
+
+func TestGetInstanceName(t *testing.T) {
+	tests := []struct {
+		name     string
+		nodeName string
+		expected string
+		wantErr  bool
+	}{
+		{name: "vmss node", nodeName: "test-vmss000001", expected: "test-vmss_1"},
+		{name: "aks vmss node", nodeName: "aks-nodepool1-12345678-vmss00000a", expected: "aks-nodepool1-12345678-vmss_10"},
+		{name: "shorter than the suffix", nodeName: "aks01", wantErr: true},
+		{name: "only a suffix", nodeName: "vmss01", wantErr: true},
+		{name: "empty", nodeName: "", wantErr: true},
+		{name: "upper case suffix", nodeName: "node-ABCDEF", wantErr: true},
+		{name: "signed suffix", nodeName: "node--abcde", wantErr: true},
+		{name: "availability set node", nodeName: "aks-agentpool-12345678-0", wantErr: true},
+	}
+
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	vals := config.ContextValues{Logger: logger.Sugar()}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: tc.nodeName}}
+			instance, err := getInstanceName(ctx, node)
+			if tc.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidNodeName)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, instance)
+		})
+	}
+}
+
+func TestCheckIfDrainRequiredShortNodeName(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	ctrl := gomock.NewController(t)
+	vals := config.ContextValues{
+		Logger: logger.Sugar(),
+		State:  &appstate.State{},
+	}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	mockIMDS := NewMockIMDS(ctrl)
+	mockIMDS.
+		EXPECT().
+		QueryIMDS(gomock.Any()).
+		Return(ScheduledEventsResponse{IncarnationID: 1, Events: []ScheduledEvent{{
+			EventId:      "preempt",
+			Type:         Preempt,
+			ResourceType: "VirtualMachine",
+			Resources:    []string{"test-vmss_1"},
+			EventStatus:  Scheduled,
+			NotBefore:    time.Now().Add(1 * time.Hour),
+			EventSource:  Platform,
+		}}}, nil)
+
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "aks01"}}
+
+	var drain bool
+	var err error
+	require.NotPanics(t, func() {
+		drain, _, err = CheckIfDrainRequired(ctx, mockIMDS, node, &config.DrainConditions{DrainOnPreempt: true}, config.IMDSRetryConfig{})
+	})
+	assert.ErrorIs(t, err, ErrInvalidNodeName)
+	assert.False(t, drain)
+}
+
+func TestCheckIfDrainRequiredEventsNotImpacting(t *testing.T) {
+	tests := []struct {
+		name           string
+		events         []ScheduledEvent
+		expectedResult string
+		expectLog      bool
+	}{
+		{
+			name:           "no events",
+			expectedResult: EventCheckNoEvents,
+		},
+		{
+			name: "host level event",
+			events: []ScheduledEvent{{
+				EventId:      "host",
+				Type:         Reboot,
+				ResourceType: "Host",
+				Resources:    []string{"test-vmss_1"},
+				EventStatus:  Scheduled,
+				EventSource:  Platform,
+			}},
+			expectedResult: EventCheckNotImpacting,
+			expectLog:      true,
+		},
+		{
+			name: "event for another instance",
+			events: []ScheduledEvent{{
+				EventId:      "other",
+				Type:         Reboot,
+				ResourceType: "VirtualMachine",
+				Resources:    []string{"test-vmss_2"},
+				EventStatus:  Scheduled,
+				EventSource:  Platform,
+			}},
+			expectedResult: EventCheckNotImpacting,
+			expectLog:      true,
+		},
+		{
+			name: "impacting event that isn't drained for",
+			events: []ScheduledEvent{{
+				EventId:      "redeploy",
+				Type:         Redeploy,
+				ResourceType: "VirtualMachine",
+				Resources:    []string{"test-vmss_1"},
+				EventStatus:  Scheduled,
+				EventSource:  Platform,
+			}},
+			expectedResult: EventCheckImpacting,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			core, logs := observer.New(zap.DebugLevel)
+			vals := config.ContextValues{
+				Logger: zap.New(core).Sugar(),
+				State:  &appstate.State{},
+			}
+			ctx := context.WithValue(context.Background(), "values", &vals)
+
+			ctrl := gomock.NewController(t)
+			mockIMDS := NewMockIMDS(ctrl)
+			mockIMDS.
+				EXPECT().
+				QueryIMDS(gomock.Any()).
+				Return(ScheduledEventsResponse{IncarnationID: 1, Events: tc.events}, nil)
+
+			node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-vmss000001"}}
+
+			before := testutil.ToFloat64(metrics.ScheduledEventChecks.WithLabelValues(tc.expectedResult))
+			drain, _, err := CheckIfDrainRequired(ctx, mockIMDS, node, &config.DrainConditions{DrainOnReboot: true}, config.IMDSRetryConfig{})
+			assert.NoError(t, err)
+			assert.False(t, drain)
+			assert.Equal(t, float64(1), testutil.ToFloat64(metrics.ScheduledEventChecks.WithLabelValues(tc.expectedResult))-before)
+
+			notImpacting := logs.FilterMessage("IMDS returned scheduled events but none target this node")
+			if tc.expectLog {
+				assert.Equal(t, 1, notImpacting.Len())
+				assert.Equal(t, int64(len(tc.events)), notImpacting.All()[0].ContextMap()["eventCount"])
+			} else {
+				assert.Equal(t, 0, notImpacting.Len())
+			}
+		})
+	}
+}
+
+func TestCheckIfDrainRequiredEmptyResources(t *testing.T) {
+	regionWide := ScheduledEvent{
+		EventId:      "region",
+		Type:         Reboot,
+		ResourceType: "VirtualMachine",
+		Resources:    []string{},
+		EventStatus:  Scheduled,
+		NotBefore:    time.Now().Add(1 * time.Hour),
+		EventSource:  Platform,
+	}
+
+	tests := []struct {
+		name          string
+		impacting     bool
+		expectedDrain bool
+	}{
+		{
+			name:          "empty resources ignored by default",
+			impacting:     false,
+			expectedDrain: false,
+		},
+		{
+			name:          "empty resources impact every node when enabled",
+			impacting:     true,
+			expectedDrain: true,
+		},
+	}
+
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	sugar := logger.Sugar()
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			vals := config.ContextValues{
+				Logger: sugar,
+				State:  &appstate.State{},
+			}
+			ctx := context.WithValue(context.Background(), "values", &vals)
+
+			mockIMDS := NewMockIMDS(ctrl)
+			mockIMDS.
+				EXPECT().
+				QueryIMDS(gomock.Any()).
+				Return(ScheduledEventsResponse{IncarnationID: 1, Events: []ScheduledEvent{regionWide}}, nil).
+				Times(2)
+
+			node := &v1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-vmss000001"},
+			}
+			dc := &config.DrainConditions{DrainOnReboot: true, TreatEmptyResourcesAsImpacting: tc.impacting}
+
+			drain, event, err := CheckIfDrainRequired(ctx, mockIMDS, node, dc, config.IMDSRetryConfig{})
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedDrain, drain)
+			if tc.expectedDrain {
+				assert.Equal(t, "region", event.EventId)
+			} else {
+				assert.Nil(t, event)
+			}
+
+			impacted, err := HasImpactingEvents(ctx, mockIMDS, node, dc, config.IMDSRetryConfig{})
+			assert.NoError(t, err)
+			assert.Equal(t, tc.impacting, impacted)
+		})
+	}
+}
+
+func TestCheckIfDrainRequiredResourceTypes(t *testing.T) {
+	tests := []struct {
+		name          string
+		resourceType  string
+		configured    []string
+		matchAnyType  bool
+		expectedDrain bool
+	}{
+		{name: "virtual machine by default", resourceType: "VirtualMachine", expectedDrain: true},
+		{name: "scale set naming the instance when matching any type", resourceType: "VirtualMachineScaleSet", matchAnyType: true, expectedDrain: true},
+		{name: "unexpected type naming the instance when matching any type", resourceType: "AvailabilitySet", configured: []string{"VirtualMachine"}, matchAnyType: true, expectedDrain: true},
+		{name: "scale set not impacting by default", resourceType: "VirtualMachineScaleSet", expectedDrain: false},
+		{name: "scale set opted in", resourceType: "VirtualMachineScaleSet", configured: []string{"VirtualMachine", "VirtualMachineScaleSet"}, expectedDrain: true},
+		{name: "resource types matched case-insensitively", resourceType: "VirtualMachineScaleSet", configured: []string{"virtualmachinescaleset"}, expectedDrain: true},
+		{name: "virtual machine excluded when not configured", resourceType: "VirtualMachine", configured: []string{"VirtualMachineScaleSet"}, expectedDrain: false},
+		{name: "host not configured", resourceType: "Host", configured: []string{"VirtualMachine", "VirtualMachineScaleSet"}, expectedDrain: false},
+	}
+
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	sugar := logger.Sugar()
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			vals := config.ContextValues{
+				Logger: sugar,
+				State:  &appstate.State{},
+			}
+			ctx := context.WithValue(context.Background(), "values", &vals)
+
+			mockIMDS := NewMockIMDS(ctrl)
+			mockIMDS.
+				EXPECT().
+				QueryIMDS(gomock.Any()).
+				Return(ScheduledEventsResponse{IncarnationID: 1, Events: []ScheduledEvent{{
+					EventId:      "reboot",
+					Type:         Reboot,
+					ResourceType: tc.resourceType,
+					Resources:    []string{"test-vmss_1"},
+					EventStatus:  Scheduled,
+					NotBefore:    time.Now().Add(1 * time.Hour),
+					EventSource:  Platform,
+				}}}, nil)
+
+			node := &v1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-vmss000001"},
+			}
+			dc := &config.DrainConditions{DrainOnReboot: true, ImpactingResourceTypes: tc.configured, MatchResourcesOfAnyType: tc.matchAnyType}
+
+			drain, _, err := CheckIfDrainRequired(ctx, mockIMDS, node, dc, config.IMDSRetryConfig{})
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedDrain, drain)
+		})
+	}
+}
+
+func TestIsNodeImpactedUnexpectedResourceType(t *testing.T) {
+	core, logs := observer.New(zap.DebugLevel)
+	vals := config.ContextValues{Logger: zap.New(core).Sugar(), State: &appstate.State{}}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-vmss000001"}}
+	dc := &config.DrainConditions{MatchResourcesOfAnyType: true}
+	event := ScheduledEvent{EventId: "host", Type: Reboot, ResourceType: "Host", Resources: []string{"test-vmss_1"}, EventStatus: Scheduled}
+
+	impacted, err := isNodeImpacted(ctx, node, event, dc)
+	require.NoError(t, err)
+	assert.True(t, impacted)
+	unexpected := logs.FilterMessage("Event names the node's instance under an unexpected resource type, treating it as impacting").All()
+	require.Len(t, unexpected, 1)
+	assert.Equal(t, "Host", unexpected[0].ContextMap()["resourceType"])
+
+	// the resources still have to name the instance
+	event.Resources = []string{"test-vmss_2"}
+	impacted, err = isNodeImpacted(ctx, node, event, dc)
+	require.NoError(t, err)
+	assert.False(t, impacted)
+}
+
+func TestIsNodeImpactedMatchesInstanceExactly(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	vals := config.ContextValues{Logger: logger.Sugar(), State: &appstate.State{}}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	// test-vmss000001 is instance test-vmss_1
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-vmss000001"}}
+	dc := &config.DrainConditions{}
+
+	tests := []struct {
+		resource string
+		expected bool
+	}{
+		{resource: "test-vmss_1", expected: true},
+		{resource: "_test-vmss_1", expected: true},
+		{resource: "TEST-VMSS_1", expected: true},
+		{resource: " test-vmss_1 ", expected: true},
+		{resource: "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachineScaleSets/test-vmss/virtualMachines/test-vmss_1", expected: true},
+		{resource: "test-vmss_10", expected: false},
+		{resource: "_test-vmss_10", expected: false},
+		{resource: "test-vmss_11", expected: false},
+		{resource: "other-test-vmss_1", expected: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.resource, func(t *testing.T) {
+			event := ScheduledEvent{EventId: "reboot", Type: Reboot, ResourceType: "VirtualMachine", Resources: []string{tc.resource}, EventStatus: Scheduled}
+			impacted, err := isNodeImpacted(ctx, node, event, dc)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, impacted)
+		})
+	}
+}
+
+func TestIsNodeImpactedCompletedEvent(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	vals := config.ContextValues{Logger: logger.Sugar(), State: &appstate.State{}}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-vmss000001"}}
+	dc := &config.DrainConditions{TreatEmptyResourcesAsImpacting: true}
+
+	for _, resources := range [][]string{{"test-vmss_1"}, {}} {
+		event := ScheduledEvent{EventId: "reboot", Type: Reboot, ResourceType: "VirtualMachine", Resources: resources, EventStatus: Completed}
+		impacted, err := isNodeImpacted(ctx, node, event, dc)
+		require.NoError(t, err)
+		assert.False(t, impacted, "a completed event doesn't impact the node, resources %v", resources)
+
+		event.EventStatus = Started
+		impacted, err = isNodeImpacted(ctx, node, event, dc)
+		require.NoError(t, err)
+		assert.True(t, impacted, "a started event still impacts the node, resources %v", resources)
+	}
+}
+
+func TestCheckIfDrainRequiredLeadTime(t *testing.T) {
+	tests := []struct {
+		name          string
+		notBefore     time.Time
+		leadTime      time.Duration
+		expectedDrain bool
+	}{
+		{name: "well in the future", notBefore: time.Now().Add(1 * time.Hour), leadTime: 10 * time.Minute, expectedDrain: false},
+		{name: "just inside the window", notBefore: time.Now().Add(9 * time.Minute), leadTime: 10 * time.Minute, expectedDrain: true},
+		{name: "already past", notBefore: time.Now().Add(-1 * time.Minute), leadTime: 10 * time.Minute, expectedDrain: true},
+		{name: "no NotBefore drains immediately", notBefore: time.Time{}, leadTime: 10 * time.Minute, expectedDrain: true},
+		{name: "no lead time drains immediately", notBefore: time.Now().Add(1 * time.Hour), leadTime: 0, expectedDrain: true},
+		{name: "non-UTC NotBefore compared in UTC", notBefore: time.Now().Add(5 * time.Minute).In(time.FixedZone("UTC+10", 10*60*60)), leadTime: 10 * time.Minute, expectedDrain: true},
+	}
+
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	sugar := logger.Sugar()
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			vals := config.ContextValues{
+				Logger: sugar,
+				State:  &appstate.State{},
+			}
+			ctx := context.WithValue(context.Background(), "values", &vals)
+
+			mockIMDS := NewMockIMDS(ctrl)
+			mockIMDS.
+				EXPECT().
+				QueryIMDS(gomock.Any()).
+				Return(ScheduledEventsResponse{IncarnationID: 1, Events: []ScheduledEvent{{
+					EventId:      "preempt",
+					Type:         Preempt,
+					ResourceType: "VirtualMachine",
+					Resources:    []string{"test-vmss_1"},
+					EventStatus:  Scheduled,
+					NotBefore:    tc.notBefore,
+					EventSource:  Platform,
+				}}}, nil)
+
+			node := &v1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-vmss000001"},
+			}
+			dc := &config.DrainConditions{DrainOnPreempt: true, LeadTime: tc.leadTime}
+
+			drain, _, err := CheckIfDrainRequired(ctx, mockIMDS, node, dc, config.IMDSRetryConfig{})
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedDrain, drain)
+		})
+	}
+}
+
+func TestCheckIfDrainRequiredMultipleEvents(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name            string
+		events          []ScheduledEvent
+		drainConditions config.DrainConditions
+		expectedDrain   bool
+		expectedEventID string
+	}{
+		{
+			name: "a later drainable event is found after a non-draining one",
+			events: []ScheduledEvent{
+				{EventId: "freeze", Type: Freeze, NotBefore: now.Add(time.Minute)},
+				{EventId: "reboot", Type: Reboot, NotBefore: now.Add(5 * time.Minute)},
+			},
+			drainConditions: config.DrainConditions{DrainOnReboot: true},
+			expectedDrain:   true,
+			expectedEventID: "reboot",
+		},
+		{
+			name: "the earliest drainable event is picked",
+			events: []ScheduledEvent{
+				{EventId: "terminate", Type: Terminate, NotBefore: now.Add(10 * time.Minute)},
+				{EventId: "reboot", Type: Reboot, NotBefore: now.Add(2 * time.Minute)},
+				{EventId: "redeploy", Type: Redeploy, NotBefore: now.Add(5 * time.Minute)},
+			},
+			drainConditions: config.DrainConditions{DrainOnReboot: true, DrainOnRedeploy: true, DrainOnTerminate: true},
+			expectedDrain:   true,
+			expectedEventID: "reboot",
+		},
+		{
+			name: "an event without a NotBefore is due now",
+			events: []ScheduledEvent{
+				{EventId: "reboot", Type: Reboot, NotBefore: now.Add(2 * time.Minute)},
+				{EventId: "redeploy", Type: Redeploy},
+			},
+			drainConditions: config.DrainConditions{DrainOnReboot: true, DrainOnRedeploy: true},
+			expectedDrain:   true,
+			expectedEventID: "redeploy",
+		},
+		{
+			name: "the most severe event is picked when they're due at the same time",
+			events: []ScheduledEvent{
+				{EventId: "reboot", Type: Reboot, NotBefore: now.Add(2 * time.Minute)},
+				{EventId: "preempt", Type: Preempt, NotBefore: now.Add(2 * time.Minute)},
+			},
+			drainConditions: config.DrainConditions{DrainOnReboot: true, DrainOnPreempt: true},
+			expectedDrain:   true,
+			expectedEventID: "preempt",
+		},
+		{
+			name: "no drain when none of the events require one",
+			events: []ScheduledEvent{
+				{EventId: "freeze", Type: Freeze, NotBefore: now.Add(time.Minute)},
+				{EventId: "redeploy", Type: Redeploy, NotBefore: now.Add(5 * time.Minute)},
+			},
+			drainConditions: config.DrainConditions{DrainOnReboot: true},
+		},
+	}
+
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			vals := config.ContextValues{
+				Logger:   logger.Sugar(),
+				State:    &appstate.State{},
+				Recorder: record.NewFakeRecorder(10),
+			}
+			ctx := context.WithValue(context.Background(), "values", &vals)
+
+			var events []ScheduledEvent
+			for _, event := range tc.events {
+				event.ResourceType = "VirtualMachine"
+				event.Resources = []string{"test-vmss_1"}
+				event.EventStatus = Scheduled
+				events = append(events, event)
+			}
+			mockIMDS := NewMockIMDS(ctrl)
+			mockIMDS.EXPECT().QueryIMDS(gomock.Any()).Return(ScheduledEventsResponse{IncarnationID: 1, Events: events}, nil)
+
+			node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-vmss000001"}}
+			drain, event, err := CheckIfDrainRequired(ctx, mockIMDS, node, &tc.drainConditions, config.IMDSRetryConfig{})
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedDrain, drain)
+			if !tc.expectedDrain {
+				assert.Nil(t, event)
+				return
+			}
+			require.NotNil(t, event)
+			assert.Equal(t, tc.expectedEventID, event.EventId)
+		})
+	}
+}
+
+func TestCheckIfDrainRequiredReportsEventStarted(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+
+	ctrl := gomock.NewController(t)
+	recorder := record.NewFakeRecorder(10)
+	vals := config.ContextValues{
+		Logger:   logger.Sugar(),
+		State:    &appstate.State{},
+		Recorder: recorder,
+	}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	response := func(status ScheduledEventStatus) ScheduledEventsResponse {
+		return ScheduledEventsResponse{IncarnationID: 1, Events: []ScheduledEvent{
+			{EventId: "reboot", Type: Reboot, ResourceType: "VirtualMachine", Resources: []string{"test-vmss_1"}, EventStatus: status},
+			// events for other VMs aren't reported
+			{EventId: "other", Type: Reboot, ResourceType: "VirtualMachine", Resources: []string{"test-vmss_2"}, EventStatus: status},
+		}}
+	}
+	mockIMDS := NewMockIMDS(ctrl)
+	gomock.InOrder(
+		mockIMDS.EXPECT().QueryIMDS(gomock.Any()).Return(response(Scheduled), nil),
+		mockIMDS.EXPECT().QueryIMDS(gomock.Any()).Return(response(Started), nil),
+		mockIMDS.EXPECT().QueryIMDS(gomock.Any()).Return(response(Started), nil),
+	)
+
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-vmss000001"}}
+	dc := &config.DrainConditions{DrainOnReboot: true}
+	before := testutil.ToFloat64(metrics.ScheduledEventsStarted.WithLabelValues(string(Reboot)))
+
+	_, _, err := CheckIfDrainRequired(ctx, mockIMDS, node, dc, config.IMDSRetryConfig{})
+	require.NoError(t, err)
+	assert.Empty(t, recorder.Events, "no transition on the first response")
+
+	// the event moved from Scheduled to Started, and stays Started in the response after
+	for i := 0; i < 2; i++ {
+		_, _, err = CheckIfDrainRequired(ctx, mockIMDS, node, dc, config.IMDSRetryConfig{})
+		require.NoError(t, err)
+	}
+
+	require.Len(t, recorder.Events, 1)
+	assert.Equal(t, "Normal ScheduledEventStarted Scheduled Reboot event reboot has started", <-recorder.Events)
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.ScheduledEventsStarted.WithLabelValues(string(Reboot)))-before)
+}
+
+func TestHasImpactingEvents(t *testing.T) {
+	tests := []struct {
+		name     string
+		events   []ScheduledEvent
+		expected bool
+	}{
+		{
+			name:     "stale condition, IMDS has no events",
+			events:   []ScheduledEvent{},
+			expected: false,
+		},
+		{
+			name: "events for other instances only",
+			events: []ScheduledEvent{
+				{EventId: "other", Type: Reboot, ResourceType: "VirtualMachine", Resources: []string{"test-vmss_4"}},
+			},
+			expected: false,
+		},
+		{
+			name: "event impacts the node even though we don't drain for it",
+			events: []ScheduledEvent{
+				{EventId: "freeze", Type: Freeze, ResourceType: "VirtualMachine", Resources: []string{"test-vmss_1"}},
+			},
+			expected: true,
+		},
+	}
+
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	sugar := logger.Sugar()
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			vals := config.ContextValues{
+				Logger: sugar,
+				State:  &appstate.State{},
+			}
+			ctx := context.WithValue(context.Background(), "values", &vals)
+
+			mockIMDS := NewMockIMDS(ctrl)
+			mockIMDS.
+				EXPECT().
+				QueryIMDS(gomock.Any()).
+				Return(ScheduledEventsResponse{IncarnationID: 1, Events: tc.events}, nil)
+
+			node := &v1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-vmss000001"},
+			}
+
+			impacted, err := HasImpactingEvents(ctx, mockIMDS, node, &config.DrainConditions{}, config.IMDSRetryConfig{})
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, impacted)
+		})
+	}
+}
+
+func TestQueryIMDS(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	vals := config.ContextValues{Logger: logger.Sugar(), State: &appstate.State{}}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "true", r.Header.Get("Metadata"))
+		assert.Equal(t, "2020-07-01", r.URL.Query().Get("api-version"))
+		w.Write([]byte(`{"DocumentIncarnation": 2, "Events": [{"EventId": "reboot", "EventType": "Reboot", ` +
+			`"ResourceType": "VirtualMachine", "Resources": ["test-vmss_1"], "EventStatus": "Scheduled", ` +
+			`"NotBefore": "Mon, 19 Sep 2016 18:29:47 GMT", "Description": "", "EventSource": "Platform", "DurationInSeconds": 5}]}`))
+	}))
+	defer server.Close()
+
+	before := testutil.ToFloat64(metrics.IMDSQueries.WithLabelValues(QueryResultSuccess))
+	ic := IMDSClient{Timeout: time.Second, Endpoint: server.URL}
+	resp, err := ic.QueryIMDS(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.IMDSQueries.WithLabelValues(QueryResultSuccess))-before)
+	assert.Equal(t, float64(2), resp.IncarnationID)
+	require.Len(t, resp.Events, 1)
+	assert.Equal(t, "reboot", resp.Events[0].EventId)
+	assert.Equal(t, 5*time.Second, resp.Events[0].Duration)
+}
+
+func TestQueryIMDSMaxEventsPerResponse(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	vals := config.ContextValues{Logger: logger.Sugar(), State: &appstate.State{}}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	// an oversized response, far more events than a node ever has scheduled
+	events := make([]string, 250)
+	for i := range events {
+		events[i] = fmt.Sprintf(`{"EventId": "event-%d", "EventType": "Reboot", "ResourceType": "VirtualMachine", `+
+			`"Resources": ["test-vmss_1"], "EventStatus": "Scheduled", "NotBefore": "Mon, 19 Sep 2016 18:29:47 GMT", `+
+			`"Description": "", "EventSource": "Platform", "DurationInSeconds": 5}`, i)
+	}
+	body := `{"DocumentIncarnation": 1, "Events": [` + strings.Join(events, ",") + `]}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name      string
+		maxEvents int
+		expected  int
+	}{
+		{name: "truncated to the cap", maxEvents: 10, expected: 10},
+		{name: "under the cap", maxEvents: 500, expected: 250},
+		{name: "no cap", maxEvents: 0, expected: 250},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			before := eventsPerResponseSnapshot(t)
+
+			ic := &IMDSClient{Timeout: time.Second, Endpoint: server.URL, MaxEventsPerResponse: tc.maxEvents}
+			resp, err := ic.QueryIMDS(ctx)
+			require.NoError(t, err)
+			require.Len(t, resp.Events, tc.expected)
+			assert.Equal(t, "event-0", resp.Events[0].EventId, "the events before the cap are kept")
+
+			after := eventsPerResponseSnapshot(t)
+			assert.Equal(t, uint64(1), after.GetSampleCount()-before.GetSampleCount())
+			assert.Equal(t, float64(250), after.GetSampleSum()-before.GetSampleSum(), "the metric records the size before truncation")
+		})
+	}
+}
+
+func eventsPerResponseSnapshot(t *testing.T) *dto.Histogram {
+	m := &dto.Metric{}
+	require.NoError(t, metrics.EventsPerResponse.Write(m))
+	return m.GetHistogram()
+}
+
+func TestQueryIMDSTimeout(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	vals := config.ContextValues{Logger: logger.Sugar(), State: &appstate.State{}}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	// the server hangs until the client gives up on the request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(10 * time.Second):
+		}
+	}))
+	defer server.Close()
+
+	before := testutil.ToFloat64(metrics.IMDSQueries.WithLabelValues(QueryResultError))
+	ic := IMDSClient{Timeout: 50 * time.Millisecond, Endpoint: server.URL}
+	start := time.Now()
+	_, err := ic.QueryIMDS(ctx)
+	assert.Error(t, err)
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.IMDSQueries.WithLabelValues(QueryResultError))-before)
+	assert.Less(t, time.Since(start), 5*time.Second, "the query should return once the timeout passes")
+}
+
+func TestBuildEventResponseMalformed(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	vals := config.ContextValues{Logger: logger.Sugar(), State: &appstate.State{}}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	valid := `{"EventId": "valid", "EventType": "Reboot", "ResourceType": "VirtualMachine", "Resources": ["test-vmss_1"], ` +
+		`"EventStatus": "Scheduled", "NotBefore": "Mon, 19 Sep 2016 18:29:47 GMT", "Description": "", ` +
+		`"EventSource": "Platform", "DurationInSeconds": 5}`
+
+	tests := []struct {
+		name        string
+		body        string
+		expectError bool
+		expectedIDs []string
+	}{
+		{
+			name:        "missing incarnation",
+			body:        `{"Events": []}`,
+			expectError: true,
+		},
+		{
+			name:        "incarnation is a string",
+			body:        `{"DocumentIncarnation": "1", "Events": []}`,
+			expectError: true,
+		},
+		{
+			name:        "missing events",
+			body:        `{"DocumentIncarnation": 1}`,
+			expectError: true,
+		},
+		{
+			name:        "events is an object",
+			body:        `{"DocumentIncarnation": 1, "Events": {"EventId": "valid"}}`,
+			expectError: true,
+		},
+		{
+			name:        "event that isn't an object is skipped",
+			body:        `{"DocumentIncarnation": 1, "Events": ["garbled", ` + valid + `]}`,
+			expectedIDs: []string{"valid"},
+		},
+		{
+			name:        "event missing resources is skipped",
+			body:        `{"DocumentIncarnation": 1, "Events": [{"EventId": "no-resources", "EventType": "Reboot"}, ` + valid + `]}`,
+			expectedIDs: []string{"valid"},
+		},
+		{
+			name:        "event with a numeric id is skipped",
+			body:        `{"DocumentIncarnation": 1, "Events": [{"EventId": 7, "EventType": "Reboot", "Resources": []}, ` + valid + `]}`,
+			expectedIDs: []string{"valid"},
+		},
+		{
+			name:        "event with a resource that isn't a string is skipped",
+			body:        `{"DocumentIncarnation": 1, "Events": [{"EventId": "bad", "EventType": "Reboot", "Resources": [1]}, ` + valid + `]}`,
+			expectedIDs: []string{"valid"},
+		},
+		{
+			name: "event with a string duration is skipped",
+			body: `{"DocumentIncarnation": 1, "Events": [{"EventId": "bad", "EventType": "Reboot", "Resources": [], ` +
+				`"DurationInSeconds": "5"}, ` + valid + `]}`,
+			expectedIDs: []string{"valid"},
+		},
+		{
+			name: "started event without a NotBefore or duration is kept",
+			body: `{"DocumentIncarnation": 1, "Events": [{"EventId": "started", "EventType": "Reboot", ` +
+				`"Resources": ["test-vmss_1"], "EventStatus": "Started", "NotBefore": ""}]}`,
+			expectedIDs: []string{"started"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var generic map[string]interface{}
+			require.NoError(t, json.Unmarshal([]byte(tc.body), &generic))
+
+			resp := ScheduledEventsResponse{}
+			err := buildEventResponse(ctx, generic, &resp, 0)
+			if tc.expectError {
+				assert.ErrorIs(t, err, ErrMalformedResponse)
+				return
+			}
+			require.NoError(t, err)
+			ids := []string{}
+			for _, event := range resp.Events {
+				ids = append(ids, event.EventId)
+			}
+			assert.Equal(t, tc.expectedIDs, ids)
+		})
+	}
+}
+
+func TestBuildEventResponseNotBeforeAndDuration(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	vals := config.ContextValues{Logger: logger.Sugar(), State: &appstate.State{}}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	notBefore := time.Date(2016, time.September, 19, 18, 29, 47, 0, time.UTC)
+	tests := []struct {
+		name              string
+		fields            string
+		expectedNotBefore time.Time
+		expectedDuration  time.Duration
+	}{
+		{
+			name:              "both",
+			fields:            `"NotBefore": "Mon, 19 Sep 2016 18:29:47 GMT", "DurationInSeconds": 5`,
+			expectedNotBefore: notBefore,
+			expectedDuration:  5 * time.Second,
+		},
+		{
+			name:             "duration without a NotBefore",
+			fields:           `"NotBefore": "", "DurationInSeconds": 5`,
+			expectedDuration: 5 * time.Second,
+		},
+		{
+			name:             "duration with NotBefore missing",
+			fields:           `"DurationInSeconds": 5`,
+			expectedDuration: 5 * time.Second,
+		},
+		{
+			name:              "NotBefore without a duration",
+			fields:            `"NotBefore": "Mon, 19 Sep 2016 18:29:47 GMT"`,
+			expectedNotBefore: notBefore,
+		},
+		{
+			name:              "NotBefore with a null duration",
+			fields:            `"NotBefore": "Mon, 19 Sep 2016 18:29:47 GMT", "DurationInSeconds": null`,
+			expectedNotBefore: notBefore,
+		},
+		{
+			name: "neither",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			body := `{"EventId": "reboot", "EventType": "Reboot", "Resources": ["test-vmss_1"]`
+			if tc.fields != "" {
+				body += ", " + tc.fields
+			}
+			var generic map[string]interface{}
+			require.NoError(t, json.Unmarshal([]byte(`{"DocumentIncarnation": 1, "Events": [`+body+`}]}`), &generic))
+
+			resp := ScheduledEventsResponse{}
+			require.NoError(t, buildEventResponse(ctx, generic, &resp, 0))
+			require.Len(t, resp.Events, 1)
+			assert.True(t, tc.expectedNotBefore.Equal(resp.Events[0].NotBefore), "NotBefore is %s, expected %s", resp.Events[0].NotBefore, tc.expectedNotBefore)
+			assert.Equal(t, tc.expectedDuration, resp.Events[0].Duration)
+		})
+	}
+}
+
+func TestQueryIMDSMalformedResponse(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	vals := config.ContextValues{Logger: logger.Sugar(), State: &appstate.State{}}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{name: "truncated", body: `{"DocumentIncarnation": 1, "Events": [{"EventId": "reb`},
+		{name: "wrong shape", body: `{"DocumentIncarnation": 1, "Events": "none"}`},
+		{name: "not an object", body: `[]`},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(tc.body))
+			}))
+			defer server.Close()
+
+			before := testutil.ToFloat64(metrics.IMDSQueries.WithLabelValues(QueryResultError))
+			ic := IMDSClient{Timeout: time.Second, Endpoint: server.URL}
+			_, err := ic.QueryIMDS(ctx)
+			assert.Error(t, err)
+			assert.Equal(t, float64(1), testutil.ToFloat64(metrics.IMDSQueries.WithLabelValues(QueryResultError))-before)
+		})
+	}
+}
+
+func TestAckEvent(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	vals := config.ContextValues{Logger: logger.Sugar(), State: &appstate.State{}}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	status := http.StatusOK
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "true", r.Header.Get("Metadata"))
+		assert.Equal(t, "2020-07-01", r.URL.Query().Get("api-version"))
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		bodies = append(bodies, string(body))
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	ic := &IMDSClient{Timeout: time.Second, Endpoint: server.URL}
+	require.NoError(t, ic.AckEvent(ctx, "f020ba2e-3bc0-4c40-a10b-86575a9eabd5"))
+	assert.Equal(t, []string{`{"StartRequests":[{"EventId":"f020ba2e-3bc0-4c40-a10b-86575a9eabd5"}]}`}, bodies)
+
+	status = http.StatusInternalServerError
+	assert.ErrorContains(t, ic.AckEvent(ctx, "f020ba2e-3bc0-4c40-a10b-86575a9eabd5"), "500")
+}
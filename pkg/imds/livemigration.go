@@ -0,0 +1,46 @@
+package imds
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/amargherio/mechanic/internal/config"
+)
+
+// LiveMigrationPatterns compiles the drain conditions' live migration patterns, which tell live migrations apart from
+// other freezes. Patterns are matched case-insensitively, and an empty list uses the defaults. An error is returned if
+// any pattern isn't a valid regular expression.
+func LiveMigrationPatterns(dc config.DrainConditions) ([]*regexp.Regexp, error) {
+	patterns := dc.LiveMigrationPatterns
+	if len(patterns) == 0 {
+		patterns = config.DefaultLiveMigrationPatterns
+	}
+	return compileLiveMigrationPatterns(patterns)
+}
+
+// compileLiveMigrationPatterns compiles each pattern to match case-insensitively
+func compileLiveMigrationPatterns(patterns []string) ([]*regexp.Regexp, error) {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile("(?i)" + pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid live migration pattern %q: %w", pattern, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// isLiveMigration reports whether the event is a Freeze for a memory-preserving live migration. IMDS doesn't have a
+// separate event type for them, so the description is matched against the patterns.
+func isLiveMigration(event ScheduledEvent, patterns []*regexp.Regexp) bool {
+	if event.Type != Freeze {
+		return false
+	}
+	for _, re := range patterns {
+		if re.MatchString(event.Description) {
+			return true
+		}
+	}
+	return false
+}
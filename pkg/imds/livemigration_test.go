@@ -0,0 +1,90 @@
+package imds
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/amargherio/mechanic/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsLiveMigration(t *testing.T) {
+	defaults, err := compileLiveMigrationPatterns(config.DefaultLiveMigrationPatterns)
+	require.NoError(t, err)
+	custom, err := compileLiveMigrationPatterns([]string{`live[- ]migrat(ion|ing)`, "migration préservant la mémoire"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		event    ScheduledEvent
+		patterns []*regexp.Regexp
+		expected bool
+	}{
+		{
+			name:     "default phrase",
+			event:    ScheduledEvent{Type: Freeze, Description: "Virtual machine is being paused because of a memory-preserving Live Migration operation."},
+			patterns: defaults,
+			expected: true,
+		},
+		{
+			name:     "default phrase in another case",
+			event:    ScheduledEvent{Type: Freeze, Description: "VIRTUAL MACHINE IS BEING PAUSED BECAUSE OF A MEMORY-PRESERVING LIVE MIGRATION OPERATION."},
+			patterns: defaults,
+			expected: true,
+		},
+		{
+			name:     "alternate phrasing missed by the defaults",
+			event:    ScheduledEvent{Type: Freeze, Description: "The VM is live-migrating to another host."},
+			patterns: defaults,
+			expected: false,
+		},
+		{
+			name:     "alternate phrasing matched by a configured pattern",
+			event:    ScheduledEvent{Type: Freeze, Description: "The VM is Live-Migrating to another host."},
+			patterns: custom,
+			expected: true,
+		},
+		{
+			name:     "localized description matched by a configured pattern",
+			event:    ScheduledEvent{Type: Freeze, Description: "La machine virtuelle est suspendue pour une migration préservant la mémoire."},
+			patterns: custom,
+			expected: true,
+		},
+		{
+			name:     "plain freeze",
+			event:    ScheduledEvent{Type: Freeze, Description: "Host update"},
+			patterns: defaults,
+			expected: false,
+		},
+		{
+			name:     "only freezes are live migrations",
+			event:    ScheduledEvent{Type: Reboot, Description: "memory-preserving Live Migration"},
+			patterns: defaults,
+			expected: false,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, isLiveMigration(tc.event, tc.patterns))
+		})
+	}
+}
+
+func TestLiveMigrationPatterns(t *testing.T) {
+	event := ScheduledEvent{Type: Freeze, Description: "VM paused for live migration"}
+
+	defaults, err := LiveMigrationPatterns(config.DrainConditions{})
+	require.NoError(t, err)
+	assert.False(t, isLiveMigration(event, defaults), "an empty list uses the defaults")
+	assert.Equal(t, SeverityLow, event.Severity(defaults))
+
+	custom, err := LiveMigrationPatterns(config.DrainConditions{LiveMigrationPatterns: []string{"live migration"}})
+	require.NoError(t, err)
+	assert.True(t, isLiveMigration(event, custom))
+	assert.Equal(t, SeverityMedium, event.Severity(custom), "live migrations get their own severity")
+
+	_, err = LiveMigrationPatterns(config.DrainConditions{LiveMigrationPatterns: []string{"live (migration"}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,74 @@
+package imds
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"syscall"
+	"time"
+
+	"github.com/amargherio/mechanic/internal/config"
+)
+
+// QueryWithRetry queries IMDS for scheduled events, retrying with exponential backoff under the policy while the
+// query fails with a transient error (see isRetriableIMDSError). Other errors are returned right away. Fewer than one
+// attempt is treated as one. The last error is returned once the attempts run out or ctx is done. Nothing is recorded
+// in the state.
+func QueryWithRetry(ctx context.Context, ic IMDS, policy config.IMDSRetryConfig) (ScheduledEventsResponse, error) {
+	vals := ctx.Value("values").(*config.ContextValues)
+	log := vals.Logger
+
+	delay := policy.BaseDelay
+	for attempt := 1; ; attempt++ {
+		resp, err := ic.QueryIMDS(ctx)
+		if err == nil {
+			return resp, nil
+		}
+		if !isRetriableIMDSError(err) || attempt >= policy.Attempts {
+			log.Errorw("Failed to query IMDS", "error", err, "attempts", attempt, "traceCtx", ctx)
+			return resp, err
+		}
+
+		log.Warnw("IMDS query failed with a transient error, retrying...", "attempt", attempt, "delay", delay, "error", err, "traceCtx", ctx)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return resp, err
+		}
+		delay *= 2
+		if policy.MaxDelay > 0 && delay > policy.MaxDelay {
+			delay = policy.MaxDelay
+		}
+	}
+}
+
+// isRetriableIMDSError reports whether a failed IMDS query is worth trying again. IMDS closing the connection without a
+// response (io.EOF), timeouts, and failures to reach IMDS at all, like a refused connection while it restarts or a
+// temporary DNS failure, are. Error responses from IMDS, responses that can't be decoded, and cancellations aren't.
+func isRetriableIMDSError(err error) bool {
+	if errors.Is(err, io.EOF) {
+		return true
+	}
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTemporary || dnsErr.IsTimeout
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+
+	// http.Client wraps every failure to send the request or read the response headers in a url.Error. failing to parse
+	// the endpoint is reported the same way but won't change on a retry.
+	var urlErr *url.Error
+	return errors.As(err, &urlErr) && urlErr.Op != "parse"
+}
@@ -0,0 +1,156 @@
+package imds
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/amargherio/mechanic/internal/appstate"
+	"github.com/amargherio/mechanic/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	"go.uber.org/zap/zaptest"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestQueryWithRetry(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+
+	retry := config.IMDSRetryConfig{Attempts: 3, BaseDelay: 10 * time.Millisecond, MaxDelay: 15 * time.Millisecond}
+	expected := ScheduledEventsResponse{IncarnationID: 7}
+	otherErr := errors.New("IMDS returned 500 Internal Server Error")
+	timeoutErr := &url.Error{Op: "Get", URL: "http://169.254.169.254", Err: context.DeadlineExceeded}
+
+	tests := []struct {
+		name          string
+		errs          []error
+		expectErr     error
+		expectQueries int
+		minElapsed    time.Duration
+	}{
+		{name: "success on the first try", expectQueries: 1},
+		{name: "EOF then success", errs: []error{io.EOF, io.EOF}, expectQueries: 3, minElapsed: 25 * time.Millisecond},
+		{name: "timeout then success", errs: []error{timeoutErr}, expectQueries: 2, minElapsed: 10 * time.Millisecond},
+		{name: "EOF on every attempt", errs: []error{io.EOF, io.EOF, io.EOF}, expectErr: io.EOF, expectQueries: 3},
+		{name: "other errors aren't retried", errs: []error{otherErr}, expectErr: otherErr, expectQueries: 1},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			vals := config.ContextValues{Logger: logger.Sugar(), State: &appstate.State{}}
+			ctx := context.WithValue(context.Background(), "values", &vals)
+
+			ctrl := gomock.NewController(t)
+			mockIMDS := NewMockIMDS(ctrl)
+			queries := 0
+			mockIMDS.EXPECT().QueryIMDS(gomock.Any()).DoAndReturn(func(ctx context.Context) (ScheduledEventsResponse, error) {
+				queries++
+				if queries <= len(tc.errs) {
+					return ScheduledEventsResponse{}, tc.errs[queries-1]
+				}
+				return expected, nil
+			}).Times(tc.expectQueries)
+
+			start := time.Now()
+			resp, err := QueryWithRetry(ctx, mockIMDS, retry)
+			if tc.expectErr != nil {
+				assert.ErrorIs(t, err, tc.expectErr)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, expected, resp)
+			}
+			// the waits are 10ms and then 15ms once the doubled delay is capped
+			assert.GreaterOrEqual(t, time.Since(start), tc.minElapsed)
+		})
+	}
+}
+
+func TestCheckIfDrainRequiredRetriesEOF(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+
+	retry := config.IMDSRetryConfig{Attempts: 2, BaseDelay: time.Millisecond}
+	vals := config.ContextValues{Logger: logger.Sugar(), State: &appstate.State{}}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	ctrl := gomock.NewController(t)
+	mockIMDS := NewMockIMDS(ctrl)
+	gomock.InOrder(
+		mockIMDS.EXPECT().QueryIMDS(gomock.Any()).Return(ScheduledEventsResponse{}, io.EOF),
+		mockIMDS.EXPECT().QueryIMDS(gomock.Any()).Return(ScheduledEventsResponse{IncarnationID: 1}, nil),
+	)
+
+	shouldDrain, event, err := CheckIfDrainRequired(ctx, mockIMDS, &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-vmss000001"}}, &config.DrainConditions{}, retry)
+	require.NoError(t, err)
+	assert.False(t, shouldDrain)
+	assert.Nil(t, event)
+}
+
+func TestIsRetriableIMDSError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{name: "EOF", err: io.EOF, expected: true},
+		{name: "wrapped EOF", err: fmt.Errorf("decoding response: %w", io.EOF), expected: true},
+		{name: "request timeout", err: &url.Error{Op: "Get", URL: "http://169.254.169.254", Err: context.DeadlineExceeded}, expected: true},
+		{name: "connection refused", err: &url.Error{Op: "Get", URL: "http://169.254.169.254", Err: &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}}, expected: true},
+		{name: "connection reset", err: &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}, expected: true},
+		{name: "temporary DNS failure", err: &net.DNSError{Err: "server misbehaving", Name: "imds", IsTemporary: true}, expected: true},
+		{name: "unknown host", err: &net.DNSError{Err: "no such host", Name: "imds", IsNotFound: true}, expected: false},
+		{name: "other transport error", err: &url.Error{Op: "Get", URL: "http://169.254.169.254", Err: errors.New("malformed HTTP response")}, expected: true},
+		{name: "invalid endpoint", err: &url.Error{Op: "parse", URL: "::", Err: errors.New("missing protocol scheme")}, expected: false},
+		{name: "cancelled", err: &url.Error{Op: "Get", URL: "http://169.254.169.254", Err: context.Canceled}, expected: false},
+		{name: "JSON syntax error", err: &json.SyntaxError{Offset: 3}, expected: false},
+		{name: "error response", err: errors.New("IMDS returned 500 Internal Server Error for api-version 2020-07-01"), expected: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, isRetriableIMDSError(tc.err))
+		})
+	}
+}
+
+func TestQueryWithRetryTimeoutThenSuccess(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	vals := config.ContextValues{Logger: logger.Sugar(), State: &appstate.State{}}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	retry := config.IMDSRetryConfig{Attempts: 3, BaseDelay: 10 * time.Millisecond}
+
+	// the first request hangs until the client gives up on it, like IMDS does while it restarts
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(10 * time.Second):
+			}
+			return
+		}
+		w.Write([]byte(`{"DocumentIncarnation": 4, "Events": []}`))
+	}))
+	defer server.Close()
+
+	ic := &IMDSClient{Timeout: 50 * time.Millisecond, Endpoint: server.URL}
+	resp, err := QueryWithRetry(ctx, ic, retry)
+	require.NoError(t, err)
+	assert.Equal(t, float64(4), resp.IncarnationID)
+	assert.Equal(t, int32(2), requests.Load(), "the timed out query should have been retried")
+}
@@ -0,0 +1,118 @@
+package imds
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/amargherio/mechanic/internal/config"
+)
+
+// Severity ranks how disruptive a scheduled event is to the workloads on the node. Higher severities compare greater.
+type Severity int
+
+const (
+	SeverityUnknown Severity = iota
+	SeverityLow
+	SeverityMedium
+	SeverityHigh
+)
+
+// liveMigrationSeverityKey is the severity mapping key for Freeze events that are memory-preserving live migrations,
+// which are more disruptive than a regular freeze
+const liveMigrationSeverityKey = "livemigration"
+
+// defaultSeverities maps lowercase event types to their severity when no mapping is configured
+var defaultSeverities = map[string]Severity{
+	"preempt":                SeverityHigh,
+	"terminate":              SeverityHigh,
+	"reboot":                 SeverityMedium,
+	"redeploy":               SeverityMedium,
+	liveMigrationSeverityKey: SeverityMedium,
+	"freeze":                 SeverityLow,
+}
+
+// severities is the mapping used by ScheduledEvent.Severity. It's replaced by ConfigureSeverities at startup.
+var (
+	severitiesLock sync.RWMutex
+	severities     = defaultSeverities
+)
+
+// String returns the lowercase name of the severity
+func (s Severity) String() string {
+	switch s {
+	case SeverityLow:
+		return "low"
+	case SeverityMedium:
+		return "medium"
+	case SeverityHigh:
+		return "high"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseSeverity parses a severity name, case-insensitively. An empty name is SeverityUnknown.
+func ParseSeverity(name string) (Severity, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "":
+		return SeverityUnknown, nil
+	case "low":
+		return SeverityLow, nil
+	case "medium":
+		return SeverityMedium, nil
+	case "high":
+		return SeverityHigh, nil
+	default:
+		return SeverityUnknown, fmt.Errorf("unknown severity %q, expected low, medium, or high", name)
+	}
+}
+
+// ConfigureSeverities validates the configured severity settings and replaces the mapping used by
+// ScheduledEvent.Severity with the defaults plus the configured overrides. An error is returned, and the mapping is
+// left alone, if any severity can't be parsed.
+func ConfigureSeverities(dc config.DrainConditions) error {
+	mapping := make(map[string]Severity, len(defaultSeverities))
+	for eventType, severity := range defaultSeverities {
+		mapping[eventType] = severity
+	}
+	for eventType, name := range dc.Severities {
+		severity, err := ParseSeverity(name)
+		if err != nil {
+			return fmt.Errorf("invalid severity for %s events: %w", eventType, err)
+		}
+		mapping[strings.ToLower(eventType)] = severity
+	}
+	if _, err := ParseSeverity(dc.LeadTimeExemptSeverity); err != nil {
+		return fmt.Errorf("invalid lead time exempt severity: %w", err)
+	}
+
+	severitiesLock.Lock()
+	defer severitiesLock.Unlock()
+	severities = mapping
+	return nil
+}
+
+// Severity classifies how disruptive the event is using the configured mapping, recognizing live migrations with the
+// given patterns. By default Preempt and Terminate are high, Reboot, Redeploy, and live migrations are medium, and other
+// freezes are low. Event types missing from the mapping are SeverityUnknown.
+func (e ScheduledEvent) Severity(liveMigrationPatterns []*regexp.Regexp) Severity {
+	key := strings.ToLower(string(e.Type))
+	if isLiveMigration(e, liveMigrationPatterns) {
+		key = liveMigrationSeverityKey
+	}
+
+	severitiesLock.RLock()
+	defer severitiesLock.RUnlock()
+	return severities[key]
+}
+
+// exemptFromLeadTime reports whether the event is severe enough to act on right away, ignoring the drain lead time
+func exemptFromLeadTime(event ScheduledEvent, exemptSeverity string, liveMigrationPatterns []*regexp.Regexp) bool {
+	threshold, err := ParseSeverity(exemptSeverity)
+	if err != nil || threshold == SeverityUnknown {
+		return false
+	}
+	return event.Severity(liveMigrationPatterns) >= threshold
+}
@@ -0,0 +1,132 @@
+package imds
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/amargherio/mechanic/internal/appstate"
+	"github.com/amargherio/mechanic/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	"go.uber.org/zap/zaptest"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestScheduledEventSeverity(t *testing.T) {
+	patterns, err := LiveMigrationPatterns(config.DrainConditions{})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		event    ScheduledEvent
+		expected Severity
+	}{
+		{name: "preempt", event: ScheduledEvent{Type: Preempt}, expected: SeverityHigh},
+		{name: "terminate", event: ScheduledEvent{Type: Terminate}, expected: SeverityHigh},
+		{name: "reboot", event: ScheduledEvent{Type: Reboot}, expected: SeverityMedium},
+		{name: "redeploy", event: ScheduledEvent{Type: Redeploy}, expected: SeverityMedium},
+		{name: "live migration freeze", event: ScheduledEvent{Type: Freeze, Description: "Virtual machine is being paused because of a memory-preserving Live Migration operation."}, expected: SeverityMedium},
+		{name: "freeze", event: ScheduledEvent{Type: Freeze, Description: "Host update"}, expected: SeverityLow},
+		{name: "unknown event type", event: ScheduledEvent{Type: "Hibernate"}, expected: SeverityUnknown},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.event.Severity(patterns))
+		})
+	}
+}
+
+func TestConfigureSeverities(t *testing.T) {
+	t.Cleanup(func() { require.NoError(t, ConfigureSeverities(config.DrainConditions{})) })
+
+	require.NoError(t, ConfigureSeverities(config.DrainConditions{Severities: map[string]string{"reboot": "High", "freeze": "medium"}}))
+	assert.Equal(t, SeverityHigh, ScheduledEvent{Type: Reboot}.Severity(nil))
+	assert.Equal(t, SeverityMedium, ScheduledEvent{Type: Freeze}.Severity(nil))
+	assert.Equal(t, SeverityMedium, ScheduledEvent{Type: Redeploy}.Severity(nil), "event types that aren't overridden keep their default")
+
+	// an invalid mapping is rejected and the current one is kept
+	assert.Error(t, ConfigureSeverities(config.DrainConditions{Severities: map[string]string{"reboot": "critical"}}))
+	assert.Error(t, ConfigureSeverities(config.DrainConditions{LeadTimeExemptSeverity: "urgent"}))
+	assert.Equal(t, SeverityHigh, ScheduledEvent{Type: Reboot}.Severity(nil))
+}
+
+func TestCheckIfDrainRequiredSelectsMostSevereEvent(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+
+	tests := []struct {
+		name            string
+		events          []ScheduledEvent
+		drainConditions config.DrainConditions
+		expectedEventID string
+	}{
+		{
+			name: "terminate is picked over an earlier reboot",
+			events: []ScheduledEvent{
+				{EventId: "reboot", Type: Reboot},
+				{EventId: "terminate", Type: Terminate},
+			},
+			drainConditions: config.DrainConditions{DrainOnReboot: true, DrainOnTerminate: true},
+			expectedEventID: "terminate",
+		},
+		{
+			name: "first event wins a tie",
+			events: []ScheduledEvent{
+				{EventId: "redeploy", Type: Redeploy},
+				{EventId: "reboot", Type: Reboot},
+			},
+			drainConditions: config.DrainConditions{DrainOnReboot: true, DrainOnRedeploy: true},
+			expectedEventID: "redeploy",
+		},
+		{
+			name: "events we don't drain for aren't picked",
+			events: []ScheduledEvent{
+				{EventId: "reboot", Type: Reboot},
+				{EventId: "preempt", Type: Preempt},
+			},
+			drainConditions: config.DrainConditions{DrainOnReboot: true},
+			expectedEventID: "reboot",
+		},
+		{
+			name: "high severity events skip the lead time when exempt",
+			events: []ScheduledEvent{
+				{EventId: "reboot", Type: Reboot, NotBefore: time.Now().Add(time.Hour)},
+				{EventId: "preempt", Type: Preempt, NotBefore: time.Now().Add(time.Hour)},
+			},
+			drainConditions: config.DrainConditions{DrainOnReboot: true, DrainOnPreempt: true, LeadTime: 10 * time.Minute, LeadTimeExemptSeverity: "high"},
+			expectedEventID: "preempt",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			vals := config.ContextValues{
+				Logger: logger.Sugar(),
+				State:  &appstate.State{},
+			}
+			ctx := context.WithValue(context.Background(), "values", &vals)
+
+			var events []ScheduledEvent
+			for _, event := range tc.events {
+				event.ResourceType = "VirtualMachine"
+				event.Resources = []string{"test-vmss_1"}
+				event.EventStatus = Scheduled
+				events = append(events, event)
+			}
+			mockIMDS := NewMockIMDS(ctrl)
+			mockIMDS.EXPECT().QueryIMDS(gomock.Any()).Return(ScheduledEventsResponse{IncarnationID: 1, Events: events}, nil)
+
+			node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-vmss000001"}}
+			drain, event, err := CheckIfDrainRequired(ctx, mockIMDS, node, &tc.drainConditions, config.IMDSRetryConfig{})
+			require.NoError(t, err)
+			assert.True(t, drain)
+			require.NotNil(t, event)
+			assert.Equal(t, tc.expectedEventID, event.EventId)
+		})
+	}
+}
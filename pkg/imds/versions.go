@@ -0,0 +1,109 @@
+package imds
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/amargherio/mechanic/internal/config"
+	"github.com/amargherio/mechanic/pkg/consts"
+	"go.opentelemetry.io/otel"
+)
+
+// DefaultAPIVersion is the scheduled events api-version used when none is configured
+const DefaultAPIVersion = "2020-07-01"
+
+// apiVersions is the body returned by the IMDS api-version listing
+type apiVersions struct {
+	APIVersions []string `json:"apiVersions"`
+}
+
+func (ic *IMDSClient) defaultAPIVersion() string {
+	if ic.APIVersion != "" {
+		return ic.APIVersion
+	}
+	return DefaultAPIVersion
+}
+
+// resolveAPIVersion returns the api-version to query scheduled events with. When negotiation is on, IMDS is asked
+// for its api-versions once and the newest is used from then on. Any failure falls back to the configured version.
+func (ic *IMDSClient) resolveAPIVersion(ctx context.Context, client *http.Client) string {
+	tracer := otel.Tracer("github.com/amargherio/mechanic/pkg/imds")
+	ctx, span := tracer.Start(ctx, "resolveAPIVersion")
+	defer span.End()
+
+	vals := ctx.Value("values").(*config.ContextValues)
+	log := vals.Logger
+
+	ic.versionLock.Lock()
+	defer ic.versionLock.Unlock()
+	if ic.resolvedVersion != "" {
+		return ic.resolvedVersion
+	}
+
+	ic.resolvedVersion = ic.defaultAPIVersion()
+	if !ic.NegotiateAPIVersion {
+		return ic.resolvedVersion
+	}
+
+	newest, err := ic.newestAPIVersion(ctx, client)
+	if err != nil {
+		log.Warnw("Failed to negotiate the IMDS api-version, using the configured version", "apiVersion", ic.resolvedVersion, "error", err, "traceCtx", ctx)
+		return ic.resolvedVersion
+	}
+
+	log.Infow("Negotiated the IMDS api-version", "apiVersion", newest, "traceCtx", ctx)
+	ic.resolvedVersion = newest
+	return ic.resolvedVersion
+}
+
+// rejectAPIVersion goes back to the configured api-version after IMDS refused the negotiated one
+func (ic *IMDSClient) rejectAPIVersion(version string) {
+	ic.versionLock.Lock()
+	defer ic.versionLock.Unlock()
+	if ic.resolvedVersion == version {
+		ic.resolvedVersion = ic.defaultAPIVersion()
+	}
+}
+
+// newestAPIVersion queries IMDS for the api-versions it supports and returns the newest. api-versions are dates, so
+// the newest is the one that sorts last.
+func (ic *IMDSClient) newestAPIVersion(ctx context.Context, client *http.Client) (string, error) {
+	endpoint := ic.VersionsEndpoint
+	if endpoint == "" {
+		endpoint = consts.IMDS_VERSIONS_API_ENDPOINT
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Add("Metadata", "true")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("IMDS returned %s for the api-version listing", resp.Status)
+	}
+
+	var versions apiVersions
+	if err := json.NewDecoder(resp.Body).Decode(&versions); err != nil {
+		return "", err
+	}
+
+	newest := ""
+	for _, v := range versions.APIVersions {
+		if v > newest {
+			newest = v
+		}
+	}
+	if newest == "" {
+		return "", errors.New("IMDS did not advertise any api-versions")
+	}
+	return newest, nil
+}
@@ -0,0 +1,143 @@
+package imds
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/amargherio/mechanic/internal/appstate"
+	"github.com/amargherio/mechanic/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+// versionServer is a fake IMDS serving an api-version listing and a scheduled events API that only accepts the
+// supported api-versions
+type versionServer struct {
+	lock         sync.Mutex
+	listing      string
+	supported    map[string]bool
+	listings     int
+	usedVersions []string
+}
+
+func (v *versionServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	switch r.URL.Path {
+	case "/metadata/versions":
+		v.listings++
+		if v.listing == "" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(v.listing))
+	case "/metadata/scheduledevents":
+		version := r.URL.Query().Get("api-version")
+		v.usedVersions = append(v.usedVersions, version)
+		if !v.supported[version] {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": "Bad request. api-version is invalid or was not specified in the request."}`))
+			return
+		}
+		w.Write([]byte(`{"DocumentIncarnation": 1, "Events": []}`))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestQueryIMDSAPIVersionNegotiation(t *testing.T) {
+	tests := []struct {
+		name             string
+		negotiate        bool
+		listing          string
+		supported        []string
+		expectedVersions []string
+		expectedListings int
+	}{
+		{
+			name:             "newest advertised version is used",
+			negotiate:        true,
+			listing:          `{"apiVersions": ["2019-08-01", "2021-02-01", "2020-07-01"]}`,
+			supported:        []string{"2020-07-01", "2021-02-01"},
+			expectedVersions: []string{"2021-02-01", "2021-02-01"},
+			expectedListings: 1,
+		},
+		{
+			name:             "listing unavailable falls back to the configured version",
+			negotiate:        true,
+			supported:        []string{"2020-07-01"},
+			expectedVersions: []string{"2020-07-01", "2020-07-01"},
+			expectedListings: 1,
+		},
+		{
+			name:             "rejected version falls back to the configured version",
+			negotiate:        true,
+			listing:          `{"apiVersions": ["2020-07-01", "2023-07-01"]}`,
+			supported:        []string{"2020-07-01"},
+			expectedVersions: []string{"2023-07-01", "2020-07-01", "2020-07-01"},
+			expectedListings: 1,
+		},
+		{
+			name:             "negotiation disabled uses the configured version",
+			negotiate:        false,
+			listing:          `{"apiVersions": ["2021-02-01"]}`,
+			supported:        []string{"2020-07-01", "2021-02-01"},
+			expectedVersions: []string{"2020-07-01", "2020-07-01"},
+			expectedListings: 0,
+		},
+	}
+
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			vals := config.ContextValues{Logger: logger.Sugar(), State: &appstate.State{}}
+			ctx := context.WithValue(context.Background(), "values", &vals)
+
+			fake := &versionServer{listing: tc.listing, supported: map[string]bool{}}
+			for _, v := range tc.supported {
+				fake.supported[v] = true
+			}
+			server := httptest.NewServer(fake)
+			defer server.Close()
+
+			ic := &IMDSClient{
+				Endpoint:            server.URL + "/metadata/scheduledevents",
+				VersionsEndpoint:    server.URL + "/metadata/versions",
+				NegotiateAPIVersion: tc.negotiate,
+			}
+
+			// the version is settled on the first query and reused after that
+			for i := 0; i < 2; i++ {
+				_, err := ic.QueryIMDS(ctx)
+				require.NoError(t, err)
+			}
+
+			assert.Equal(t, tc.expectedVersions, fake.usedVersions)
+			assert.Equal(t, tc.expectedListings, fake.listings)
+		})
+	}
+}
+
+func TestQueryIMDSErrorStatus(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	vals := config.ContextValues{Logger: logger.Sugar(), State: &appstate.State{}}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	// the configured version is rejected, so there's nothing to fall back to
+	fake := &versionServer{supported: map[string]bool{}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	ic := &IMDSClient{Endpoint: server.URL + "/metadata/scheduledevents", APIVersion: "2017-11-01"}
+	_, err := ic.QueryIMDS(ctx)
+	assert.ErrorContains(t, err, "400")
+	assert.Equal(t, []string{"2017-11-01"}, fake.usedVersions)
+}
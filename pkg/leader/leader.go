@@ -0,0 +1,88 @@
+package leader
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/amargherio/mechanic/internal/config"
+	"go.opentelemetry.io/otel"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// Elector holds a per-node Lease so only one mechanic instance acts on a node, even when more than one is running for
+// it, like a DaemonSet and a Deployment side by side during a migration.
+type Elector struct {
+	cfg      config.LeaderElectionConfig
+	nodeName string
+	identity string
+	leading  atomic.Bool
+}
+
+// NewElector returns an Elector competing for the node's Lease under the given identity, usually the pod name
+func NewElector(cfg config.LeaderElectionConfig, nodeName, identity string) *Elector {
+	return &Elector{cfg: cfg, nodeName: nodeName, identity: identity}
+}
+
+// LeaseName is the name of the Lease held for the node
+func (e *Elector) LeaseName() string {
+	return fmt.Sprintf("mechanic-%s", e.nodeName)
+}
+
+// IsLeader reports whether this instance currently holds the node's Lease
+func (e *Elector) IsLeader() bool {
+	return e.leading.Load()
+}
+
+// Run competes for the node's Lease until the context is cancelled. Leadership that's lost is competed for again. The
+// Lease is released when Run returns so another instance can take over without waiting for it to expire.
+func (e *Elector) Run(ctx context.Context, clientset kubernetes.Interface) error {
+	tracer := otel.Tracer("github.com/amargherio/mechanic/pkg/leader")
+	ctx, span := tracer.Start(ctx, "Run")
+	defer span.End()
+
+	vals := ctx.Value("values").(*config.ContextValues)
+	log := vals.Logger
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta:  metav1.ObjectMeta{Namespace: e.cfg.Namespace, Name: e.LeaseName()},
+		Client:     clientset.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: e.identity},
+	}
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   e.cfg.LeaseDuration,
+		RenewDeadline:   e.cfg.RenewDeadline,
+		RetryPeriod:     e.cfg.RetryPeriod,
+		ReleaseOnCancel: true,
+		Name:            e.LeaseName(),
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				e.leading.Store(true)
+				log.Infow("Acquired the node lease, acting on the node", "node", e.nodeName, "lease", e.LeaseName(), "identity", e.identity, "traceCtx", ctx)
+			},
+			OnStoppedLeading: func() {
+				if e.leading.Swap(false) {
+					log.Infow("Released the node lease, no longer acting on the node", "node", e.nodeName, "lease", e.LeaseName(), "identity", e.identity, "traceCtx", ctx)
+				}
+			},
+			OnNewLeader: func(identity string) {
+				if identity != e.identity {
+					log.Infow("Another instance holds the node lease", "node", e.nodeName, "lease", e.LeaseName(), "leader", identity, "traceCtx", ctx)
+				}
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Infow("Starting leader election for the node", "node", e.nodeName, "namespace", e.cfg.Namespace, "lease", e.LeaseName(), "identity", e.identity, "traceCtx", ctx)
+	for ctx.Err() == nil {
+		elector.Run(ctx)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package leader
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/amargherio/mechanic/internal/appstate"
+	"github.com/amargherio/mechanic/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestElectorAcquireAndRelease(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	vals := config.ContextValues{Logger: logger.Sugar(), State: &appstate.State{}}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	cfg := config.LeaderElectionConfig{
+		Enabled:       true,
+		Namespace:     "mechanic",
+		LeaseDuration: 2 * time.Second,
+		RenewDeadline: time.Second,
+		RetryPeriod:   100 * time.Millisecond,
+	}
+	clientset := fake.NewClientset()
+
+	first := NewElector(cfg, "test-node", "mechanic-ds-abcde")
+	firstCtx, stopFirst := context.WithCancel(ctx)
+	firstDone := make(chan struct{})
+	go func() {
+		defer close(firstDone)
+		assert.NoError(t, first.Run(firstCtx, clientset))
+	}()
+	require.Eventually(t, first.IsLeader, 5*time.Second, 10*time.Millisecond)
+
+	lease, err := clientset.CoordinationV1().Leases("mechanic").Get(ctx, "mechanic-test-node", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "mechanic-ds-abcde", *lease.Spec.HolderIdentity)
+
+	// a second instance for the same node waits while the first holds the lease
+	second := NewElector(cfg, "test-node", "mechanic-deploy-fghij")
+	secondCtx, stopSecond := context.WithCancel(ctx)
+	secondDone := make(chan struct{})
+	go func() {
+		defer close(secondDone)
+		second.Run(secondCtx, clientset)
+	}()
+	// the second instance logs when it releases the lease, so it has to stop before the test ends
+	defer func() {
+		stopSecond()
+		<-secondDone
+	}()
+	assert.Never(t, second.IsLeader, 500*time.Millisecond, 10*time.Millisecond)
+
+	// stopping the first releases the lease, so the second takes over without waiting for it to expire
+	stopFirst()
+	<-firstDone
+	assert.False(t, first.IsLeader())
+	require.Eventually(t, second.IsLeader, time.Second, 10*time.Millisecond)
+}
+
+func TestElectorLeaseName(t *testing.T) {
+	e := NewElector(config.LeaderElectionConfig{}, "aks-nodepool1-12345678-vmss000001", "mechanic-ds-abcde")
+	assert.Equal(t, "mechanic-aks-nodepool1-12345678-vmss000001", e.LeaseName())
+}
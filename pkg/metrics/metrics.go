@@ -0,0 +1,119 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// NodeNameParseErrors counts the number of times the node name could not be resolved to a VMSS instance name
+	NodeNameParseErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "mechanic_node_name_parse_errors_total",
+		Help: "Number of times the node name could not be decoded into a VMSS instance name for scheduled event matching.",
+	})
+
+	// Cordons counts the nodes cordoned by mechanic, labeled by the node's zone and region and whether a scheduled
+	// event or a node condition triggered the cordon
+	Cordons = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mechanic_cordons_total",
+		Help: "Number of times mechanic cordoned a node.",
+	}, []string{"zone", "region", "category"})
+
+	// Drains counts the node drains completed by mechanic, labeled by the node's zone and region and whether a
+	// scheduled event or a node condition triggered the drain
+	Drains = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mechanic_drains_total",
+		Help: "Number of times mechanic drained a node.",
+	}, []string{"zone", "region", "category"})
+
+	// DrainResults counts every drain attempt by its classified outcome: success, pdb_blocked, timeout, incomplete,
+	// api_error, or aborted
+	DrainResults = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mechanic_drain_results_total",
+		Help: "Number of drain attempts by outcome.",
+	}, []string{"result"})
+
+	// DrainEscalations counts the escalation steps taken for nodes whose drains kept failing, labeled by the step:
+	// force or page
+	DrainEscalations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mechanic_drain_escalations_total",
+		Help: "Number of drain escalation steps taken for nodes whose drains kept failing, by step.",
+	}, []string{"step"})
+
+	// DrainDuration observes how long each drain attempt took, labeled by the same outcome as DrainResults
+	DrainDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "mechanic_drain_duration_seconds",
+		Help:    "Seconds taken by each drain attempt, by outcome.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 12),
+	}, []string{"result"})
+
+	// IMDSQueries counts every scheduled events query made to IMDS by whether it returned a response or failed
+	IMDSQueries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mechanic_imds_queries_total",
+		Help: "Number of IMDS scheduled events queries, by result.",
+	}, []string{"result"})
+
+	// EventSinkFailures counts the Kubernetes event writes the API server rejected, labeled by the write operation and
+	// the status reason returned, such as Forbidden when RBAC is missing or TooManyRequests when rate limited
+	EventSinkFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mechanic_event_sink_failures_total",
+		Help: "Number of Kubernetes event writes that failed, by operation and reason.",
+	}, []string{"operation", "reason"})
+
+	// ScheduledEventChecks counts every scheduled events response checked for a drain by what it held for the node: no
+	// events at all, events that don't target the node, or at least one event that does
+	ScheduledEventChecks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mechanic_scheduled_event_checks_total",
+		Help: "Number of IMDS scheduled events responses checked, by whether they held events impacting the node.",
+	}, []string{"result"})
+
+	// ScheduledEventsStarted counts the scheduled events impacting the node that were seen moving from Scheduled to
+	// Started, labeled by event type
+	ScheduledEventsStarted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mechanic_scheduled_events_started_total",
+		Help: "Number of scheduled events impacting the node seen moving from Scheduled to Started, by event type.",
+	}, []string{"type"})
+
+	// EventToDrainSeconds observes the time from when mechanic first saw a scheduled event or condition on the node to
+	// when the drain it triggered completed, labeled by whether an event or a condition triggered the drain
+	EventToDrainSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "mechanic_event_to_drain_seconds",
+		Help:    "Seconds from first detecting a scheduled event or condition to completing the node drain.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 12),
+	}, []string{"category"})
+
+	// EventsPerResponse observes how many events each IMDS scheduled events response held, before the events per
+	// response cap is applied
+	EventsPerResponse = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "mechanic_imds_events_per_response",
+		Help:    "Number of events in each IMDS scheduled events response.",
+		Buckets: []float64{0, 1, 2, 5, 10, 25, 50, 100, 250},
+	})
+)
+
+// collectors are the metrics registered by Register
+var collectors = []prometheus.Collector{
+	NodeNameParseErrors,
+	Cordons,
+	Drains,
+	DrainResults,
+	DrainEscalations,
+	DrainDuration,
+	IMDSQueries,
+	EventSinkFailures,
+	ScheduledEventChecks,
+	ScheduledEventsStarted,
+	EventToDrainSeconds,
+	EventsPerResponse,
+}
+
+// Register registers the metrics with registerer, labeling every series with the operating mode so metrics from a
+// fleet running in different modes can be told apart
+func Register(registerer prometheus.Registerer, mode string) error {
+	wrapped := prometheus.WrapRegistererWith(prometheus.Labels{"mode": mode}, registerer)
+	for _, c := range collectors {
+		if err := wrapped.Register(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
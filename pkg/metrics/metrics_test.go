@@ -0,0 +1,44 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/amargherio/mechanic/internal/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegisterLabelsOperatingMode(t *testing.T) {
+	tests := []struct {
+		name         string
+		cfg          config.Config
+		expectedMode string
+	}{
+		{name: "informer only", cfg: config.Config{}, expectedMode: "informer"},
+		{name: "informer with polling", cfg: config.Config{PollingInterval: 30 * time.Second}, expectedMode: "hybrid"},
+		{name: "informer with IMDS polling", cfg: config.Config{AlsoPollIMDS: true}, expectedMode: "hybrid"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			registry := prometheus.NewRegistry()
+			require.NoError(t, Register(registry, tc.cfg.OperatingMode()))
+			DrainResults.WithLabelValues("success").Inc()
+
+			families, err := registry.Gather()
+			require.NoError(t, err)
+			require.NotEmpty(t, families)
+			for _, family := range families {
+				for _, metric := range family.GetMetric() {
+					labels := map[string]string{}
+					for _, label := range metric.GetLabel() {
+						labels[label.GetName()] = label.GetValue()
+					}
+					assert.Equal(t, tc.expectedMode, labels["mode"], "%s is missing the mode label", family.GetName())
+				}
+			}
+		})
+	}
+}
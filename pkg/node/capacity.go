@@ -0,0 +1,59 @@
+package node
+
+import (
+	"context"
+
+	"github.com/amargherio/mechanic/internal/config"
+	"github.com/amargherio/mechanic/pkg/imds"
+	"go.opentelemetry.io/otel"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// IsUrgent reports whether the trigger is one where the node goes away no matter what we do, so holding back the
+// drain to protect cluster capacity would only make the workload disruption worse
+func (t Trigger) IsUrgent() bool {
+	return t.Category == TriggerCategoryEvent && (t.Reason == string(imds.Preempt) || t.Reason == string(imds.Terminate))
+}
+
+// HasMinSchedulableNodes reports whether the cluster keeps at least min schedulable, Ready nodes once the node is
+// drained, along with the number of schedulable, Ready nodes other than the node. A min of zero disables the check.
+func HasMinSchedulableNodes(ctx context.Context, clientset kubernetes.Interface, node *v1.Node, min int) (bool, int, error) {
+	tracer := otel.Tracer("github.com/amargherio/mechanic/pkg/node")
+	ctx, span := tracer.Start(ctx, "HasMinSchedulableNodes")
+	defer span.End()
+
+	vals := ctx.Value("values").(*config.ContextValues)
+	log := vals.Logger
+
+	if min <= 0 {
+		return true, 0, nil
+	}
+
+	nodes, err := clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		log.Errorw("Failed to list nodes to count schedulable nodes", "error", err, "traceCtx", ctx)
+		return false, 0, err
+	}
+
+	schedulable := 0
+	for _, n := range nodes.Items {
+		if n.Name == node.Name || n.Spec.Unschedulable || !isNodeReady(n) {
+			continue
+		}
+		schedulable++
+	}
+
+	log.Debugw("Counted schedulable nodes", "node", node.Name, "schedulable", schedulable, "min", min, "traceCtx", ctx)
+	return schedulable >= min, schedulable, nil
+}
+
+func isNodeReady(node v1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == v1.NodeReady {
+			return condition.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
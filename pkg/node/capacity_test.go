@@ -0,0 +1,75 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/amargherio/mechanic/internal/appstate"
+	"github.com/amargherio/mechanic/internal/config"
+	"github.com/amargherio/mechanic/pkg/imds"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestHasMinSchedulableNodes(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+
+	vals := config.ContextValues{
+		Logger: logger.Sugar(),
+		State:  &appstate.State{},
+	}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	newNode := func(name string, ready v1.ConditionStatus, unschedulable bool) *v1.Node {
+		return &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       v1.NodeSpec{Unschedulable: unschedulable},
+			Status:     v1.NodeStatus{Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: ready}}},
+		}
+	}
+
+	node := newNode("test-node", v1.ConditionTrue, true)
+	// three other schedulable, Ready nodes plus some that don't count
+	objects := []runtime.Object{node, newNode("not-ready", v1.ConditionFalse, false), newNode("cordoned", v1.ConditionTrue, true)}
+	for i := 0; i < 3; i++ {
+		objects = append(objects, newNode(fmt.Sprintf("ready-%d", i), v1.ConditionTrue, false))
+	}
+	clientset := fake.NewClientset(objects...)
+
+	tests := []struct {
+		name     string
+		min      int
+		expected bool
+	}{
+		{name: "disabled", min: 0, expected: true},
+		{name: "above the threshold", min: 2, expected: true},
+		{name: "at the threshold", min: 3, expected: true},
+		{name: "below the threshold", min: 4, expected: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ok, count, err := HasMinSchedulableNodes(ctx, clientset, node, tc.min)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, ok)
+			if tc.min > 0 {
+				assert.Equal(t, 3, count)
+			}
+		})
+	}
+}
+
+func TestTriggerIsUrgent(t *testing.T) {
+	assert.True(t, EventTrigger(&imds.ScheduledEvent{Type: imds.Preempt}).IsUrgent())
+	assert.True(t, EventTrigger(&imds.ScheduledEvent{Type: imds.Terminate}).IsUrgent())
+	assert.False(t, EventTrigger(&imds.ScheduledEvent{Type: imds.Reboot}).IsUrgent())
+	assert.False(t, EventTrigger(&imds.ScheduledEvent{Type: imds.Freeze}).IsUrgent())
+	assert.False(t, ConditionTrigger("GPUUncorrectableECCError").IsUrgent())
+}
@@ -0,0 +1,27 @@
+package node
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// apiConnectivityTimeout bounds the connectivity check. It's short so a struggling apiserver defers the action quickly.
+const apiConnectivityTimeout = 5 * time.Second
+
+// CheckAPIConnectivity makes a cheap request to the apiserver, getting the node, and returns the error when it fails or
+// doesn't return within a few seconds.
+func CheckAPIConnectivity(ctx context.Context, clientset kubernetes.Interface, nodeName string) error {
+	tracer := otel.Tracer("github.com/amargherio/mechanic/pkg/node")
+	ctx, span := tracer.Start(ctx, "CheckAPIConnectivity")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, apiConnectivityTimeout)
+	defer cancel()
+
+	_, err := clientset.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{ResourceVersion: "0"})
+	return err
+}
@@ -0,0 +1,98 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/amargherio/mechanic/internal/appstate"
+	"github.com/amargherio/mechanic/internal/config"
+	"github.com/amargherio/mechanic/pkg/imds"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// failNodeGets makes every node get on the clientset fail, as if the apiserver couldn't be reached
+func failNodeGets(clientset *fake.Clientset) {
+	clientset.PrependReactor("get", "nodes", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("dial tcp 10.0.0.1:443: connect: connection refused")
+	})
+}
+
+func TestCheckAPIConnectivity(t *testing.T) {
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-vmss000001"}}
+
+	clientset := fake.NewClientset(node)
+	assert.NoError(t, CheckAPIConnectivity(context.Background(), clientset, node.Name))
+
+	failNodeGets(clientset)
+	assert.ErrorContains(t, CheckAPIConnectivity(context.Background(), clientset, node.Name), "connection refused")
+}
+
+func TestReconcileNodeRequiresAPIConnectivity(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	vals := config.ContextValues{Logger: logger.Sugar()}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	preempt := imds.ScheduledEvent{
+		EventId:      "preempt",
+		Type:         imds.Preempt,
+		ResourceType: "VirtualMachine",
+		Resources:    []string{"test-vmss_1"},
+		EventStatus:  imds.Scheduled,
+		NotBefore:    time.Now().Add(1 * time.Hour),
+		EventSource:  imds.Platform,
+	}
+
+	tests := []struct {
+		name          string
+		require       bool
+		expectedEvent string
+	}{
+		{
+			name:          "action deferred when the apiserver can't be reached",
+			require:       true,
+			expectedEvent: "Warning ActionDeferred Cordon and drain of node test-vmss000001 deferred, the apiserver could not be reached (event: Preempt)",
+		},
+		{
+			name:          "check disabled attempts the action anyway",
+			require:       false,
+			expectedEvent: "Warning CordonNode Failed to cordon node test-vmss000001 (event: Preempt)",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			node := &v1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-vmss000001", UID: "uid-1", Labels: map[string]string{}},
+				Status:     v1.NodeStatus{Conditions: []v1.NodeCondition{{Type: "PreemptScheduled", Status: v1.ConditionTrue}}},
+			}
+			clientset := fake.NewClientset(node)
+			failNodeGets(clientset)
+			cfg := config.Config{
+				DrainConditions:                    config.DrainConditions{DrainOnPreempt: true},
+				RequireAPIConnectivityBeforeAction: tc.require,
+			}
+			ic := &fakeIMDS{resp: imds.ScheduledEventsResponse{IncarnationID: 1, Events: []imds.ScheduledEvent{preempt}}}
+			state := &appstate.State{NodeUID: node.UID}
+			recorder := &MockRecorder{}
+
+			err := ReconcileNode(ctx, clientset, ic, cfg, state, recorder, node)
+			assert.ErrorContains(t, err, "connection refused")
+			assert.False(t, state.Cordoned())
+			require.NotEmpty(t, recorder.Events)
+			assert.Equal(t, tc.expectedEvent, recorder.Events[0])
+			if tc.require {
+				assert.Len(t, recorder.Events, 1, "nothing else should be attempted once the action is deferred")
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package node
+
+import (
+	"github.com/amargherio/mechanic/internal/config"
+	v1 "k8s.io/api/core/v1"
+)
+
+// defaultCordonLabelKey is the label that marks mechanic's cordons when no other key is configured
+const defaultCordonLabelKey = "mechanic.cordoned"
+
+// cordonLabelKey returns the label that marks mechanic's cordons, falling back to the default when none is configured
+func cordonLabelKey(markers config.CordonConfig) string {
+	if markers.LabelKey == "" {
+		return defaultCordonLabelKey
+	}
+	return markers.LabelKey
+}
+
+// ownsCordon reports whether the node carries mechanic's cordon label. It doesn't check the node is unschedulable.
+func ownsCordon(node *v1.Node, markers config.CordonConfig) bool {
+	_, ok := node.GetLabels()[cordonLabelKey(markers)]
+	return ok
+}
+
+// cordonLabelValue returns the value of mechanic's cordon label and whether the node has it
+func cordonLabelValue(node *v1.Node, markers config.CordonConfig) (string, bool) {
+	value, ok := node.GetLabels()[cordonLabelKey(markers)]
+	return value, ok
+}
+
+// markCordoned cordons the node in place, adding mechanic's cordon label and, when configured, its taint
+func markCordoned(n *v1.Node, markers config.CordonConfig) {
+	n.Spec.Unschedulable = true
+	labels := n.GetLabels()
+	if labels == nil {
+		labels = make(map[string]string)
+	}
+	labels[cordonLabelKey(markers)] = "true"
+	n.SetLabels(labels)
+	if markers.ApplyTaint {
+		addCordonTaint(n, markers.TaintKey)
+	}
+}
+
+// unmarkCordoned uncordons the node in place, removing mechanic's cordon label and taint
+func unmarkCordoned(n *v1.Node, markers config.CordonConfig) {
+	n.Spec.Unschedulable = false
+	removeCordonLabel(n, markers)
+	removeCordonTaint(n, markers)
+}
+
+// removeCordonLabel removes mechanic's cordon label from the node in place and reports whether it was there
+func removeCordonLabel(n *v1.Node, markers config.CordonConfig) bool {
+	labels := n.GetLabels()
+	key := cordonLabelKey(markers)
+	if _, ok := labels[key]; !ok {
+		return false
+	}
+	delete(labels, key)
+	n.SetLabels(labels)
+	return true
+}
+
+// addCordonTaint adds mechanic's NoSchedule taint with the given key to the node in place and reports whether it was
+// missing
+func addCordonTaint(n *v1.Node, key string) bool {
+	for _, taint := range n.Spec.Taints {
+		if taint.Key == key && taint.Effect == v1.TaintEffectNoSchedule {
+			return false
+		}
+	}
+	n.Spec.Taints = append(n.Spec.Taints, v1.Taint{Key: key, Value: "true", Effect: v1.TaintEffectNoSchedule})
+	return true
+}
+
+// removeCordonTaint removes mechanic's taint from the node in place and reports whether it was there. The taint is
+// removed even when ApplyTaint has since been turned off, so it isn't left behind.
+func removeCordonTaint(n *v1.Node, markers config.CordonConfig) bool {
+	key := markers.TaintKey
+	if key == "" {
+		return false
+	}
+
+	removed := false
+	var taints []v1.Taint
+	for _, taint := range n.Spec.Taints {
+		if taint.Key == key && taint.Effect == v1.TaintEffectNoSchedule {
+			removed = true
+			continue
+		}
+		taints = append(taints, taint)
+	}
+	if removed {
+		n.Spec.Taints = taints
+	}
+	return removed
+}
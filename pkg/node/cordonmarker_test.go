@@ -0,0 +1,119 @@
+package node
+
+import (
+	"context"
+	"testing"
+
+	"github.com/amargherio/mechanic/internal/appstate"
+	"github.com/amargherio/mechanic/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func hasTaint(node *v1.Node, key string) bool {
+	for _, taint := range node.Spec.Taints {
+		if taint.Key == key && taint.Effect == v1.TaintEffectNoSchedule {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCordonWithCustomLabelAndTaint(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+
+	cfg := config.Config{Cordon: config.CordonConfig{LabelKey: "platform.example.com/cordoned", ApplyTaint: true, TaintKey: "platform.example.com/maintenance"}}
+
+	state := &appstate.State{}
+	vals := config.ContextValues{Logger: logger.Sugar(), State: state}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-node", Labels: map[string]string{}}}
+	clientset := fake.NewClientset(node)
+
+	cordoned, err := CordonNode(ctx, clientset, node, cfg, Trigger{})
+	require.NoError(t, err)
+	require.True(t, cordoned)
+
+	stored, err := clientset.CoreV1().Nodes().Get(ctx, node.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.True(t, stored.Spec.Unschedulable)
+	assert.Equal(t, "true", stored.Labels["platform.example.com/cordoned"])
+	assert.NotContains(t, stored.Labels, "mechanic.cordoned")
+	assert.True(t, hasTaint(stored, "platform.example.com/maintenance"))
+
+	// with the event gone, the cordon is recognized as ours under the custom key and released along with the taint
+	state.SetCordoned(true)
+	recorder := &MockRecorder{}
+	ValidateCordon(ctx, clientset, stored, cfg, recorder)
+
+	released, err := clientset.CoreV1().Nodes().Get(ctx, node.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.False(t, released.Spec.Unschedulable)
+	assert.NotContains(t, released.Labels, "platform.example.com/cordoned")
+	assert.False(t, hasTaint(released, "platform.example.com/maintenance"))
+	assert.Equal(t, []string{"Normal UncordonNode Node test-node uncordoned by mechanic"}, recorder.Events)
+}
+
+func TestValidateCordonIgnoresDefaultLabelUnderCustomKey(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+
+	cfg := config.Config{Cordon: config.CordonConfig{LabelKey: "platform.example.com/cordoned"}}
+
+	state := &appstate.State{IsCordoned: true}
+	vals := config.ContextValues{Logger: logger.Sugar(), State: state}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	// the default label isn't ours once a custom key is configured, so the cordon is left alone
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-node", Labels: map[string]string{"mechanic.cordoned": "true"}},
+		Spec:       v1.NodeSpec{Unschedulable: true},
+	}
+	clientset := fake.NewClientset(node)
+	ValidateCordon(ctx, clientset, node, cfg, &MockRecorder{})
+
+	stored, err := clientset.CoreV1().Nodes().Get(ctx, node.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.True(t, stored.Spec.Unschedulable)
+	assert.Equal(t, "true", stored.Annotations[cordonNotManagedAnnotation])
+}
+
+func TestReconcileCordonMarkersTaint(t *testing.T) {
+	markers := config.CordonConfig{LabelKey: "platform.example.com/cordoned", ApplyTaint: true, TaintKey: "platform.example.com/maintenance"}
+
+	tests := []struct {
+		name        string
+		labels      map[string]string
+		taints      []v1.Taint
+		expectTaint bool
+	}{
+		{
+			name:        "our cordon without the taint gets it",
+			labels:      map[string]string{"platform.example.com/cordoned": "true"},
+			expectTaint: true,
+		},
+		{
+			name:   "the taint without our label is removed",
+			labels: map[string]string{},
+			taints: []v1.Taint{{Key: "platform.example.com/maintenance", Value: "true", Effect: v1.TaintEffectNoSchedule}},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			node := &v1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-node", Labels: tc.labels},
+				Spec:       v1.NodeSpec{Unschedulable: true, Taints: tc.taints},
+			}
+			assert.True(t, reconcileCordonMarkers(node, markers, true))
+			assert.Equal(t, tc.expectTaint, hasTaint(node, "platform.example.com/maintenance"))
+			assert.True(t, node.Spec.Unschedulable)
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package node
+
+import (
+	"context"
+
+	"github.com/amargherio/mechanic/internal/config"
+	"github.com/amargherio/mechanic/pkg/imds"
+	"go.opentelemetry.io/otel"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// DrainDecision is the result of evaluating a node's conditions and scheduled events
+type DrainDecision struct {
+	// HasEventScheduled is true when anything calls for acting on the node: a drainable condition, a GPU health
+	// condition, a maintenance taint, or an event found by polling IMDS
+	HasEventScheduled bool
+	// ShouldDrain is true when a drain is required, whether for a scheduled event or the node's other triggers
+	ShouldDrain bool
+	// Trigger describes what drives the drain, empty when nothing does
+	Trigger Trigger
+	// Event is the scheduled event requiring a drain, nil when IMDS wasn't asked or has none
+	Event *imds.ScheduledEvent
+}
+
+// EvaluateNode runs the node's trigger checks and IMDS lookup and returns the resulting decision without cordoning or
+// draining the node. The node is re-read through the clientset so the decision reflects its current conditions rather
+// than a possibly stale copy. IMDS is queried at most once and the events are evaluated by imds.EvaluateEvents, so
+// nothing is recorded in the state, reported, or counted in the metrics.
+func EvaluateNode(ctx context.Context, clientset kubernetes.Interface, ic imds.IMDS, node *v1.Node, cfg config.Config) (DrainDecision, error) {
+	tracer := otel.Tracer("github.com/amargherio/mechanic/pkg/node")
+	ctx, span := tracer.Start(ctx, "EvaluateNode")
+	defer span.End()
+
+	vals := ctx.Value("values").(*config.ContextValues)
+	log := vals.Logger
+
+	decision := DrainDecision{}
+
+	current, err := clientset.CoreV1().Nodes().Get(ctx, node.Name, metav1.GetOptions{})
+	if err != nil {
+		log.Errorw("Failed to get node for evaluation", "node", node.Name, "error", err, "traceCtx", ctx)
+		return decision, err
+	}
+
+	// the poll and the drain lookup share one query and evaluation
+	var evaluated *imds.DrainDecision
+	evaluate := func(ctx context.Context) (imds.DrainDecision, error) {
+		if evaluated != nil {
+			return *evaluated, nil
+		}
+		resp, err := imds.QueryWithRetry(ctx, ic, cfg.IMDSRetry)
+		if err != nil {
+			return imds.DrainDecision{}, err
+		}
+		d, err := imds.EvaluateEvents(ctx, resp.Events, current, &cfg.DrainConditions)
+		if err != nil {
+			return imds.DrainDecision{}, err
+		}
+		evaluated = &d
+		return d, nil
+	}
+	lookup := eventLookup{
+		impacting: func(ctx context.Context) (bool, error) {
+			d, err := evaluate(ctx)
+			return len(d.Impacting) > 0, err
+		},
+		drainRequired: func(ctx context.Context) (bool, *imds.ScheduledEvent, error) {
+			d, err := evaluate(ctx)
+			decision.Event = d.Event
+			return d.Drain, d.Event, err
+		},
+	}
+
+	triggers := checkTriggers(ctx, current, cfg)
+	triggers.poll(ctx, current, cfg, lookup)
+	decision.HasEventScheduled = triggers.scheduled()
+	if !decision.HasEventScheduled {
+		log.Debugw("Node has nothing calling for a drain, no drain required", "node", current.Name, "traceCtx", ctx)
+		return decision, nil
+	}
+
+	drainTriggers, shouldDrain, err := triggers.resolve(ctx, current, cfg, lookup)
+	if err != nil {
+		return DrainDecision{HasEventScheduled: true}, err
+	}
+	decision.ShouldDrain = shouldDrain
+	decision.Trigger = combineTriggers(drainTriggers)
+
+	log.Debugw("Evaluated drain decision for node", "node", current.Name, "decision", decision, "traceCtx", ctx)
+	return decision, nil
+}
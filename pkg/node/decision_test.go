@@ -0,0 +1,228 @@
+package node
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/amargherio/mechanic/internal/appstate"
+	"github.com/amargherio/mechanic/internal/config"
+	"github.com/amargherio/mechanic/pkg/imds"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap/zaptest"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// fakeIMDS returns a canned scheduled events response, counts how many times it was queried, and records the events
+// it was asked to acknowledge
+type fakeIMDS struct {
+	resp    imds.ScheduledEventsResponse
+	err     error
+	queries int
+	acked   []string
+	ackErr  error
+}
+
+func (f *fakeIMDS) QueryIMDS(ctx context.Context) (imds.ScheduledEventsResponse, error) {
+	f.queries++
+	return f.resp, f.err
+}
+
+func (f *fakeIMDS) AckEvent(ctx context.Context, eventID string) error {
+	f.acked = append(f.acked, eventID)
+	return f.ackErr
+}
+
+func TestEvaluateNode(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	log := logger.Sugar()
+
+	cfg := config.Config{
+		DrainConditions: config.DrainConditions{
+			DrainOnRedeploy:  true,
+			DrainOnPreempt:   true,
+			DrainOnTerminate: true,
+		},
+	}
+
+	preempt := imds.ScheduledEvent{
+		EventId:      "preempt",
+		Type:         imds.Preempt,
+		ResourceType: "VirtualMachine",
+		Resources:    []string{"test-vmss_1"},
+		EventStatus:  imds.Scheduled,
+		NotBefore:    time.Now().Add(1 * time.Hour),
+		EventSource:  imds.Platform,
+	}
+	reboot := preempt
+	reboot.EventId = "reboot"
+	reboot.Type = imds.Reboot
+
+	preemptTrigger := EventTrigger(&preempt)
+	preemptTrigger.Condition = "PreemptScheduled"
+
+	tests := []struct {
+		name             string
+		conditions       []v1.NodeCondition
+		events           []imds.ScheduledEvent
+		expectedDecision DrainDecision
+		expectedQueries  int
+	}{
+		{
+			name:             "no conditions skips IMDS",
+			expectedDecision: DrainDecision{},
+			expectedQueries:  0,
+		},
+		{
+			name:       "condition with drainable event",
+			conditions: []v1.NodeCondition{{Type: "PreemptScheduled", Status: v1.ConditionTrue}},
+			events:     []imds.ScheduledEvent{preempt},
+			expectedDecision: DrainDecision{
+				HasEventScheduled: true,
+				ShouldDrain:       true,
+				Trigger:           preemptTrigger,
+				Event:             &preempt,
+			},
+			expectedQueries: 1,
+		},
+		{
+			name:       "condition with event that isn't drainable",
+			conditions: []v1.NodeCondition{{Type: "VMEventScheduled", Status: v1.ConditionTrue}},
+			events:     []imds.ScheduledEvent{reboot},
+			expectedDecision: DrainDecision{
+				HasEventScheduled: true,
+			},
+			expectedQueries: 1,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			state := &appstate.State{}
+			vals := config.ContextValues{
+				Logger: log,
+				State:  state,
+			}
+			ctx := context.WithValue(context.Background(), "values", &vals)
+
+			node := &v1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-vmss000001"},
+				Status:     v1.NodeStatus{Conditions: tc.conditions},
+			}
+			clientset := fake.NewClientset(node)
+			ic := &fakeIMDS{resp: imds.ScheduledEventsResponse{IncarnationID: 1, Events: tc.events}}
+
+			decision, err := EvaluateNode(ctx, clientset, ic, node, cfg)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedDecision, decision)
+			assert.Equal(t, tc.expectedQueries, ic.queries)
+
+			// evaluating must not act on the node
+			updated, _ := clientset.CoreV1().Nodes().Get(ctx, node.Name, metav1.GetOptions{})
+			assert.False(t, updated.Spec.Unschedulable)
+			assert.False(t, state.Cordoned())
+			assert.False(t, state.Drained())
+		})
+	}
+}
+
+func TestEvaluateNodeTriggers(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+
+	preempt := imds.ScheduledEvent{
+		EventId:      "preempt",
+		Type:         imds.Preempt,
+		ResourceType: "VirtualMachine",
+		Resources:    []string{"test-vmss_1"},
+		EventStatus:  imds.Scheduled,
+		NotBefore:    time.Now().Add(1 * time.Hour),
+		EventSource:  imds.Platform,
+	}
+	freeze := preempt
+	freeze.EventId = "freeze"
+	freeze.Type = imds.Freeze
+
+	tests := []struct {
+		name             string
+		cfg              config.Config
+		conditions       []v1.NodeCondition
+		taints           []v1.Taint
+		events           []imds.ScheduledEvent
+		expectedDecision DrainDecision
+		expectedQueries  int
+	}{
+		{
+			name:       "GPU condition drains without IMDS",
+			cfg:        config.Config{GPUHealth: config.GPUHealthConfig{Conditions: []string{"GpuUnhealthy"}}},
+			conditions: []v1.NodeCondition{{Type: "GpuUnhealthy", Status: v1.ConditionTrue}},
+			expectedDecision: DrainDecision{
+				HasEventScheduled: true,
+				ShouldDrain:       true,
+				Trigger:           ConditionTrigger("GpuUnhealthy"),
+			},
+		},
+		{
+			name:   "maintenance taint drains without IMDS",
+			cfg:    config.Config{MaintenanceTaints: []config.MaintenanceTaint{{Key: "example.com/maintenance"}}},
+			taints: []v1.Taint{{Key: "example.com/maintenance", Effect: v1.TaintEffectNoSchedule}},
+			expectedDecision: DrainDecision{
+				HasEventScheduled: true,
+				ShouldDrain:       true,
+				Trigger:           TaintTrigger("example.com/maintenance"),
+			},
+		},
+		{
+			name:   "polling IMDS finds an event no condition reports",
+			cfg:    config.Config{AlsoPollIMDS: true, DrainConditions: config.DrainConditions{DrainOnPreempt: true}},
+			events: []imds.ScheduledEvent{preempt},
+			expectedDecision: DrainDecision{
+				HasEventScheduled: true,
+				ShouldDrain:       true,
+				Trigger:           EventTrigger(&preempt),
+				Event:             &preempt,
+			},
+			expectedQueries: 1,
+		},
+		{
+			name:            "polling IMDS without events",
+			cfg:             config.Config{AlsoPollIMDS: true, DrainConditions: config.DrainConditions{DrainOnPreempt: true}},
+			expectedQueries: 1,
+		},
+		{
+			name:   "skipped freezes aren't reported",
+			cfg:    config.Config{AlsoPollIMDS: true, DrainConditions: config.DrainConditions{DrainOnPreempt: true}},
+			events: []imds.ScheduledEvent{freeze},
+			expectedDecision: DrainDecision{
+				HasEventScheduled: true,
+			},
+			expectedQueries: 1,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// evaluating doesn't need a state or recorder, and leaves nothing behind in either
+			recorder := &MockRecorder{}
+			vals := config.ContextValues{Logger: logger.Sugar(), Recorder: recorder}
+			ctx := context.WithValue(context.Background(), "values", &vals)
+
+			node := &v1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-vmss000001"},
+				Spec:       v1.NodeSpec{Taints: tc.taints},
+				Status:     v1.NodeStatus{Conditions: tc.conditions},
+			}
+			clientset := fake.NewClientset(node)
+			ic := &fakeIMDS{resp: imds.ScheduledEventsResponse{IncarnationID: 1, Events: tc.events}}
+
+			decision, err := EvaluateNode(ctx, clientset, ic, node, tc.cfg)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedDecision, decision)
+			assert.Equal(t, tc.expectedQueries, ic.queries)
+			assert.Empty(t, recorder.Events)
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/amargherio/mechanic/internal/appstate"
+	"github.com/amargherio/mechanic/internal/config"
+	"github.com/amargherio/mechanic/pkg/imds"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	v1 "k8s.io/api/core/v1"
+)
+
+// outcomes recorded in the decision log
+const (
+	DecisionNoEvent        = "no-event"
+	DecisionAlreadyDrained = "already-drained"
+	DecisionNoDrain        = "no-drain"
+	DecisionDrain          = "drain"
+	DecisionDeferred       = "deferred"
+	DecisionSafeMode       = "safe-mode"
+	DecisionUpgrade        = "upgrade-in-progress"
+	DecisionError          = "error"
+)
+
+// decisionLog gathers the inputs and outcome of a reconcile so they can be written out as one log line. Reading that
+// line should be enough to explain why mechanic did or didn't act on the node.
+type decisionLog struct {
+	startedAt time.Time
+	node      *v1.Node
+	cfg       config.Config
+	state     *appstate.State
+
+	trigger Trigger
+	outcome string
+	err     error
+}
+
+func newDecisionLog(node *v1.Node, cfg config.Config, state *appstate.State) *decisionLog {
+	return &decisionLog{startedAt: time.Now(), node: node, cfg: cfg, state: state}
+}
+
+// finish records the outcome of the reconcile and the error that ended it, if any
+func (d *decisionLog) finish(outcome string, err error) {
+	d.outcome = outcome
+	d.err = err
+}
+
+// write logs the decision if it's enabled in the config
+func (d *decisionLog) write(ctx context.Context, log *zap.SugaredLogger) {
+	if !d.cfg.LogDecisions {
+		return
+	}
+
+	fields := []interface{}{
+		"node", d.node.Name,
+		"outcome", d.outcome,
+		"conditions", nodeConditionSummaries(d.node),
+		"enabledConditions", d.cfg.DrainConditions.DrainableConditions(),
+		"imdsEvents", d.imdsEvents(),
+		"hasEventScheduled", d.state.EventScheduled(),
+		"shouldDrain", d.state.DrainRequired(),
+		"cordoned", d.state.Cordoned(),
+		"drained", d.state.Drained(),
+	}
+	if d.trigger.Reason != "" {
+		fields = append(fields, "triggerCategory", d.trigger.Category, "triggerReason", d.trigger.Reason)
+		if d.trigger.EventID != "" {
+			fields = append(fields, "eventId", d.trigger.EventID)
+		}
+		if d.trigger.Source != "" {
+			fields = append(fields, "triggerSource", d.trigger.Source)
+		}
+		if !d.trigger.DetectedAt.IsZero() {
+			fields = append(fields, "detectedAt", d.trigger.DetectedAt)
+		}
+		if len(d.trigger.Also) > 0 {
+			fields = append(fields, "alsoTriggeredBy", d.trigger.Also)
+		}
+	}
+	if d.err != nil {
+		fields = append(fields, "error", d.err)
+	}
+	fields = append(fields, "traceCtx", ctx)
+	log.Infow("Reconcile decision", fields...)
+}
+
+// annotate adds the decision to the reconcile span so traces show what the reconcile did without the logs. The error
+// that ended the reconcile, if any, is recorded on the span.
+func (d *decisionLog) annotate(span trace.Span) {
+	attrs := []attribute.KeyValue{
+		attribute.String("node.name", d.node.Name),
+		attribute.String("drain.decision", d.outcome),
+		attribute.Bool("node.cordoned", d.state.Cordoned()),
+		attribute.Bool("node.drained", d.state.Drained()),
+	}
+	if d.trigger.Reason != "" {
+		attrs = append(attrs, attribute.String("trigger.category", d.trigger.Category))
+		if d.trigger.Category == TriggerCategoryEvent {
+			attrs = append(attrs, attribute.String("event.type", d.trigger.Reason))
+		} else {
+			attrs = append(attrs, attribute.String("condition.type", d.trigger.Reason))
+		}
+		if d.trigger.EventID != "" {
+			attrs = append(attrs, attribute.String("event.id", d.trigger.EventID))
+		}
+	}
+	span.SetAttributes(attrs...)
+
+	if d.err != nil {
+		span.RecordError(d.err)
+		span.SetStatus(codes.Error, d.err.Error())
+	}
+}
+
+// imdsEvents summarizes the scheduled events considered by this reconcile. It's nil when IMDS wasn't queried.
+func (d *decisionLog) imdsEvents() []string {
+	snapshot, ok := d.state.LastIMDSResponse()
+	if !ok || snapshot.FetchedAt.Before(d.startedAt) {
+		return nil
+	}
+	resp, ok := snapshot.Response.(imds.ScheduledEventsResponse)
+	if !ok {
+		return nil
+	}
+	return imds.EventSummaries(resp.Events)
+}
+
+// nodeConditionSummaries describes each of the node's conditions by its type and status
+func nodeConditionSummaries(node *v1.Node) []string {
+	summaries := make([]string, 0, len(node.Status.Conditions))
+	for _, condition := range node.Status.Conditions {
+		summaries = append(summaries, fmt.Sprintf("%s=%s", condition.Type, condition.Status))
+	}
+	return summaries
+}
@@ -0,0 +1,236 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/amargherio/mechanic/internal/appstate"
+	"github.com/amargherio/mechanic/internal/config"
+	"github.com/amargherio/mechanic/pkg/imds"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestReconcileNodeDecisionLog(t *testing.T) {
+	preempt := imds.ScheduledEvent{
+		EventId:      "preempt",
+		Type:         imds.Preempt,
+		ResourceType: "VirtualMachine",
+		Resources:    []string{"test-vmss_1"},
+		EventStatus:  imds.Scheduled,
+		NotBefore:    time.Now().Add(1 * time.Hour),
+		EventSource:  imds.Platform,
+	}
+	reboot := preempt
+	reboot.EventId = "reboot"
+	reboot.Type = imds.Reboot
+
+	tests := []struct {
+		name            string
+		logDecisions    bool
+		conditions      []v1.NodeCondition
+		events          []imds.ScheduledEvent
+		imdsErr         error
+		expectLogged    bool
+		expectedOutcome string
+		expectedEvents  []string
+		expectedTrigger string
+		expectError     bool
+	}{
+		{
+			name:            "drain decision includes the event and trigger",
+			logDecisions:    true,
+			conditions:      []v1.NodeCondition{{Type: "PreemptScheduled", Status: v1.ConditionTrue}},
+			events:          []imds.ScheduledEvent{preempt},
+			expectLogged:    true,
+			expectedOutcome: DecisionDrain,
+			expectedEvents:  []string{"preempt Preempt VirtualMachine [test-vmss_1]"},
+			expectedTrigger: "Preempt",
+		},
+		{
+			name:            "event that isn't drainable is logged as no drain",
+			logDecisions:    true,
+			conditions:      []v1.NodeCondition{{Type: "VMEventScheduled", Status: v1.ConditionTrue}},
+			events:          []imds.ScheduledEvent{reboot},
+			expectLogged:    true,
+			expectedOutcome: DecisionNoDrain,
+			expectedEvents:  []string{"reboot Reboot VirtualMachine [test-vmss_1]"},
+		},
+		{
+			name:            "no condition means IMDS isn't queried",
+			logDecisions:    true,
+			conditions:      []v1.NodeCondition{{Type: "Ready", Status: v1.ConditionTrue}},
+			expectLogged:    true,
+			expectedOutcome: DecisionNoEvent,
+		},
+		{
+			name:            "IMDS failure is logged with the error",
+			logDecisions:    true,
+			conditions:      []v1.NodeCondition{{Type: "PreemptScheduled", Status: v1.ConditionTrue}},
+			imdsErr:         errors.New("connection refused"),
+			expectLogged:    true,
+			expectedOutcome: DecisionError,
+			expectError:     true,
+		},
+		{
+			name:         "disabled",
+			logDecisions: false,
+			conditions:   []v1.NodeCondition{{Type: "PreemptScheduled", Status: v1.ConditionTrue}},
+			events:       []imds.ScheduledEvent{preempt},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			core, logs := observer.New(zap.InfoLevel)
+			vals := config.ContextValues{Logger: zap.New(core).Sugar()}
+			ctx := context.WithValue(context.Background(), "values", &vals)
+
+			cfg := config.Config{
+				DrainConditions: config.DrainConditions{DrainOnPreempt: true},
+				LogDecisions:    tc.logDecisions,
+			}
+			node := &v1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-vmss000001", UID: "uid-1", Labels: map[string]string{}},
+				Status:     v1.NodeStatus{Conditions: tc.conditions},
+			}
+			clientset := fake.NewClientset(node)
+			ic := &fakeIMDS{resp: imds.ScheduledEventsResponse{IncarnationID: 1, Events: tc.events}, err: tc.imdsErr}
+			state := &appstate.State{NodeUID: node.UID}
+
+			err := ReconcileNode(ctx, clientset, ic, cfg, state, &MockRecorder{}, node)
+			if tc.expectError {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+
+			decisions := logs.FilterMessage("Reconcile decision").All()
+			if !tc.expectLogged {
+				assert.Empty(t, decisions)
+				return
+			}
+			require.Len(t, decisions, 1, "exactly one decision is logged per reconcile")
+
+			fields := decisions[0].ContextMap()
+			assert.Equal(t, node.Name, fields["node"])
+			assert.Equal(t, tc.expectedOutcome, fields["outcome"])
+			assert.ElementsMatch(t, nodeConditionSummaries(node), fields["conditions"])
+			assert.ElementsMatch(t, cfg.DrainConditions.DrainableConditions(), fields["enabledConditions"])
+			if tc.expectedEvents == nil {
+				assert.Empty(t, fields["imdsEvents"])
+			} else {
+				assert.ElementsMatch(t, tc.expectedEvents, fields["imdsEvents"])
+			}
+			if tc.expectedTrigger != "" {
+				assert.Equal(t, tc.expectedTrigger, fields["triggerReason"])
+				assert.Equal(t, TriggerCategoryEvent, fields["triggerCategory"])
+			} else {
+				assert.NotContains(t, fields, "triggerReason")
+			}
+			if tc.expectError {
+				assert.Equal(t, tc.imdsErr.Error(), fields["error"])
+			}
+		})
+	}
+}
+
+func TestReconcileNodeSpanAttributes(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	preempt := imds.ScheduledEvent{
+		EventId:      "preempt",
+		Type:         imds.Preempt,
+		ResourceType: "VirtualMachine",
+		Resources:    []string{"test-vmss_1"},
+		EventStatus:  imds.Scheduled,
+		NotBefore:    time.Now().Add(1 * time.Hour),
+		EventSource:  imds.Platform,
+	}
+
+	tests := []struct {
+		name          string
+		imdsErr       error
+		expectedAttrs map[attribute.Key]attribute.Value
+		expectError   bool
+	}{
+		{
+			name: "drain",
+			expectedAttrs: map[attribute.Key]attribute.Value{
+				"node.name":        attribute.StringValue("test-vmss000001"),
+				"drain.decision":   attribute.StringValue(DecisionDrain),
+				"trigger.category": attribute.StringValue(TriggerCategoryEvent),
+				"event.type":       attribute.StringValue("Preempt"),
+				"event.id":         attribute.StringValue("preempt"),
+				"node.cordoned":    attribute.BoolValue(true),
+				"node.drained":     attribute.BoolValue(true),
+			},
+		},
+		{
+			name:    "imds error",
+			imdsErr: errors.New("imds unavailable"),
+			expectedAttrs: map[attribute.Key]attribute.Value{
+				"node.name":      attribute.StringValue("test-vmss000001"),
+				"drain.decision": attribute.StringValue(DecisionError),
+			},
+			expectError: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			vals := config.ContextValues{Logger: zap.NewNop().Sugar()}
+			ctx := context.WithValue(context.Background(), "values", &vals)
+
+			cfg := config.Config{DrainConditions: config.DrainConditions{DrainOnPreempt: true}}
+			node := &v1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-vmss000001", UID: "uid-1", Labels: map[string]string{}},
+				Status:     v1.NodeStatus{Conditions: []v1.NodeCondition{{Type: "PreemptScheduled", Status: v1.ConditionTrue}}},
+			}
+			ic := &fakeIMDS{resp: imds.ScheduledEventsResponse{IncarnationID: 1, Events: []imds.ScheduledEvent{preempt}}, err: tc.imdsErr}
+			state := &appstate.State{NodeUID: node.UID}
+
+			err := ReconcileNode(ctx, fake.NewClientset(node), ic, cfg, state, &MockRecorder{}, node)
+			assert.Equal(t, tc.expectError, err != nil, "unexpected reconcile error: %v", err)
+
+			var span sdktrace.ReadOnlySpan
+			for _, s := range recorder.Ended() {
+				if s.Name() == "ReconcileNode" {
+					span = s
+				}
+			}
+			require.NotNil(t, span, "the reconcile span wasn't recorded")
+
+			attrs := make(map[attribute.Key]attribute.Value)
+			for _, kv := range span.Attributes() {
+				attrs[kv.Key] = kv.Value
+			}
+			for key, expected := range tc.expectedAttrs {
+				assert.Equal(t, expected, attrs[key], "attribute %s", key)
+			}
+
+			if tc.expectError {
+				assert.Equal(t, codes.Error, span.Status().Code)
+				require.NotEmpty(t, span.Events())
+				assert.Equal(t, "exception", span.Events()[0].Name)
+			} else {
+				assert.NotEqual(t, codes.Error, span.Status().Code)
+			}
+		})
+	}
+}
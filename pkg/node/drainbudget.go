@@ -0,0 +1,42 @@
+package node
+
+import (
+	"context"
+	"time"
+
+	"github.com/amargherio/mechanic/internal/appstate"
+	"github.com/amargherio/mechanic/internal/config"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// drainBudgetWindow is the window MaxDrainsPerHour counts drains over
+const drainBudgetWindow = time.Hour
+
+// withinDrainBudget reports whether another drain of the node can start without going over the drain budget. When it
+// can't, the skipped drain is logged and reported with a warning event. Drains are always allowed when no budget is
+// configured.
+func withinDrainBudget(ctx context.Context, node *v1.Node, drainCfg config.DrainConfig, state *appstate.State, recorder record.EventRecorder, trigger Trigger) bool {
+	if drainCfg.MaxDrainsPerHour <= 0 {
+		return true
+	}
+
+	now := time.Now()
+	started := state.DrainStartsSince(now.Add(-drainBudgetWindow))
+	if started < drainCfg.MaxDrainsPerHour {
+		return true
+	}
+
+	// the oldest drain in the window is the next to age out and free up the budget
+	oldest, _ := state.OldestDrainStart()
+	retryAt := oldest.Add(drainBudgetWindow)
+	vals := ctx.Value("values").(*config.ContextValues)
+	vals.Logger.Warnw("Drain budget for the node is used up, skipping drain",
+		"node", node.Name,
+		"drainsInWindow", started,
+		"maxDrainsPerHour", drainCfg.MaxDrainsPerHour,
+		"retryAt", retryAt.UTC(),
+		"traceCtx", ctx)
+	TriggerEventf(recorder, node, trigger, v1.EventTypeWarning, "DrainBudgetExceeded", "Drain of node %s skipped, %d drains already started in the last hour and at most %d are allowed, the node was left cordoned", node.Name, started, drainCfg.MaxDrainsPerHour)
+	return false
+}
@@ -0,0 +1,80 @@
+package node
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/amargherio/mechanic/internal/appstate"
+	"github.com/amargherio/mechanic/internal/config"
+	"github.com/amargherio/mechanic/pkg/imds"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestReconcileNodeDrainBudget(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	vals := config.ContextValues{Logger: logger.Sugar()}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	cfg := config.Config{
+		DrainConditions: config.DrainConditions{DrainOnPreempt: true},
+		Drain:           config.DrainConfig{Timeout: 5 * time.Second, Force: true, MaxDrainsPerHour: 2},
+	}
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-vmss000001", UID: "uid-1", Labels: map[string]string{}},
+		Status:     v1.NodeStatus{Conditions: []v1.NodeCondition{{Type: "PreemptScheduled", Status: v1.ConditionTrue}}},
+	}
+	clientset := fake.NewClientset(node)
+	ic := &fakeIMDS{resp: imds.ScheduledEventsResponse{IncarnationID: 1, Events: []imds.ScheduledEvent{{
+		EventId:      "preempt",
+		Type:         imds.Preempt,
+		ResourceType: "VirtualMachine",
+		Resources:    []string{"test-vmss_1"},
+		EventStatus:  imds.Scheduled,
+		NotBefore:    time.Now().Add(1 * time.Hour),
+		EventSource:  imds.Platform,
+	}}}}
+	state := &appstate.State{NodeUID: node.UID}
+	const exceeded = "Warning DrainBudgetExceeded Drain of node test-vmss000001 skipped, 2 drains already started in the last hour and at most 2 are allowed, the node was left cordoned (event: Preempt)"
+
+	// a runaway loop that forgets the node was drained keeps draining it, until the budget runs out
+	for i := 0; i < 2; i++ {
+		recorder := &MockRecorder{}
+		require.NoError(t, ReconcileNode(ctx, clientset, ic, cfg, state, recorder, node))
+		assert.True(t, state.Drained(), "drain %d should be within the budget", i+1)
+		assert.NotContains(t, recorder.Events, exceeded)
+		state.SetDrained(false)
+	}
+
+	recorder := &MockRecorder{}
+	require.NoError(t, ReconcileNode(ctx, clientset, ic, cfg, state, recorder, node))
+	assert.False(t, state.Drained(), "the third drain in the hour is over the budget")
+	assert.Contains(t, recorder.Events, exceeded)
+	updated, err := clientset.CoreV1().Nodes().Get(ctx, node.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.True(t, updated.Spec.Unschedulable, "the node stays cordoned while the drain is skipped")
+
+	// once the earlier drains age out of the window, the node can be drained again
+	for i := range state.DrainStarts {
+		state.DrainStarts[i] = state.DrainStarts[i].Add(-2 * time.Hour)
+	}
+	recorder = &MockRecorder{}
+	require.NoError(t, ReconcileNode(ctx, clientset, ic, cfg, state, recorder, node))
+	assert.True(t, state.Drained())
+	assert.NotContains(t, recorder.Events, exceeded)
+	assert.Len(t, state.DrainStarts, 1)
+}
+
+func TestWithinDrainBudgetDisabled(t *testing.T) {
+	state := &appstate.State{}
+	for i := 0; i < 100; i++ {
+		state.RecordDrainStart(time.Now())
+	}
+	assert.True(t, withinDrainBudget(context.Background(), &v1.Node{}, config.DrainConfig{}, state, &MockRecorder{}, Trigger{}))
+}
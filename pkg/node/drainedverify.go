@@ -0,0 +1,49 @@
+package node
+
+import (
+	"context"
+	"time"
+
+	"github.com/amargherio/mechanic/internal/appstate"
+	"github.com/amargherio/mechanic/internal/config"
+	"go.opentelemetry.io/otel"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+)
+
+// drainedNodeStillCordoned reports whether the state's view of the node as cordoned and drained can be trusted. Once
+// interval has passed since the last check, the node is read from the apiserver. If it's no longer cordoned, someone
+// else uncordoned it and pods may have landed on it since, so the cordon and drain state is reset for the reconcile to
+// cordon and drain it again. A failed read trusts the state and is retried on the next reconcile. An interval of zero
+// always trusts the state.
+func drainedNodeStillCordoned(ctx context.Context, clientset kubernetes.Interface, node *v1.Node, state *appstate.State, recorder record.EventRecorder, interval time.Duration) bool {
+	tracer := otel.Tracer("github.com/amargherio/mechanic/pkg/node")
+	ctx, span := tracer.Start(ctx, "drainedNodeStillCordoned")
+	defer span.End()
+
+	vals := ctx.Value("values").(*config.ContextValues)
+	log := vals.Logger
+
+	if interval <= 0 || time.Since(state.DrainedVerified()) < interval {
+		return true
+	}
+
+	current, err := clientset.CoreV1().Nodes().Get(ctx, node.Name, metav1.GetOptions{})
+	if err != nil {
+		log.Warnw("Failed to read the node to verify it's still cordoned, trusting the state", "node", node.Name, "error", err, "traceCtx", ctx)
+		return true
+	}
+	if current.Spec.Unschedulable {
+		state.SetDrainedVerified(time.Now())
+		return true
+	}
+
+	log.Warnw("Node was uncordoned outside of mechanic after it was drained, cordoning and draining it again", "node", node.Name, "state", state, "traceCtx", ctx)
+	Eventf(recorder, node, v1.EventTypeWarning, "ExternalUncordon", "Node %s was uncordoned outside of mechanic after it was drained, cordoning and draining it again", node.Name)
+	state.SetCordoned(false)
+	state.SetDrained(false)
+	state.SetDrainedVerified(time.Time{})
+	return false
+}
@@ -0,0 +1,105 @@
+package node
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/amargherio/mechanic/internal/appstate"
+	"github.com/amargherio/mechanic/internal/config"
+	"github.com/amargherio/mechanic/pkg/imds"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestReconcileNodeExternalUncordon(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	vals := config.ContextValues{Logger: logger.Sugar()}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	preempt := imds.ScheduledEvent{
+		EventId:      "preempt",
+		Type:         imds.Preempt,
+		ResourceType: "VirtualMachine",
+		Resources:    []string{"test-vmss_1"},
+		EventStatus:  imds.Scheduled,
+		NotBefore:    time.Now().Add(1 * time.Hour),
+		EventSource:  imds.Platform,
+	}
+
+	tests := []struct {
+		name           string
+		interval       time.Duration
+		verifiedAgo    time.Duration
+		expectCordoned bool
+		expectedEvents []string
+	}{
+		{
+			name:        "an uncordon is caught once the interval passes",
+			interval:    time.Minute,
+			verifiedAgo: 2 * time.Minute,
+			// cordoned and drained again for the event that's still scheduled
+			expectCordoned: true,
+			expectedEvents: []string{
+				"Warning ExternalUncordon Node test-vmss000001 was uncordoned outside of mechanic after it was drained, cordoning and draining it again",
+				"Normal CordonNode Node test-vmss000001 cordoned by mechanic (event: Preempt)",
+				"Normal DrainNode Node test-vmss000001 drained by mechanic (event: Preempt)",
+			},
+		},
+		{
+			name:        "a recent check trusts the state",
+			interval:    time.Minute,
+			verifiedAgo: 10 * time.Second,
+		},
+		{
+			name:        "a zero interval always trusts the state",
+			verifiedAgo: time.Hour,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := config.Config{
+				DrainConditions:       config.DrainConditions{DrainOnPreempt: true},
+				DrainedVerifyInterval: tc.interval,
+			}
+			// mechanic drained the node for the event, then someone uncordoned it
+			node := &v1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-vmss000001", UID: "uid-1", Labels: map[string]string{}},
+				Status:     v1.NodeStatus{Conditions: []v1.NodeCondition{{Type: "PreemptScheduled", Status: v1.ConditionTrue}}},
+			}
+			clientset := fake.NewClientset(node)
+			ic := &fakeIMDS{resp: imds.ScheduledEventsResponse{IncarnationID: 1, Events: []imds.ScheduledEvent{preempt}}}
+			state := &appstate.State{NodeUID: node.UID}
+			state.SetCordoned(true)
+			state.SetDrained(true)
+			state.SetDrainedVerified(time.Now().Add(-tc.verifiedAgo))
+			recorder := &MockRecorder{}
+
+			require.NoError(t, ReconcileNode(ctx, clientset, ic, cfg, state, recorder, node))
+			updated, err := clientset.CoreV1().Nodes().Get(ctx, node.Name, metav1.GetOptions{})
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectCordoned, updated.Spec.Unschedulable)
+			assert.True(t, state.Cordoned())
+			assert.True(t, state.Drained())
+			assert.Equal(t, tc.expectedEvents, recorder.Events)
+			if !tc.expectCordoned {
+				return
+			}
+
+			// the node is cordoned again, so the next check passes and the fast path is taken
+			state.SetDrainedVerified(time.Time{})
+			recorder.Events = nil
+			queries := ic.queries
+			require.NoError(t, ReconcileNode(ctx, clientset, ic, cfg, state, recorder, updated))
+			assert.Empty(t, recorder.Events)
+			assert.Equal(t, queries, ic.queries)
+			assert.WithinDuration(t, time.Now(), state.DrainedVerified(), time.Minute)
+		})
+	}
+}
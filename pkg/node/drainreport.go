@@ -0,0 +1,176 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/amargherio/mechanic/internal/config"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/kubectl/pkg/drain"
+)
+
+// maxReportedOwners caps how many owners are listed in the drain summary event. The log lists all of them.
+const maxReportedOwners = 10
+
+// workloadOwner is the controller that owns a pod removed by a drain. Pods owned by a ReplicaSet are attributed to the
+// Deployment that owns the ReplicaSet. Pods without a controller have an empty Kind.
+type workloadOwner struct {
+	Kind      string
+	Namespace string
+	Name      string
+}
+
+func (o workloadOwner) String() string {
+	if o.Kind == "" {
+		return "bare pods"
+	}
+	return fmt.Sprintf("%s %s/%s", o.Kind, o.Namespace, o.Name)
+}
+
+// ownerGroup is the pods removed by a drain that share an owner
+type ownerGroup struct {
+	Owner workloadOwner
+	// Pods are the namespace/name of each pod removed
+	Pods []string
+}
+
+func (g ownerGroup) String() string {
+	return fmt.Sprintf("%s (%d)", g.Owner, len(g.Pods))
+}
+
+// drainReport collects the pods a drain removes, grouped by the controller that owns them, so the disruption can be
+// reported by workload instead of by pod
+type drainReport struct {
+	clientset kubernetes.Interface
+
+	lock sync.Mutex
+	pods map[workloadOwner][]string
+	// deployments caches the Deployment owning each ReplicaSet, keyed by namespace/name, so pods from the same
+	// ReplicaSet don't each look it up
+	deployments map[string]string
+}
+
+func newDrainReport(clientset kubernetes.Interface) *drainReport {
+	return &drainReport{
+		clientset:   clientset,
+		pods:        make(map[workloadOwner][]string),
+		deployments: make(map[string]string),
+	}
+}
+
+// track adds every pod the helper removes to the report, keeping any hook already set on the helper. The helper removes
+// pods concurrently, so the hook can be called from several goroutines at once.
+func (r *drainReport) track(ctx context.Context, helper *drain.Helper) {
+	next := helper.OnPodDeletionOrEvictionFinished
+	helper.OnPodDeletionOrEvictionFinished = func(pod *v1.Pod, usingEviction bool, err error) {
+		if err == nil {
+			r.add(r.ownerOf(ctx, pod), pod)
+		}
+		if next != nil {
+			next(pod, usingEviction, err)
+		}
+	}
+}
+
+// add records the pod as removed under owner
+func (r *drainReport) add(owner workloadOwner, pod *v1.Pod) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.pods[owner] = append(r.pods[owner], pod.Namespace+"/"+pod.Name)
+}
+
+// ownerOf returns the controller that owns the pod. A ReplicaSet that can't be looked up, or that isn't owned by a
+// Deployment, is reported as the owner itself.
+func (r *drainReport) ownerOf(ctx context.Context, pod *v1.Pod) workloadOwner {
+	ref := metav1.GetControllerOf(pod)
+	if ref == nil {
+		return workloadOwner{}
+	}
+	owner := workloadOwner{Kind: ref.Kind, Namespace: pod.Namespace, Name: ref.Name}
+	if ref.Kind != "ReplicaSet" {
+		return owner
+	}
+
+	key := pod.Namespace + "/" + ref.Name
+	r.lock.Lock()
+	deployment, cached := r.deployments[key]
+	r.lock.Unlock()
+	if !cached {
+		rs, err := r.clientset.AppsV1().ReplicaSets(pod.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+		if err == nil {
+			if deployRef := metav1.GetControllerOf(rs); deployRef != nil && deployRef.Kind == "Deployment" {
+				deployment = deployRef.Name
+			}
+		}
+		// a failed lookup is cached too, the pods are reported under the ReplicaSet either way
+		r.lock.Lock()
+		r.deployments[key] = deployment
+		r.lock.Unlock()
+	}
+	if deployment != "" {
+		return workloadOwner{Kind: "Deployment", Namespace: pod.Namespace, Name: deployment}
+	}
+	return owner
+}
+
+// ownerGroups returns the owners of the removed pods, with the owners that lost the most pods first
+func (r *drainReport) ownerGroups() []ownerGroup {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	groups := make([]ownerGroup, 0, len(r.pods))
+	for owner, pods := range r.pods {
+		sorted := append([]string(nil), pods...)
+		sort.Strings(sorted)
+		groups = append(groups, ownerGroup{Owner: owner, Pods: sorted})
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if len(groups[i].Pods) != len(groups[j].Pods) {
+			return len(groups[i].Pods) > len(groups[j].Pods)
+		}
+		return groups[i].Owner.String() < groups[j].Owner.String()
+	})
+	return groups
+}
+
+// emit logs the removed pods by owner and records a summary event on the node. Nothing is reported when the drain
+// didn't remove any pods.
+func (r *drainReport) emit(ctx context.Context, node *v1.Node, trigger Trigger) {
+	vals := ctx.Value("values").(*config.ContextValues)
+	log := vals.Logger
+
+	groups := r.ownerGroups()
+	if len(groups) == 0 {
+		return
+	}
+
+	total := 0
+	byOwner := make(map[string][]string, len(groups))
+	for _, group := range groups {
+		total += len(group.Pods)
+		byOwner[group.Owner.String()] = group.Pods
+	}
+	log.Infow("Pods removed by the drain by owner", "node", node.Name, "pods", total, "owners", byOwner, "traceCtx", ctx)
+	if vals.Recorder == nil {
+		return
+	}
+
+	listed := groups
+	if len(listed) > maxReportedOwners {
+		listed = listed[:maxReportedOwners]
+	}
+	owners := make([]string, 0, len(listed))
+	for _, group := range listed {
+		owners = append(owners, group.String())
+	}
+	summary := strings.Join(owners, ", ")
+	if more := len(groups) - len(listed); more > 0 {
+		summary += fmt.Sprintf(" and %d more", more)
+	}
+	TriggerEventf(vals.Recorder, node, trigger, v1.EventTypeNormal, "DrainSummary", "Drain of node %s removed %d pods: %s", node.Name, total, summary)
+}
@@ -0,0 +1,100 @@
+package node
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/amargherio/mechanic/internal/appstate"
+	"github.com/amargherio/mechanic/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/ptr"
+)
+
+func controllerRef(kind, name string) []metav1.OwnerReference {
+	return []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: kind, Name: name, Controller: ptr.To(true)}}
+}
+
+func TestDrainNodeReportsOwners(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-node"}}
+	pod := func(name string, owners []metav1.OwnerReference) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", OwnerReferences: owners},
+			Spec:       v1.PodSpec{NodeName: node.Name},
+		}
+	}
+	objects := []runtime.Object{
+		node,
+		&appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Name: "web-5d8f", Namespace: "default", OwnerReferences: controllerRef("Deployment", "web")}},
+		&appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Name: "orphan", Namespace: "default"}},
+		&appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Name: "agent", Namespace: "default"}},
+		pod("web-5d8f-a", controllerRef("ReplicaSet", "web-5d8f")),
+		pod("web-5d8f-b", controllerRef("ReplicaSet", "web-5d8f")),
+		pod("db-0", controllerRef("StatefulSet", "db")),
+		pod("orphan-a", controllerRef("ReplicaSet", "orphan")),
+		pod("agent-x", controllerRef("DaemonSet", "agent")),
+		pod("debug", nil),
+	}
+
+	tests := []struct {
+		name         string
+		enabled      bool
+		expectEvents []string
+	}{
+		{
+			name:    "pods are grouped by owner when enabled",
+			enabled: true,
+			expectEvents: []string{
+				"Normal DrainSummary Drain of node test-node removed 5 pods: Deployment default/web (2), ReplicaSet default/orphan (1), StatefulSet default/db (1), bare pods (1) (event: Reboot)",
+			},
+		},
+		{name: "no summary when disabled"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := &MockRecorder{}
+			vals := config.ContextValues{Logger: logger.Sugar(), State: &appstate.State{IsCordoned: true}, Recorder: recorder}
+			ctx := context.WithValue(context.Background(), "values", &vals)
+
+			var evicted []time.Time
+			clientset := newEvictionTestClientset(&evicted, objects...)
+			drainCfg := config.DrainConfig{ReportOwners: tc.enabled, Force: true, IgnoreAllDaemonSets: true}
+
+			drained, err := DrainNode(ctx, clientset, node, drainCfg, Trigger{Category: TriggerCategoryEvent, Reason: "Reboot"})
+			require.NoError(t, err)
+			assert.True(t, drained)
+			assert.Equal(t, tc.expectEvents, recorder.Events)
+		})
+	}
+}
+
+func TestDrainReportOwnerGroups(t *testing.T) {
+	report := newDrainReport(nil)
+	for i, owner := range []workloadOwner{
+		{Kind: "StatefulSet", Namespace: "db", Name: "pg"},
+		{},
+		{Kind: "Deployment", Namespace: "web", Name: "frontend"},
+		{Kind: "Deployment", Namespace: "web", Name: "frontend"},
+		{},
+		{Kind: "Deployment", Namespace: "web", Name: "frontend"},
+	} {
+		report.add(owner, &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: string(rune('a' + i)), Namespace: owner.Namespace}})
+	}
+
+	groups := report.ownerGroups()
+	require.Len(t, groups, 3)
+	assert.Equal(t, "Deployment web/frontend (3)", groups[0].String())
+	assert.Equal(t, []string{"web/c", "web/d", "web/f"}, groups[0].Pods)
+	assert.Equal(t, "bare pods (2)", groups[1].String())
+	assert.Equal(t, "StatefulSet db/pg (1)", groups[2].String())
+}
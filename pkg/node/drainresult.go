@@ -0,0 +1,127 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/kubernetes"
+	policyv1client "k8s.io/client-go/kubernetes/typed/policy/v1"
+)
+
+// drain outcomes recorded in the mechanic_drain_results_total metric
+const (
+	DrainResultSuccess    = "success"
+	DrainResultPDBBlocked = "pdb_blocked"
+	DrainResultTimeout    = "timeout"
+	DrainResultIncomplete = "incomplete"
+	DrainResultAPIError   = "api_error"
+	DrainResultAborted    = "aborted"
+)
+
+// ErrDrainTimedOut is returned by DrainNode when the pods on the node weren't all evicted before the drain timeout
+var ErrDrainTimedOut = errors.New("drain timed out")
+
+// ErrDrainPDBBlocked is returned by DrainNode when PodDisruptionBudgets kept pods from being evicted before the drain
+// timeout, or kept the drain from starting because they can never allow a disruption
+var ErrDrainPDBBlocked = errors.New("drain blocked by PodDisruptionBudgets")
+
+// evictionErrors records what the API server answered the drain helper's evictions with. The helper retries evictions a
+// PodDisruptionBudget rejects until the drain times out, and formats the errors it returns as strings, so the API
+// errors are only seen with their status where the evictions are made.
+type evictionErrors struct {
+	pdbBlocked atomic.Bool
+
+	lock     sync.Mutex
+	failures []error
+}
+
+// record notes an eviction's result. The eviction API answers with 429 Too Many Requests when evicting the pod would
+// violate a PodDisruptionBudget, and the helper treats a pod that's already gone as evicted.
+func (e *evictionErrors) record(err error) {
+	switch {
+	case err == nil, apierrors.IsNotFound(err):
+	case apierrors.IsTooManyRequests(err):
+		e.pdbBlocked.Store(true)
+	default:
+		e.lock.Lock()
+		defer e.lock.Unlock()
+		e.failures = append(e.failures, err)
+	}
+}
+
+// cause returns the API errors that failed evictions, so a drain that failed on them can be classified by their status
+// rather than by the helper's messages. err is returned as it is when no eviction failed.
+func (e *evictionErrors) cause(err error) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if len(e.failures) == 0 {
+		return err
+	}
+	return utilerrors.NewAggregate(e.failures)
+}
+
+// evictionClient is the clientset the drain helper evicts pods with. Evictions go through the clientset as usual and
+// their results are recorded in errs.
+type evictionClient struct {
+	kubernetes.Interface
+	errs *evictionErrors
+}
+
+func (c *evictionClient) PolicyV1() policyv1client.PolicyV1Interface {
+	return &evictionPolicyClient{PolicyV1Interface: c.Interface.PolicyV1(), errs: c.errs}
+}
+
+type evictionPolicyClient struct {
+	policyv1client.PolicyV1Interface
+	errs *evictionErrors
+}
+
+func (c *evictionPolicyClient) Evictions(namespace string) policyv1client.EvictionInterface {
+	return &recordedEvictions{EvictionInterface: c.PolicyV1Interface.Evictions(namespace), errs: c.errs}
+}
+
+type recordedEvictions struct {
+	policyv1client.EvictionInterface
+	errs *evictionErrors
+}
+
+func (e *recordedEvictions) Evict(ctx context.Context, eviction *policyv1.Eviction) error {
+	err := e.EvictionInterface.Evict(ctx, eviction)
+	e.errs.record(err)
+	return err
+}
+
+// classifyDrainResult maps the outcome of a drain to one of the drain result labels. The drain helper formats its
+// errors as strings, so timeouts are recognized by their messages. PDB rejections only surface as a timeout, so
+// pdbBlocked reports whether the API server rejected an eviction for a PodDisruptionBudget.
+func classifyDrainResult(ctx context.Context, err error, pdbBlocked bool) string {
+	if err == nil {
+		return DrainResultSuccess
+	}
+
+	// the caller gave up on the drain, e.g. on shutdown. the helper reports this as a timeout so check it first.
+	if ctx.Err() != nil {
+		return DrainResultAborted
+	}
+
+	if errors.Is(err, ErrDrainIncomplete) {
+		return DrainResultIncomplete
+	}
+
+	msg := err.Error()
+	if strings.Contains(msg, "global timeout reached") || strings.Contains(msg, context.DeadlineExceeded.Error()) || errors.Is(err, context.DeadlineExceeded) {
+		// evictions that kept getting rejected by a PDB only surface as a timeout
+		if pdbBlocked {
+			return DrainResultPDBBlocked
+		}
+		return DrainResultTimeout
+	}
+
+	return DrainResultAPIError
+}
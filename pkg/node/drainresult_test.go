@@ -0,0 +1,182 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/amargherio/mechanic/internal/appstate"
+	"github.com/amargherio/mechanic/internal/config"
+	"github.com/amargherio/mechanic/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+	v1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/kubectl/pkg/drain"
+)
+
+func TestClassifyDrainResult(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name       string
+		ctx        context.Context
+		err        error
+		pdbBlocked bool
+		expected   string
+	}{
+		{name: "no error", ctx: context.Background(), expected: DrainResultSuccess},
+		{name: "global timeout", ctx: context.Background(), err: errors.New(`error when evicting pods/"web" -n "default": global timeout reached: 1m0s`), expected: DrainResultTimeout},
+		{name: "wait for delete deadline", ctx: context.Background(), err: errors.New(`error when waiting for pod "web" in namespace "default" to terminate: context deadline exceeded`), expected: DrainResultTimeout},
+		{name: "timeout after pdb rejections", ctx: context.Background(), err: errors.New(`error when evicting pods/"web" -n "default": global timeout reached: 1m0s`), pdbBlocked: true, expected: DrainResultPDBBlocked},
+		{name: "api error", ctx: context.Background(), err: errors.New(`error when evicting pods/"web" -n "default": Internal error occurred: etcd unavailable`), expected: DrainResultAPIError},
+		{name: "cancelled context", ctx: cancelled, err: errors.New(`error when evicting pods/"web" -n "default": global timeout reached: 1m0s`), expected: DrainResultAborted},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, classifyDrainResult(tc.ctx, tc.err, tc.pdbBlocked))
+		})
+	}
+}
+
+// newDrainResultClientset returns a clientset that supports evictions, answering each eviction with evictErr. When
+// evictErr is nil the eviction is accepted but the pod is left in place so the drain waits on it.
+func newDrainResultClientset(evictErr error, objects ...runtime.Object) *fake.Clientset {
+	clientset := fake.NewClientset(objects...)
+	clientset.Resources = []*metav1.APIResourceList{
+		{
+			GroupVersion: "v1",
+			APIResources: []metav1.APIResource{
+				{Name: drain.EvictionSubresource, Kind: drain.EvictionKind, Group: "policy", Version: "v1"},
+			},
+		},
+	}
+	clientset.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "eviction" {
+			return false, nil, nil
+		}
+		return true, nil, evictErr
+	})
+
+	return clientset
+}
+
+func TestDrainNodeRecordsResult(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	log := logger.Sugar()
+
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-node"}}
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec:       v1.PodSpec{NodeName: node.Name},
+	}
+	pdbErr := apierrors.NewTooManyRequests("Cannot evict pod as it would violate the pod's disruption budget.", 0)
+
+	tests := []struct {
+		name      string
+		clientset func() *fake.Clientset
+		timeout   time.Duration
+		cancel    bool
+		expected  string
+	}{
+		{
+			name:      "success",
+			clientset: func() *fake.Clientset { return fake.NewClientset(node) },
+			expected:  DrainResultSuccess,
+		},
+		{
+			name:      "pod never terminates",
+			clientset: func() *fake.Clientset { return newDrainResultClientset(nil, node, pod) },
+			timeout:   time.Second,
+			expected:  DrainResultTimeout,
+		},
+		{
+			// the helper waits 5 seconds between retries of a rejected eviction, so this case is slower than the rest
+			name:      "eviction blocked by pdb",
+			clientset: func() *fake.Clientset { return newDrainResultClientset(pdbErr, node, pod) },
+			timeout:   time.Second,
+			expected:  DrainResultPDBBlocked,
+		},
+		{
+			name: "eviction api error",
+			clientset: func() *fake.Clientset {
+				return newDrainResultClientset(apierrors.NewInternalError(fmt.Errorf("etcd unavailable")), node, pod)
+			},
+			expected: DrainResultAPIError,
+		},
+		{
+			name:      "drain cancelled",
+			clientset: func() *fake.Clientset { return newDrainResultClientset(nil, node, pod) },
+			cancel:    true,
+			expected:  DrainResultAborted,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			vals := config.ContextValues{
+				Logger: log,
+				State:  &appstate.State{IsCordoned: true},
+			}
+			ctx, cancel := context.WithCancel(context.WithValue(context.Background(), "values", &vals))
+			defer cancel()
+			if tc.cancel {
+				cancel()
+			}
+
+			before := testutil.ToFloat64(metrics.DrainResults.WithLabelValues(tc.expected))
+			durationsBefore := drainDurationSamples(t, tc.expected)
+			_, err := DrainNode(ctx, tc.clientset(), node, config.DrainConfig{Timeout: tc.timeout, Force: true}, Trigger{Category: TriggerCategoryEvent, Reason: "Freeze"})
+			assert.Equal(t, tc.expected == DrainResultSuccess, err == nil, "unexpected drain error: %v", err)
+			assert.Equal(t, tc.expected == DrainResultTimeout, errors.Is(err, ErrDrainTimedOut), "unexpected drain error: %v", err)
+			assert.Equal(t, tc.expected == DrainResultPDBBlocked, errors.Is(err, ErrDrainPDBBlocked), "unexpected drain error: %v", err)
+			assert.Equal(t, float64(1), testutil.ToFloat64(metrics.DrainResults.WithLabelValues(tc.expected))-before)
+			assert.Equal(t, uint64(1), drainDurationSamples(t, tc.expected)-durationsBefore)
+		})
+	}
+}
+
+func drainDurationSamples(t *testing.T, result string) uint64 {
+	m := &dto.Metric{}
+	require.NoError(t, metrics.DrainDuration.WithLabelValues(result).(prometheus.Histogram).Write(m))
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestEvictionClientRecordsPDBRejections(t *testing.T) {
+	tests := []struct {
+		name      string
+		evictErr  error
+		expectPDB bool
+	}{
+		{name: "accepted eviction", expectPDB: false},
+		{name: "rejected by a budget", evictErr: apierrors.NewTooManyRequests("Cannot evict pod as it would violate the pod's disruption budget.", 10), expectPDB: true},
+		{name: "other api error mentioning a budget", evictErr: apierrors.NewInternalError(errors.New("disruption budget controller unavailable")), expectPDB: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			evictions := &evictionErrors{}
+			client := &evictionClient{Interface: newDrainResultClientset(tc.evictErr), errs: evictions}
+
+			err := client.PolicyV1().Evictions("default").Evict(context.Background(), &policyv1.Eviction{
+				ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+			})
+			assert.Equal(t, tc.evictErr == nil, err == nil)
+			assert.Equal(t, tc.expectPDB, evictions.pdbBlocked.Load())
+		})
+	}
+}
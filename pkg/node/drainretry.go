@@ -0,0 +1,70 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/amargherio/mechanic/internal/config"
+	"go.opentelemetry.io/otel"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/kubernetes"
+)
+
+// maxDrainRetryDelay caps the backoff between drain attempts
+const maxDrainRetryDelay = 30 * time.Second
+
+// DrainNodeWithRetry drains the node like DrainNode, retrying drain attempts that fail on transient API errors like
+// timeouts and conflicts with exponential backoff starting at drainCfg.BaseDelay. Up to drainCfg.MaxRetries retries are
+// made. The pre-drain steps run once, and the retries share the drain's timeout rather than each getting their own.
+// Drains that time out or fail for any other reason are returned right away since retrying them won't help.
+func DrainNodeWithRetry(ctx context.Context, clientset kubernetes.Interface, node *v1.Node, drainCfg config.DrainConfig, trigger Trigger) (bool, error) {
+	tracer := otel.Tracer("github.com/amargherio/mechanic/pkg/node")
+	ctx, span := tracer.Start(ctx, "DrainNodeWithRetry")
+	defer span.End()
+
+	return drainNode(ctx, clientset, node, drainCfg, trigger, drainCfg.MaxRetries)
+}
+
+// drainRetryDelay returns the backoff before the retry following attempt, which counts from zero. The delay starts at
+// base and doubles with each attempt up to maxDrainRetryDelay. It's doubled a step at a time so a high retry count
+// can't overflow it.
+func drainRetryDelay(base time.Duration, attempt int) time.Duration {
+	if base <= 0 {
+		return 0
+	}
+	delay := base
+	for i := 0; i < attempt && delay < maxDrainRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxDrainRetryDelay)
+}
+
+// isRetriableDrainError reports whether a failed drain is worth trying again. Timeouts and conflicts from the API
+// server are, as are requests that timed out before reaching it, while missing objects and anything else aren't.
+func isRetriableDrainError(err error) bool {
+	// errors listing pods come back from the helper as they were returned by the client, wrapped in an aggregate
+	var agg utilerrors.Aggregate
+	if errors.As(err, &agg) {
+		for _, e := range agg.Errors() {
+			if !isRetriableDrainError(e) {
+				return false
+			}
+		}
+		return len(agg.Errors()) > 0
+	}
+
+	if apierrors.IsNotFound(err) {
+		return false
+	}
+	if apierrors.IsConflict(err) || apierrors.IsServerTimeout(err) || apierrors.IsTimeout(err) {
+		return true
+	}
+
+	// requests that timed out before the API server answered, like on a dial or TLS handshake
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
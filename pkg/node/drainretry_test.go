@@ -0,0 +1,173 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/amargherio/mechanic/internal/appstate"
+	"github.com/amargherio/mechanic/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestIsRetriableDrainError(t *testing.T) {
+	pods := schema.GroupResource{Resource: "pods"}
+
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{name: "server timeout", err: apierrors.NewServerTimeout(pods, "list", 1), expected: true},
+		{name: "request timeout", err: apierrors.NewTimeoutError("request did not complete within 60s", 1), expected: true},
+		{name: "conflict", err: apierrors.NewConflict(pods, "web", errors.New("the object has been modified")), expected: true},
+		{name: "aggregated timeout", err: utilerrors.NewAggregate([]error{apierrors.NewServerTimeout(pods, "list", 1)}), expected: true},
+		{name: "network timeout", err: &url.Error{Op: "Get", URL: "https://10.0.0.1/api/v1/pods", Err: &net.DNSError{Err: "i/o timeout", IsTimeout: true}}, expected: true},
+		{name: "eviction conflict formatted by the helper", err: fmt.Errorf(`error when evicting pods/"web" -n "default": %v`, apierrors.NewConflict(pods, "web", errors.New("the object has been modified"))), expected: false},
+		{name: "pod not found", err: apierrors.NewNotFound(pods, "web"), expected: false},
+		{name: "aggregate with a pod not found", err: utilerrors.NewAggregate([]error{apierrors.NewServerTimeout(pods, "list", 1), apierrors.NewNotFound(pods, "web")}), expected: false},
+		{name: "drain timed out", err: fmt.Errorf("%w after 1m0s: %w", ErrDrainTimedOut, errors.New("global timeout reached: 1m0s")), expected: false},
+		{name: "unmanaged pod", err: errors.New("cannot delete Pods that declare no controller"), expected: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, isRetriableDrainError(tc.err))
+		})
+	}
+}
+
+func TestDrainRetryDelay(t *testing.T) {
+	assert.Equal(t, time.Second, drainRetryDelay(time.Second, 0))
+	assert.Equal(t, 4*time.Second, drainRetryDelay(time.Second, 2))
+	assert.Equal(t, maxDrainRetryDelay, drainRetryDelay(time.Second, 5))
+	assert.Equal(t, maxDrainRetryDelay, drainRetryDelay(time.Minute, 0), "a base over the cap is clamped")
+
+	// a shifted delay overflows around attempt 34, stepping the doubling keeps every delay within the cap
+	for attempt := 0; attempt < 1000; attempt++ {
+		delay := drainRetryDelay(time.Second, attempt)
+		require.Greater(t, delay, time.Duration(0), "attempt %d", attempt)
+		require.LessOrEqual(t, delay, maxDrainRetryDelay, "attempt %d", attempt)
+	}
+}
+
+func TestDrainNodeWithRetry(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	log := logger.Sugar()
+
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-node"}}
+	pods := schema.GroupResource{Resource: "pods"}
+
+	tests := []struct {
+		name          string
+		failures      []error
+		maxRetries    int
+		expectDrained bool
+		expectLists   int
+	}{
+		{
+			name:          "transient failure then success",
+			failures:      []error{apierrors.NewServerTimeout(pods, "list", 1)},
+			maxRetries:    3,
+			expectDrained: true,
+			expectLists:   2,
+		},
+		{
+			name:          "conflicts until retries run out",
+			failures:      []error{apierrors.NewConflict(pods, "web", errors.New("the object has been modified")), apierrors.NewConflict(pods, "web", errors.New("the object has been modified")), apierrors.NewConflict(pods, "web", errors.New("the object has been modified"))},
+			maxRetries:    2,
+			expectDrained: false,
+			expectLists:   3,
+		},
+		{
+			name:          "permanent failure isn't retried",
+			failures:      []error{apierrors.NewNotFound(pods, "web")},
+			maxRetries:    3,
+			expectDrained: false,
+			expectLists:   1,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			vals := config.ContextValues{
+				Logger: log,
+				State:  &appstate.State{IsCordoned: true},
+			}
+			ctx := context.WithValue(context.Background(), "values", &vals)
+
+			// the drain starts by listing the pods on the node, so failing the list fails the drain
+			lists := 0
+			clientset := fake.NewClientset(node)
+			clientset.PrependReactor("list", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				lists++
+				if lists <= len(tc.failures) {
+					return true, nil, tc.failures[lists-1]
+				}
+				return false, nil, nil
+			})
+
+			drainCfg := config.DrainConfig{Force: true, MaxRetries: tc.maxRetries, BaseDelay: time.Millisecond}
+			drained, err := DrainNodeWithRetry(ctx, clientset, node, drainCfg, Trigger{Category: TriggerCategoryEvent, Reason: "Reboot"})
+			assert.Equal(t, tc.expectDrained, drained)
+			assert.Equal(t, tc.expectDrained, err == nil, "unexpected drain error: %v", err)
+			assert.Equal(t, tc.expectLists, lists)
+		})
+	}
+}
+
+func TestDrainNodeWithRetryEvictionConflict(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	vals := config.ContextValues{Logger: logger.Sugar(), State: &appstate.State{IsCordoned: true}}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-node"}}
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec:       v1.PodSpec{NodeName: node.Name},
+	}
+
+	var evicted []time.Time
+	clientset := newEvictionTestClientset(&evicted, node, pod)
+	// the helper only passes the conflict on as a message, so the retry depends on the error the eviction API returned
+	conflicts := 0
+	clientset.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "eviction" || conflicts > 0 {
+			return false, nil, nil
+		}
+		conflicts++
+		return true, nil, apierrors.NewConflict(schema.GroupResource{Resource: "pods"}, pod.Name, errors.New("the object has been modified"))
+	})
+
+	drainCfg := config.DrainConfig{Timeout: time.Minute, Force: true, MaxRetries: 2, BaseDelay: time.Millisecond, EndpointDrainTimeout: time.Second}
+	drained, err := DrainNodeWithRetry(ctx, clientset, node, drainCfg, Trigger{Category: TriggerCategoryEvent, Reason: "Reboot"})
+	require.NoError(t, err)
+	assert.True(t, drained)
+	assert.Equal(t, 1, conflicts)
+	assert.Len(t, evicted, 1)
+
+	// the wait for endpoint removal comes before the drain and isn't repeated for the retry
+	endpointLists := 0
+	for _, action := range clientset.Actions() {
+		if action.Matches("list", "endpointslices") {
+			endpointLists++
+		}
+	}
+	assert.Equal(t, 1, endpointLists)
+}
@@ -0,0 +1,84 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/amargherio/mechanic/internal/config"
+	"go.opentelemetry.io/otel"
+	v1 "k8s.io/api/core/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ErrDrainIncomplete is returned by DrainNode when pods the drain should have removed are still on the node once the
+// verify timeout has passed
+var ErrDrainIncomplete = errors.New("drain incomplete")
+
+// drainVerifyPollInterval is how often the node is checked for pods left behind by a drain
+const drainVerifyPollInterval = 500 * time.Millisecond
+
+// verifyDrain waits up to drainCfg.VerifyTimeout for the pods the drain should have removed to be gone from the node.
+// Pods that are slow to terminate, or that landed on the node while it was draining, get the verify timeout to go
+// away. Failures listing the pods are retried up to drainCfg.VerifyRetries times.
+func verifyDrain(ctx context.Context, clientset kubernetes.Interface, node *v1.Node, drainCfg config.DrainConfig, trigger Trigger) error {
+	if drainCfg.VerifyTimeout <= 0 {
+		return nil
+	}
+
+	tracer := otel.Tracer("github.com/amargherio/mechanic/pkg/node")
+	ctx, span := tracer.Start(ctx, "verifyDrain")
+	defer span.End()
+
+	vals := ctx.Value("values").(*config.ContextValues)
+	log := vals.Logger
+
+	deadline := time.Now().Add(drainCfg.VerifyTimeout)
+	failures := 0
+	for {
+		remaining, err := podsLeftToDrain(ctx, clientset, node, drainCfg, trigger)
+		if err != nil {
+			failures++
+			if failures > drainCfg.VerifyRetries {
+				return fmt.Errorf("failed to list the pods left on the node after %d attempts: %w", failures, err)
+			}
+			log.Warnw("Failed to list the pods left on the node after the drain, retrying", "node", node.Name, "attempt", failures, "error", err, "traceCtx", ctx)
+		} else if len(remaining) == 0 {
+			return nil
+		} else if time.Now().After(deadline) {
+			log.Warnw("Pods are still on the node after the drain", "node", node.Name, "pods", remaining, "timeout", drainCfg.VerifyTimeout, "traceCtx", ctx)
+			return fmt.Errorf("%w, pods still on the node after %s: %s", ErrDrainIncomplete, drainCfg.VerifyTimeout, strings.Join(remaining, ", "))
+		} else {
+			log.Debugw("Waiting for pods to leave the node after the drain", "node", node.Name, "pods", remaining, "traceCtx", ctx)
+		}
+
+		select {
+		case <-time.After(drainVerifyPollInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// podsLeftToDrain returns the namespace/name of the pods on the node the drain would still evict, using the same pod
+// filters as the drain. Pods the drain leaves alone, like DaemonSet pods and protected pods, aren't included.
+func podsLeftToDrain(ctx context.Context, clientset kubernetes.Interface, node *v1.Node, drainCfg config.DrainConfig, trigger Trigger) ([]string, error) {
+	// the filters record events for the pods they skip, which the drain already did
+	vals := *ctx.Value("values").(*config.ContextValues)
+	vals.Recorder = nil
+	quietCtx := context.WithValue(ctx, "values", &vals)
+
+	podList, errs := newDrainHelper(quietCtx, clientset, drainCfg, trigger).GetPodsForDeletion(node.Name)
+	if len(errs) > 0 {
+		return nil, utilerrors.NewAggregate(errs)
+	}
+
+	var remaining []string
+	for _, pod := range podList.Pods() {
+		remaining = append(remaining, pod.Namespace+"/"+pod.Name)
+	}
+	return remaining, nil
+}
@@ -0,0 +1,127 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/amargherio/mechanic/internal/appstate"
+	"github.com/amargherio/mechanic/internal/config"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap/zaptest"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestDrainNodeVerifiesSlowPods(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-node"}}
+	newPod := func(name string) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec:       v1.PodSpec{NodeName: node.Name},
+		}
+	}
+
+	tests := []struct {
+		name          string
+		drainTimeout  time.Duration
+		verifyTimeout time.Duration
+		terminateIn   time.Duration
+		expectDrained bool
+	}{
+		{name: "pod terminates within the verify window", verifyTimeout: 5 * time.Second, terminateIn: 300 * time.Millisecond, expectDrained: true},
+		{name: "pod outlasts the verify window", verifyTimeout: 200 * time.Millisecond, terminateIn: time.Hour},
+		{name: "drain timeout cuts the verify window short", drainTimeout: time.Second, verifyTimeout: time.Hour, terminateIn: time.Hour},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			vals := config.ContextValues{Logger: logger.Sugar(), State: &appstate.State{IsCordoned: true}}
+			ctx := context.WithValue(context.Background(), "values", &vals)
+
+			var evicted []time.Time
+			clientset := newEvictionTestClientset(&evicted, node, newPod("web"))
+
+			// a pod lands on the node while it's draining and is slow to terminate, so the drain itself never sees it
+			var once sync.Once
+			done := make(chan struct{})
+			defer close(done)
+			clientset.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				once.Do(func() {
+					late := newPod("late")
+					late.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+					assert.NoError(t, clientset.Tracker().Add(late))
+					go func() {
+						select {
+						case <-time.After(tc.terminateIn):
+							_ = clientset.Tracker().Delete(v1.SchemeGroupVersion.WithResource("pods"), late.Namespace, late.Name)
+						case <-done:
+						}
+					}()
+				})
+				return false, nil, nil
+			})
+
+			drainCfg := config.DrainConfig{Timeout: tc.drainTimeout, Force: true, VerifyTimeout: tc.verifyTimeout}
+			start := time.Now()
+			drained, err := DrainNode(ctx, clientset, node, drainCfg, Trigger{Category: TriggerCategoryEvent, Reason: "Reboot"})
+			assert.Equal(t, tc.expectDrained, drained)
+			if tc.expectDrained {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, ErrDrainIncomplete)
+				assert.Contains(t, err.Error(), "default/late")
+				assert.Less(t, time.Since(start), time.Minute)
+			}
+		})
+	}
+}
+
+func TestVerifyDrainRetriesListErrors(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+
+	tests := []struct {
+		name        string
+		listErrors  int
+		retries     int
+		expectError bool
+	}{
+		{name: "list errors within the retries", listErrors: 2, retries: 2},
+		{name: "list errors beyond the retries", listErrors: 2, retries: 1, expectError: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			vals := config.ContextValues{Logger: logger.Sugar(), State: &appstate.State{}}
+			ctx := context.WithValue(context.Background(), "values", &vals)
+
+			node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-node"}}
+			clientset := fake.NewClientset(node)
+			lists := 0
+			clientset.PrependReactor("list", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				lists++
+				if lists <= tc.listErrors {
+					return true, nil, errors.New("connection refused")
+				}
+				return false, nil, nil
+			})
+
+			drainCfg := config.DrainConfig{Force: true, VerifyTimeout: 5 * time.Second, VerifyRetries: tc.retries}
+			err := verifyDrain(ctx, clientset, node, drainCfg, Trigger{})
+			if tc.expectError {
+				assert.ErrorContains(t, err, "connection refused")
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
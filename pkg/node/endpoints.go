@@ -0,0 +1,98 @@
+package node
+
+import (
+	"context"
+	"time"
+
+	"github.com/amargherio/mechanic/internal/config"
+	"go.opentelemetry.io/otel"
+	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+// endpointPollInterval is how often we check whether the node's pods are still serving Service traffic
+const endpointPollInterval = time.Second
+
+// waitForEndpointRemoval waits, bounded by the configured endpoint drain timeout and ctx's deadline, until no EndpointSlice has a ready
+// endpoint on the node, so traffic has moved away from the node's pods before they're evicted. If the endpoints
+// aren't removed in time the drain goes ahead anyway, since the maintenance is coming either way.
+func waitForEndpointRemoval(ctx context.Context, clientset kubernetes.Interface, node *v1.Node, drainCfg config.DrainConfig) {
+	if drainCfg.EndpointDrainTimeout <= 0 {
+		return
+	}
+
+	tracer := otel.Tracer("github.com/amargherio/mechanic/pkg/node")
+	ctx, span := tracer.Start(ctx, "waitForEndpointRemoval")
+	defer span.End()
+
+	vals := ctx.Value("values").(*config.ContextValues)
+	log := vals.Logger
+
+	var serving []string
+	err := wait.PollUntilContextTimeout(ctx, endpointPollInterval, drainCfg.EndpointDrainTimeout, true, func(ctx context.Context) (bool, error) {
+		var err error
+		serving, err = servingEndpointsOnNode(ctx, clientset, node)
+		if err != nil {
+			return false, err
+		}
+		if len(serving) > 0 {
+			log.Debugw("Waiting for the node's pods to be removed from Service endpoints", "node", node.Name, "endpoints", serving, "traceCtx", ctx)
+		}
+		return len(serving) == 0, nil
+	})
+	if err != nil {
+		log.Warnw("Pods on the node are still Service endpoints after the wait elapsed, continuing with the drain", "node", node.Name, "timeout", drainCfg.EndpointDrainTimeout, "endpoints", serving, "error", err, "traceCtx", ctx)
+		return
+	}
+	log.Infow("Pods on the node are no longer Service endpoints", "node", node.Name, "traceCtx", ctx)
+}
+
+// servingEndpointsOnNode returns the ready endpoints on the node, as the namespace/name of the slice and the endpoint's
+// target. EndpointSlices can't be selected by the nodes their endpoints are on, so only the slices backing a Service in
+// the namespaces of the node's pods are listed, rather than every slice in the cluster.
+func servingEndpointsOnNode(ctx context.Context, clientset kubernetes.Interface, node *v1.Node) ([]string, error) {
+	pods, err := clientset.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", node.Name).String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	namespaces := map[string]bool{}
+	for _, pod := range pods.Items {
+		namespaces[pod.Namespace] = true
+	}
+
+	var serving []string
+	for namespace := range namespaces {
+		slices, err := clientset.DiscoveryV1().EndpointSlices(namespace).List(ctx, metav1.ListOptions{LabelSelector: discoveryv1.LabelServiceName})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, slice := range slices.Items {
+			for _, endpoint := range slice.Endpoints {
+				if endpoint.NodeName == nil || *endpoint.NodeName != node.Name {
+					continue
+				}
+				// an unset ready condition means the endpoint is ready
+				if endpoint.Conditions.Ready != nil && !*endpoint.Conditions.Ready {
+					continue
+				}
+
+				target := "unknown"
+				if endpoint.TargetRef != nil {
+					target = endpoint.TargetRef.Name
+				} else if len(endpoint.Addresses) > 0 {
+					target = endpoint.Addresses[0]
+				}
+				serving = append(serving, slice.Namespace+"/"+slice.Name+": "+target)
+			}
+		}
+	}
+	return serving, nil
+}
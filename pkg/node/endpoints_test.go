@@ -0,0 +1,152 @@
+package node
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/amargherio/mechanic/internal/appstate"
+	"github.com/amargherio/mechanic/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/ptr"
+)
+
+func newEndpointSlice(name string, endpoints ...discoveryv1.Endpoint) *discoveryv1.EndpointSlice {
+	return &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			Labels:    map[string]string{discoveryv1.LabelServiceName: strings.Split(name, "-")[0]},
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+		Endpoints:   endpoints,
+	}
+}
+
+func newEndpoint(nodeName, podName string, ready *bool) discoveryv1.Endpoint {
+	return discoveryv1.Endpoint{
+		Addresses:  []string{"10.0.0.1"},
+		NodeName:   ptr.To(nodeName),
+		TargetRef:  &v1.ObjectReference{Kind: "Pod", Namespace: "default", Name: podName},
+		Conditions: discoveryv1.EndpointConditions{Ready: ready},
+	}
+}
+
+func TestDrainNodeWaitsForEndpointRemoval(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-node"}}
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web-1", Namespace: "default"},
+		Spec:       v1.PodSpec{NodeName: node.Name},
+	}
+
+	tests := []struct {
+		name          string
+		removeAfter   time.Duration
+		timeout       time.Duration
+		expectRemoved bool
+	}{
+		{name: "drain waits for the endpoints to be removed", removeAfter: 300 * time.Millisecond, timeout: 5 * time.Second, expectRemoved: true},
+		{name: "drain goes ahead once the timeout passes", timeout: 300 * time.Millisecond},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			vals := config.ContextValues{Logger: logger.Sugar(), State: &appstate.State{IsCordoned: true}}
+			ctx := context.WithValue(context.Background(), "values", &vals)
+
+			// the other node's endpoint and the one that's no longer ready don't hold up the drain
+			slice := newEndpointSlice("web-abc12",
+				newEndpoint(node.Name, pod.Name, nil),
+				newEndpoint("other-node", "web-2", ptr.To(true)),
+				newEndpoint(node.Name, "web-3", ptr.To(false)),
+			)
+			var evicted []time.Time
+			clientset := newEvictionTestClientset(&evicted, node, pod, slice)
+
+			var lock sync.Mutex
+			var removedAt time.Time
+			if tc.expectRemoved {
+				go func() {
+					time.Sleep(tc.removeAfter)
+					updated := slice.DeepCopy()
+					updated.Endpoints = updated.Endpoints[1:]
+					lock.Lock()
+					defer lock.Unlock()
+					_, err := clientset.DiscoveryV1().EndpointSlices("default").Update(context.Background(), updated, metav1.UpdateOptions{})
+					assert.NoError(t, err)
+					removedAt = time.Now()
+				}()
+			}
+
+			start := time.Now()
+			drainCfg := config.DrainConfig{Force: true, EndpointDrainTimeout: tc.timeout}
+			drained, err := DrainNode(ctx, clientset, node, drainCfg, Trigger{Category: TriggerCategoryEvent, Reason: "Reboot"})
+			require.NoError(t, err)
+			assert.True(t, drained)
+			require.Len(t, evicted, 1)
+
+			lock.Lock()
+			defer lock.Unlock()
+			if tc.expectRemoved {
+				require.False(t, removedAt.IsZero())
+				assert.True(t, evicted[0].After(removedAt), "the pod was evicted before it was removed from the endpoints")
+			} else {
+				assert.GreaterOrEqual(t, evicted[0].Sub(start), tc.timeout, "the pod was evicted before the endpoint wait timed out")
+			}
+		})
+	}
+}
+
+func TestServingEndpointsOnNode(t *testing.T) {
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-node"}}
+
+	// slices not backing a Service, and those in namespaces without pods on the node, aren't listed
+	unowned := newEndpointSlice("manual-ghi56", newEndpoint(node.Name, "manual-1", ptr.To(true)))
+	unowned.Labels = nil
+	elsewhere := newEndpointSlice("db-jkl78", newEndpoint(node.Name, "db-1", ptr.To(true)))
+	elsewhere.Namespace = "data"
+
+	clientset := fake.NewClientset(
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-1", Namespace: "default"}, Spec: v1.PodSpec{NodeName: node.Name}},
+		newEndpointSlice("web-abc12", newEndpoint(node.Name, "web-1", nil), newEndpoint(node.Name, "web-2", ptr.To(false))),
+		newEndpointSlice("api-def34", newEndpoint(node.Name, "api-1", ptr.To(true)), newEndpoint("other-node", "api-2", ptr.To(true))),
+		unowned,
+		elsewhere,
+	)
+
+	serving, err := servingEndpointsOnNode(context.Background(), clientset, node)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"default/web-abc12: web-1", "default/api-def34: api-1"}, serving)
+}
+
+func TestWaitForEndpointRemovalDeadline(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+
+	vals := config.ContextValues{Logger: logger.Sugar(), State: &appstate.State{IsCordoned: true}}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+	ctx, cancel := context.WithTimeout(ctx, 300*time.Millisecond)
+	defer cancel()
+
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-node"}}
+	clientset := fake.NewClientset(
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-1", Namespace: "default"}, Spec: v1.PodSpec{NodeName: node.Name}},
+		newEndpointSlice("web-abc12", newEndpoint(node.Name, "web-1", nil)),
+	)
+
+	// the drain's deadline ends the wait long before the configured endpoint timeout would
+	start := time.Now()
+	waitForEndpointRemoval(ctx, clientset, node, config.DrainConfig{EndpointDrainTimeout: time.Hour})
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
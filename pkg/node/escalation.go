@@ -0,0 +1,68 @@
+package node
+
+import (
+	"context"
+	"time"
+
+	"github.com/amargherio/mechanic/internal/appstate"
+	"github.com/amargherio/mechanic/internal/config"
+	"github.com/amargherio/mechanic/pkg/metrics"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// escalatedDrainConfig returns the drain settings for the next drain of the node. Once the node's drains have been
+// failing long enough to reach the force escalation step, the drain is forced: pods are deleted instead of evicted so
+// PodDisruptionBudgets can't hold it up, and pods using emptyDir or without a controller are removed too.
+func escalatedDrainConfig(ctx context.Context, node *v1.Node, cfg config.Config, state *appstate.State, recorder record.EventRecorder, trigger Trigger) config.DrainConfig {
+	drainCfg := cfg.Drain
+	failures, failingFor := drainFailureRun(state)
+	if !cfg.DrainEscalation.Force.Reached(failures, failingFor) {
+		return drainCfg
+	}
+
+	vals := ctx.Value("values").(*config.ContextValues)
+	vals.Logger.Warnw("Drains of the node keep failing, escalating to a forced drain",
+		"node", node.Name,
+		"failures", failures,
+		"failingFor", failingFor,
+		"traceCtx", ctx)
+	metrics.DrainEscalations.WithLabelValues("force").Inc()
+	TriggerEventf(recorder, node, trigger, v1.EventTypeWarning, "DrainEscalatedForce", "Drain of node %s has failed %d times over %s, forcing the drain and deleting pods instead of evicting them", node.Name, failures, failingFor.Round(time.Second))
+
+	drainCfg.Force = true
+	drainCfg.DeleteEmptyDirData = true
+	drainCfg.DisableEviction = true
+	return drainCfg
+}
+
+// recordDrainFailure counts a failed drain of the node and, the first time the page escalation step is reached for the
+// current run of failures, reports the stuck drain with a warning event and the drain escalation metric so alerting
+// can page someone.
+func recordDrainFailure(ctx context.Context, node *v1.Node, cfg config.Config, state *appstate.State, recorder record.EventRecorder, trigger Trigger) {
+	state.RecordDrainFailure(time.Now())
+	failures, failingFor := drainFailureRun(state)
+	if state.DrainFailurePageSent() || !cfg.DrainEscalation.Page.Reached(failures, failingFor) {
+		return
+	}
+
+	vals := ctx.Value("values").(*config.ContextValues)
+	vals.Logger.Errorw("Drains of the node keep failing and need attention",
+		"node", node.Name,
+		"failures", failures,
+		"failingFor", failingFor,
+		"traceCtx", ctx)
+	metrics.DrainEscalations.WithLabelValues("page").Inc()
+	TriggerEventf(recorder, node, trigger, v1.EventTypeWarning, "DrainEscalationPage", "Drain of node %s has failed %d times over %s and needs attention, the node was left cordoned", node.Name, failures, failingFor.Round(time.Second))
+	state.MarkDrainFailurePaged()
+}
+
+// drainFailureRun returns how many of the node's drains have failed in a row and for how long, or zeros when the last
+// drain didn't fail
+func drainFailureRun(state *appstate.State) (int, time.Duration) {
+	failures, failingSince := state.DrainFailureRun()
+	if failures == 0 {
+		return 0, 0
+	}
+	return failures, time.Since(failingSince)
+}
@@ -0,0 +1,133 @@
+package node
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/amargherio/mechanic/internal/appstate"
+	"github.com/amargherio/mechanic/internal/config"
+	"github.com/amargherio/mechanic/pkg/imds"
+	"github.com/amargherio/mechanic/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/utils/ptr"
+)
+
+func TestReconcileNodeDrainEscalation(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	vals := config.ContextValues{Logger: logger.Sugar()}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	cfg := config.Config{
+		DrainConditions: config.DrainConditions{DrainOnPreempt: true},
+		Drain:           config.DrainConfig{Timeout: 5 * time.Second, RespectPDBs: true},
+		DrainEscalation: config.DrainEscalationConfig{
+			Page:  config.EscalationStep{AfterFailures: 2},
+			Force: config.EscalationStep{AfterFailures: 3},
+		},
+	}
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-vmss000001", UID: "uid-1", Labels: map[string]string{}},
+		Status:     v1.NodeStatus{Conditions: []v1.NodeCondition{{Type: "PreemptScheduled", Status: v1.ConditionTrue}}},
+	}
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web-1", Namespace: "default", OwnerReferences: []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "web", Controller: ptr.To(true)}}},
+		Spec:       v1.PodSpec{NodeName: node.Name},
+	}
+	// every eviction is refused, so only a drain that deletes the pod can succeed
+	evictErr := apierrors.NewForbidden(schema.GroupResource{Resource: "pods"}, pod.Name, nil)
+	clientset := newDrainResultClientset(evictErr, node, pod)
+	ic := &fakeIMDS{resp: imds.ScheduledEventsResponse{IncarnationID: 1, Events: []imds.ScheduledEvent{{
+		EventId:      "preempt",
+		Type:         imds.Preempt,
+		ResourceType: "VirtualMachine",
+		Resources:    []string{"test-vmss_1"},
+		EventStatus:  imds.Scheduled,
+		NotBefore:    time.Now().Add(1 * time.Hour),
+		EventSource:  imds.Platform,
+	}}}}
+	state := &appstate.State{NodeUID: node.UID}
+	drainFailures := func() int {
+		failures, _ := state.DrainFailureRun()
+		return failures
+	}
+	pagesBefore := testutil.ToFloat64(metrics.DrainEscalations.WithLabelValues("page"))
+	forcesBefore := testutil.ToFloat64(metrics.DrainEscalations.WithLabelValues("force"))
+
+	// the first drain fails without escalating
+	recorder := &MockRecorder{}
+	require.NoError(t, ReconcileNode(ctx, clientset, ic, cfg, state, recorder, node))
+	assert.False(t, state.Drained())
+	assert.Equal(t, 1, drainFailures())
+	for _, e := range recorder.Events {
+		assert.NotContains(t, e, "DrainEscalat")
+	}
+
+	// the second failure reaches the page step
+	recorder = &MockRecorder{}
+	require.NoError(t, ReconcileNode(ctx, clientset, ic, cfg, state, recorder, node))
+	assert.False(t, state.Drained())
+	assert.Equal(t, 2, drainFailures())
+	assert.True(t, state.DrainFailurePageSent())
+	assert.Contains(t, recorder.Events, "Warning DrainEscalationPage Drain of node test-vmss000001 has failed 2 times over 0s and needs attention, the node was left cordoned (event: Preempt)")
+	assert.Equal(t, pagesBefore+1, testutil.ToFloat64(metrics.DrainEscalations.WithLabelValues("page")))
+
+	// the third failure doesn't page again
+	recorder = &MockRecorder{}
+	require.NoError(t, ReconcileNode(ctx, clientset, ic, cfg, state, recorder, node))
+	assert.Equal(t, 3, drainFailures())
+	for _, e := range recorder.Events {
+		assert.NotContains(t, e, "DrainEscalationPage")
+	}
+	assert.Equal(t, pagesBefore+1, testutil.ToFloat64(metrics.DrainEscalations.WithLabelValues("page")))
+
+	// with three failures the drain is forced, deleting the pod instead of evicting it
+	recorder = &MockRecorder{}
+	require.NoError(t, ReconcileNode(ctx, clientset, ic, cfg, state, recorder, node))
+	assert.True(t, state.Drained())
+	assert.Equal(t, 0, drainFailures(), "a successful drain ends the run of failures")
+	assert.False(t, state.DrainFailurePageSent())
+	assert.Contains(t, recorder.Events, "Warning DrainEscalatedForce Drain of node test-vmss000001 has failed 3 times over 0s, forcing the drain and deleting pods instead of evicting them (event: Preempt)")
+	assert.Equal(t, forcesBefore+1, testutil.ToFloat64(metrics.DrainEscalations.WithLabelValues("force")))
+
+	_, err := clientset.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err), "the forced drain should have deleted the pod")
+}
+
+func TestEscalatedDrainConfigAfterDuration(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	vals := config.ContextValues{Logger: logger.Sugar()}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	cfg := config.Config{
+		Drain:           config.DrainConfig{RespectPDBs: true},
+		DrainEscalation: config.DrainEscalationConfig{Force: config.EscalationStep{After: 10 * time.Minute}},
+	}
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-node"}}
+	recorder := &MockRecorder{}
+
+	// drains that started failing recently aren't forced
+	state := &appstate.State{}
+	state.RecordDrainFailure(time.Now().Add(-time.Minute))
+	assert.Equal(t, cfg.Drain, escalatedDrainConfig(ctx, node, cfg, state, recorder, Trigger{}))
+	assert.Empty(t, recorder.Events)
+
+	// once they've been failing for the configured duration, the drain is forced
+	state.ResetDrainFailures()
+	state.RecordDrainFailure(time.Now().Add(-15 * time.Minute))
+	forced := escalatedDrainConfig(ctx, node, cfg, state, recorder, Trigger{})
+	assert.True(t, forced.Force)
+	assert.True(t, forced.DeleteEmptyDirData)
+	assert.True(t, forced.DisableEviction)
+	assert.True(t, forced.RespectPDBs, "the other drain settings are kept")
+	assert.Len(t, recorder.Events, 1)
+}
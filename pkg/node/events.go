@@ -0,0 +1,53 @@
+package node
+
+import (
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// Topology returns the zone and region the node runs in, read from the well-known topology labels. Either value is
+// empty when the label isn't set.
+func Topology(node *v1.Node) (zone string, region string) {
+	labels := node.GetLabels()
+	return labels[v1.LabelTopologyZone], labels[v1.LabelTopologyRegion]
+}
+
+// Eventf records an event against the node with the node's zone and region attached as event annotations and
+// included in the message, so drain and cordon activity can be broken down by availability zone.
+func Eventf(recorder record.EventRecorder, node *v1.Node, eventtype, reason, messageFmt string, args ...interface{}) {
+	annotatedEventf(recorder, node, nil, eventtype, reason, fmt.Sprintf(messageFmt, args...))
+}
+
+// TriggerEventf records an event like Eventf, adding the category and reason of what triggered the cordon or drain so
+// downstream automation can tell scheduled events apart from node conditions.
+func TriggerEventf(recorder record.EventRecorder, node *v1.Node, trigger Trigger, eventtype, reason, messageFmt string, args ...interface{}) {
+	message := fmt.Sprintf(messageFmt, args...)
+	if trigger.Category != "" {
+		message = fmt.Sprintf("%s (%s)", message, trigger)
+		if len(trigger.Also) > 0 {
+			message = fmt.Sprintf("%s (also %s)", message, strings.Join(trigger.Also, ", "))
+		}
+	}
+	annotatedEventf(recorder, node, trigger.annotations(), eventtype, reason, message)
+}
+
+func annotatedEventf(recorder record.EventRecorder, node *v1.Node, annotations map[string]string, eventtype, reason, message string) {
+	zone, region := Topology(node)
+	if zone != "" || region != "" {
+		if annotations == nil {
+			annotations = make(map[string]string)
+		}
+		annotations[v1.LabelTopologyZone] = zone
+		annotations[v1.LabelTopologyRegion] = region
+		message = fmt.Sprintf("%s (zone: %s, region: %s)", message, zone, region)
+	}
+
+	if len(annotations) == 0 {
+		recorder.Event(node, eventtype, reason, message)
+		return
+	}
+	recorder.AnnotatedEventf(node, annotations, eventtype, reason, "%s", message)
+}
@@ -0,0 +1,82 @@
+package node
+
+import (
+	"context"
+	"testing"
+
+	"github.com/amargherio/mechanic/internal/appstate"
+	"github.com/amargherio/mechanic/internal/config"
+	"github.com/amargherio/mechanic/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap/zaptest"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestEventfIncludesTopology(t *testing.T) {
+	tests := []struct {
+		name                string
+		labels              map[string]string
+		expectedEvent       string
+		expectedAnnotations []map[string]string
+	}{
+		{
+			name: "node with zone and region",
+			labels: map[string]string{
+				v1.LabelTopologyZone:   "eastus-1",
+				v1.LabelTopologyRegion: "eastus",
+			},
+			expectedEvent: "Normal CordonNode Node test-node cordoned by mechanic (zone: eastus-1, region: eastus)",
+			expectedAnnotations: []map[string]string{
+				{v1.LabelTopologyZone: "eastus-1", v1.LabelTopologyRegion: "eastus"},
+			},
+		},
+		{
+			name:          "node without topology labels",
+			labels:        map[string]string{},
+			expectedEvent: "Normal CordonNode Node test-node cordoned by mechanic",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := &MockRecorder{}
+			node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-node", Labels: tc.labels}}
+
+			Eventf(recorder, node, v1.EventTypeNormal, "CordonNode", "Node %s cordoned by mechanic", node.Name)
+
+			assert.Equal(t, []string{tc.expectedEvent}, recorder.Events)
+			assert.Equal(t, tc.expectedAnnotations, recorder.Annotations)
+		})
+	}
+}
+
+func TestCordonNodeMetricTopologyLabels(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	log := logger.Sugar()
+
+	vals := config.ContextValues{
+		Logger: log,
+		State:  &appstate.State{},
+	}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "test-node",
+			Labels: map[string]string{
+				v1.LabelTopologyZone:   "westus2-3",
+				v1.LabelTopologyRegion: "westus2",
+			},
+		},
+	}
+	clientset := fake.NewClientset(node)
+
+	before := testutil.ToFloat64(metrics.Cordons.WithLabelValues("westus2-3", "westus2", TriggerCategoryEvent))
+	_, err := CordonNode(ctx, clientset, node, config.Config{}, Trigger{Category: TriggerCategoryEvent, Reason: "Reboot"})
+	assert.NoError(t, err)
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.Cordons.WithLabelValues("westus2-3", "westus2", TriggerCategoryEvent))-before)
+}
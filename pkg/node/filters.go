@@ -0,0 +1,53 @@
+package node
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/amargherio/mechanic/internal/config"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/kubectl/pkg/drain"
+)
+
+// protectEmptyDirAnnotation marks a pod whose emptyDir data must not be deleted by a drain
+const protectEmptyDirAnnotation = "mechanic.io/protect-emptydir"
+
+// protectedEmptyDirFilter returns a drain pod filter that skips pods with emptyDir volumes when they carry the protect
+// annotation or match the configured selector. Skipped pods are left on the node and reported as a drain warning
+// instead of having their emptyDir data deleted.
+func protectedEmptyDirFilter(ctx context.Context, drainCfg config.DrainConfig) drain.PodFilter {
+	vals := ctx.Value("values").(*config.ContextValues)
+	log := vals.Logger
+
+	selector := labels.Nothing()
+	if drainCfg.ProtectedEmptyDirSelector != "" {
+		s, err := labels.Parse(drainCfg.ProtectedEmptyDirSelector)
+		if err != nil {
+			log.Warnw("Invalid protected emptyDir selector, only the annotation will protect pods", "selector", drainCfg.ProtectedEmptyDirSelector, "error", err, "traceCtx", ctx)
+		} else {
+			selector = s
+		}
+	}
+
+	return func(pod v1.Pod) drain.PodDeleteStatus {
+		if !hasEmptyDir(pod) {
+			return drain.MakePodDeleteStatusOkay()
+		}
+		if pod.Annotations[protectEmptyDirAnnotation] != "true" && !selector.Matches(labels.Set(pod.Labels)) {
+			return drain.MakePodDeleteStatusOkay()
+		}
+
+		log.Infow("Skipping pod with protected emptyDir data", "pod", pod.Name, "namespace", pod.Namespace, "traceCtx", ctx)
+		return drain.MakePodDeleteStatusWithWarning(false, fmt.Sprintf("skipping pods with protected emptyDir data: %s/%s", pod.Namespace, pod.Name))
+	}
+}
+
+func hasEmptyDir(pod v1.Pod) bool {
+	for _, volume := range pod.Spec.Volumes {
+		if volume.EmptyDir != nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+package node
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/amargherio/mechanic/internal/appstate"
+	"github.com/amargherio/mechanic/internal/config"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap/zaptest"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDrainNodeProtectedEmptyDir(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	log := logger.Sugar()
+
+	vals := config.ContextValues{
+		Logger: log,
+		State:  &appstate.State{IsCordoned: true},
+	}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-node"}}
+	emptyDir := []v1.Volume{{Name: "scratch", VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{}}}}
+	newPod := func(name string, labels, annotations map[string]string, volumes []v1.Volume) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: labels, Annotations: annotations},
+			Spec:       v1.PodSpec{NodeName: node.Name, Volumes: volumes},
+		}
+	}
+
+	annotated := newPod("annotated", nil, map[string]string{protectEmptyDirAnnotation: "true"}, emptyDir)
+	selected := newPod("selected", map[string]string{"app": "scratch-heavy"}, nil, emptyDir)
+	cache := newPod("cache", map[string]string{"app": "cache"}, nil, emptyDir)
+	noEmptyDir := newPod("no-emptydir", nil, map[string]string{protectEmptyDirAnnotation: "true"}, nil)
+
+	var evicted []time.Time
+	clientset := newEvictionTestClientset(&evicted, node, annotated, selected, cache, noEmptyDir)
+
+	drainCfg := config.DrainConfig{ProtectedEmptyDirSelector: "app=scratch-heavy", Force: true, DeleteEmptyDirData: true}
+	drained, err := DrainNode(ctx, clientset, node, drainCfg, Trigger{Category: TriggerCategoryEvent, Reason: "Freeze"})
+	assert.NoError(t, err)
+	assert.True(t, drained)
+	assert.Len(t, evicted, 2, "only the unprotected pods should be evicted")
+
+	tests := []struct {
+		name      string
+		pod       *v1.Pod
+		remaining bool
+	}{
+		{name: "annotated emptyDir pod is kept", pod: annotated, remaining: true},
+		{name: "selected emptyDir pod is kept", pod: selected, remaining: true},
+		{name: "unprotected emptyDir pod is evicted", pod: cache, remaining: false},
+		{name: "annotated pod without emptyDir is evicted", pod: noEmptyDir, remaining: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := clientset.CoreV1().Pods(tc.pod.Namespace).Get(ctx, tc.pod.Name, metav1.GetOptions{})
+			if tc.remaining {
+				assert.NoError(t, err)
+			} else {
+				assert.True(t, apierrors.IsNotFound(err), "expected pod to be evicted, got %v", err)
+			}
+		})
+	}
+}
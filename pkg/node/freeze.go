@@ -0,0 +1,73 @@
+package node
+
+import (
+	"context"
+	"time"
+
+	"github.com/amargherio/mechanic/internal/appstate"
+	"github.com/amargherio/mechanic/internal/config"
+	"go.opentelemetry.io/otel"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// freezeSkippedAnnotation holds the ID of the most recent freeze event mechanic didn't drain the node for
+	freezeSkippedAnnotation = "mechanic.io/freeze-skipped"
+	// freezeSkippedNotBeforeAnnotation holds that freeze's NotBefore, when it had one
+	freezeSkippedNotBeforeAnnotation = "mechanic.io/freeze-skipped-not-before"
+)
+
+// annotateSkippedFreeze records the most recent freeze event the IMDS check decided not to drain for on the node, so
+// brief freezes can be correlated with latency seen on the node after the event is gone. The node is only updated when
+// the annotations don't already describe that freeze, and a failure is logged since the annotations are informational.
+func annotateSkippedFreeze(ctx context.Context, clientset kubernetes.Interface, node *v1.Node, cfg config.Config, state *appstate.State) {
+	freezeID, freezeNotBefore := state.SkippedFreeze()
+	if freezeID == "" {
+		return
+	}
+
+	notBefore := ""
+	if !freezeNotBefore.IsZero() {
+		notBefore = freezeNotBefore.UTC().Format(time.RFC3339)
+	}
+	annotations := node.GetAnnotations()
+	if annotations[freezeSkippedAnnotation] == freezeID && annotations[freezeSkippedNotBeforeAnnotation] == notBefore {
+		return
+	}
+
+	tracer := otel.Tracer("github.com/amargherio/mechanic/pkg/node")
+	ctx, span := tracer.Start(ctx, "annotateSkippedFreeze")
+	defer span.End()
+
+	vals := ctx.Value("values").(*config.ContextValues)
+	log := vals.Logger
+
+	retryErr := retryNodeUpdate(ctx, cfg.NodeUpdateRetry, func() error {
+		n, err := clientset.CoreV1().Nodes().Get(ctx, node.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		annotations := n.GetAnnotations()
+		if annotations == nil {
+			annotations = make(map[string]string)
+		}
+		annotations[freezeSkippedAnnotation] = freezeID
+		if notBefore != "" {
+			annotations[freezeSkippedNotBeforeAnnotation] = notBefore
+		} else {
+			delete(annotations, freezeSkippedNotBeforeAnnotation)
+		}
+		n.SetAnnotations(annotations)
+
+		_, err = clientset.CoreV1().Nodes().Update(ctx, n, metav1.UpdateOptions{})
+		return err
+	})
+	if retryErr != nil {
+		log.Warnw("Failed to annotate node with skipped freeze event - retry error encountered", "node", node.Name, "eventId", freezeID, "error", retryErr, "traceCtx", ctx)
+		return
+	}
+	log.Debugw("Annotated node with skipped freeze event", "node", node.Name, "eventId", freezeID, "traceCtx", ctx)
+}
@@ -0,0 +1,120 @@
+package node
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/amargherio/mechanic/internal/appstate"
+	"github.com/amargherio/mechanic/internal/config"
+	"github.com/amargherio/mechanic/pkg/imds"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestReconcileNodeAnnotatesSkippedFreeze(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	vals := config.ContextValues{Logger: logger.Sugar()}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	notBefore := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name             string
+		description      string
+		expectAnnotation bool
+	}{
+		{name: "non-LM freeze is recorded on the node", description: "freeze maintenance", expectAnnotation: true},
+		{name: "live migration is drained instead", description: "Virtual machine is being paused because of a memory-preserving Live Migration operation."},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// freezes aren't drained for, so IMDS is only checked because of the reboot condition
+			cfg := config.Config{DrainConditions: config.DrainConditions{DrainOnReboot: true}}
+			node := &v1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-vmss000001", UID: "uid-1", Labels: map[string]string{}},
+				Status: v1.NodeStatus{Conditions: []v1.NodeCondition{
+					{Type: "RebootScheduled", Status: v1.ConditionTrue},
+					{Type: "FreezeScheduled", Status: v1.ConditionTrue},
+				}},
+			}
+			clientset := fake.NewClientset(node)
+			ic := &fakeIMDS{resp: imds.ScheduledEventsResponse{IncarnationID: 1, Events: []imds.ScheduledEvent{{
+				EventId:      "freeze",
+				Type:         imds.Freeze,
+				Description:  tc.description,
+				ResourceType: "VirtualMachine",
+				Resources:    []string{"test-vmss_1"},
+				EventStatus:  imds.Scheduled,
+				NotBefore:    notBefore,
+				EventSource:  imds.Platform,
+			}}}}
+			state := &appstate.State{NodeUID: node.UID}
+			recorder := &MockRecorder{}
+
+			require.NoError(t, ReconcileNode(ctx, clientset, ic, cfg, state, recorder, node))
+
+			updated, err := clientset.CoreV1().Nodes().Get(ctx, node.Name, metav1.GetOptions{})
+			require.NoError(t, err)
+			if tc.expectAnnotation {
+				assert.Equal(t, "freeze", updated.Annotations[freezeSkippedAnnotation])
+				assert.Equal(t, "2030-01-02T03:04:05Z", updated.Annotations[freezeSkippedNotBeforeAnnotation])
+				assert.Contains(t, recorder.Events, `Normal FreezeSkipped Freeze event freeze detected but not a live migration; not draining (notBefore: 2030-01-02T03:04:05Z, description: "freeze maintenance")`)
+			} else {
+				assert.NotContains(t, updated.Annotations, freezeSkippedAnnotation)
+				for _, e := range recorder.Events {
+					assert.NotContains(t, e, "FreezeSkipped")
+				}
+			}
+		})
+	}
+}
+
+func TestAnnotateSkippedFreezeSkipsUnchangedNode(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	vals := config.ContextValues{Logger: logger.Sugar()}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-node", Annotations: map[string]string{freezeSkippedAnnotation: "freeze"}}}
+	clientset := fake.NewClientset(node)
+	state := &appstate.State{}
+	state.RecordSkippedFreeze("freeze", time.Time{})
+
+	annotateSkippedFreeze(ctx, clientset, node, config.Config{}, state)
+	for _, action := range clientset.Actions() {
+		assert.NotEqual(t, "update", action.GetVerb(), "the node already describes the freeze")
+	}
+}
+
+func TestSkippedFreezeAnnotationsCleared(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	vals := config.ContextValues{Logger: logger.Sugar(), State: &appstate.State{IsCordoned: true}}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	annotations := map[string]string{freezeSkippedAnnotation: "freeze", freezeSkippedNotBeforeAnnotation: "2030-01-02T03:04:05Z"}
+
+	// a node mechanic doesn't own the cordon of keeps them, since they're set on nodes mechanic never cordoned
+	unowned := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "unowned-node", Annotations: annotations}}
+	reconciled, err := ReconcileCordonMarkers(ctx, fake.NewClientset(unowned), unowned, config.Config{})
+	require.NoError(t, err)
+	assert.Equal(t, annotations, reconciled.Annotations)
+
+	// releasing mechanic's cordon removes them with the rest of its annotations
+	owned := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "owned-node", Labels: map[string]string{"mechanic.cordoned": "true"}, Annotations: annotations},
+		Spec:       v1.NodeSpec{Unschedulable: true},
+	}
+	clientset := fake.NewClientset(owned)
+	require.NoError(t, UncordonNode(ctx, clientset, owned, config.Config{}))
+	updated, err := clientset.CoreV1().Nodes().Get(ctx, owned.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.NotContains(t, updated.Annotations, freezeSkippedAnnotation)
+	assert.NotContains(t, updated.Annotations, freezeSkippedNotBeforeAnnotation)
+}
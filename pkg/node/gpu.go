@@ -0,0 +1,53 @@
+package node
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/amargherio/mechanic/internal/config"
+	"go.opentelemetry.io/otel"
+	v1 "k8s.io/api/core/v1"
+)
+
+// CheckGPUHealthConditions checks the node for a configured GPU health condition that has been true for at least the
+// sustained duration and returns its type. Conditions that haven't been true long enough, like corrected ECC errors
+// that clear on their own, are ignored so transient faults don't evict workloads. An empty string means no sustained
+// GPU health condition was found.
+func CheckGPUHealthConditions(ctx context.Context, node *v1.Node, gpuCfg config.GPUHealthConfig) string {
+	tracer := otel.Tracer("github.com/amargherio/mechanic/pkg/node")
+	ctx, span := tracer.Start(ctx, "CheckGPUHealthConditions")
+	defer span.End()
+
+	vals := ctx.Value("values").(*config.ContextValues)
+	log := vals.Logger
+
+	for _, condition := range node.Status.Conditions {
+		if !slices.Contains(gpuCfg.Conditions, string(condition.Type)) || condition.Status != v1.ConditionTrue {
+			continue
+		}
+
+		sustained := time.Since(condition.LastTransitionTime.Time)
+		if sustained < gpuCfg.SustainedFor {
+			log.Infow("Node has a GPU health condition that hasn't been sustained long enough to drain",
+				"node", node.Name,
+				"type", condition.Type,
+				"sustainedFor", sustained,
+				"required", gpuCfg.SustainedFor,
+				"reason", condition.Reason,
+				"traceCtx", ctx)
+			continue
+		}
+
+		log.Infow("Node has a sustained GPU health condition. Flagging for drain.",
+			"node", node.Name,
+			"type", condition.Type,
+			"lastTransitionTime", condition.LastTransitionTime,
+			"reason", condition.Reason,
+			"message", condition.Message,
+			"traceCtx", ctx)
+		return string(condition.Type)
+	}
+
+	return ""
+}
@@ -0,0 +1,89 @@
+package node
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/amargherio/mechanic/internal/appstate"
+	"github.com/amargherio/mechanic/internal/config"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap/zaptest"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCheckGPUHealthConditions(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+
+	vals := config.ContextValues{
+		Logger: logger.Sugar(),
+		State:  &appstate.State{},
+	}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	gpuCfg := config.GPUHealthConfig{
+		Conditions:   []string{"GPUCorrectableECCError", "GPUUncorrectableECCError", "GPUThermalThrottle"},
+		SustainedFor: 5 * time.Minute,
+	}
+
+	condition := func(conditionType string, status v1.ConditionStatus, since time.Duration) v1.NodeCondition {
+		return v1.NodeCondition{
+			Type:               v1.NodeConditionType(conditionType),
+			Status:             status,
+			LastTransitionTime: metav1.NewTime(time.Now().Add(-since)),
+		}
+	}
+
+	tests := []struct {
+		name       string
+		conditions []v1.NodeCondition
+		gpuCfg     config.GPUHealthConfig
+		expected   string
+	}{
+		{
+			name:       "transient corrected ECC errors don't drain",
+			conditions: []v1.NodeCondition{condition("GPUCorrectableECCError", v1.ConditionTrue, 30*time.Second)},
+			gpuCfg:     gpuCfg,
+			expected:   "",
+		},
+		{
+			name:       "sustained uncorrectable ECC errors drain",
+			conditions: []v1.NodeCondition{condition("GPUUncorrectableECCError", v1.ConditionTrue, 10*time.Minute)},
+			gpuCfg:     gpuCfg,
+			expected:   "GPUUncorrectableECCError",
+		},
+		{
+			name: "sustained condition found alongside a transient one",
+			conditions: []v1.NodeCondition{
+				condition("GPUCorrectableECCError", v1.ConditionTrue, 30*time.Second),
+				condition("GPUThermalThrottle", v1.ConditionTrue, 6*time.Minute),
+			},
+			gpuCfg:   gpuCfg,
+			expected: "GPUThermalThrottle",
+		},
+		{
+			name:       "cleared condition doesn't drain",
+			conditions: []v1.NodeCondition{condition("GPUUncorrectableECCError", v1.ConditionFalse, 10*time.Minute)},
+			gpuCfg:     gpuCfg,
+			expected:   "",
+		},
+		{
+			name:       "unconfigured condition doesn't drain",
+			conditions: []v1.NodeCondition{condition("GPUUncorrectableECCError", v1.ConditionTrue, 10*time.Minute)},
+			gpuCfg:     config.GPUHealthConfig{SustainedFor: 5 * time.Minute},
+			expected:   "",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			node := &v1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-node"},
+				Status:     v1.NodeStatus{Conditions: tc.conditions},
+			}
+			assert.Equal(t, tc.expected, CheckGPUHealthConditions(ctx, node, tc.gpuCfg))
+		})
+	}
+}
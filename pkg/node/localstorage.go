@@ -0,0 +1,108 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/amargherio/mechanic/internal/config"
+	"go.opentelemetry.io/otel"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/kubectl/pkg/drain"
+)
+
+// ErrLocalStorageDeferred is returned by DrainNode when the local storage policy is defer and pods on the node use
+// local PersistentVolumes
+var ErrLocalStorageDeferred = errors.New("pods on the node use local persistent volumes")
+
+// localStorageFilter returns a drain pod filter that skips pods using local PersistentVolumes when the local storage
+// policy is skip. Skipped pods are left on the node, reported as a drain warning, and get an event saying why. With any
+// other policy every pod is passed on to the other filters.
+func localStorageFilter(ctx context.Context, clientset kubernetes.Interface, drainCfg config.DrainConfig) drain.PodFilter {
+	vals := ctx.Value("values").(*config.ContextValues)
+	log := vals.Logger
+
+	return func(pod v1.Pod) drain.PodDeleteStatus {
+		if drainCfg.LocalStoragePolicy != config.LocalStoragePolicySkip {
+			return drain.MakePodDeleteStatusOkay()
+		}
+
+		local, err := usesLocalPV(ctx, clientset, pod)
+		if err != nil {
+			// evicting a pod whose data can't follow it loses that data, so a pod we can't check is kept
+			log.Warnw("Failed to check pod for local persistent volumes, leaving it on the node", "pod", pod.Name, "namespace", pod.Namespace, "error", err, "traceCtx", ctx)
+		} else if !local {
+			return drain.MakePodDeleteStatusOkay()
+		}
+
+		log.Infow("Skipping pod with local persistent volumes", "pod", pod.Name, "namespace", pod.Namespace, "traceCtx", ctx)
+		if vals.Recorder != nil {
+			vals.Recorder.Eventf(&pod, v1.EventTypeWarning, "LocalStorageSkipped",
+				"Pod left on node %s by mechanic's drain because it uses local persistent volumes", pod.Spec.NodeName)
+		}
+		return drain.MakePodDeleteStatusWithWarning(false, fmt.Sprintf("skipping pods with local persistent volumes: %s/%s", pod.Namespace, pod.Name))
+	}
+}
+
+// localStoragePods returns the namespace/name of the running pods on the node that use local PersistentVolumes
+func localStoragePods(ctx context.Context, clientset kubernetes.Interface, node *v1.Node) ([]string, error) {
+	tracer := otel.Tracer("github.com/amargherio/mechanic/pkg/node")
+	ctx, span := tracer.Start(ctx, "localStoragePods")
+	defer span.End()
+
+	pods, err := clientset.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", node.Name).String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var local []string
+	for _, pod := range pods.Items {
+		if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+			continue
+		}
+		ok, err := usesLocalPV(ctx, clientset, pod)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			local = append(local, pod.Namespace+"/"+pod.Name)
+		}
+	}
+	return local, nil
+}
+
+// usesLocalPV reports whether the pod mounts a PersistentVolumeClaim bound to a local PersistentVolume. Claims and
+// volumes that no longer exist are ignored.
+func usesLocalPV(ctx context.Context, clientset kubernetes.Interface, pod v1.Pod) (bool, error) {
+	for _, volume := range pod.Spec.Volumes {
+		if volume.PersistentVolumeClaim == nil {
+			continue
+		}
+		pvc, err := clientset.CoreV1().PersistentVolumeClaims(pod.Namespace).Get(ctx, volume.PersistentVolumeClaim.ClaimName, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return false, err
+		}
+		if pvc.Spec.VolumeName == "" {
+			continue
+		}
+
+		pv, err := clientset.CoreV1().PersistentVolumes().Get(ctx, pvc.Spec.VolumeName, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return false, err
+		}
+		if pv.Spec.Local != nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
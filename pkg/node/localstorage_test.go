@@ -0,0 +1,110 @@
+package node
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/amargherio/mechanic/internal/appstate"
+	"github.com/amargherio/mechanic/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDrainNodeLocalStoragePolicy(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-node"}}
+	localPV := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "local-pv"},
+		Spec: v1.PersistentVolumeSpec{PersistentVolumeSource: v1.PersistentVolumeSource{
+			Local: &v1.LocalVolumeSource{Path: "/mnt/disks/ssd0"},
+		}},
+	}
+	networkPV := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "network-pv"},
+		Spec: v1.PersistentVolumeSpec{PersistentVolumeSource: v1.PersistentVolumeSource{
+			CSI: &v1.CSIPersistentVolumeSource{Driver: "disk.csi.azure.com", VolumeHandle: "disk-1"},
+		}},
+	}
+	newClaim := func(name, volume string) *v1.PersistentVolumeClaim {
+		return &v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec:       v1.PersistentVolumeClaimSpec{VolumeName: volume},
+		}
+	}
+	newPod := func(name, claim string) *v1.Pod {
+		pod := &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec:       v1.PodSpec{NodeName: node.Name},
+		}
+		if claim != "" {
+			pod.Spec.Volumes = []v1.Volume{{Name: "data", VolumeSource: v1.VolumeSource{
+				PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: claim},
+			}}}
+		}
+		return pod
+	}
+
+	tests := []struct {
+		name             string
+		policy           string
+		expectDeferred   bool
+		expectLocalKept  bool
+		expectedEvicted  int
+		expectedPodEvent []string
+	}{
+		{name: "force evicts every pod", policy: config.LocalStoragePolicyForce, expectedEvicted: 3},
+		{
+			name:             "skip leaves local storage pods on the node",
+			policy:           config.LocalStoragePolicySkip,
+			expectLocalKept:  true,
+			expectedEvicted:  2,
+			expectedPodEvent: []string{"Warning LocalStorageSkipped Pod left on node test-node by mechanic's drain because it uses local persistent volumes"},
+		},
+		{name: "defer doesn't drain while local storage pods are on the node", policy: config.LocalStoragePolicyDefer, expectDeferred: true, expectLocalKept: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := &MockRecorder{}
+			vals := config.ContextValues{
+				Logger:   logger.Sugar(),
+				State:    &appstate.State{IsCordoned: true},
+				Recorder: recorder,
+			}
+			ctx := context.WithValue(context.Background(), "values", &vals)
+
+			local := newPod("local", "local-data")
+			var evicted []time.Time
+			clientset := newEvictionTestClientset(&evicted, node, localPV, networkPV,
+				newClaim("local-data", "local-pv"), newClaim("network-data", "network-pv"),
+				local, newPod("network", "network-data"), newPod("stateless", ""))
+
+			drainCfg := config.DrainConfig{LocalStoragePolicy: tc.policy, Force: true}
+			drained, err := DrainNode(ctx, clientset, node, drainCfg, Trigger{Category: TriggerCategoryEvent, Reason: "Reboot"})
+			if tc.expectDeferred {
+				require.ErrorIs(t, err, ErrLocalStorageDeferred)
+				assert.Contains(t, err.Error(), "default/local")
+				assert.False(t, drained)
+			} else {
+				require.NoError(t, err)
+				assert.True(t, drained)
+			}
+			assert.Len(t, evicted, tc.expectedEvicted)
+			assert.Equal(t, tc.expectedPodEvent, recorder.Events)
+
+			_, err = clientset.CoreV1().Pods(local.Namespace).Get(ctx, local.Name, metav1.GetOptions{})
+			if tc.expectLocalKept {
+				assert.NoError(t, err)
+			} else {
+				assert.True(t, apierrors.IsNotFound(err), "expected local storage pod to be evicted, got %v", err)
+			}
+		})
+	}
+}
@@ -3,19 +3,31 @@ package node
 import (
 	"context"
 	"errors"
+	"fmt"
 	"github.com/amargherio/mechanic/internal/config"
+	"github.com/amargherio/mechanic/pkg/metrics"
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 	"go.uber.org/zap"
+	"golang.org/x/time/rate"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/record"
-	"k8s.io/client-go/util/retry"
 	"k8s.io/kubectl/pkg/drain"
 	"slices"
 	"strings"
+	"time"
 )
 
+// cordonNotManagedAnnotation is set on nodes that are cordoned by something other than mechanic so it's clear why
+// mechanic isn't releasing the cordon
+const cordonNotManagedAnnotation = "mechanic.io/cordon-not-managed"
+
+// minDrainTimeout is the shortest timeout a drain is given when it's clamped to a deadline that's close or already past
+const minDrainTimeout = 10 * time.Second
+
 // temp type for wrapping the zap logger to be io.Writer compatible
 // this is needed for the drain helper to use the zap logger
 type logger struct {
@@ -38,7 +50,10 @@ func (l *logger) Write(p []byte) (n int, err error) {
 	return len(p), nil
 }
 
-func CordonNode(ctx context.Context, clientset kubernetes.Interface, node *v1.Node) (bool, error) {
+// CordonNode cordons the node and labels it as cordoned by mechanic, using the cordon markers and node update retry
+// policy in cfg. The trigger is recorded in the node's annotations so downstream automation can tell what caused the
+// cordon.
+func CordonNode(ctx context.Context, clientset kubernetes.Interface, node *v1.Node, cfg config.Config, trigger Trigger) (bool, error) {
 	tracer := otel.Tracer("github.com/amargherio/mechanic/pkg/node")
 	ctx, span := tracer.Start(ctx, "ReadConfiguration")
 	defer span.End()
@@ -48,33 +63,40 @@ func CordonNode(ctx context.Context, clientset kubernetes.Interface, node *v1.No
 
 	// check if our node is cordoned, which throws our app state out of sync
 	if node.Spec.Unschedulable {
-		if !vals.State.IsCordoned {
+		if !vals.State.Cordoned() {
 			// the node is unschedulable but our state is not in sync - check if we did it, and reconcile cordoned state.
-			if _, ok := node.GetLabels()["mechanic.cordoned"]; ok {
-				vals.State.IsCordoned = true
+			if ownsCordon(node, cfg.Cordon) {
+				vals.State.SetCordoned(true)
 				log.Warnw("Node is cordoned, but our state is not in sync. Reconciling state.", "traceCtx", ctx)
 			} else {
 				log.Infow("Node is cordoned, but we aren't responsible for the cordon.", "node", node.Name, "traceCtx", ctx)
 				// we could still benefit from the cordon and don't need to cordon again, so sync state
-				vals.State.IsCordoned = true
+				vals.State.SetCordoned(true)
 			}
 		}
-		log.Infow("Node is already cordoned", "node", node.Name, "state", vals.State.IsCordoned, "traceCtx", ctx)
+		log.Infow("Node is already cordoned", "node", node.Name, "state", vals.State.Cordoned(), "traceCtx", ctx)
 		return true, nil
 	}
 
-	retryErr := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+	retryErr := retryNodeUpdate(ctx, cfg.NodeUpdateRetry, func() error {
 		n, err := clientset.CoreV1().Nodes().Get(ctx, node.Name, metav1.GetOptions{})
 		if err != nil {
 			return err
 		}
 
-		// update the labels to show mechanic cordoned the node and cordon the node
-		n.Spec.Unschedulable = true
-		labels := n.GetLabels()
-		labels["mechanic.cordoned"] = "true"
-		n.SetLabels(labels)
-		log.Debugw("Node object updated with unschedulable set to true and mechanic.cordoned label", "traceCtx", ctx)
+		// cordon the node and mark it with our label, and taint when configured, to show mechanic cordoned it
+		markCordoned(n, cfg.Cordon)
+		if triggerAnnotations := trigger.annotations(); triggerAnnotations != nil {
+			annotations := n.GetAnnotations()
+			if annotations == nil {
+				annotations = make(map[string]string)
+			}
+			for k, v := range triggerAnnotations {
+				annotations[k] = v
+			}
+			n.SetAnnotations(annotations)
+		}
+		log.Debugw("Node object updated with unschedulable set to true and mechanic's cordon label", "label", cordonLabelKey(cfg.Cordon), "trigger", trigger, "traceCtx", ctx)
 
 		_, err = clientset.CoreV1().Nodes().Update(ctx, n, metav1.UpdateOptions{})
 		return err
@@ -96,17 +118,23 @@ func CordonNode(ctx context.Context, clientset kubernetes.Interface, node *v1.No
 		return false, errors.New("node was not cordoned")
 	}
 
-	if res_node.GetLabels()["mechanic.cordoned"] != "true" {
+	if value, _ := cordonLabelValue(res_node, cfg.Cordon); value != "true" {
 		log.Errorw("Node was not labeled as cordoned by mechanic", "node", node.Name, "traceCtx", ctx)
 		return false, errors.New("node was not labeled as cordoned by mechanic")
 	}
 
 	// successfully cordoned
 	log.Infow("Node cordoned", "node", node.Name, "traceCtx", ctx)
+	// state left from an earlier cordon describes a drain that's over, so it can't be restored into this one
+	clearPersistedState(ctx, clientset, res_node, cfg)
+	zone, region := Topology(node)
+	metrics.Cordons.WithLabelValues(zone, region, trigger.Category).Inc()
 	return true, nil
 }
 
-func UncordonNode(ctx context.Context, clientset kubernetes.Interface, node *v1.Node) error {
+// UncordonNode releases mechanic's cordon on the node, removing the cordon markers in cfg and the annotations mechanic
+// owns, and clears the persisted state
+func UncordonNode(ctx context.Context, clientset kubernetes.Interface, node *v1.Node, cfg config.Config) error {
 	vals := ctx.Value("values").(*config.ContextValues)
 
 	tracer := otel.Tracer("github.com/amargherio/mechanic/pkg/node")
@@ -115,20 +143,22 @@ func UncordonNode(ctx context.Context, clientset kubernetes.Interface, node *v1.
 
 	log := vals.Logger
 
-	retryErr := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+	retryErr := retryNodeUpdate(ctx, cfg.NodeUpdateRetry, func() error {
 		n, err := clientset.CoreV1().Nodes().Get(ctx, node.Name, metav1.GetOptions{})
 		if err != nil {
 			return err
 		}
 
-		// update the labels to show mechanic cordoned the node and cordon the node
-		n.Spec.Unschedulable = false
-		log.Debugw("Unschedulable set to false on node object", "traceCtx", ctx)
+		// uncordon the node and remove our cordon label and taint
+		unmarkCordoned(n, cfg.Cordon)
+		log.Debugw("Unschedulable set to false on node object and mechanic's cordon label removed", "label", cordonLabelKey(cfg.Cordon), "traceCtx", ctx)
 
-		labels := n.GetLabels()
-		delete(labels, "mechanic.cordoned")
-		n.SetLabels(labels)
-		log.Debugw("Labels updated on node object with mechanic.cordoned label removed", "traceCtx", ctx)
+		annotations := n.GetAnnotations()
+		delete(annotations, cordonNotManagedAnnotation)
+		for _, key := range ownedAnnotationKeys {
+			delete(annotations, key)
+		}
+		n.SetAnnotations(annotations)
 
 		_, err = clientset.CoreV1().Nodes().Update(ctx, n, metav1.UpdateOptions{})
 		return err
@@ -138,44 +168,246 @@ func UncordonNode(ctx context.Context, clientset kubernetes.Interface, node *v1.
 		return retryErr
 	}
 
-	vals.State.IsCordoned = false
+	vals.State.SetCordoned(false)
+	clearPersistedState(ctx, clientset, node, cfg)
+	if err := restoreScaledDownWorkloads(ctx, clientset, node); err != nil {
+		log.Warnw("Failed to restore deployments scaled down before the drain", "node", node.Name, "error", err, "traceCtx", ctx)
+	}
 	return nil
 }
 
-func DrainNode(ctx context.Context, clientset kubernetes.Interface, node *v1.Node) (bool, error) {
+// DrainNode drains the node using the kubectl drain helper. The trigger's reason is the event type or node condition
+// that caused the drain and is used to select the drain timeout. A non-zero trigger deadline, usually the scheduled
+// event's NotBefore, caps the timeout so the drain doesn't run past the point where the maintenance proceeds anyway.
+func DrainNode(ctx context.Context, clientset kubernetes.Interface, node *v1.Node, drainCfg config.DrainConfig, trigger Trigger) (bool, error) {
 	tracer := otel.Tracer("github.com/amargherio/mechanic/pkg/node")
 	ctx, span := tracer.Start(ctx, "DrainNode")
 	defer span.End()
 
+	return drainNode(ctx, clientset, node, drainCfg, trigger, 0)
+}
+
+// drainNode drains the node, making up to maxRetries more attempts when an attempt fails on a transient API error. The
+// checks and pre-drain steps run once, and every attempt shares the drain's timeout.
+func drainNode(ctx context.Context, clientset kubernetes.Interface, node *v1.Node, drainCfg config.DrainConfig, trigger Trigger, maxRetries int) (bool, error) {
 	vals := ctx.Value("values").(*config.ContextValues)
 	log := vals.Logger
 
 	// drain the node
-	log.Infow("Beginning node drain", "node", node.Name, "traceCtx", ctx)
+	timeout := drainTimeout(drainCfg.TimeoutFor(trigger.Reason), trigger.Deadline)
+	log.Infow("Beginning node drain", "node", node.Name, "category", trigger.Category, "reason", trigger.Reason, "timeout", timeout, "deadline", trigger.Deadline, "traceCtx", ctx)
+
+	// pods using local volumes lose access to their data once evicted, so with the defer policy nothing is evicted
+	// until they're gone. the node stays cordoned and the drain is tried again on the next reconcile.
+	if drainCfg.LocalStoragePolicy == config.LocalStoragePolicyDefer {
+		pods, err := localStoragePods(ctx, clientset, node)
+		if err != nil {
+			log.Errorw("Failed to check the node for pods with local persistent volumes", "node", node.Name, "error", err, "traceCtx", ctx)
+			return false, err
+		}
+		if len(pods) > 0 {
+			log.Infow("Pods on the node use local persistent volumes, deferring drain", "node", node.Name, "pods", pods, "traceCtx", ctx)
+			return false, fmt.Errorf("%w: %s", ErrLocalStorageDeferred, strings.Join(pods, ", "))
+		}
+	}
+
+	// the helper keeps retrying evictions a PDB rejects until the drain times out, which is time wasted when a budget can
+	// never allow a disruption. deleting pods instead of evicting them isn't held up by budgets at all.
+	if drainCfg.RespectPDBs && !drainCfg.DisableEviction {
+		blocks, err := FindBlockingPDBs(ctx, clientset, node)
+		if err != nil {
+			log.Warnw("Failed to check the PodDisruptionBudgets covering pods on the node, continuing with the drain", "node", node.Name, "error", err, "traceCtx", ctx)
+		} else if pdbErr := (&PDBBlockedError{Blocks: blocks, err: errUnsatisfiablePDB}); pdbErr.Unsatisfiable() {
+			log.Infow("PodDisruptionBudgets covering pods on the node can never allow a disruption, not draining", "node", node.Name, "pdbs", pdbErr.describe(), "traceCtx", ctx)
+			metrics.DrainResults.WithLabelValues(DrainResultPDBBlocked).Inc()
+			return false, pdbErr
+		}
+	}
+
+	// the helper's timeout only bounds its wait for pods to go away, so the context is cancelled too. that stops the
+	// eviction retries and API calls still in flight when the timeout passes, and bounds the waits before the drain and
+	// the retries.
+	drainCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		drainCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	// give matching workloads a chance to shut down gracefully before we start evicting. a failure here shouldn't stop
+	// the drain since the maintenance is coming either way.
+	if err := scaleDownWorkloads(drainCtx, clientset, node, drainCfg); err != nil {
+		log.Warnw("Failed to scale down workloads before draining, continuing with the drain", "node", node.Name, "error", err, "traceCtx", ctx)
+	}
+
+	// with the pods still receiving Service traffic, evicting them drops requests in flight. give whatever shifts the
+	// traffic away from the cordoned node time to do so.
+	waitForEndpointRemoval(drainCtx, clientset, node, drainCfg)
+
+	var result string
+	var err error
+	for attempt := 0; ; attempt++ {
+		var retriable bool
+		result, retriable, err = drainAttempt(ctx, drainCtx, clientset, node, drainCfg, trigger)
+		if err == nil || attempt >= maxRetries || !retriable {
+			break
+		}
+
+		delay := drainRetryDelay(drainCfg.BaseDelay, attempt) // exponential backoff
+		log.Warnw("Drain failed with a transient error, retrying...", "node", node.Name, "attempt", attempt+1, "delay", delay, "error", err, "traceCtx", ctx)
+		select {
+		case <-time.After(delay):
+			continue
+		case <-drainCtx.Done():
+		}
+		break
+	}
+
+	// the scaled down workloads are brought back as soon as the drain finishes. once it succeeds their pods can only land
+	// on other nodes, and a failed drain is tried again later. waiting for the uncordon would leave them at zero for
+	// good after a Preempt or Terminate, since the node is deleted rather than uncordoned.
+	if drainCfg.ScaleDownSelector != "" {
+		if restoreErr := restoreScaledDownWorkloads(ctx, clientset, node); restoreErr != nil {
+			log.Warnw("Failed to restore deployments scaled down before the drain", "node", node.Name, "error", restoreErr, "traceCtx", ctx)
+		}
+	}
+
+	if err != nil {
+		log.Debugw("Classified failed drain", "node", node.Name, "result", result, "traceCtx", ctx)
+		if result == DrainResultTimeout {
+			err = fmt.Errorf("%w after %s: %w", ErrDrainTimedOut, timeout, err)
+		}
+		if result == DrainResultPDBBlocked {
+			err = fmt.Errorf("%w after %s: %w", ErrDrainPDBBlocked, timeout, err)
+			// the helper keeps retrying evictions a PDB rejects until it times out, so look up which budgets are
+			// still holding pods on the node to say what the drain was waiting on
+			if drainCfg.RespectPDBs {
+				blocks, pdbErr := FindBlockingPDBs(ctx, clientset, node)
+				if pdbErr != nil {
+					log.Warnw("Failed to look up the PodDisruptionBudgets blocking the drain", "node", node.Name, "error", pdbErr, "traceCtx", ctx)
+				} else if len(blocks) > 0 {
+					return false, &PDBBlockedError{Blocks: blocks, err: err}
+				}
+			}
+		}
+		return false, err
+	}
+
+	zone, region := Topology(node)
+	metrics.Drains.WithLabelValues(zone, region, trigger.Category).Inc()
+	return true, nil
+}
+
+// drainAttempt runs the drain helper once with drainCtx, which carries the drain's timeout, and checks that the node
+// was emptied. It returns the attempt's drain result and whether a failed attempt is worth retrying.
+func drainAttempt(ctx, drainCtx context.Context, clientset kubernetes.Interface, node *v1.Node, drainCfg config.DrainConfig, trigger Trigger) (string, bool, error) {
+	tracer := otel.Tracer("github.com/amargherio/mechanic/pkg/node")
+	ctx, span := tracer.Start(ctx, "drainAttempt")
+	defer span.End()
+
+	evictions := &evictionErrors{}
+	drainHelper := newDrainHelper(drainCtx, &evictionClient{Interface: clientset, errs: evictions}, drainCfg, trigger)
+	// a retry only gets what's left of the drain's timeout, and so does the check for pods left behind
+	verifyCfg := drainCfg
+	if deadline, ok := drainCtx.Deadline(); ok {
+		remaining := max(time.Until(deadline), time.Millisecond)
+		drainHelper.Timeout = remaining
+		verifyCfg.VerifyTimeout = min(verifyCfg.VerifyTimeout, remaining)
+	}
+	var report *drainReport
+	if drainCfg.ReportOwners {
+		report = newDrainReport(clientset)
+		report.track(ctx, drainHelper)
+	}
+
+	start := time.Now()
+	err := drain.RunNodeDrain(drainHelper, node.Name)
+	if err == nil {
+		// the drain only waits for the pods it found when it started, so check nothing it should have removed is left
+		err = verifyDrain(ctx, clientset, node, verifyCfg, trigger)
+	}
+	if report != nil {
+		report.emit(ctx, node, trigger)
+	}
+	result := classifyDrainResult(ctx, err, evictions.pdbBlocked.Load())
+	span.SetAttributes(attribute.String("node.name", node.Name), attribute.String("drain.result", result))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, result)
+	}
+	metrics.DrainResults.WithLabelValues(result).Inc()
+	metrics.DrainDuration.WithLabelValues(result).Observe(time.Since(start).Seconds())
+
+	// timeouts, PDB rejections and pods left behind won't go away by trying again within the same timeout
+	retriable := result == DrainResultAPIError && isRetriableDrainError(evictions.cause(err))
+	return result, retriable, err
+}
+
+// newDrainHelper builds the kubectl drain helper used to drain the node, applying the timeout for the trigger's reason
+// clamped to its deadline
+func newDrainHelper(ctx context.Context, clientset kubernetes.Interface, drainCfg config.DrainConfig, trigger Trigger) *drain.Helper {
+	vals := ctx.Value("values").(*config.ContextValues)
+	log := vals.Logger
 
 	// hack: use the logger wrapper to make the zap logger compatible with the drain helper
 	errWrap := &logger{log: log, level: "error"}
 	logWrap := &logger{log: log, level: "info"}
 
-	drainHelper := &drain.Helper{
+	helper := &drain.Helper{
 		Client:              clientset,
 		Ctx:                 ctx,
-		Force:               true,
-		DeleteEmptyDirData:  true,
-		IgnoreAllDaemonSets: true,
+		Force:               drainCfg.Force,
+		DeleteEmptyDirData:  drainCfg.DeleteEmptyDirData,
+		IgnoreAllDaemonSets: drainCfg.IgnoreAllDaemonSets,
+		DisableEviction:     drainCfg.DisableEviction,
 		GracePeriodSeconds:  -1,
+		Timeout:             drainTimeout(drainCfg.TimeoutFor(trigger.Reason), trigger.Deadline),
+		AdditionalFilters:   []drain.PodFilter{protectedEmptyDirFilter(ctx, drainCfg), localStorageFilter(ctx, clientset, drainCfg)},
 		Out:                 logWrap,
 		ErrOut:              errWrap,
 	}
 
-	if err := drain.RunNodeDrain(drainHelper, node.Name); err != nil {
-		return false, err
+	// the helper evicts pods concurrently and calls this hook right before each eviction request, so blocking on the
+	// limiter here spaces out the evictions without serializing the wait for pods to terminate.
+	if drainCfg.EvictionRatePerSecond > 0 {
+		limiter := rate.NewLimiter(rate.Limit(drainCfg.EvictionRatePerSecond), 1)
+		helper.OnPodDeletionOrEvictionStarted = func(pod *v1.Pod, usingEviction bool) {
+			if err := limiter.Wait(ctx); err != nil {
+				log.Debugw("Eviction rate limiter wait ended early", "pod", pod.Name, "namespace", pod.Namespace, "error", err, "traceCtx", ctx)
+			}
+		}
 	}
 
-	return true, nil
+	if drainCfg.EventOnEvictedPods && vals.Recorder != nil {
+		helper.OnPodDeletionOrEvictionFinished = func(pod *v1.Pod, usingEviction bool, err error) {
+			if err != nil {
+				return
+			}
+			vals.Recorder.Eventf(pod, v1.EventTypeNormal, "EvictedByMechanic",
+				"Pod evicted by mechanic while draining node %s for maintenance (%s: %s)", pod.Spec.NodeName, trigger.Category, trigger.Reason)
+		}
+	}
+
+	return helper
+}
+
+// drainTimeout returns the configured drain timeout, shortened so the drain ends by the deadline. A zero timeout means
+// no timeout and a zero deadline means there's no deadline. Once the deadline is close or has passed the drain still
+// gets minDrainTimeout to evict what it can, unless the configured timeout is shorter still.
+func drainTimeout(configured time.Duration, deadline time.Time) time.Duration {
+	if deadline.IsZero() {
+		return configured
+	}
+
+	// the minimum only keeps a close deadline from cutting the drain short, it never lengthens the configured timeout
+	remaining := max(time.Until(deadline), minDrainTimeout)
+	if configured > 0 && configured < remaining {
+		return configured
+	}
+	return remaining
 }
 
-func ValidateCordon(ctx context.Context, clientset kubernetes.Interface, node *v1.Node, recorder record.EventRecorder) {
+func ValidateCordon(ctx context.Context, clientset kubernetes.Interface, node *v1.Node, cfg config.Config, recorder record.EventRecorder) {
 	tracer := otel.Tracer("github.com/amargherio/mechanic/pkg/node")
 	ctx, span := tracer.Start(ctx, "ValidateCordon")
 	defer span.End()
@@ -192,21 +424,23 @@ func ValidateCordon(ctx context.Context, clientset kubernetes.Interface, node *v
 	// - node is not cordoned but our state is: we need to reconcile the state
 
 	// checking if we have a scheduled event. if we do, we should make sure node and app state is in sync
-	if vals.State.HasEventScheduled {
-		if vals.State.IsCordoned && !node.Spec.Unschedulable {
+	if vals.State.EventScheduled() {
+		if vals.State.Cordoned() && !node.Spec.Unschedulable {
 			log.Debugw("Node has an upcoming event scheduled, state shows cordoned but node is not. Cordon the node.", "node", node.Name, "state", vals.State, "traceCtx", ctx)
-			isCordoned, err := CordonNode(ctx, clientset, node)
+			// the trigger annotations survive a manual uncordon, so reuse them when restoring our cordon
+			trigger := triggerFromNode(node)
+			isCordoned, err := CordonNode(ctx, clientset, node, cfg, trigger)
 			if err != nil {
 				log.Errorw("Failed to cordon node", "node", node.Name, "error", err, "traceCtx", ctx)
-				recorder.Eventf(node, v1.EventTypeWarning, "CordonNode", "Failed to cordon node %s", node.Name)
+				TriggerEventf(recorder, node, trigger, v1.EventTypeWarning, "CordonNode", "Failed to cordon node %s", node.Name)
 			} else {
 				log.Infow("Node cordoned", "node", node.Name, "traceCtx", ctx)
-				recorder.Eventf(node, v1.EventTypeNormal, "CordonNode", "Node %s cordoned by mechanic", node.Name)
-				vals.State.IsCordoned = isCordoned
+				TriggerEventf(recorder, node, trigger, v1.EventTypeNormal, "CordonNode", "Node %s cordoned by mechanic", node.Name)
+				vals.State.SetCordoned(isCordoned)
 			}
-		} else if !vals.State.IsCordoned && node.Spec.Unschedulable {
+		} else if !vals.State.Cordoned() && node.Spec.Unschedulable {
 			log.Debugw("Node has an upcoming event scheduled, state shows not cordoned but node is. Update state to reflect actual configuration.", "node", node.Name, "state", vals.State, "traceCtx", ctx)
-			vals.State.IsCordoned = true
+			vals.State.SetCordoned(true)
 		} else {
 			log.Debugw("No need to check for unneeded cordon, event is scheduled", "node", node.Name, "state", vals.State, "traceCtx", ctx)
 		}
@@ -215,58 +449,67 @@ func ValidateCordon(ctx context.Context, clientset kubernetes.Interface, node *v
 	}
 
 	// we don't have an upcoming event, so check if it's cordoned or not
-	if vals.State.IsCordoned {
+	if vals.State.Cordoned() {
 		// did we cordon it? if so, our label should be there and we can uncordon. if the label is missing, we don't touch
 		// the cordon because we can't guarantee we're the ones that cordoned it
-		if _, ok := node.Labels["mechanic.cordoned"]; ok {
+		if ownsCordon(node, cfg.Cordon) {
 			log.Infow("Node is cordoned by mechanic but no scheduled events found. Uncordoning node and removing the label", "node", node.Name, "traceCtx", ctx)
 
-			err := UncordonNode(ctx, clientset, node)
+			err := UncordonNode(ctx, clientset, node, cfg)
 			if err != nil {
 				log.Errorw("Failed to uncordon node", "node", node.Name, "error", err, "traceCtx", ctx)
-				recorder.Eventf(node, v1.EventTypeWarning, "UncordonNode", "Failed to uncordon node %s", node.Name)
+				Eventf(recorder, node, v1.EventTypeWarning, "UncordonNode", "Failed to uncordon node %s", node.Name)
 			} else {
 				log.Infow("Node uncordoned", "node", node.Name, "traceCtx", ctx)
-				recorder.Eventf(node, v1.EventTypeNormal, "UncordonNode", "Node %s uncordoned by mechanic", node.Name)
-				vals.State.IsCordoned = false
+				Eventf(recorder, node, v1.EventTypeNormal, "UncordonNode", "Node %s uncordoned by mechanic", node.Name)
+				vals.State.SetCordoned(false)
 			}
 		} else {
-			vals.State.IsCordoned = true
+			vals.State.SetCordoned(true)
 			log.Infow("Node is cordoned but does not have the mechanic label - no action required to uncordon", "node", node.Name, "state", vals.State, "traceCtx", ctx)
+			setCordonNotManagedAnnotation(ctx, node, clientset, cfg, node.Spec.Unschedulable)
 		}
 	} else {
 		// our state shows it's not cordoned, so we should check if state is out of sync and reconcile
 		if node.Spec.Unschedulable {
-			if _, ok := node.Labels["mechanic.cordoned"]; ok {
+			if ownsCordon(node, cfg.Cordon) {
 				log.Warnw("Node is cordoned but our state shows it's not. No upcoming events so uncordoning the node and removing the label", "node", node.Name, "traceCtx", ctx)
-				err := UncordonNode(ctx, clientset, node)
+				err := UncordonNode(ctx, clientset, node, cfg)
 				if err != nil {
 					log.Errorw("Failed to uncordon node", "node", node.Name, "error", err, "traceCtx", ctx)
-					recorder.Eventf(node, v1.EventTypeWarning, "UncordonNode", "Failed to uncordon node %s", node.Name)
+					Eventf(recorder, node, v1.EventTypeWarning, "UncordonNode", "Failed to uncordon node %s", node.Name)
 				} else {
 					log.Infow("Node uncordoned", "node", node.Name, "traceCtx", ctx)
-					recorder.Eventf(node, v1.EventTypeNormal, "UncordonNode", "Node %s uncordoned by mechanic", node.Name)
-					vals.State.IsCordoned = false
-					removeMechanicCordonLabel(ctx, node, clientset)
+					Eventf(recorder, node, v1.EventTypeNormal, "UncordonNode", "Node %s uncordoned by mechanic", node.Name)
+					vals.State.SetCordoned(false)
+					removeMechanicCordonLabel(ctx, node, clientset, cfg)
 				}
 			} else {
 				log.Infow("Node is cordoned but no mechanic label found - no action required", "node", node.Name, "traceCtx", ctx)
+				setCordonNotManagedAnnotation(ctx, node, clientset, cfg, true)
 			}
+		} else {
+			// node isn't cordoned, so make sure we don't have a stale annotation left behind
+			setCordonNotManagedAnnotation(ctx, node, clientset, cfg, false)
 		}
 	}
 
 	// at this point we've either left the node cordoned because we didn't cordon it or we've released our cordon.
 	// clean up the app state and return
-	if vals.State.ShouldDrain {
-		vals.State.ShouldDrain = false
+	if vals.State.DrainRequired() {
+		vals.State.SetDrainRequired(false)
 	}
 
-	if vals.State.IsDrained {
-		vals.State.IsDrained = false
+	if vals.State.Drained() {
+		vals.State.SetDrained(false)
 	}
+	vals.State.ResetDrainFailures()
 }
 
-func CheckNodeConditions(ctx context.Context, node *v1.Node, drainConditions config.DrainConditions) bool {
+// CheckNodeConditions checks the node for drainable scheduled event conditions and returns the types of the ones that
+// are true, in the order they're listed on the node. It returns nothing when the node has no drainable condition that's
+// true.
+func CheckNodeConditions(ctx context.Context, node *v1.Node, drainConditions config.DrainConditions) []string {
 	tracer := otel.Tracer("github.com/amargherio/mechanic/pkg/node")
 	ctx, span := tracer.Start(ctx, "CheckNodeConditions")
 	defer span.End()
@@ -274,58 +517,35 @@ func CheckNodeConditions(ctx context.Context, node *v1.Node, drainConditions con
 	vals := ctx.Value("values").(*config.ContextValues)
 	log := vals.Logger
 
-	// iterate through the DrainConditions fields and build a list of drainable node conditions
-	// todo: this feels hacky...should be a better way to do this
-	drainableConditions := make([]string, 0)
-	drainableConditions = append(drainableConditions, "VMEventScheduled") // always cover a generic `VMEventScheduled` condition
-
-	if drainConditions.DrainOnFreeze {
-		drainableConditions = append(drainableConditions, "FreezeScheduled")
-	}
-	if drainConditions.DrainOnReboot {
-		drainableConditions = append(drainableConditions, "RebootScheduled")
-	}
-	if drainConditions.DrainOnRedeploy {
-		drainableConditions = append(drainableConditions, "RedeployScheduled")
-	}
-	if drainConditions.DrainOnPreempt {
-		drainableConditions = append(drainableConditions, "PreemptScheduled")
-	}
-	if drainConditions.DrainOnTerminate {
-		drainableConditions = append(drainableConditions, "TerminateScheduled")
-	}
+	// the drainable node conditions follow the enabled event types, with any configured condition name overrides
+	drainableConditions := drainConditions.DrainableConditions()
 
-	resp := false
-	conditions := node.Status.Conditions
-	for _, condition := range conditions {
-		if resp {
+	var matched []string
+	for _, condition := range node.Status.Conditions {
+		if !slices.Contains(drainableConditions, string(condition.Type)) || condition.Status != v1.ConditionTrue {
+			continue
+		}
+		log.Infow("Node has an upcoming scheduled event. Flagging for impact assessment.",
+			"node", node.Name,
+			"type", condition.Type,
+			"lastTransitionTime", condition.LastTransitionTime,
+			"reason", condition.Reason,
+			"message", condition.Message,
+			"traceCtx", ctx)
+		matched = append(matched, string(condition.Type))
+		// once every drainable condition has been found the rest of the conditions can't add to the matches
+		if len(matched) == len(drainableConditions) {
 			break
-		} else {
-			if slices.Contains(drainableConditions, string(condition.Type)) {
-				// check the status of the condition. if it's true, update state.HasEventScheduled to true. if it's false, reset it to false and
-				// remove the cordon if we're the ones who cordoned it
-				switch condition.Status {
-				case "True":
-					log.Infow("Node has an upcoming scheduled event. Flagging for impact assessment.",
-						"node", node.Name,
-						"type", condition.Type,
-						"lastTransitionTime", condition.LastTransitionTime,
-						"reason", condition.Reason,
-						"message", condition.Message,
-						"traceCtx", ctx)
-					resp = true
-				case "False":
-					log.Infow("Node has no upcoming scheduled events", "node", node.Name, "traceCtx", ctx)
-					resp = false
-				}
-				break
-			}
 		}
 	}
-	return resp
+	// with none of them true there's nothing scheduled, and the cordon is removed if we're the ones who cordoned it
+	if len(matched) == 0 {
+		log.Infow("Node has no upcoming scheduled events", "node", node.Name, "traceCtx", ctx)
+	}
+	return matched
 }
 
-func removeMechanicCordonLabel(ctx context.Context, node *v1.Node, clientset kubernetes.Interface) {
+func removeMechanicCordonLabel(ctx context.Context, node *v1.Node, clientset kubernetes.Interface, cfg config.Config) {
 	tracer := otel.Tracer("github.com/amargherio/mechanic/pkg/node")
 	ctx, span := tracer.Start(ctx, "removeMechanicCordonLabel")
 	defer span.End()
@@ -333,21 +553,63 @@ func removeMechanicCordonLabel(ctx context.Context, node *v1.Node, clientset kub
 	vals := ctx.Value("values").(*config.ContextValues)
 	log := vals.Logger
 
-	retryErr := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+	retryErr := retryNodeUpdate(ctx, cfg.NodeUpdateRetry, func() error {
 		n, err := clientset.CoreV1().Nodes().Get(ctx, node.Name, metav1.GetOptions{})
 		if err != nil {
 			return err
 		}
 
-		labels := n.GetLabels()
-		delete(labels, "mechanic.cordoned")
-		n.SetLabels(labels)
+		removeCordonLabel(n, cfg.Cordon)
 
 		_, err = clientset.CoreV1().Nodes().Update(ctx, n, metav1.UpdateOptions{})
 		return err
 	})
 	if retryErr != nil {
 		log.Warnw("Failed to remove mechanic label from node - retry error encountered", "node", node.Name, "error", retryErr, "traceCtx", ctx)
+		return
 	}
 	log.Debugw("Mechanic label removed from node", "node", node.Name, "traceCtx", ctx)
+	clearPersistedState(ctx, clientset, node, cfg)
+}
+
+// setCordonNotManagedAnnotation adds or removes the annotation marking a cordon as not managed by mechanic. The node is
+// only updated when the annotation doesn't already match the requested state.
+func setCordonNotManagedAnnotation(ctx context.Context, node *v1.Node, clientset kubernetes.Interface, cfg config.Config, notManaged bool) {
+	tracer := otel.Tracer("github.com/amargherio/mechanic/pkg/node")
+	ctx, span := tracer.Start(ctx, "setCordonNotManagedAnnotation")
+	defer span.End()
+
+	vals := ctx.Value("values").(*config.ContextValues)
+	log := vals.Logger
+
+	_, present := node.GetAnnotations()[cordonNotManagedAnnotation]
+	if present == notManaged {
+		return
+	}
+
+	retryErr := retryNodeUpdate(ctx, cfg.NodeUpdateRetry, func() error {
+		n, err := clientset.CoreV1().Nodes().Get(ctx, node.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		annotations := n.GetAnnotations()
+		if notManaged {
+			if annotations == nil {
+				annotations = make(map[string]string)
+			}
+			annotations[cordonNotManagedAnnotation] = "true"
+		} else {
+			delete(annotations, cordonNotManagedAnnotation)
+		}
+		n.SetAnnotations(annotations)
+
+		_, err = clientset.CoreV1().Nodes().Update(ctx, n, metav1.UpdateOptions{})
+		return err
+	})
+	if retryErr != nil {
+		log.Warnw("Failed to update cordon-not-managed annotation on node - retry error encountered", "node", node.Name, "notManaged", notManaged, "error", retryErr, "traceCtx", ctx)
+		return
+	}
+	log.Debugw("Updated cordon-not-managed annotation on node", "node", node.Name, "notManaged", notManaged, "traceCtx", ctx)
 }
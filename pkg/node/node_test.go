@@ -4,7 +4,10 @@ import (
 	"context"
 	"fmt"
 	"k8s.io/apimachinery/pkg/runtime"
+	"slices"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/amargherio/mechanic/internal/appstate"
 	"github.com/amargherio/mechanic/internal/config"
@@ -12,13 +15,18 @@ import (
 
 	"go.uber.org/zap/zaptest"
 	v1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/kubectl/pkg/drain"
 )
 
 // Mock for event recorder, required for some of the node operation logic
 type MockRecorder struct {
-	Events []string
+	Events      []string
+	Annotations []map[string]string
 }
 
 func (m *MockRecorder) Event(object runtime.Object, eventtype, reason, message string) {
@@ -35,6 +43,7 @@ func (m *MockRecorder) PastEventf(object runtime.Object, timestamp metav1.Time,
 
 func (m *MockRecorder) AnnotatedEventf(object runtime.Object, annotations map[string]string, eventtype, reason, messageFmt string, args ...interface{}) {
 	m.Events = append(m.Events, eventtype+" "+reason+" "+fmt.Sprintf(messageFmt, args...))
+	m.Annotations = append(m.Annotations, annotations)
 }
 
 func TestCordonNode(t *testing.T) {
@@ -97,16 +106,16 @@ func TestCordonNode(t *testing.T) {
 
 			ctx := context.WithValue(context.Background(), "values", &vals)
 
-			cordoned, err := CordonNode(ctx, clientset, node)
+			cordoned, err := CordonNode(ctx, clientset, node, config.Config{}, Trigger{Category: TriggerCategoryEvent, Reason: "Freeze"})
 			if (err != nil) != tc.expectError {
 				t.Errorf("CordonNode() error = %v, expectError %v", err, tc.expectError)
 				return
 			}
-			state.IsCordoned = cordoned
+			state.SetCordoned(cordoned)
 			updatedNode, _ := clientset.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
 
 			assert.Equal(t, tc.expectedCordon, updatedNode.Spec.Unschedulable, "Expected node.Spec.Unschedulable to be %v, got %v", tc.expectedCordon, updatedNode.Spec.Unschedulable)
-			assert.Equal(t, tc.expectedCordon, state.IsCordoned, "Expected state.IsCordoned to be %v, got %v", tc.expectedCordon, state.IsCordoned)
+			assert.Equal(t, tc.expectedCordon, state.Cordoned(), "Expected state.Cordoned() to be %v, got %v", tc.expectedCordon, state.Cordoned())
 
 			// clean up and prep for next test
 			err = clientset.CoreV1().Nodes().Delete(ctx, nodeName, metav1.DeleteOptions{})
@@ -162,13 +171,13 @@ func TestDrainNode(t *testing.T) {
 
 			ctx := context.WithValue(context.Background(), "values", &vals)
 
-			drained, err := DrainNode(ctx, clientset, node)
+			drained, err := DrainNode(ctx, clientset, node, config.DrainConfig{}, Trigger{})
 			if (err != nil) != tc.expectError {
 				t.Errorf("DrainNode() error = %v, expectError %v", err, tc.expectError)
 			}
-			state.IsDrained = drained
+			state.SetDrained(drained)
 
-			assert.Equal(t, tc.expectedState, state.IsDrained, "Expected state.IsDrained to be %v, got %v", tc.expectedState, state.IsDrained)
+			assert.Equal(t, tc.expectedState, state.Drained(), "Expected state.Drained() to be %v, got %v", tc.expectedState, state.Drained())
 		})
 	}
 }
@@ -372,7 +381,7 @@ func TestValidateCordon(t *testing.T) {
 				t.Errorf("Error creating node: %v", err)
 			}
 
-			ValidateCordon(ctx, clientset, node, recorder)
+			ValidateCordon(ctx, clientset, node, config.Config{}, recorder)
 			updatedNode, _ := clientset.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
 
 			assert.Equal(t, &tc.expectedState, &tc.inputState, "Expected state to be %v, got %v", &tc.expectedState, &tc.inputState)
@@ -390,9 +399,9 @@ func TestCheckNodeConditions(t *testing.T) {
 	log := logger.Sugar()
 
 	tests := []struct {
-		name             string
-		prepNodeFunc     func(*v1.Node)
-		expectedResponse bool
+		name               string
+		prepNodeFunc       func(*v1.Node)
+		expectedConditions []string
 	}{
 		{
 			name: "node has VMScheduledEvent",
@@ -402,7 +411,7 @@ func TestCheckNodeConditions(t *testing.T) {
 					Status: v1.ConditionTrue,
 				})
 			},
-			expectedResponse: true,
+			expectedConditions: []string{"VMEventScheduled"},
 		},
 		{
 			name: "node has FreezeScheduled",
@@ -412,7 +421,7 @@ func TestCheckNodeConditions(t *testing.T) {
 					Status: v1.ConditionTrue,
 				})
 			},
-			expectedResponse: true,
+			expectedConditions: []string{"FreezeScheduled"},
 		},
 		{
 			name: "node has RebootScheduled",
@@ -422,7 +431,7 @@ func TestCheckNodeConditions(t *testing.T) {
 					Status: v1.ConditionTrue,
 				})
 			},
-			expectedResponse: true,
+			expectedConditions: []string{"RebootScheduled"},
 		},
 		{
 			name: "node has RedeployScheduled",
@@ -432,7 +441,7 @@ func TestCheckNodeConditions(t *testing.T) {
 					Status: v1.ConditionTrue,
 				})
 			},
-			expectedResponse: true,
+			expectedConditions: []string{"RedeployScheduled"},
 		},
 		{
 			name: "node has PreemptScheduled",
@@ -442,7 +451,7 @@ func TestCheckNodeConditions(t *testing.T) {
 					Status: v1.ConditionTrue,
 				})
 			},
-			expectedResponse: true,
+			expectedConditions: []string{"PreemptScheduled"},
 		},
 		{
 			name: "node has TerminateScheduled",
@@ -452,13 +461,24 @@ func TestCheckNodeConditions(t *testing.T) {
 					Status: v1.ConditionTrue,
 				})
 			},
-			expectedResponse: true,
+			expectedConditions: []string{"TerminateScheduled"},
 		},
 		{
 			name: "node has no scheduled events",
 			prepNodeFunc: func(n *v1.Node) {
 			},
-			expectedResponse: false,
+			expectedConditions: nil,
+		},
+		{
+			name: "node has several conditions",
+			prepNodeFunc: func(n *v1.Node) {
+				n.Status.Conditions = append(n.Status.Conditions,
+					v1.NodeCondition{Type: v1.NodeConditionType("VMEventScheduled"), Status: v1.ConditionTrue},
+					v1.NodeCondition{Type: v1.NodeConditionType("FreezeScheduled"), Status: v1.ConditionFalse},
+					v1.NodeCondition{Type: v1.NodeConditionType("PreemptScheduled"), Status: v1.ConditionTrue},
+				)
+			},
+			expectedConditions: []string{"VMEventScheduled", "PreemptScheduled"},
 		},
 	}
 
@@ -485,7 +505,378 @@ func TestCheckNodeConditions(t *testing.T) {
 				DrainOnPreempt:   true,
 				DrainOnTerminate: true,
 			})
-			assert.Equal(t, tc.expectedResponse, response, "Expected response to be %v, got %v", tc.expectedResponse, response)
+			assert.Equal(t, tc.expectedConditions, response)
+		})
+	}
+}
+
+func TestCheckNodeConditionsOverrides(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+
+	dc := config.DrainConditions{
+		DrainOnPreempt:  true,
+		DrainOnRedeploy: false,
+		ConditionOverrides: map[string]string{
+			"preempt":  "SpotEvictionScheduled",
+			"redeploy": "HostMaintenanceScheduled",
+		},
+	}
+
+	tests := []struct {
+		name             string
+		condition        string
+		expectedResponse bool
+	}{
+		{name: "overridden condition for a drainable event", condition: "SpotEvictionScheduled", expectedResponse: true},
+		{name: "default condition for an overridden event is ignored", condition: "PreemptScheduled", expectedResponse: false},
+		{name: "overridden condition for an event we don't drain for", condition: "HostMaintenanceScheduled", expectedResponse: false},
+		{name: "generic condition is always drainable", condition: "VMEventScheduled", expectedResponse: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			vals := config.ContextValues{Logger: logger.Sugar(), State: &appstate.State{}}
+			ctx := context.WithValue(context.Background(), "values", &vals)
+
+			node := &v1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-node"},
+				Status: v1.NodeStatus{Conditions: []v1.NodeCondition{
+					{Type: v1.NodeConditionType(tc.condition), Status: v1.ConditionTrue},
+				}},
+			}
+			conditions := CheckNodeConditions(ctx, node, dc)
+			if tc.expectedResponse {
+				assert.Equal(t, []string{tc.condition}, conditions)
+			} else {
+				assert.Empty(t, conditions)
+			}
+		})
+	}
+}
+
+func TestValidateCordonNotManagedAnnotation(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	log := logger.Sugar()
+
+	tests := []struct {
+		name               string
+		prepNodeFunc       func(*v1.Node)
+		inputState         *appstate.State
+		expectedAnnotation bool
+		expectedCordon     bool
+	}{
+		{
+			name: "cordoned by someone else, state uncordoned",
+			prepNodeFunc: func(n *v1.Node) {
+				n.Spec.Unschedulable = true
+			},
+			inputState:         &appstate.State{},
+			expectedAnnotation: true,
+			expectedCordon:     true,
+		},
+		{
+			name: "cordoned by someone else, state cordoned",
+			prepNodeFunc: func(n *v1.Node) {
+				n.Spec.Unschedulable = true
+			},
+			inputState:         &appstate.State{IsCordoned: true},
+			expectedAnnotation: true,
+			expectedCordon:     true,
+		},
+		{
+			name: "external cordon released, stale annotation removed",
+			prepNodeFunc: func(n *v1.Node) {
+				n.Annotations[cordonNotManagedAnnotation] = "true"
+			},
+			inputState:         &appstate.State{},
+			expectedAnnotation: false,
+			expectedCordon:     false,
+		},
+		{
+			name: "mechanic managed cordon released, annotation removed",
+			prepNodeFunc: func(n *v1.Node) {
+				n.Spec.Unschedulable = true
+				n.Labels["mechanic.cordoned"] = "true"
+				n.Annotations[cordonNotManagedAnnotation] = "true"
+			},
+			inputState:         &appstate.State{IsCordoned: true},
+			expectedAnnotation: false,
+			expectedCordon:     false,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			vals := config.ContextValues{
+				Logger: log,
+				State:  tc.inputState,
+			}
+			ctx := context.WithValue(context.Background(), "values", &vals)
+
+			node := &v1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "test-node",
+					Labels:      make(map[string]string),
+					Annotations: make(map[string]string),
+				},
+			}
+			tc.prepNodeFunc(node)
+			clientset := fake.NewClientset(node)
+
+			ValidateCordon(ctx, clientset, node, config.Config{}, &MockRecorder{})
+			updatedNode, _ := clientset.CoreV1().Nodes().Get(ctx, node.Name, metav1.GetOptions{})
+
+			_, annotated := updatedNode.Annotations[cordonNotManagedAnnotation]
+			assert.Equal(t, tc.expectedAnnotation, annotated, "Expected cordon-not-managed annotation presence to be %v", tc.expectedAnnotation)
+			assert.Equal(t, tc.expectedCordon, updatedNode.Spec.Unschedulable)
+		})
+	}
+}
+
+func TestNewDrainHelperTimeout(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	log := logger.Sugar()
+
+	drainCfg := config.DrainConfig{
+		Timeout: 5 * time.Minute,
+		TimeoutsByReason: map[string]time.Duration{
+			"reboot":  15 * time.Minute,
+			"preempt": 30 * time.Second,
+		},
+	}
+
+	tests := []struct {
+		name     string
+		reason   string
+		expected time.Duration
+	}{
+		{name: "reboot uses its override", reason: "Reboot", expected: 15 * time.Minute},
+		{name: "preempt uses its override", reason: "Preempt", expected: 30 * time.Second},
+		{name: "redeploy uses the global timeout", reason: "Redeploy", expected: 5 * time.Minute},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			vals := config.ContextValues{
+				Logger: log,
+				State:  &appstate.State{},
+			}
+			ctx := context.WithValue(context.Background(), "values", &vals)
+
+			helper := newDrainHelper(ctx, fake.NewClientset(), drainCfg, Trigger{Reason: tc.reason})
+			assert.Equal(t, tc.expected, helper.Timeout)
+		})
+	}
+}
+
+func TestNewDrainHelperDeadline(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	log := logger.Sugar()
+
+	tests := []struct {
+		name      string
+		timeout   time.Duration
+		deadline  time.Time
+		expectMin time.Duration
+		expectMax time.Duration
+	}{
+		{name: "no deadline keeps the configured timeout", timeout: 5 * time.Minute, expectMin: 5 * time.Minute, expectMax: 5 * time.Minute},
+		{name: "deadline before the timeout clamps it", timeout: 5 * time.Minute, deadline: time.Now().Add(2 * time.Minute), expectMin: 119 * time.Second, expectMax: 2 * time.Minute},
+		{name: "deadline after the timeout keeps the timeout", timeout: 5 * time.Minute, deadline: time.Now().Add(10 * time.Minute), expectMin: 5 * time.Minute, expectMax: 5 * time.Minute},
+		{name: "deadline clamps an unlimited timeout", timeout: 0, deadline: time.Now().Add(2 * time.Minute), expectMin: 119 * time.Second, expectMax: 2 * time.Minute},
+		{name: "passed deadline uses the minimum timeout", timeout: 5 * time.Minute, deadline: time.Now().Add(-time.Minute), expectMin: minDrainTimeout, expectMax: minDrainTimeout},
+		{name: "passed deadline keeps a timeout shorter than the minimum", timeout: 5 * time.Second, deadline: time.Now().Add(-time.Minute), expectMin: 5 * time.Second, expectMax: 5 * time.Second},
+		{name: "close deadline keeps a timeout shorter than the minimum", timeout: 3 * time.Second, deadline: time.Now().Add(5 * time.Second), expectMin: 3 * time.Second, expectMax: 3 * time.Second},
+		{name: "passed deadline with an unlimited timeout uses the minimum", timeout: 0, deadline: time.Now().Add(-time.Minute), expectMin: minDrainTimeout, expectMax: minDrainTimeout},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			vals := config.ContextValues{
+				Logger: log,
+				State:  &appstate.State{},
+			}
+			ctx := context.WithValue(context.Background(), "values", &vals)
+
+			helper := newDrainHelper(ctx, fake.NewClientset(), config.DrainConfig{Timeout: tc.timeout}, Trigger{Reason: "Freeze", Deadline: tc.deadline})
+			assert.GreaterOrEqual(t, helper.Timeout, tc.expectMin)
+			assert.LessOrEqual(t, helper.Timeout, tc.expectMax)
+		})
+	}
+}
+
+// newEvictionTestClientset returns a fake clientset that advertises the eviction subresource. Evictions are recorded
+// and complete immediately by removing the pod.
+func newEvictionTestClientset(evicted *[]time.Time, objects ...runtime.Object) *fake.Clientset {
+	clientset := fake.NewClientset(objects...)
+	clientset.Resources = []*metav1.APIResourceList{
+		{
+			GroupVersion: "v1",
+			APIResources: []metav1.APIResource{
+				{Name: drain.EvictionSubresource, Kind: drain.EvictionKind, Group: "policy", Version: "v1"},
+			},
+		},
+	}
+
+	var mu sync.Mutex
+	clientset.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "eviction" {
+			return false, nil, nil
+		}
+
+		mu.Lock()
+		*evicted = append(*evicted, time.Now())
+		mu.Unlock()
+
+		eviction := action.(k8stesting.CreateAction).GetObject().(*policyv1.Eviction)
+		err := clientset.Tracker().Delete(v1.SchemeGroupVersion.WithResource("pods"), eviction.Namespace, eviction.Name)
+		return true, nil, err
+	})
+
+	return clientset
+}
+
+func TestNewDrainHelperOptions(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	vals := config.ContextValues{
+		Logger: logger.Sugar(),
+		State:  &appstate.State{},
+	}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	tests := []struct {
+		name     string
+		drainCfg config.DrainConfig
+	}{
+		{name: "all enabled", drainCfg: config.DrainConfig{Force: true, DeleteEmptyDirData: true, IgnoreAllDaemonSets: true}},
+		{name: "all disabled", drainCfg: config.DrainConfig{}},
+		{name: "keep emptyDir data", drainCfg: config.DrainConfig{Force: true, IgnoreAllDaemonSets: true}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			helper := newDrainHelper(ctx, fake.NewClientset(), tc.drainCfg, Trigger{})
+			assert.Equal(t, tc.drainCfg.Force, helper.Force)
+			assert.Equal(t, tc.drainCfg.DeleteEmptyDirData, helper.DeleteEmptyDirData)
+			assert.Equal(t, tc.drainCfg.IgnoreAllDaemonSets, helper.IgnoreAllDaemonSets)
+		})
+	}
+}
+
+func TestDrainNodeWithoutForce(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	vals := config.ContextValues{
+		Logger: logger.Sugar(),
+		State:  &appstate.State{IsCordoned: true},
+	}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-node"}}
+	// a bare pod with no controller to recreate it elsewhere
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "bare", Namespace: "default"},
+		Spec:       v1.PodSpec{NodeName: node.Name},
+	}
+	var evicted []time.Time
+	clientset := newEvictionTestClientset(&evicted, node, pod)
+
+	drained, err := DrainNode(ctx, clientset, node, config.DrainConfig{IgnoreAllDaemonSets: true}, Trigger{})
+	assert.Error(t, err, "the drain should fail rather than evict an unmanaged pod")
+	assert.False(t, drained)
+	assert.Empty(t, evicted)
+}
+
+func TestDrainNodeEvictionRateLimit(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	log := logger.Sugar()
+
+	vals := config.ContextValues{
+		Logger: log,
+		State:  &appstate.State{IsCordoned: true},
+	}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-node"}}
+	objects := []runtime.Object{node}
+	for i := 0; i < 4; i++ {
+		objects = append(objects, &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("pod-%d", i), Namespace: "default"},
+			Spec:       v1.PodSpec{NodeName: node.Name},
+		})
+	}
+
+	var evicted []time.Time
+	clientset := newEvictionTestClientset(&evicted, objects...)
+
+	drained, err := DrainNode(ctx, clientset, node, config.DrainConfig{EvictionRatePerSecond: 10, Force: true}, Trigger{})
+	assert.NoError(t, err)
+	assert.True(t, drained)
+	assert.Len(t, evicted, 4)
+
+	// evictions happen concurrently, so sort before checking the spacing between them
+	slices.SortFunc(evicted, func(a, b time.Time) int { return a.Compare(b) })
+	for i := 1; i < len(evicted); i++ {
+		// allow a little slack for scheduling, the limiter spaces evictions 100ms apart at 10/s
+		assert.GreaterOrEqual(t, evicted[i].Sub(evicted[i-1]), 90*time.Millisecond, "evictions %d and %d were not spaced out", i-1, i)
+	}
+}
+
+func TestDrainNodeEventOnEvictedPods(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	log := logger.Sugar()
+
+	tests := []struct {
+		name           string
+		enabled        bool
+		expectedEvents int
+	}{
+		{name: "events emitted when enabled", enabled: true, expectedEvents: 2},
+		{name: "no events when disabled", enabled: false, expectedEvents: 0},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := record.NewFakeRecorder(10)
+			vals := config.ContextValues{
+				Logger:   log,
+				State:    &appstate.State{IsCordoned: true},
+				Recorder: recorder,
+			}
+			ctx := context.WithValue(context.Background(), "values", &vals)
+
+			node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-node"}}
+			objects := []runtime.Object{node}
+			for i := 0; i < 2; i++ {
+				objects = append(objects, &v1.Pod{
+					ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("pod-%d", i), Namespace: "default"},
+					Spec:       v1.PodSpec{NodeName: node.Name},
+				})
+			}
+
+			var evicted []time.Time
+			clientset := newEvictionTestClientset(&evicted, objects...)
+
+			drained, err := DrainNode(ctx, clientset, node, config.DrainConfig{EventOnEvictedPods: tc.enabled, Force: true}, Trigger{Category: TriggerCategoryEvent, Reason: "Reboot"})
+			assert.NoError(t, err)
+			assert.True(t, drained)
+			close(recorder.Events)
+
+			var events []string
+			for e := range recorder.Events {
+				events = append(events, e)
+			}
+			assert.Len(t, events, tc.expectedEvents)
+			for _, e := range events {
+				assert.Equal(t, "Normal EvictedByMechanic Pod evicted by mechanic while draining node test-node for maintenance (event: Reboot)", e)
+			}
 		})
 	}
 }
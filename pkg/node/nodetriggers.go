@@ -0,0 +1,108 @@
+package node
+
+import (
+	"context"
+	"errors"
+
+	"github.com/amargherio/mechanic/internal/config"
+	"github.com/amargherio/mechanic/pkg/imds"
+	v1 "k8s.io/api/core/v1"
+)
+
+// eventLookup queries IMDS for the node's scheduled events on behalf of the trigger checks. ReconcileNode's lookups
+// record what they find in the state and report it, EvaluateNode's leave no trace.
+type eventLookup struct {
+	// impacting reports whether any scheduled event targets the node
+	impacting func(ctx context.Context) (bool, error)
+	// drainRequired reports whether a scheduled event requires draining the node, and the event when one does
+	drainRequired func(ctx context.Context) (bool, *imds.ScheduledEvent, error)
+}
+
+// nodeTriggers are what call for acting on a node: its scheduled event conditions, a sustained GPU health condition, a
+// maintenance taint added by an external operator, and, without any of those, an event found by polling IMDS
+type nodeTriggers struct {
+	eventConditions  []string
+	gpuCondition     string
+	maintenanceTaint string
+	polled           bool
+}
+
+// checkTriggers checks the node's conditions and taints for anything that calls for acting on it. IMDS isn't
+// queried, see poll.
+func checkTriggers(ctx context.Context, node *v1.Node, cfg config.Config) nodeTriggers {
+	return nodeTriggers{
+		eventConditions:  CheckNodeConditions(ctx, node, cfg.DrainConditions),
+		gpuCondition:     CheckGPUHealthConditions(ctx, node, cfg.GPUHealth),
+		maintenanceTaint: CheckMaintenanceTaints(ctx, node, cfg.MaintenanceTaints),
+	}
+}
+
+// scheduled reports whether anything calls for acting on the node
+func (t *nodeTriggers) scheduled() bool {
+	return len(t.eventConditions) > 0 || t.gpuCondition != "" || t.maintenanceTaint != "" || t.polled
+}
+
+// poll checks IMDS directly for an event targeting the node when nothing else calls for acting on it and the config
+// says to, like on clusters where nothing publishes the event conditions. Failures are logged and leave the node to its
+// conditions.
+func (t *nodeTriggers) poll(ctx context.Context, node *v1.Node, cfg config.Config, lookup eventLookup) {
+	if !cfg.AlsoPollIMDS || t.scheduled() {
+		return
+	}
+	log := ctx.Value("values").(*config.ContextValues).Logger
+
+	impacted, err := lookup.impacting(ctx)
+	if errors.Is(err, imds.ErrInvalidNodeName) {
+		// already reported with guidance by the IMDS check, don't repeat the error on every poll
+		log.Debugw("Unable to poll IMDS, node name can't be matched to scheduled events", "node", node.Name, "error", err, "traceCtx", ctx)
+	} else if err != nil {
+		log.Warnw("Failed to poll IMDS for scheduled events, relying on node conditions", "node", node.Name, "error", err, "traceCtx", ctx)
+	} else if impacted {
+		log.Infow("Polling IMDS found a scheduled event targeting the node that no node condition reports", "node", node.Name, "traceCtx", ctx)
+		t.polled = true
+	}
+}
+
+// resolve works out the triggers for the drain and whether one is required. A GPU condition or maintenance taint calls
+// for a drain without checking IMDS. Otherwise, or alongside them when combining triggers, IMDS is asked for a scheduled
+// event. An IMDS error is only returned when there's nothing else to drain for.
+func (t *nodeTriggers) resolve(ctx context.Context, node *v1.Node, cfg config.Config, lookup eventLookup) ([]Trigger, bool, error) {
+	log := ctx.Value("values").(*config.ContextValues).Logger
+
+	var triggers []Trigger
+	if t.gpuCondition != "" {
+		log.Infow("Node has a sustained GPU health condition, draining", "node", node.Name, "condition", t.gpuCondition, "traceCtx", ctx)
+		triggers = append(triggers, ConditionTrigger(t.gpuCondition))
+	}
+	if t.maintenanceTaint != "" {
+		log.Infow("Node has a maintenance taint, draining", "node", node.Name, "taint", t.maintenanceTaint, "traceCtx", ctx)
+		triggers = append(triggers, TaintTrigger(t.maintenanceTaint))
+	}
+	drainRequired := len(triggers) > 0
+	if len(triggers) > 0 && !(cfg.CombineTriggers && len(t.eventConditions) > 0) {
+		return triggers, drainRequired, nil
+	}
+
+	// query IMDS for more information on the scheduled event
+	b, e, err := lookup.drainRequired(ctx)
+	if err != nil && len(triggers) > 0 {
+		// the other triggers already call for a drain, so don't hold it up on IMDS
+		log.Warnw("Failed to query IMDS for a scheduled event alongside the node's other triggers, draining for those", "node", node.Name, "error", err, "traceCtx", ctx)
+		return triggers, drainRequired, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if e != nil {
+		eventTrigger := EventTrigger(e)
+		eventTrigger.Condition = eventCondition(t.eventConditions, &cfg.DrainConditions, e)
+		if !cfg.AnnotateMaintenanceDescription {
+			eventTrigger.Description = ""
+		}
+		// an event that isn't drainable on its own doesn't add to the node's other triggers
+		if b || len(triggers) == 0 {
+			triggers = append(triggers, eventTrigger)
+		}
+	}
+	return triggers, drainRequired || b, nil
+}
@@ -0,0 +1,160 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/amargherio/mechanic/internal/config"
+	"go.opentelemetry.io/otel"
+	v1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+)
+
+// PDBBlock is a PodDisruptionBudget that's preventing pods on the node from being evicted
+type PDBBlock struct {
+	Namespace string
+	Name      string
+	// Pods are the names of the pods on the node covered by the budget
+	Pods []string
+	// Unsatisfiable is true when the budget can never allow a disruption, like one with maxUnavailable set to 0, so
+	// waiting won't let the drain finish
+	Unsatisfiable bool
+}
+
+func (b PDBBlock) String() string {
+	return fmt.Sprintf("%s/%s (pods: %s)", b.Namespace, b.Name, strings.Join(b.Pods, ", "))
+}
+
+// errUnsatisfiablePDB is wrapped by the PDBBlockedError DrainNode returns without draining when a budget can never allow
+// a disruption
+var errUnsatisfiablePDB = fmt.Errorf("%w: budgets can never allow a disruption", ErrDrainPDBBlocked)
+
+// PDBBlockedError is returned by DrainNode when PDBs kept the drain from finishing before it timed out, or kept it from
+// starting because they can never be satisfied
+type PDBBlockedError struct {
+	Blocks []PDBBlock
+	err    error
+}
+
+func (e *PDBBlockedError) Error() string {
+	return fmt.Sprintf("eviction blocked by PodDisruptionBudgets %s: %v", e.describe(), e.err)
+}
+
+func (e *PDBBlockedError) Unwrap() error {
+	return e.err
+}
+
+// Unsatisfiable reports whether any of the blocking PDBs can never allow a disruption
+func (e *PDBBlockedError) Unsatisfiable() bool {
+	for _, block := range e.Blocks {
+		if block.Unsatisfiable {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *PDBBlockedError) describe() string {
+	blocks := make([]string, 0, len(e.Blocks))
+	for _, block := range e.Blocks {
+		blocks = append(blocks, block.String())
+	}
+	return strings.Join(blocks, "; ")
+}
+
+// FindBlockingPDBs returns the PodDisruptionBudgets covering pods on the node that don't currently allow a disruption
+func FindBlockingPDBs(ctx context.Context, clientset kubernetes.Interface, node *v1.Node) ([]PDBBlock, error) {
+	tracer := otel.Tracer("github.com/amargherio/mechanic/pkg/node")
+	ctx, span := tracer.Start(ctx, "FindBlockingPDBs")
+	defer span.End()
+
+	vals := ctx.Value("values").(*config.ContextValues)
+	log := vals.Logger
+
+	pods, err := clientset.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{FieldSelector: "spec.nodeName=" + node.Name})
+	if err != nil {
+		log.Errorw("Failed to list pods on node to check PodDisruptionBudgets", "node", node.Name, "error", err, "traceCtx", ctx)
+		return nil, err
+	}
+
+	pdbsByNamespace := make(map[string][]policyv1.PodDisruptionBudget)
+	var blocks []PDBBlock
+	for _, pod := range pods.Items {
+		// the field selector isn't applied by every client, so check the node again
+		if pod.Spec.NodeName != node.Name {
+			continue
+		}
+
+		pdbs, ok := pdbsByNamespace[pod.Namespace]
+		if !ok {
+			list, err := clientset.PolicyV1().PodDisruptionBudgets(pod.Namespace).List(ctx, metav1.ListOptions{})
+			if err != nil {
+				log.Errorw("Failed to list PodDisruptionBudgets", "namespace", pod.Namespace, "error", err, "traceCtx", ctx)
+				return nil, err
+			}
+			pdbs = list.Items
+			pdbsByNamespace[pod.Namespace] = pdbs
+		}
+
+		for _, pdb := range pdbs {
+			if pdb.Status.DisruptionsAllowed > 0 || !pdbCoversPod(pdb, pod) {
+				continue
+			}
+			blocks = addPDBBlock(blocks, pdb, pod.Name)
+		}
+	}
+
+	return blocks, nil
+}
+
+// addPDBBlock adds the pod to the block for the PDB, creating it on the PDB's first blocked pod
+func addPDBBlock(blocks []PDBBlock, pdb policyv1.PodDisruptionBudget, pod string) []PDBBlock {
+	for i := range blocks {
+		if blocks[i].Namespace == pdb.Namespace && blocks[i].Name == pdb.Name {
+			blocks[i].Pods = append(blocks[i].Pods, pod)
+			return blocks
+		}
+	}
+	return append(blocks, PDBBlock{
+		Namespace:     pdb.Namespace,
+		Name:          pdb.Name,
+		Pods:          []string{pod},
+		Unsatisfiable: isUnsatisfiablePDB(pdb),
+	})
+}
+
+func pdbCoversPod(pdb policyv1.PodDisruptionBudget, pod v1.Pod) bool {
+	// a nil selector matches no pods and an empty one matches every pod in the namespace, like the eviction API
+	selector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+	if err != nil {
+		return false
+	}
+	return selector.Matches(labels.Set(pod.Labels))
+}
+
+// isUnsatisfiablePDB reports whether the budget can never allow a disruption: maxUnavailable of 0 or 0%, or a
+// minAvailable of 100% or at least as many pods as it expects
+func isUnsatisfiablePDB(pdb policyv1.PodDisruptionBudget) bool {
+	if pdb.Spec.MaxUnavailable != nil {
+		return isZeroIntOrPercent(*pdb.Spec.MaxUnavailable)
+	}
+	if minAvailable := pdb.Spec.MinAvailable; minAvailable != nil {
+		if minAvailable.Type == intstr.String {
+			return minAvailable.StrVal == "100%"
+		}
+		return pdb.Status.ExpectedPods > 0 && minAvailable.IntVal >= pdb.Status.ExpectedPods
+	}
+	return false
+}
+
+func isZeroIntOrPercent(v intstr.IntOrString) bool {
+	if v.Type == intstr.String {
+		return v.StrVal == "0%"
+	}
+	return v.IntVal == 0
+}
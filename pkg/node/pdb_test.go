@@ -0,0 +1,140 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/amargherio/mechanic/internal/appstate"
+	"github.com/amargherio/mechanic/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+	v1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newTestPDB(name string, selector map[string]string, spec policyv1.PodDisruptionBudgetSpec, allowed, expected int32) *policyv1.PodDisruptionBudget {
+	spec.Selector = &metav1.LabelSelector{MatchLabels: selector}
+	return &policyv1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec:       spec,
+		Status:     policyv1.PodDisruptionBudgetStatus{DisruptionsAllowed: allowed, ExpectedPods: expected},
+	}
+}
+
+func TestFindBlockingPDBs(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	vals := config.ContextValues{Logger: logger.Sugar(), State: &appstate.State{}}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-node"}}
+	pod := func(name, app, nodeName string) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: map[string]string{"app": app}},
+			Spec:       v1.PodSpec{NodeName: nodeName},
+		}
+	}
+	one := intstr.FromInt32(1)
+	zero := intstr.FromInt32(0)
+
+	clientset := fake.NewClientset(
+		node,
+		pod("web-1", "web", node.Name),
+		pod("web-2", "web", node.Name),
+		pod("db-1", "db", node.Name),
+		pod("cache-1", "cache", node.Name),
+		pod("web-3", "web", "other-node"),
+		// exhausted for now, but it'll allow a disruption once a replica is back
+		newTestPDB("web", map[string]string{"app": "web"}, policyv1.PodDisruptionBudgetSpec{MinAvailable: &one}, 0, 3),
+		// never allows a disruption
+		newTestPDB("db", map[string]string{"app": "db"}, policyv1.PodDisruptionBudgetSpec{MaxUnavailable: &zero}, 0, 1),
+		// has room for a disruption
+		newTestPDB("cache", map[string]string{"app": "cache"}, policyv1.PodDisruptionBudgetSpec{MaxUnavailable: &one}, 1, 1),
+	)
+
+	blocks, err := FindBlockingPDBs(ctx, clientset, node)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []PDBBlock{
+		{Namespace: "default", Name: "web", Pods: []string{"web-1", "web-2"}},
+		{Namespace: "default", Name: "db", Pods: []string{"db-1"}, Unsatisfiable: true},
+	}, blocks)
+}
+
+func TestIsUnsatisfiablePDB(t *testing.T) {
+	intOrString := func(v intstr.IntOrString) *intstr.IntOrString { return &v }
+
+	tests := []struct {
+		name     string
+		spec     policyv1.PodDisruptionBudgetSpec
+		expected int32
+		want     bool
+	}{
+		{name: "maxUnavailable 0", spec: policyv1.PodDisruptionBudgetSpec{MaxUnavailable: intOrString(intstr.FromInt32(0))}, want: true},
+		{name: "maxUnavailable 0%", spec: policyv1.PodDisruptionBudgetSpec{MaxUnavailable: intOrString(intstr.FromString("0%"))}, want: true},
+		{name: "maxUnavailable 1", spec: policyv1.PodDisruptionBudgetSpec{MaxUnavailable: intOrString(intstr.FromInt32(1))}, want: false},
+		{name: "minAvailable 100%", spec: policyv1.PodDisruptionBudgetSpec{MinAvailable: intOrString(intstr.FromString("100%"))}, want: true},
+		{name: "minAvailable equal to expected pods", spec: policyv1.PodDisruptionBudgetSpec{MinAvailable: intOrString(intstr.FromInt32(3))}, expected: 3, want: true},
+		{name: "minAvailable below expected pods", spec: policyv1.PodDisruptionBudgetSpec{MinAvailable: intOrString(intstr.FromInt32(2))}, expected: 3, want: false},
+		{name: "no budget", want: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			pdb := newTestPDB("pdb", nil, tc.spec, 0, tc.expected)
+			assert.Equal(t, tc.want, isUnsatisfiablePDB(*pdb))
+		})
+	}
+}
+
+func TestDrainNodeUnsatisfiablePDB(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	vals := config.ContextValues{Logger: logger.Sugar(), State: &appstate.State{IsCordoned: true}}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-node"}}
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "db-1", Namespace: "default", Labels: map[string]string{"app": "db"}},
+		Spec:       v1.PodSpec{NodeName: node.Name},
+	}
+	zero := intstr.FromInt32(0)
+	pdb := newTestPDB("db", map[string]string{"app": "db"}, policyv1.PodDisruptionBudgetSpec{MaxUnavailable: &zero}, 0, 1)
+
+	tests := []struct {
+		name            string
+		respectPDBs     bool
+		expectedBlocked bool
+	}{
+		{name: "respecting PDBs", respectPDBs: true, expectedBlocked: true},
+		{name: "not respecting PDBs", respectPDBs: false, expectedBlocked: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// the fake accepts the eviction without removing the pod, so a drain that gets to evicting times out
+			clientset := newDrainResultClientset(nil, node, pod, pdb)
+			drainCfg := config.DrainConfig{Timeout: time.Second, Force: true, RespectPDBs: tc.respectPDBs}
+
+			_, err := DrainNode(ctx, clientset, node, drainCfg, Trigger{Category: TriggerCategoryEvent, Reason: "Freeze"})
+			require.Error(t, err)
+
+			var pdbErr *PDBBlockedError
+			assert.Equal(t, tc.expectedBlocked, errors.As(err, &pdbErr), "unexpected drain error: %v", err)
+			assert.Equal(t, tc.expectedBlocked, errors.Is(err, ErrDrainPDBBlocked), "unexpected drain error: %v", err)
+			evicted := false
+			for _, action := range clientset.Actions() {
+				evicted = evicted || action.GetSubresource() == "eviction"
+			}
+			assert.Equal(t, !tc.expectedBlocked, evicted)
+			if tc.expectedBlocked {
+				assert.Equal(t, []PDBBlock{{Namespace: "default", Name: "db", Pods: []string{"db-1"}, Unsatisfiable: true}}, pdbErr.Blocks)
+			}
+		})
+	}
+}
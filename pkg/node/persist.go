@@ -0,0 +1,112 @@
+package node
+
+import (
+	"context"
+
+	"github.com/amargherio/mechanic/internal/appstate"
+	"github.com/amargherio/mechanic/internal/config"
+	"github.com/amargherio/mechanic/pkg/imds"
+	"go.opentelemetry.io/otel"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// PersistState records the state's drain flags in the state store configured in cfg, along with the ID of the scheduled
+// event that triggered the drain. The event ID is empty for drains triggered by a node condition or taint.
+func PersistState(ctx context.Context, clientset kubernetes.Interface, node *v1.Node, cfg config.Config, state *appstate.State, eventID string) error {
+	tracer := otel.Tracer("github.com/amargherio/mechanic/pkg/node")
+	ctx, span := tracer.Start(ctx, "PersistState")
+	defer span.End()
+
+	vals := ctx.Value("values").(*config.ContextValues)
+	log := vals.Logger
+
+	store, err := NewStateStore(cfg.StateStore, cfg.NodeUpdateRetry, clientset)
+	if err != nil {
+		log.Warnw("Failed to get the state store", "error", err, "traceCtx", ctx)
+		return err
+	}
+
+	persisted := PersistedState{Drained: state.Drained(), ShouldDrain: state.DrainRequired(), EventID: eventID}
+	if err := store.Save(ctx, node, persisted); err != nil {
+		log.Warnw("Failed to persist state", "node", node.Name, "store", cfg.StateStore.Backend, "error", err, "traceCtx", ctx)
+		return err
+	}
+	log.Debugw("Persisted state", "node", node.Name, "store", cfg.StateStore.Backend, "drained", persisted.Drained, "shouldDrain", persisted.ShouldDrain, "eventId", eventID, "traceCtx", ctx)
+	return nil
+}
+
+// RestoreState loads the drain flags recorded by PersistState into state. They're only trusted while the node is still
+// cordoned by mechanic, since they're cleared along with mechanic's cordon and anything else could be stale or have
+// been left by someone else. State saved for a scheduled event is also only trusted while IMDS still lists the event,
+// so a cordon for a later event doesn't pick up the flags of one that's over. It returns true when the flags were
+// restored.
+func RestoreState(ctx context.Context, clientset kubernetes.Interface, ic imds.IMDS, node *v1.Node, cfg config.Config, state *appstate.State) bool {
+	vals := ctx.Value("values").(*config.ContextValues)
+	log := vals.Logger
+
+	store, err := NewStateStore(cfg.StateStore, cfg.NodeUpdateRetry, clientset)
+	if err != nil {
+		log.Warnw("Failed to get the state store", "error", err, "traceCtx", ctx)
+		return false
+	}
+
+	persisted, ok, err := store.Load(ctx, node)
+	if err != nil {
+		log.Warnw("Failed to load persisted state", "node", node.Name, "store", cfg.StateStore.Backend, "error", err, "traceCtx", ctx)
+		return false
+	}
+	if !ok {
+		return false
+	}
+	if persisted.NodeUID != "" && persisted.NodeUID != node.UID {
+		log.Infow("Ignoring persisted state saved for a previous node with the same name", "node", node.Name, "store", cfg.StateStore.Backend, "traceCtx", ctx)
+		return false
+	}
+	if value, _ := cordonLabelValue(node, cfg.Cordon); !node.Spec.Unschedulable || value != "true" {
+		log.Infow("Ignoring persisted state on a node that isn't cordoned by mechanic", "node", node.Name, "store", cfg.StateStore.Backend, "traceCtx", ctx)
+		return false
+	}
+	if persisted.EventID != "" && !eventStillScheduled(ctx, ic, persisted.EventID) {
+		log.Infow("Ignoring persisted state saved for a scheduled event IMDS no longer lists", "node", node.Name, "store", cfg.StateStore.Backend, "eventId", persisted.EventID, "traceCtx", ctx)
+		return false
+	}
+
+	state.SetDrained(persisted.Drained)
+	state.SetDrainRequired(persisted.ShouldDrain)
+	return true
+}
+
+// eventStillScheduled reports whether IMDS still lists the scheduled event. A failed query counts as the event being
+// gone, since restoring stale flags could skip a drain that's needed.
+func eventStillScheduled(ctx context.Context, ic imds.IMDS, eventID string) bool {
+	vals := ctx.Value("values").(*config.ContextValues)
+	log := vals.Logger
+
+	resp, err := ic.QueryIMDS(ctx)
+	if err != nil {
+		log.Warnw("Failed to query IMDS for the scheduled event the persisted state was saved for", "eventId", eventID, "error", err, "traceCtx", ctx)
+		return false
+	}
+	for _, event := range resp.Events {
+		if event.EventId == eventID {
+			return true
+		}
+	}
+	return false
+}
+
+// clearPersistedState removes the state recorded by PersistState once mechanic's cordon is released or replaced by a
+// new one. A failure is only logged, since RestoreState ignores state on a node mechanic hasn't cordoned.
+func clearPersistedState(ctx context.Context, clientset kubernetes.Interface, node *v1.Node, cfg config.Config) {
+	vals := ctx.Value("values").(*config.ContextValues)
+	log := vals.Logger
+
+	store, err := NewStateStore(cfg.StateStore, cfg.NodeUpdateRetry, clientset)
+	if err == nil {
+		err = store.Clear(ctx, node)
+	}
+	if err != nil {
+		log.Warnw("Failed to clear persisted state", "node", node.Name, "store", cfg.StateStore.Backend, "error", err, "traceCtx", ctx)
+	}
+}
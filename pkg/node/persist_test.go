@@ -0,0 +1,203 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/amargherio/mechanic/internal/appstate"
+	"github.com/amargherio/mechanic/internal/config"
+	"github.com/amargherio/mechanic/pkg/imds"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestPersistStateRoundTrip(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+
+	tests := []struct {
+		name          string
+		labels        map[string]string
+		unschedulable bool
+		expectRestore bool
+	}{
+		{name: "cordoned by mechanic", labels: map[string]string{"mechanic.cordoned": "true"}, unschedulable: true, expectRestore: true},
+		{name: "cordoned by someone else", labels: map[string]string{}, unschedulable: true},
+		{name: "not cordoned", labels: map[string]string{"mechanic.cordoned": "true"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			state := &appstate.State{IsCordoned: true, IsDrained: true, ShouldDrain: true}
+			vals := config.ContextValues{Logger: logger.Sugar(), State: state}
+			ctx := context.WithValue(context.Background(), "values", &vals)
+
+			node := &v1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-node", Labels: tc.labels},
+				Spec:       v1.NodeSpec{Unschedulable: tc.unschedulable},
+			}
+			clientset := fake.NewClientset(node)
+			require.NoError(t, PersistState(ctx, clientset, node, config.Config{}, state, ""))
+
+			stored, err := clientset.CoreV1().Nodes().Get(ctx, node.Name, metav1.GetOptions{})
+			require.NoError(t, err)
+			assert.Equal(t, "true", stored.Annotations[stateDrainedAnnotation])
+			assert.Equal(t, "true", stored.Annotations[stateShouldDrainAnnotation])
+
+			// a restarted agent starts from empty state
+			restarted := &appstate.State{}
+			assert.Equal(t, tc.expectRestore, RestoreState(ctx, clientset, &fakeIMDS{}, stored, config.Config{}, restarted))
+			assert.Equal(t, tc.expectRestore, restarted.Drained())
+			assert.Equal(t, tc.expectRestore, restarted.DrainRequired())
+		})
+	}
+}
+
+func TestRestoreStateIgnoresInvalidAnnotations(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	vals := config.ContextValues{Logger: logger.Sugar()}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test-node",
+			Labels:      map[string]string{"mechanic.cordoned": "true"},
+			Annotations: map[string]string{stateDrainedAnnotation: "yes please"},
+		},
+		Spec: v1.NodeSpec{Unschedulable: true},
+	}
+	state := &appstate.State{}
+	assert.False(t, RestoreState(ctx, fake.NewClientset(node), &fakeIMDS{}, node, config.Config{}, state))
+	assert.False(t, state.Drained())
+}
+
+func TestUncordonRemovesPersistedState(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	state := &appstate.State{IsCordoned: true, IsDrained: true, ShouldDrain: true}
+	vals := config.ContextValues{Logger: logger.Sugar(), State: state}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-node", Labels: map[string]string{"mechanic.cordoned": "true"}},
+		Spec:       v1.NodeSpec{Unschedulable: true},
+	}
+	clientset := fake.NewClientset(node)
+	require.NoError(t, PersistState(ctx, clientset, node, config.Config{}, state, ""))
+	require.NoError(t, UncordonNode(ctx, clientset, node, config.Config{}))
+
+	stored, err := clientset.CoreV1().Nodes().Get(ctx, node.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	for _, key := range stateAnnotationKeys {
+		assert.NotContains(t, stored.Annotations, key)
+	}
+}
+
+func TestRestoreStateRequiresScheduledEvent(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+
+	tests := []struct {
+		name          string
+		ic            *fakeIMDS
+		expectRestore bool
+	}{
+		{name: "event still scheduled", ic: &fakeIMDS{resp: imds.ScheduledEventsResponse{Events: []imds.ScheduledEvent{{EventId: "event-1"}}}}, expectRestore: true},
+		{name: "a different event", ic: &fakeIMDS{resp: imds.ScheduledEventsResponse{Events: []imds.ScheduledEvent{{EventId: "event-2"}}}}},
+		{name: "no events", ic: &fakeIMDS{}},
+		{name: "imds unavailable", ic: &fakeIMDS{err: errors.New("connection refused")}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			state := &appstate.State{IsCordoned: true, IsDrained: true, ShouldDrain: true}
+			vals := config.ContextValues{Logger: logger.Sugar(), State: state}
+			ctx := context.WithValue(context.Background(), "values", &vals)
+
+			node := &v1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-node", Labels: map[string]string{"mechanic.cordoned": "true"}},
+				Spec:       v1.NodeSpec{Unschedulable: true},
+			}
+			clientset := fake.NewClientset(node)
+			require.NoError(t, PersistState(ctx, clientset, node, config.Config{}, state, "event-1"))
+
+			stored, err := clientset.CoreV1().Nodes().Get(ctx, node.Name, metav1.GetOptions{})
+			require.NoError(t, err)
+			restarted := &appstate.State{}
+			assert.Equal(t, tc.expectRestore, RestoreState(ctx, clientset, tc.ic, stored, config.Config{}, restarted))
+			assert.Equal(t, tc.expectRestore, restarted.Drained())
+		})
+	}
+}
+
+func TestPersistedStateClearedWithCordon(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+
+	cfg := config.Config{StateStore: config.StateStoreConfig{Backend: config.StateStoreConfigMap, ConfigMapNamespace: "mechanic"}}
+
+	tests := []struct {
+		name   string
+		labels map[string]string
+		// unschedulable is the node's cordon when the state was saved, and change is what happens to it afterwards
+		unschedulable bool
+		change        func(ctx context.Context, clientset *fake.Clientset, node *v1.Node)
+	}{
+		{
+			name: "new cordon",
+			change: func(ctx context.Context, clientset *fake.Clientset, node *v1.Node) {
+				_, err := CordonNode(ctx, clientset, node, cfg, Trigger{Category: TriggerCategoryEvent, Reason: "Reboot", EventID: "event-2"})
+				require.NoError(t, err)
+			},
+		},
+		{
+			name:          "cordon removed by someone else",
+			labels:        map[string]string{"mechanic.cordoned": "true"},
+			unschedulable: true,
+			change: func(ctx context.Context, clientset *fake.Clientset, node *v1.Node) {
+				n, err := clientset.CoreV1().Nodes().Get(ctx, node.Name, metav1.GetOptions{})
+				require.NoError(t, err)
+				n.Spec.Unschedulable = false
+				n, err = clientset.CoreV1().Nodes().Update(ctx, n, metav1.UpdateOptions{})
+				require.NoError(t, err)
+				_, err = ReconcileCordonMarkers(ctx, clientset, n, cfg)
+				require.NoError(t, err)
+			},
+		},
+		{
+			name:          "label removed",
+			labels:        map[string]string{"mechanic.cordoned": "true"},
+			unschedulable: true,
+			change: func(ctx context.Context, clientset *fake.Clientset, node *v1.Node) {
+				removeMechanicCordonLabel(ctx, node, clientset, cfg)
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			state := &appstate.State{IsCordoned: tc.unschedulable, IsDrained: true, ShouldDrain: true}
+			vals := config.ContextValues{Logger: logger.Sugar(), State: state}
+			ctx := context.WithValue(context.Background(), "values", &vals)
+
+			node := &v1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-node", Labels: tc.labels},
+				Spec:       v1.NodeSpec{Unschedulable: tc.unschedulable},
+			}
+			clientset := fake.NewClientset(node)
+			require.NoError(t, PersistState(ctx, clientset, node, cfg, state, "event-1"))
+
+			tc.change(ctx, clientset, node)
+
+			_, err := clientset.CoreV1().ConfigMaps("mechanic").Get(ctx, stateConfigMapPrefix+node.Name, metav1.GetOptions{})
+			assert.True(t, apierrors.IsNotFound(err), "persisted state left behind: %v", err)
+		})
+	}
+}
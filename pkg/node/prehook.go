@@ -0,0 +1,244 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/amargherio/mechanic/internal/config"
+	"go.opentelemetry.io/otel"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+// preDrainReplicasAnnotation records the replica count a Deployment had before mechanic scaled it down
+const preDrainReplicasAnnotation = "mechanic.io/pre-drain-replicas"
+
+// preDrainNodeAnnotation records the node whose drain scaled the Deployment down, so only that node's mechanic
+// restores it
+const preDrainNodeAnnotation = "mechanic.io/pre-drain-node"
+
+// scaledDownLabel marks the Deployments mechanic scaled down so they can be found to restore once the pods have left the
+// node
+const scaledDownLabel = "mechanic.io/scaled-down"
+
+// scaleDownPollInterval is how often we check whether scaled down pods have left the node
+const scaleDownPollInterval = 2 * time.Second
+
+// scaleDownWorkloads scales Deployments matching the configured selector that have pods on the node to zero, then
+// waits for those pods to leave the node (bounded by the configured wait) so they can shut down on their own terms
+// instead of being evicted.
+func scaleDownWorkloads(ctx context.Context, clientset kubernetes.Interface, node *v1.Node, drainCfg config.DrainConfig) error {
+	if drainCfg.ScaleDownSelector == "" {
+		return nil
+	}
+
+	tracer := otel.Tracer("github.com/amargherio/mechanic/pkg/node")
+	ctx, span := tracer.Start(ctx, "scaleDownWorkloads")
+	defer span.End()
+
+	vals := ctx.Value("values").(*config.ContextValues)
+	log := vals.Logger
+
+	selector, err := labels.Parse(drainCfg.ScaleDownSelector)
+	if err != nil {
+		return fmt.Errorf("invalid scale down selector %q: %w", drainCfg.ScaleDownSelector, err)
+	}
+
+	deployments, err := deploymentsOnNode(ctx, clientset, node, selector)
+	if err != nil {
+		return err
+	}
+	if len(deployments) == 0 {
+		log.Debugw("No deployments on the node match the scale down selector", "node", node.Name, "selector", drainCfg.ScaleDownSelector, "traceCtx", ctx)
+		return nil
+	}
+
+	for _, d := range deployments {
+		if err := scaleDeploymentToZero(ctx, clientset, d, node.Name); err != nil {
+			return err
+		}
+		log.Infow("Scaled deployment to zero before draining", "node", node.Name, "deployment", d.String(), "traceCtx", ctx)
+	}
+
+	// wait for the pods to leave the node. if they don't go in time, the drain evicts whatever's left. the context
+	// carries the drain's deadline, so the wait never outlasts the drain.
+	err = wait.PollUntilContextTimeout(ctx, scaleDownPollInterval, drainCfg.ScaleDownWait, true, func(ctx context.Context) (bool, error) {
+		remaining, err := deploymentsOnNode(ctx, clientset, node, selector)
+		if err != nil {
+			return false, err
+		}
+		return len(remaining) == 0, nil
+	})
+	if err != nil {
+		log.Warnw("Scaled down pods did not leave the node before the wait elapsed, continuing with the drain", "node", node.Name, "wait", drainCfg.ScaleDownWait, "error", err, "traceCtx", ctx)
+	}
+
+	return nil
+}
+
+// deploymentsOnNode returns the Deployments matching the selector that own pods on the node. Ownership is resolved
+// through the pod's controlling ReplicaSet.
+func deploymentsOnNode(ctx context.Context, clientset kubernetes.Interface, node *v1.Node, selector labels.Selector) ([]types.NamespacedName, error) {
+	pods, err := clientset.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", node.Name).String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[types.NamespacedName]bool)
+	deployments := make([]types.NamespacedName, 0)
+	for _, pod := range pods.Items {
+		if pod.Spec.NodeName != node.Name {
+			continue
+		}
+
+		rsRef := metav1.GetControllerOf(&pod)
+		if rsRef == nil || rsRef.Kind != "ReplicaSet" {
+			continue
+		}
+
+		rs, err := clientset.AppsV1().ReplicaSets(pod.Namespace).Get(ctx, rsRef.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		deployRef := metav1.GetControllerOf(rs)
+		if deployRef == nil || deployRef.Kind != "Deployment" {
+			continue
+		}
+
+		name := types.NamespacedName{Namespace: pod.Namespace, Name: deployRef.Name}
+		if seen[name] {
+			continue
+		}
+
+		deployment, err := clientset.AppsV1().Deployments(pod.Namespace).Get(ctx, deployRef.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		if !selector.Matches(labels.Set(deployment.Labels)) {
+			continue
+		}
+
+		seen[name] = true
+		deployments = append(deployments, name)
+	}
+
+	return deployments, nil
+}
+
+// scaleDeploymentToZero sets the Deployment's replicas to zero, recording the previous replica count and the node in
+// annotations so it can be restored once maintenance is done. A Deployment already at zero is left alone, so a
+// Deployment another node scaled down is only restored by that node.
+func scaleDeploymentToZero(ctx context.Context, clientset kubernetes.Interface, name types.NamespacedName, nodeName string) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		d, err := clientset.AppsV1().Deployments(name.Namespace).Get(ctx, name.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		if d.Spec.Replicas != nil && *d.Spec.Replicas == 0 {
+			return nil
+		}
+
+		replicas := int32(1)
+		if d.Spec.Replicas != nil {
+			replicas = *d.Spec.Replicas
+		}
+
+		annotations := d.GetAnnotations()
+		if annotations == nil {
+			annotations = make(map[string]string)
+		}
+		annotations[preDrainReplicasAnnotation] = strconv.Itoa(int(replicas))
+		annotations[preDrainNodeAnnotation] = nodeName
+		d.SetAnnotations(annotations)
+		labelSet := d.GetLabels()
+		if labelSet == nil {
+			labelSet = make(map[string]string)
+		}
+		labelSet[scaledDownLabel] = "true"
+		d.SetLabels(labelSet)
+
+		zero := int32(0)
+		d.Spec.Replicas = &zero
+
+		_, err = clientset.AppsV1().Deployments(name.Namespace).Update(ctx, d, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// restoreScaledDownWorkloads scales the Deployments mechanic scaled down for this node's drain back to the replica count
+// they had before. It's called once the drain finishes, whether or not it succeeded, and again when the node is
+// uncordoned in case that failed, so workloads aren't left at zero. Every Deployment is tried, and the errors are joined.
+func restoreScaledDownWorkloads(ctx context.Context, clientset kubernetes.Interface, node *v1.Node) error {
+	tracer := otel.Tracer("github.com/amargherio/mechanic/pkg/node")
+	ctx, span := tracer.Start(ctx, "restoreScaledDownWorkloads")
+	defer span.End()
+
+	vals := ctx.Value("values").(*config.ContextValues)
+	log := vals.Logger
+
+	deployments, err := clientset.AppsV1().Deployments(metav1.NamespaceAll).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(labels.Set{scaledDownLabel: "true"}).String(),
+	})
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, d := range deployments.Items {
+		if d.Annotations[preDrainNodeAnnotation] != node.Name {
+			continue
+		}
+		name := types.NamespacedName{Namespace: d.Namespace, Name: d.Name}
+		replicas, err := restoreDeployment(ctx, clientset, name)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("restoring deployment %s: %w", name, err))
+			continue
+		}
+		log.Infow("Restored deployment scaled down before the drain", "node", node.Name, "deployment", name.String(), "replicas", replicas, "traceCtx", ctx)
+	}
+	return errors.Join(errs...)
+}
+
+// restoreDeployment sets the Deployment's replicas back to the count recorded when it was scaled down and removes
+// mechanic's label and annotations. A count that doesn't parse restores a single replica. The restored count is returned.
+func restoreDeployment(ctx context.Context, clientset kubernetes.Interface, name types.NamespacedName) (int32, error) {
+	var replicas int32
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		d, err := clientset.AppsV1().Deployments(name.Namespace).Get(ctx, name.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		replicas = 1
+		if saved, err := strconv.Atoi(d.Annotations[preDrainReplicasAnnotation]); err == nil && saved >= 0 {
+			replicas = int32(saved)
+		}
+		d.Spec.Replicas = &replicas
+		delete(d.Annotations, preDrainReplicasAnnotation)
+		delete(d.Annotations, preDrainNodeAnnotation)
+		delete(d.Labels, scaledDownLabel)
+
+		_, err = clientset.AppsV1().Deployments(name.Namespace).Update(ctx, d, metav1.UpdateOptions{})
+		return err
+	})
+	return replicas, err
+}
@@ -0,0 +1,221 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/amargherio/mechanic/internal/appstate"
+	"github.com/amargherio/mechanic/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// newDrainTestClientset returns a fake clientset that advertises the core v1 API without eviction support, so the drain
+// helper falls back to deleting pods
+func newDrainTestClientset(objects ...runtime.Object) *fake.Clientset {
+	clientset := fake.NewClientset(objects...)
+	clientset.Resources = []*metav1.APIResourceList{{GroupVersion: "v1"}}
+	return clientset
+}
+
+// deploymentWithPod builds a Deployment, its ReplicaSet, and a pod owned by the ReplicaSet on the given node
+func deploymentWithPod(name string, nodeName string, deployLabels map[string]string) []runtime.Object {
+	replicas := int32(3)
+	isController := true
+
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: deployLabels},
+		Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+	}
+	rs := &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name + "-rs",
+			Namespace: "default",
+			OwnerReferences: []metav1.OwnerReference{
+				{APIVersion: "apps/v1", Kind: "Deployment", Name: name, Controller: &isController},
+			},
+		},
+	}
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name + "-pod",
+			Namespace: "default",
+			OwnerReferences: []metav1.OwnerReference{
+				{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: name + "-rs", Controller: &isController},
+			},
+		},
+		Spec: v1.PodSpec{NodeName: nodeName},
+	}
+
+	return []runtime.Object{deployment, rs, pod}
+}
+
+func TestScaleDownWorkloadsBeforeDrain(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	log := logger.Sugar()
+
+	vals := config.ContextValues{
+		Logger: log,
+		State:  &appstate.State{IsCordoned: true},
+	}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-node"}}
+	objects := []runtime.Object{node}
+	objects = append(objects, deploymentWithPod("graceful", node.Name, map[string]string{"mechanic.io/scale-down": "true"})...)
+	objects = append(objects, deploymentWithPod("evicted", node.Name, map[string]string{"app": "evicted"})...)
+	clientset := newDrainTestClientset(objects...)
+
+	// record the replica count of each deployment at the moment its pod is removed from the node
+	replicasAtDeletion := make(map[string]int32)
+	clientset.PrependReactor("delete", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		podName := action.(k8stesting.DeleteAction).GetName()
+		deployName := podName[:len(podName)-len("-pod")]
+		d, err := clientset.Tracker().Get(appsv1.SchemeGroupVersion.WithResource("deployments"), "default", deployName)
+		if err == nil {
+			replicasAtDeletion[deployName] = *d.(*appsv1.Deployment).Spec.Replicas
+		}
+		return false, nil, nil
+	})
+
+	drainCfg := config.DrainConfig{
+		ScaleDownSelector: "mechanic.io/scale-down=true",
+		ScaleDownWait:     10 * time.Millisecond,
+	}
+
+	drained, err := DrainNode(ctx, clientset, node, drainCfg, Trigger{Category: TriggerCategoryEvent, Reason: "Reboot"})
+	assert.NoError(t, err)
+	assert.True(t, drained)
+
+	// the matching deployment was scaled to zero before its pod was evicted, the other was left alone
+	assert.Equal(t, int32(0), replicasAtDeletion["graceful"])
+	assert.Equal(t, int32(3), replicasAtDeletion["evicted"])
+
+	// and brought back once the drain finished
+	graceful, _ := clientset.AppsV1().Deployments("default").Get(ctx, "graceful", metav1.GetOptions{})
+	assert.Equal(t, int32(3), *graceful.Spec.Replicas)
+	assert.NotContains(t, graceful.Annotations, preDrainReplicasAnnotation)
+}
+
+func TestScaledDownWorkloadsRestoredWhenNodeDeleted(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	vals := config.ContextValues{Logger: logger.Sugar(), State: &appstate.State{IsCordoned: true}}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-node"}}
+	objects := append([]runtime.Object{node}, deploymentWithPod("graceful", node.Name, map[string]string{"mechanic.io/scale-down": "true"})...)
+	clientset := newDrainTestClientset(objects...)
+
+	drainCfg := config.DrainConfig{ScaleDownSelector: "mechanic.io/scale-down=true", ScaleDownWait: 10 * time.Millisecond}
+	drained, err := DrainNode(ctx, clientset, node, drainCfg, Trigger{Category: TriggerCategoryEvent, Reason: "Preempt"})
+	require.NoError(t, err)
+	require.True(t, drained)
+
+	// a preempted node is deleted rather than uncordoned, so nothing restores the deployment after this
+	require.NoError(t, clientset.CoreV1().Nodes().Delete(ctx, node.Name, metav1.DeleteOptions{}))
+
+	graceful, err := clientset.AppsV1().Deployments("default").Get(ctx, "graceful", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), *graceful.Spec.Replicas)
+	assert.NotContains(t, graceful.Labels, scaledDownLabel)
+}
+
+func TestScaleDownWorkloadsDisabled(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	log := logger.Sugar()
+
+	vals := config.ContextValues{
+		Logger: log,
+		State:  &appstate.State{},
+	}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-node"}}
+	objects := append([]runtime.Object{node}, deploymentWithPod("graceful", node.Name, map[string]string{"mechanic.io/scale-down": "true"})...)
+	clientset := newDrainTestClientset(objects...)
+
+	err := scaleDownWorkloads(ctx, clientset, node, config.DrainConfig{})
+	assert.NoError(t, err)
+
+	graceful, _ := clientset.AppsV1().Deployments("default").Get(ctx, "graceful", metav1.GetOptions{})
+	assert.Equal(t, int32(3), *graceful.Spec.Replicas)
+}
+
+func TestScaledDownWorkloadsRestored(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	vals := config.ContextValues{Logger: logger.Sugar(), State: &appstate.State{}}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-node"}}
+	objects := []runtime.Object{node}
+	objects = append(objects, deploymentWithPod("graceful", node.Name, map[string]string{"mechanic.io/scale-down": "true"})...)
+	objects = append(objects, deploymentWithPod("elsewhere", "other-node", map[string]string{"mechanic.io/scale-down": "true"})...)
+	clientset := newDrainTestClientset(objects...)
+	name := func(d string) types.NamespacedName { return types.NamespacedName{Namespace: "default", Name: d} }
+
+	require.NoError(t, scaleDeploymentToZero(ctx, clientset, name("graceful"), node.Name))
+	require.NoError(t, scaleDeploymentToZero(ctx, clientset, name("elsewhere"), "other-node"))
+
+	// uncordoning the node once maintenance is over restores what its drain scaled down
+	require.NoError(t, UncordonNode(ctx, clientset, node, config.Config{}))
+
+	graceful, err := clientset.AppsV1().Deployments("default").Get(ctx, "graceful", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), *graceful.Spec.Replicas)
+	assert.NotContains(t, graceful.Annotations, preDrainReplicasAnnotation)
+	assert.NotContains(t, graceful.Annotations, preDrainNodeAnnotation)
+	assert.NotContains(t, graceful.Labels, scaledDownLabel)
+	assert.Equal(t, "true", graceful.Labels["mechanic.io/scale-down"])
+
+	// another node's scale down is left for that node to restore
+	elsewhere, err := clientset.AppsV1().Deployments("default").Get(ctx, "elsewhere", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, int32(0), *elsewhere.Spec.Replicas)
+}
+
+func TestScaleDownWaitBoundedByDrainTimeout(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	vals := config.ContextValues{Logger: logger.Sugar(), State: &appstate.State{IsCordoned: true}}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-node"}}
+	objects := append([]runtime.Object{node}, deploymentWithPod("graceful", node.Name, map[string]string{"mechanic.io/scale-down": "true"})...)
+	// the fake clientset doesn't remove pods when their deployment scales down, so the wait never sees them go
+	clientset := newDrainTestClientset(objects...)
+	clientset.PrependReactor("delete", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("connection refused")
+	})
+
+	drainCfg := config.DrainConfig{
+		Timeout:           time.Second,
+		ScaleDownSelector: "mechanic.io/scale-down=true",
+		ScaleDownWait:     time.Hour,
+	}
+
+	start := time.Now()
+	drained, err := DrainNode(ctx, clientset, node, drainCfg, Trigger{Category: TriggerCategoryEvent, Reason: "Reboot"})
+	assert.Less(t, time.Since(start), 30*time.Second, "the scale down wait should end with the drain timeout")
+	assert.Error(t, err)
+	assert.False(t, drained)
+
+	// the failed drain restores the deployment instead of leaving it at zero until the next attempt
+	graceful, err := clientset.AppsV1().Deployments("default").Get(ctx, "graceful", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), *graceful.Spec.Replicas)
+	assert.NotContains(t, graceful.Labels, scaledDownLabel)
+}
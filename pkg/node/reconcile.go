@@ -0,0 +1,127 @@
+package node
+
+import (
+	"context"
+
+	"github.com/amargherio/mechanic/internal/config"
+	"go.opentelemetry.io/otel"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// cordonAnnotationKeys are the node annotations mechanic only sets while it owns the cordon. They're orphaned whenever
+// mechanic's cordon label is missing.
+var cordonAnnotationKeys = append(append([]string{}, triggerAnnotationKeys...), stateAnnotationKeys...)
+
+// ownedAnnotationKeys are all of the node annotations mechanic sets, removed when it releases its cordon. The skipped
+// freeze annotations are set on nodes mechanic doesn't cordon too, so they're left alone on nodes without the label.
+var ownedAnnotationKeys = append(append([]string{}, cordonAnnotationKeys...), freezeSkippedAnnotation, freezeSkippedNotBeforeAnnotation)
+
+// ReconcileCordonMarkers brings the mechanic cordon label and annotations into agreement with spec.unschedulable. The
+// markers can drift when someone cordons or uncordons the node by hand or a previous update, or the agent, failed
+// partway through. Mechanic's cordon label, mechanic.cordoned unless another key is configured, is the source of truth
+// for ownership:
+//   - labeled but schedulable: our cordon was removed. It's restored while an event is still scheduled, otherwise the
+//     label and the annotations we own are dropped.
+//   - labeled with a value other than true: the label is repaired, since other checks look for true.
+//   - labeled without our taint while the taint is enabled: the taint is added.
+//   - not labeled: our taint and the trigger and state annotations describe a cordon we no longer own and are removed.
+//   - labeled and unschedulable: we own the cordon, so a cordon-not-managed annotation is wrong and is removed.
+//   - schedulable: a cordon-not-managed annotation is stale and is removed.
+//
+// The reconciled node is returned. It's the node passed in when nothing needed to change.
+func ReconcileCordonMarkers(ctx context.Context, clientset kubernetes.Interface, node *v1.Node, cfg config.Config) (*v1.Node, error) {
+	tracer := otel.Tracer("github.com/amargherio/mechanic/pkg/node")
+	ctx, span := tracer.Start(ctx, "ReconcileCordonMarkers")
+	defer span.End()
+
+	vals := ctx.Value("values").(*config.ContextValues)
+	log := vals.Logger
+
+	if !reconcileCordonMarkers(node.DeepCopy(), cfg.Cordon, vals.State.EventScheduled()) {
+		return node, nil
+	}
+
+	var reconciled *v1.Node
+	retryErr := retryNodeUpdate(ctx, cfg.NodeUpdateRetry, func() error {
+		n, err := clientset.CoreV1().Nodes().Get(ctx, node.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if !reconcileCordonMarkers(n, cfg.Cordon, vals.State.EventScheduled()) {
+			reconciled = n
+			return nil
+		}
+
+		reconciled, err = clientset.CoreV1().Nodes().Update(ctx, n, metav1.UpdateOptions{})
+		return err
+	})
+	if retryErr != nil {
+		log.Warnw("Failed to reconcile cordon markers on node - retry error encountered", "node", node.Name, "error", retryErr, "traceCtx", ctx)
+		return node, retryErr
+	}
+
+	labeled := ownsCordon(reconciled, cfg.Cordon)
+	vals.State.SetCordoned(reconciled.Spec.Unschedulable)
+	// the state annotations went with our cordon, but the other stores are cleared separately
+	if !labeled {
+		clearPersistedState(ctx, clientset, reconciled, cfg)
+	}
+	log.Infow("Reconciled inconsistent cordon markers on node",
+		"node", node.Name,
+		"unschedulable", reconciled.Spec.Unschedulable,
+		"mechanicCordoned", labeled,
+		"traceCtx", ctx)
+	return reconciled, nil
+}
+
+// reconcileCordonMarkers applies the ownership rules for the markers to the node in place and reports whether anything
+// changed
+func reconcileCordonMarkers(n *v1.Node, markers config.CordonConfig, hasEventScheduled bool) bool {
+	changed := false
+	annotations := n.GetAnnotations()
+	value, labeled := cordonLabelValue(n, markers)
+
+	removeAnnotation := func(key string) {
+		if _, ok := annotations[key]; ok {
+			delete(annotations, key)
+			changed = true
+		}
+	}
+
+	switch {
+	case labeled && !n.Spec.Unschedulable && hasEventScheduled:
+		n.Spec.Unschedulable = true
+		changed = true
+	case labeled && !n.Spec.Unschedulable:
+		removeCordonLabel(n, markers)
+		labeled = false
+		changed = true
+	}
+
+	if labeled && value != "true" {
+		labels := n.GetLabels()
+		labels[cordonLabelKey(markers)] = "true"
+		n.SetLabels(labels)
+		changed = true
+	}
+	if labeled && markers.ApplyTaint && addCordonTaint(n, markers.TaintKey) {
+		changed = true
+	}
+	if !labeled {
+		if removeCordonTaint(n, markers) {
+			changed = true
+		}
+		for _, key := range cordonAnnotationKeys {
+			removeAnnotation(key)
+		}
+	}
+
+	if !n.Spec.Unschedulable || labeled {
+		removeAnnotation(cordonNotManagedAnnotation)
+	}
+
+	n.SetAnnotations(annotations)
+	return changed
+}
@@ -0,0 +1,169 @@
+package node
+
+import (
+	"context"
+	"testing"
+
+	"github.com/amargherio/mechanic/internal/appstate"
+	"github.com/amargherio/mechanic/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestReconcileCordonMarkers(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	log := logger.Sugar()
+
+	triggerAnnotations := map[string]string{
+		triggerCategoryAnnotation: TriggerCategoryEvent,
+		triggerReasonAnnotation:   "Reboot",
+	}
+
+	tests := []struct {
+		name                string
+		unschedulable       bool
+		labels              map[string]string
+		annotations         map[string]string
+		hasEventScheduled   bool
+		expectUnschedulable bool
+		expectLabel         bool
+		expectAnnotations   []string
+	}{
+		{
+			name:                "labeled but uncordoned with an event scheduled restores the cordon",
+			labels:              map[string]string{"mechanic.cordoned": "true"},
+			annotations:         triggerAnnotations,
+			hasEventScheduled:   true,
+			expectUnschedulable: true,
+			expectLabel:         true,
+			expectAnnotations:   []string{triggerCategoryAnnotation, triggerReasonAnnotation},
+		},
+		{
+			name:                "labeled but uncordoned without an event drops the label",
+			labels:              map[string]string{"mechanic.cordoned": "true"},
+			annotations:         triggerAnnotations,
+			expectUnschedulable: false,
+			expectLabel:         false,
+		},
+		{
+			name:                "trigger annotations without the label are removed",
+			unschedulable:       true,
+			labels:              map[string]string{},
+			annotations:         triggerAnnotations,
+			expectUnschedulable: true,
+			expectLabel:         false,
+		},
+		{
+			name:                "not managed annotation on a mechanic cordon is removed",
+			unschedulable:       true,
+			labels:              map[string]string{"mechanic.cordoned": "true"},
+			annotations:         map[string]string{cordonNotManagedAnnotation: "true"},
+			hasEventScheduled:   true,
+			expectUnschedulable: true,
+			expectLabel:         true,
+		},
+		{
+			name:                "not managed annotation on a schedulable node is removed",
+			labels:              map[string]string{},
+			annotations:         map[string]string{cordonNotManagedAnnotation: "true"},
+			expectUnschedulable: false,
+			expectLabel:         false,
+		},
+		{
+			name:                "consistent external cordon is left alone",
+			unschedulable:       true,
+			labels:              map[string]string{},
+			annotations:         map[string]string{cordonNotManagedAnnotation: "true"},
+			expectUnschedulable: true,
+			expectLabel:         false,
+			expectAnnotations:   []string{cordonNotManagedAnnotation},
+		},
+		{
+			name:                "state annotations without the label are removed",
+			unschedulable:       true,
+			labels:              map[string]string{},
+			annotations:         map[string]string{stateDrainedAnnotation: "true", stateShouldDrainAnnotation: "true"},
+			expectUnschedulable: true,
+			expectLabel:         false,
+		},
+		{
+			name:          "orphaned metadata on an external cordon is removed and the not managed annotation kept",
+			unschedulable: true,
+			labels:        map[string]string{},
+			annotations: map[string]string{
+				triggerReasonAnnotation:          "Reboot",
+				maintenanceDescriptionAnnotation: "Host update",
+				stateDrainedAnnotation:           "true",
+				cordonNotManagedAnnotation:       "true",
+			},
+			expectUnschedulable: true,
+			expectLabel:         false,
+			expectAnnotations:   []string{cordonNotManagedAnnotation},
+		},
+		{
+			name:                "label with an unexpected value is repaired",
+			unschedulable:       true,
+			labels:              map[string]string{"mechanic.cordoned": ""},
+			annotations:         map[string]string{stateDrainedAnnotation: "true"},
+			hasEventScheduled:   true,
+			expectUnschedulable: true,
+			expectLabel:         true,
+			expectAnnotations:   []string{stateDrainedAnnotation},
+		},
+		{
+			name:                "partial annotations on a mechanic cordon are kept",
+			unschedulable:       true,
+			labels:              map[string]string{"mechanic.cordoned": "true"},
+			annotations:         map[string]string{stateDrainedAnnotation: "true", stateShouldDrainAnnotation: "true"},
+			expectUnschedulable: true,
+			expectLabel:         true,
+			expectAnnotations:   []string{stateDrainedAnnotation, stateShouldDrainAnnotation},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			state := &appstate.State{HasEventScheduled: tc.hasEventScheduled}
+			vals := config.ContextValues{
+				Logger: log,
+				State:  state,
+			}
+			ctx := context.WithValue(context.Background(), "values", &vals)
+
+			annotations := make(map[string]string)
+			for k, v := range tc.annotations {
+				annotations[k] = v
+			}
+			node := &v1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-node", Labels: tc.labels, Annotations: annotations},
+				Spec:       v1.NodeSpec{Unschedulable: tc.unschedulable},
+			}
+			clientset := fake.NewClientset(node)
+
+			reconciled, err := ReconcileCordonMarkers(ctx, clientset, node, config.Config{})
+			require.NoError(t, err)
+
+			stored, err := clientset.CoreV1().Nodes().Get(ctx, node.Name, metav1.GetOptions{})
+			require.NoError(t, err)
+			for _, n := range []*v1.Node{reconciled, stored} {
+				assert.Equal(t, tc.expectUnschedulable, n.Spec.Unschedulable)
+				value, labeled := n.Labels["mechanic.cordoned"]
+				assert.Equal(t, tc.expectLabel, labeled)
+				if labeled {
+					assert.Equal(t, "true", value)
+				}
+
+				var keys []string
+				for k := range n.Annotations {
+					keys = append(keys, k)
+				}
+				assert.ElementsMatch(t, tc.expectAnnotations, keys)
+			}
+		})
+	}
+}
@@ -0,0 +1,301 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/amargherio/mechanic/internal/appstate"
+	"github.com/amargherio/mechanic/internal/config"
+	"github.com/amargherio/mechanic/pkg/imds"
+	"github.com/amargherio/mechanic/pkg/metrics"
+	"go.opentelemetry.io/otel"
+	"go.uber.org/zap"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+)
+
+// ReconcileNode runs the complete check and act flow for a single node: it evaluates the node's conditions, confirms
+// scheduled events with IMDS, cordons and drains the node when required, and releases cordons that are no longer
+// needed. The state and recorder passed in are used for the whole reconcile, so code embedding mechanic can drive
+// reconciliation with its own. The caller is responsible for serializing calls that share a state. An error is
+// returned when the reconcile couldn't finish; failed cordons and drains are reported through events and retried on
+// the next reconcile instead. When a scheduled event is held back by the drain lead time, the state's LeadTimeOpensAt
+// says when to reconcile again.
+func ReconcileNode(ctx context.Context, clientset kubernetes.Interface, ic imds.IMDS, cfg config.Config, state *appstate.State, recorder record.EventRecorder, node *v1.Node) error {
+	tracer := otel.Tracer("github.com/amargherio/mechanic/pkg/node")
+	ctx, span := tracer.Start(ctx, "ReconcileNode")
+	defer span.End()
+
+	// use the caller's state and recorder for everything we call, keeping the rest of the context values
+	vals := config.ContextValues{Logger: zap.NewNop().Sugar()}
+	if v, ok := ctx.Value("values").(*config.ContextValues); ok {
+		vals = *v
+	}
+	vals.State = state
+	vals.Recorder = recorder
+	ctx = context.WithValue(ctx, "values", &vals)
+	log := vals.Logger
+	// every reconcile is recorded, including the ones that end early, so the status endpoint shows the agent is alive
+	defer func() { state.RecordReconcile(time.Now()) }()
+	decision := newDecisionLog(node, cfg, state)
+	defer decision.write(ctx, log)
+	defer decision.annotate(span)
+
+	log.Infow("Reconciling node, checking for updated conditions",
+		"node", node.Name,
+		"traceCtx", ctx)
+
+	if state.ObserveNode(node.UID) {
+		// the node was recreated under the same name, so our state belongs to the old node. resync the cordon
+		// state from the new node before evaluating it.
+		state.SetCordoned(node.Spec.Unschedulable)
+		log.Warnw("Node UID changed, the node was recreated. Reset app state.",
+			"node", node.Name,
+			"uid", node.UID,
+			"state", state,
+			"traceCtx", ctx)
+	}
+
+	// an event held back by the lead time is only remembered until the next IMDS check
+	state.SetLeadTimeOpensAt(time.Time{})
+
+	// the IMDS checks made by the trigger checks record the response and report what they find
+	lookup := eventLookup{
+		impacting: func(ctx context.Context) (bool, error) {
+			return imds.HasImpactingEvents(ctx, ic, node, &cfg.DrainConditions, cfg.IMDSRetry)
+		},
+		drainRequired: func(ctx context.Context) (bool, *imds.ScheduledEvent, error) {
+			b, e, err := imds.CheckIfDrainRequired(ctx, ic, node, &cfg.DrainConditions, cfg.IMDSRetry)
+			if err == nil {
+				annotateSkippedFreeze(ctx, clientset, node, cfg, state)
+			}
+			return b, e, err
+		},
+	}
+
+	triggers := checkTriggers(ctx, node, cfg)
+
+	// on the first reconcile, a condition could be left over from an event that resolved before we started and
+	// that NPD hasn't cleared yet. confirm it against IMDS before acting on it.
+	if !state.StartupConditionsValidated() && cfg.ValidateStartupConditions && len(triggers.eventConditions) > 0 {
+		confirmed, err := imds.HasImpactingEvents(ctx, ic, node, &cfg.DrainConditions, cfg.IMDSRetry)
+		if err != nil {
+			log.Warnw("Failed to confirm scheduled event condition against IMDS on startup, trusting the condition", "node", node.Name, "error", err, "traceCtx", ctx)
+		} else if !confirmed {
+			log.Infow("Node has a scheduled event condition on startup but IMDS has no events for the node. Treating the condition as stale.", "node", node.Name, "traceCtx", ctx)
+			triggers.eventConditions = nil
+		}
+	}
+	state.MarkStartupConditionsValidated()
+
+	// a sustained GPU health condition or a maintenance taint is handled like a scheduled event, and without any of
+	// them IMDS is polled directly when configured to. once they're gone, our cordon is released like it is when an
+	// event clears.
+	triggers.poll(ctx, node, cfg, lookup)
+	state.SetEventScheduled(triggers.scheduled())
+
+	state.ObserveEventScheduled(state.EventScheduled(), time.Now())
+
+	log.Infow("Finished checking node conditions and current state.", "node", node.Name, "state", state, "traceCtx", ctx)
+
+	if state.EventScheduled() {
+		// early return if the node is already cordoned and drained, checking now and then that it's still cordoned
+		if state.Cordoned() && state.Drained() && drainedNodeStillCordoned(ctx, clientset, node, state, recorder, cfg.DrainedVerifyInterval) {
+			log.Infow("Node is already cordoned and drained, no action required", "node", node.Name, "state", state, "traceCtx", ctx)
+			decision.finish(DecisionAlreadyDrained, nil)
+			return nil
+		}
+
+		drainTriggers, drainRequired, err := triggers.resolve(ctx, node, cfg, lookup)
+		if errors.Is(err, imds.ErrInvalidNodeName) {
+			// already reported with guidance by the IMDS check, don't repeat the error on every update
+			log.Debugw("Unable to determine if drain is required, node name can't be matched to scheduled events", "error", err, "state", state, "traceCtx", ctx)
+			decision.finish(DecisionError, err)
+			return err
+		} else if err != nil {
+			log.Errorw("Failed to query IMDS for scheduled event information. Unable to determine if drain is required.", "error", err, "state", state, "traceCtx", ctx)
+			decision.finish(DecisionError, err)
+			return err
+		}
+		trigger := combineTriggers(drainTriggers)
+		if len(trigger.Also) > 0 {
+			log.Infow("Node has more than one trigger, the most urgent drives the drain", "node", node.Name, "category", trigger.Category, "reason", trigger.Reason, "also", trigger.Also, "traceCtx", ctx)
+		}
+		state.SetDrainRequired(drainRequired)
+		// the trigger is the one description of why we're acting, shared by the annotations, events, metrics, and
+		// decision log
+		trigger.DetectedAt = state.DetectedAt()
+		decision.trigger = trigger
+		decision.finish(DecisionNoDrain, nil)
+
+		if state.DrainRequired() {
+			// cordon the node, then drain
+			log.Infow("A drain has been determined as appropriate for the node", "node", node.Name, "state", state, "traceCtx", ctx)
+
+			// with a flaky apiserver connection, a cordon can land while the drain fails or the other way around.
+			// hold off until a cheap request goes through.
+			if cfg.RequireAPIConnectivityBeforeAction && !(state.Cordoned() && state.Drained()) {
+				if err := CheckAPIConnectivity(ctx, clientset, node.Name); err != nil {
+					log.Warnw("Unable to reach the apiserver, deferring cordon and drain", "node", node.Name, "error", err, "traceCtx", ctx)
+					TriggerEventf(recorder, node, trigger, v1.EventTypeWarning, "ActionDeferred", "Cordon and drain of node %s deferred, the apiserver could not be reached", node.Name)
+					decision.finish(DecisionDeferred, err)
+					return err
+				}
+			}
+
+			// the IMDS data behind the event can be stale by the time we act on it, like after a slow apiserver or
+			// IMDS retries. refresh it, and hold off when the refresh doesn't confirm the event.
+			if !(state.Cordoned() && state.Drained()) {
+				fresh, err := confirmFreshEvent(ctx, ic, node, cfg, state, trigger)
+				if !fresh {
+					TriggerEventf(recorder, node, trigger, v1.EventTypeWarning, "ActionDeferred", "Cordon and drain of node %s deferred, the scheduled event could not be confirmed with fresh IMDS data", node.Name)
+					decision.finish(DecisionDeferred, err)
+					return err
+				}
+			}
+
+			decision.finish(DecisionDrain, nil)
+
+			// check state and attempt to cordon if required
+			if state.Cordoned() {
+				log.Infow("Node is already cordoned, skipping cordon", "node", node.Name, "state", state, "traceCtx", ctx)
+				TriggerEventf(recorder, node, trigger, v1.EventTypeNormal, "CordonNode", "Node %s is already cordoned, no need to attempt a cordon.", node.Name)
+			} else {
+				b, err := CordonNode(ctx, clientset, node, cfg, trigger)
+				if err != nil {
+					log.Errorw("Failed to cordon node", "node", node.Name, "error", err, "traceCtx", ctx)
+					TriggerEventf(recorder, node, trigger, v1.EventTypeWarning, "CordonNode", "Failed to cordon node %s", node.Name)
+				} else {
+					state.SetCordoned(b)
+					log.Infow("Node cordoned", "node", node.Name, "state", state, "traceCtx", ctx)
+					TriggerEventf(recorder, node, trigger, v1.EventTypeNormal, "CordonNode", "Node %s cordoned by mechanic", node.Name)
+				}
+			}
+
+			// hold back drains that can wait when they'd leave the cluster short of schedulable nodes. the node
+			// stays cordoned and the drain is retried on the next update.
+			capacityOK := true
+			if !state.Drained() && !trigger.IsUrgent() {
+				ok, schedulable, err := HasMinSchedulableNodes(ctx, clientset, node, cfg.Drain.MinSchedulableNodes)
+				if err != nil {
+					log.Errorw("Failed to count schedulable nodes, deferring drain", "node", node.Name, "error", err, "traceCtx", ctx)
+					capacityOK = false
+				} else if !ok {
+					log.Warnw("Draining the node would leave too few schedulable nodes, deferring drain", "node", node.Name, "schedulable", schedulable, "min", cfg.Drain.MinSchedulableNodes, "traceCtx", ctx)
+					TriggerEventf(recorder, node, trigger, v1.EventTypeWarning, "DrainDeferred", "Drain of node %s deferred, only %d other schedulable nodes and at least %d are required", node.Name, schedulable, cfg.Drain.MinSchedulableNodes)
+					capacityOK = false
+				}
+				if !capacityOK {
+					decision.finish(DecisionDeferred, err)
+				}
+			}
+
+			// another controller drains nodes during a cluster upgrade, and draining alongside it disrupts the workloads
+			// twice. the node stays cordoned and the drain waits until the upgrade signal is gone.
+			upgradeOK := true
+			if capacityOK && !state.Drained() {
+				signal, err := UpgradeInProgress(ctx, clientset, node, cfg.UpgradeSignal)
+				if err != nil {
+					log.Errorw("Failed to check for a cluster upgrade, deferring drain", "node", node.Name, "error", err, "traceCtx", ctx)
+					upgradeOK = false
+					decision.finish(DecisionDeferred, err)
+				} else if signal != "" {
+					log.Infow("A cluster upgrade is in progress, suppressing drain", "node", node.Name, "signal", signal, "traceCtx", ctx)
+					TriggerEventf(recorder, node, trigger, v1.EventTypeNormal, "DrainSuppressedUpgrade", "Drain of node %s suppressed while a cluster upgrade is in progress (%s), the node was left cordoned", node.Name, signal)
+					upgradeOK = false
+					decision.finish(DecisionUpgrade, nil)
+				}
+			}
+
+			if state.Drained() {
+				log.Infow("Node is already drained, skipping drain", "node", node.Name, "traceCtx", ctx)
+			} else if capacityOK && upgradeOK && cfg.SafeMode && !safeModePromoted(node) {
+				// the cordon above is real, only the drain is held back until an operator promotes safe mode for the node
+				log.Infow("Safe mode is on, skipping drain", "node", node.Name, "traceCtx", ctx)
+				TriggerEventf(recorder, node, trigger, v1.EventTypeNormal, "DrainSkippedSafeMode", "Node %s would be drained but mechanic is in safe mode, the node was left cordoned", node.Name)
+				decision.finish(DecisionSafeMode, nil)
+			} else if capacityOK && upgradeOK && !withinDrainBudget(ctx, node, cfg.Drain, state, recorder, trigger) {
+				decision.finish(DecisionDeferred, nil)
+			} else if capacityOK && upgradeOK {
+				state.RecordDrainStart(time.Now())
+				drainCfg := escalatedDrainConfig(ctx, node, cfg, state, recorder, trigger)
+				b, err := DrainNodeWithRetry(ctx, clientset, node, drainCfg, trigger)
+				if err != nil {
+					log.Errorw("Failed to drain node", "node", node.Name, "error", err, "traceCtx", ctx)
+					var pdbErr *PDBBlockedError
+					if errors.Is(err, ErrLocalStorageDeferred) {
+						TriggerEventf(recorder, node, trigger, v1.EventTypeWarning, "DrainDeferredLocalStorage", "Drain of node %s deferred, %s, the node was left cordoned", node.Name, err)
+					} else if errors.As(err, &pdbErr) {
+						reason := "DrainBlockedByPDB"
+						if pdbErr.Unsatisfiable() {
+							// waiting won't help, someone has to change the budget or move the pods
+							reason = "DrainBlockedByUnsatisfiablePDB"
+						}
+						TriggerEventf(recorder, node, trigger, v1.EventTypeWarning, reason, "Drain of node %s blocked by PodDisruptionBudgets %s, the node was left cordoned", node.Name, pdbErr.describe())
+					} else if errors.Is(err, ErrDrainPDBBlocked) {
+						// the budgets weren't looked up, so there's nothing more specific to say about them
+						TriggerEventf(recorder, node, trigger, v1.EventTypeWarning, "DrainBlockedByPDB", "Drain of node %s blocked by PodDisruptionBudgets, the node was left cordoned", node.Name)
+					} else if errors.Is(err, ErrDrainIncomplete) {
+						TriggerEventf(recorder, node, trigger, v1.EventTypeWarning, "DrainIncomplete", "Drain of node %s finished with pods still on the node, the node was left cordoned", node.Name)
+					} else if errors.Is(err, ErrDrainTimedOut) {
+						// the node stays cordoned so nothing new lands on it, and the drain is retried on the next update
+						TriggerEventf(recorder, node, trigger, v1.EventTypeWarning, "DrainTimeout", "Drain of node %s timed out, the node was left cordoned", node.Name)
+					} else {
+						TriggerEventf(recorder, node, trigger, v1.EventTypeWarning, "DrainNode", "Failed to drain node %s", node.Name)
+					}
+					if errors.Is(err, ErrLocalStorageDeferred) {
+						decision.finish(DecisionDeferred, err)
+					} else {
+						recordDrainFailure(ctx, node, cfg, state, recorder, trigger)
+						decision.finish(DecisionDrain, err)
+					}
+				} else {
+					state.SetDrained(b)
+					if b {
+						state.ResetDrainFailures()
+					}
+					// recorded on the node so a restarted agent knows the drain is done. a failure only risks draining
+					// again after a restart.
+					if b {
+						_ = PersistState(ctx, clientset, node, cfg, state, trigger.EventID)
+					}
+					if b && !trigger.DetectedAt.IsZero() {
+						metrics.EventToDrainSeconds.WithLabelValues(trigger.Category).Observe(time.Since(trigger.DetectedAt).Seconds())
+					}
+					log.Infow("Node drain completed", "node", node.Name, "state", state, "traceCtx", ctx)
+					TriggerEventf(recorder, node, trigger, v1.EventTypeNormal, "DrainNode", "Node %s drained by mechanic", node.Name)
+
+					// approving the event lets the platform start the maintenance now instead of at NotBefore. it's
+					// only an optimization, so a failure is logged and the event proceeds on its own schedule.
+					if b && cfg.AckEventAfterDrain && trigger.EventID != "" {
+						if err := ic.AckEvent(ctx, trigger.EventID); err != nil {
+							log.Warnw("Failed to acknowledge scheduled event after drain", "node", node.Name, "eventId", trigger.EventID, "error", err, "traceCtx", ctx)
+						}
+					}
+				}
+			}
+		}
+	} else {
+		decision.finish(DecisionNoEvent, nil)
+	}
+	// finished the event checking, cordon, and drain logic. checking for unneeded cordons now. grab an updated
+	// node object that should reflect all of our changes and use that for the ValidateCordon
+	log.Infow("Checking for unneeded cordon", "node", node.Name, "state", state, "traceCtx", ctx)
+	updated, err := clientset.CoreV1().Nodes().Get(ctx, node.Name, metav1.GetOptions{})
+	if err != nil {
+		log.Errorw("Failed to get updated node object", "node", node.Name, "error", err, "state", state, "traceCtx", ctx)
+		decision.err = err
+		return err
+	}
+	if cfg.ReconcileCordonMarkers {
+		// errors are logged by the reconcile and the node it returns is still safe to validate
+		updated, _ = ReconcileCordonMarkers(ctx, clientset, updated, cfg)
+	}
+	ValidateCordon(ctx, clientset, updated, cfg, recorder)
+
+	log.Infow("Finished reconciling node", "node", node.Name, "state", state, "traceCtx", ctx)
+	return nil
+}
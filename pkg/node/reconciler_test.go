@@ -0,0 +1,1077 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/amargherio/mechanic/internal/appstate"
+	"github.com/amargherio/mechanic/internal/config"
+	"github.com/amargherio/mechanic/pkg/imds"
+	"github.com/amargherio/mechanic/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+	"go.uber.org/zap/zaptest/observer"
+	v1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestReconcileNode(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	log := logger.Sugar()
+
+	cfg := config.Config{
+		DrainConditions: config.DrainConditions{
+			DrainOnPreempt:   true,
+			DrainOnTerminate: true,
+		},
+		GPUHealth: config.GPUHealthConfig{
+			Conditions:   []string{"GPUUncorrectableECCError"},
+			SustainedFor: 5 * time.Minute,
+		},
+	}
+
+	preempt := imds.ScheduledEvent{
+		EventId:      "preempt",
+		Type:         imds.Preempt,
+		ResourceType: "VirtualMachine",
+		Resources:    []string{"test-vmss_1"},
+		EventStatus:  imds.Scheduled,
+		NotBefore:    time.Now().Add(1 * time.Hour),
+		EventSource:  imds.Platform,
+	}
+	reboot := preempt
+	reboot.EventId = "reboot"
+	reboot.Type = imds.Reboot
+
+	tests := []struct {
+		name           string
+		conditions     []v1.NodeCondition
+		cordoned       bool
+		events         []imds.ScheduledEvent
+		imdsErr        error
+		expectError    bool
+		expectCordoned bool
+		expectDrained  bool
+		expectQueries  int
+		expectedEvents []string
+	}{
+		{
+			name:          "no conditions leaves the node alone",
+			expectQueries: 0,
+		},
+		{
+			name:           "drainable event cordons and drains",
+			conditions:     []v1.NodeCondition{{Type: "PreemptScheduled", Status: v1.ConditionTrue}},
+			events:         []imds.ScheduledEvent{preempt},
+			expectCordoned: true,
+			expectDrained:  true,
+			expectQueries:  1,
+			expectedEvents: []string{
+				"Normal CordonNode Node test-vmss000001 cordoned by mechanic (event: Preempt)",
+				"Normal DrainNode Node test-vmss000001 drained by mechanic (event: Preempt)",
+			},
+		},
+		{
+			name:          "event that isn't drainable leaves the node alone",
+			conditions:    []v1.NodeCondition{{Type: "VMEventScheduled", Status: v1.ConditionTrue}},
+			events:        []imds.ScheduledEvent{reboot},
+			expectQueries: 1,
+		},
+		{
+			name: "sustained GPU condition drains without IMDS",
+			conditions: []v1.NodeCondition{{
+				Type:               "GPUUncorrectableECCError",
+				Status:             v1.ConditionTrue,
+				LastTransitionTime: metav1.NewTime(time.Now().Add(-10 * time.Minute)),
+			}},
+			expectCordoned: true,
+			expectDrained:  true,
+			expectQueries:  0,
+			expectedEvents: []string{
+				"Normal CordonNode Node test-vmss000001 cordoned by mechanic (condition: GPUUncorrectableECCError)",
+				"Normal DrainNode Node test-vmss000001 drained by mechanic (condition: GPUUncorrectableECCError)",
+			},
+		},
+		{
+			name:           "mechanic cordon is released once the event clears",
+			cordoned:       true,
+			expectQueries:  0,
+			expectedEvents: []string{"Normal UncordonNode Node test-vmss000001 uncordoned by mechanic"},
+		},
+		{
+			name:          "IMDS failure is returned",
+			conditions:    []v1.NodeCondition{{Type: "PreemptScheduled", Status: v1.ConditionTrue}},
+			imdsErr:       errors.New("connection refused"),
+			expectError:   true,
+			expectQueries: 1,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// the context carries its own state and recorder to make sure the ones passed in are used instead
+			vals := config.ContextValues{
+				Logger:   log,
+				State:    &appstate.State{},
+				Recorder: &MockRecorder{},
+			}
+			ctx := context.WithValue(context.Background(), "values", &vals)
+
+			labels := map[string]string{}
+			if tc.cordoned {
+				labels["mechanic.cordoned"] = "true"
+			}
+			node := &v1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-vmss000001", UID: "uid-1", Labels: labels},
+				Spec:       v1.NodeSpec{Unschedulable: tc.cordoned},
+				Status:     v1.NodeStatus{Conditions: tc.conditions},
+			}
+			clientset := fake.NewClientset(node)
+			ic := &fakeIMDS{resp: imds.ScheduledEventsResponse{IncarnationID: 1, Events: tc.events}, err: tc.imdsErr}
+			state := &appstate.State{NodeUID: node.UID, IsCordoned: tc.cordoned}
+			recorder := &MockRecorder{}
+
+			err := ReconcileNode(ctx, clientset, ic, cfg, state, recorder, node)
+			if tc.expectError {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+
+			updated, err := clientset.CoreV1().Nodes().Get(ctx, node.Name, metav1.GetOptions{})
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectCordoned, updated.Spec.Unschedulable)
+			assert.Equal(t, tc.expectCordoned, state.Cordoned())
+			assert.Equal(t, tc.expectDrained, state.Drained())
+			assert.Equal(t, tc.expectQueries, ic.queries)
+			assert.Equal(t, tc.expectedEvents, recorder.Events)
+			assert.Empty(t, vals.Recorder.(*MockRecorder).Events, "events should go to the recorder passed in")
+		})
+	}
+}
+
+func TestReconcileNodeEventToDrainSeconds(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	vals := config.ContextValues{Logger: logger.Sugar()}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	cfg := config.Config{DrainConditions: config.DrainConditions{DrainOnPreempt: true}}
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-vmss000001", UID: "uid-1", Labels: map[string]string{}},
+		Status:     v1.NodeStatus{Conditions: []v1.NodeCondition{{Type: "PreemptScheduled", Status: v1.ConditionTrue}}},
+	}
+	clientset := fake.NewClientset(node)
+	ic := &fakeIMDS{resp: imds.ScheduledEventsResponse{IncarnationID: 1, Events: []imds.ScheduledEvent{{
+		EventId:      "preempt",
+		Type:         imds.Preempt,
+		ResourceType: "VirtualMachine",
+		Resources:    []string{"test-vmss_1"},
+		EventStatus:  imds.Scheduled,
+		NotBefore:    time.Now().Add(1 * time.Hour),
+		EventSource:  imds.Platform,
+	}}}}
+
+	// the event was first seen on an earlier update that couldn't finish the drain
+	detected := time.Now().Add(-30 * time.Second)
+	state := &appstate.State{NodeUID: node.UID, EventDetectedAt: detected}
+
+	before := histogramSnapshot(t, TriggerCategoryEvent)
+	require.NoError(t, ReconcileNode(ctx, clientset, ic, cfg, state, &MockRecorder{}, node))
+	after := histogramSnapshot(t, TriggerCategoryEvent)
+
+	require.True(t, state.Drained())
+	assert.Equal(t, detected, state.DetectedAt(), "the detection time is kept while the event is scheduled")
+	assert.Equal(t, uint64(1), after.GetSampleCount()-before.GetSampleCount())
+	observed := after.GetSampleSum() - before.GetSampleSum()
+	assert.GreaterOrEqual(t, observed, 30.0)
+	assert.Less(t, observed, 60.0)
+}
+
+func histogramSnapshot(t *testing.T, category string) *dto.Histogram {
+	m := &dto.Metric{}
+	require.NoError(t, metrics.EventToDrainSeconds.WithLabelValues(category).(prometheus.Histogram).Write(m))
+	return m.GetHistogram()
+}
+
+func TestReconcileNodeAcksEventAfterDrain(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	vals := config.ContextValues{Logger: logger.Sugar()}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	preempt := imds.ScheduledEvent{
+		EventId:      "f020ba2e-3bc0-4c40-a10b-86575a9eabd5",
+		Type:         imds.Preempt,
+		ResourceType: "VirtualMachine",
+		Resources:    []string{"test-vmss_1"},
+		EventStatus:  imds.Scheduled,
+		NotBefore:    time.Now().Add(1 * time.Hour),
+		EventSource:  imds.Platform,
+	}
+
+	tests := []struct {
+		name          string
+		ack           bool
+		ackErr        error
+		conditionType string
+		expectedAcks  []string
+	}{
+		{
+			name:          "event acknowledged after drain",
+			ack:           true,
+			conditionType: "PreemptScheduled",
+			expectedAcks:  []string{preempt.EventId},
+		},
+		{
+			name:          "failed acknowledgement doesn't fail the reconcile",
+			ack:           true,
+			ackErr:        errors.New("IMDS returned 500 Internal Server Error"),
+			conditionType: "PreemptScheduled",
+			expectedAcks:  []string{preempt.EventId},
+		},
+		{
+			name:          "acknowledgement disabled",
+			ack:           false,
+			conditionType: "PreemptScheduled",
+		},
+		{
+			name:          "condition triggers have no event to acknowledge",
+			ack:           true,
+			conditionType: "GPUUncorrectableECCError",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			node := &v1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-vmss000001", UID: "uid-1", Labels: map[string]string{}},
+				Status: v1.NodeStatus{Conditions: []v1.NodeCondition{{
+					Type:               v1.NodeConditionType(tc.conditionType),
+					Status:             v1.ConditionTrue,
+					LastTransitionTime: metav1.NewTime(time.Now().Add(-10 * time.Minute)),
+				}}},
+			}
+			cfg := config.Config{
+				DrainConditions:    config.DrainConditions{DrainOnPreempt: true},
+				GPUHealth:          config.GPUHealthConfig{Conditions: []string{"GPUUncorrectableECCError"}},
+				AckEventAfterDrain: tc.ack,
+			}
+			ic := &fakeIMDS{resp: imds.ScheduledEventsResponse{IncarnationID: 1, Events: []imds.ScheduledEvent{preempt}}, ackErr: tc.ackErr}
+			state := &appstate.State{NodeUID: node.UID}
+
+			require.NoError(t, ReconcileNode(ctx, fake.NewClientset(node), ic, cfg, state, &MockRecorder{}, node))
+			assert.True(t, state.Drained())
+			assert.Equal(t, tc.expectedAcks, ic.acked)
+		})
+	}
+}
+
+func TestReconcileNodeDrainTimeout(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	vals := config.ContextValues{Logger: logger.Sugar()}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	cfg := config.Config{
+		DrainConditions: config.DrainConditions{DrainOnPreempt: true},
+		Drain:           config.DrainConfig{Timeout: time.Second, Force: true},
+	}
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-vmss000001", UID: "uid-1", Labels: map[string]string{}},
+		Status:     v1.NodeStatus{Conditions: []v1.NodeCondition{{Type: "PreemptScheduled", Status: v1.ConditionTrue}}},
+	}
+	// evictions are accepted but the pod is never removed, like a pod stuck on a finalizer
+	stuck := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "stuck", Namespace: "default", Finalizers: []string{"example.com/never"}},
+		Spec:       v1.PodSpec{NodeName: node.Name},
+	}
+	clientset := newDrainResultClientset(nil, node, stuck)
+	ic := &fakeIMDS{resp: imds.ScheduledEventsResponse{IncarnationID: 1, Events: []imds.ScheduledEvent{{
+		EventId:      "preempt",
+		Type:         imds.Preempt,
+		ResourceType: "VirtualMachine",
+		Resources:    []string{"test-vmss_1"},
+		EventStatus:  imds.Scheduled,
+		NotBefore:    time.Now().Add(1 * time.Hour),
+		EventSource:  imds.Platform,
+	}}}}
+	state := &appstate.State{NodeUID: node.UID}
+	recorder := &MockRecorder{}
+
+	start := time.Now()
+	require.NoError(t, ReconcileNode(ctx, clientset, ic, cfg, state, recorder, node))
+	assert.Less(t, time.Since(start), 30*time.Second, "the drain should give up once the timeout passes")
+
+	updated, err := clientset.CoreV1().Nodes().Get(ctx, node.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.True(t, updated.Spec.Unschedulable, "the node stays cordoned after the drain times out")
+	assert.True(t, state.Cordoned())
+	assert.False(t, state.Drained())
+	assert.Contains(t, recorder.Events, "Warning DrainTimeout Drain of node test-vmss000001 timed out, the node was left cordoned (event: Preempt)")
+}
+
+func TestReconcileNodeMaintenanceTaint(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	vals := config.ContextValues{Logger: logger.Sugar()}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	cfg := config.Config{
+		DrainConditions:   config.DrainConditions{DrainOnPreempt: true},
+		MaintenanceTaints: []config.MaintenanceTaint{{Key: "maintenance", Value: "true", Effect: "NoSchedule"}},
+	}
+	maintenance := v1.Taint{Key: "maintenance", Value: "true", Effect: v1.TaintEffectNoSchedule}
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-vmss000001", UID: "uid-1", Labels: map[string]string{}},
+		Spec:       v1.NodeSpec{Taints: []v1.Taint{maintenance}},
+	}
+	clientset := fake.NewClientset(node)
+	ic := &fakeIMDS{}
+	state := &appstate.State{NodeUID: node.UID}
+	recorder := &MockRecorder{}
+
+	// the operator's taint cordons and drains the node without consulting IMDS
+	require.NoError(t, ReconcileNode(ctx, clientset, ic, cfg, state, recorder, node))
+	updated, err := clientset.CoreV1().Nodes().Get(ctx, node.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.True(t, updated.Spec.Unschedulable)
+	assert.True(t, state.Cordoned())
+	assert.True(t, state.Drained())
+	assert.Zero(t, ic.queries)
+	assert.Equal(t, []string{
+		"Normal CordonNode Node test-vmss000001 cordoned by mechanic (taint: maintenance)",
+		"Normal DrainNode Node test-vmss000001 drained by mechanic (taint: maintenance)",
+	}, recorder.Events)
+
+	// the operator removes its taint once the maintenance is done, which releases our cordon
+	updated.Spec.Taints = nil
+	updated, err = clientset.CoreV1().Nodes().Update(ctx, updated, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	recorder.Events = nil
+
+	require.NoError(t, ReconcileNode(ctx, clientset, ic, cfg, state, recorder, updated))
+	updated, err = clientset.CoreV1().Nodes().Get(ctx, node.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.False(t, updated.Spec.Unschedulable)
+	assert.False(t, state.Cordoned())
+	assert.Equal(t, []string{"Normal UncordonNode Node test-vmss000001 uncordoned by mechanic"}, recorder.Events)
+}
+
+func TestReconcileNodeMaintenanceTaintLeavesForeignCordon(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	vals := config.ContextValues{Logger: logger.Sugar()}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	cfg := config.Config{
+		MaintenanceTaints: []config.MaintenanceTaint{{Key: "maintenance"}},
+	}
+	// the operator cordoned the node itself before we saw its taint, and has since removed the taint
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-vmss000001", UID: "uid-1", Labels: map[string]string{}},
+		Spec:       v1.NodeSpec{Unschedulable: true},
+	}
+	clientset := fake.NewClientset(node)
+	state := &appstate.State{NodeUID: node.UID, IsCordoned: true}
+	recorder := &MockRecorder{}
+
+	require.NoError(t, ReconcileNode(ctx, clientset, &fakeIMDS{}, cfg, state, recorder, node))
+	updated, err := clientset.CoreV1().Nodes().Get(ctx, node.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.True(t, updated.Spec.Unschedulable, "a cordon without the mechanic label isn't ours to release")
+	assert.Empty(t, recorder.Events)
+}
+
+func TestReconcileNodeTriggerConsistency(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	vals := config.ContextValues{Logger: zap.New(core).Sugar()}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	cfg := config.Config{
+		DrainConditions: config.DrainConditions{DrainOnPreempt: true},
+		LogDecisions:    true,
+	}
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-vmss000001", UID: "uid-1", Labels: map[string]string{}},
+		Status:     v1.NodeStatus{Conditions: []v1.NodeCondition{{Type: "PreemptScheduled", Status: v1.ConditionTrue}}},
+	}
+	clientset := fake.NewClientset(node)
+	ic := &fakeIMDS{resp: imds.ScheduledEventsResponse{IncarnationID: 1, Events: []imds.ScheduledEvent{{
+		EventId:      "f020ba2e-3bc0-4c40-a10b-86575a9eabd5",
+		Type:         imds.Preempt,
+		ResourceType: "VirtualMachine",
+		Resources:    []string{"test-vmss_1"},
+		EventStatus:  imds.Scheduled,
+		NotBefore:    time.Now().Add(1 * time.Hour),
+		EventSource:  imds.Platform,
+	}}}}
+	// annotations hold the detection time to the second, so start from a whole second to compare it exactly
+	detected := time.Now().Add(-30 * time.Second).Truncate(time.Second).UTC()
+	state := &appstate.State{NodeUID: node.UID, EventDetectedAt: detected}
+	recorder := &MockRecorder{}
+
+	expected := Trigger{
+		Category:   TriggerCategoryEvent,
+		Reason:     "Preempt",
+		Deadline:   ic.resp.Events[0].NotBefore,
+		EventID:    "f020ba2e-3bc0-4c40-a10b-86575a9eabd5",
+		Condition:  "PreemptScheduled",
+		Source:     "Platform",
+		DetectedAt: detected,
+	}
+
+	cordonsBefore := testutil.ToFloat64(metrics.Cordons.WithLabelValues("", "", expected.Category))
+	drainsBefore := testutil.ToFloat64(metrics.Drains.WithLabelValues("", "", expected.Category))
+	require.NoError(t, ReconcileNode(ctx, clientset, ic, cfg, state, recorder, node))
+
+	// node annotations
+	updated, err := clientset.CoreV1().Nodes().Get(ctx, node.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	fromNode := triggerFromNode(updated)
+	assert.Equal(t, expected.Category, fromNode.Category)
+	assert.Equal(t, expected.Reason, fromNode.Reason)
+	assert.Equal(t, expected.EventID, fromNode.EventID)
+	assert.Equal(t, expected.Condition, fromNode.Condition)
+	assert.Equal(t, expected.Source, fromNode.Source)
+	assert.True(t, expected.DetectedAt.Equal(fromNode.DetectedAt), "node detected at %s, expected %s", fromNode.DetectedAt, expected.DetectedAt)
+
+	// event messages and annotations
+	require.Len(t, recorder.Events, 2)
+	for i, event := range recorder.Events {
+		assert.Contains(t, event, "(event: Preempt)")
+		assert.Equal(t, expected.annotations(), recorder.Annotations[i])
+	}
+
+	// metrics
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.Cordons.WithLabelValues("", "", expected.Category))-cordonsBefore)
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.Drains.WithLabelValues("", "", expected.Category))-drainsBefore)
+
+	// decision log
+	decisions := logs.FilterMessage("Reconcile decision").All()
+	require.Len(t, decisions, 1)
+	fields := decisions[0].ContextMap()
+	assert.Equal(t, expected.Category, fields["triggerCategory"])
+	assert.Equal(t, expected.Reason, fields["triggerReason"])
+	assert.Equal(t, expected.EventID, fields["eventId"])
+	assert.Equal(t, expected.Source, fields["triggerSource"])
+	assert.Equal(t, expected.DetectedAt, fields["detectedAt"])
+}
+
+func TestReconcileNodeCombinedTriggers(t *testing.T) {
+	gpuCondition := v1.NodeCondition{
+		Type:               "GPUUncorrectableECCError",
+		Status:             v1.ConditionTrue,
+		LastTransitionTime: metav1.NewTime(time.Now().Add(-10 * time.Minute)),
+	}
+	rebootCondition := v1.NodeCondition{Type: "RebootScheduled", Status: v1.ConditionTrue}
+	// the generic condition is set for any scheduled event, including ones that aren't drainable
+	vmEventCondition := v1.NodeCondition{Type: "VMEventScheduled", Status: v1.ConditionTrue}
+	reboot := imds.ScheduledEvent{
+		EventId:      "reboot",
+		Type:         imds.Reboot,
+		ResourceType: "VirtualMachine",
+		Resources:    []string{"test-vmss_1"},
+		EventStatus:  imds.Scheduled,
+		NotBefore:    time.Now().Add(1 * time.Hour).Truncate(time.Second),
+		EventSource:  imds.Platform,
+	}
+
+	tests := []struct {
+		name           string
+		combine        bool
+		drainOnReboot  bool
+		taint          bool
+		imdsErr        error
+		expectQueries  int
+		expectCategory string
+		expectReason   string
+		expectAlso     []string
+		expectDeadline time.Time
+		expectedEvents []string
+	}{
+		{
+			name:           "the scheduled event's deadline makes it the primary trigger",
+			combine:        true,
+			drainOnReboot:  true,
+			expectQueries:  1,
+			expectCategory: TriggerCategoryEvent,
+			expectReason:   "Reboot",
+			expectAlso:     []string{"condition: GPUUncorrectableECCError"},
+			expectDeadline: reboot.NotBefore,
+			expectedEvents: []string{
+				"Normal CordonNode Node test-vmss000001 cordoned by mechanic (event: Reboot) (also condition: GPUUncorrectableECCError)",
+				"Normal DrainNode Node test-vmss000001 drained by mechanic (event: Reboot) (also condition: GPUUncorrectableECCError)",
+			},
+		},
+		{
+			name:           "without combining, the GPU condition drains without IMDS",
+			drainOnReboot:  true,
+			expectQueries:  0,
+			expectCategory: TriggerCategoryCondition,
+			expectReason:   "GPUUncorrectableECCError",
+			expectedEvents: []string{
+				"Normal CordonNode Node test-vmss000001 cordoned by mechanic (condition: GPUUncorrectableECCError)",
+				"Normal DrainNode Node test-vmss000001 drained by mechanic (condition: GPUUncorrectableECCError)",
+			},
+		},
+		{
+			name:           "an IMDS failure doesn't hold up the drain for the GPU condition",
+			combine:        true,
+			drainOnReboot:  true,
+			imdsErr:        errors.New("connection refused"),
+			expectQueries:  1,
+			expectCategory: TriggerCategoryCondition,
+			expectReason:   "GPUUncorrectableECCError",
+			expectedEvents: []string{
+				"Normal CordonNode Node test-vmss000001 cordoned by mechanic (condition: GPUUncorrectableECCError)",
+				"Normal DrainNode Node test-vmss000001 drained by mechanic (condition: GPUUncorrectableECCError)",
+			},
+		},
+		{
+			name:           "an event that isn't drainable isn't recorded with the other triggers",
+			combine:        true,
+			taint:          true,
+			expectQueries:  1,
+			expectCategory: TriggerCategoryCondition,
+			expectReason:   "GPUUncorrectableECCError",
+			expectAlso:     []string{"taint: maintenance"},
+			expectedEvents: []string{
+				"Normal CordonNode Node test-vmss000001 cordoned by mechanic (condition: GPUUncorrectableECCError) (also taint: maintenance)",
+				"Normal DrainNode Node test-vmss000001 drained by mechanic (condition: GPUUncorrectableECCError) (also taint: maintenance)",
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			core, logs := observer.New(zap.InfoLevel)
+			vals := config.ContextValues{Logger: zap.New(core).Sugar()}
+			ctx := context.WithValue(context.Background(), "values", &vals)
+
+			cfg := config.Config{
+				DrainConditions:   config.DrainConditions{DrainOnReboot: tc.drainOnReboot},
+				GPUHealth:         config.GPUHealthConfig{Conditions: []string{"GPUUncorrectableECCError"}, SustainedFor: 5 * time.Minute},
+				MaintenanceTaints: []config.MaintenanceTaint{{Key: "maintenance"}},
+				CombineTriggers:   tc.combine,
+			}
+			node := &v1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-vmss000001", UID: "uid-1", Labels: map[string]string{}},
+				Status:     v1.NodeStatus{Conditions: []v1.NodeCondition{gpuCondition, rebootCondition, vmEventCondition}},
+			}
+			if tc.taint {
+				node.Spec.Taints = []v1.Taint{{Key: "maintenance", Effect: v1.TaintEffectNoSchedule}}
+			}
+			clientset := fake.NewClientset(node)
+			ic := &fakeIMDS{resp: imds.ScheduledEventsResponse{IncarnationID: 1, Events: []imds.ScheduledEvent{reboot}}, err: tc.imdsErr}
+			state := &appstate.State{NodeUID: node.UID}
+			recorder := &MockRecorder{}
+
+			require.NoError(t, ReconcileNode(ctx, clientset, ic, cfg, state, recorder, node))
+			assert.True(t, state.Cordoned())
+			assert.True(t, state.Drained())
+			assert.Equal(t, tc.expectQueries, ic.queries)
+			assert.Equal(t, tc.expectedEvents, recorder.Events)
+
+			updated, err := clientset.CoreV1().Nodes().Get(ctx, node.Name, metav1.GetOptions{})
+			require.NoError(t, err)
+			fromNode := triggerFromNode(updated)
+			assert.Equal(t, tc.expectCategory, fromNode.Category)
+			assert.Equal(t, tc.expectReason, fromNode.Reason)
+			assert.Equal(t, tc.expectAlso, fromNode.Also)
+
+			// the primary trigger's deadline bounds the drain timeout
+			drains := logs.FilterMessage("Beginning node drain").All()
+			require.Len(t, drains, 1)
+			assert.Equal(t, tc.expectReason, drains[0].ContextMap()["reason"])
+			assert.True(t, tc.expectDeadline.Equal(drains[0].ContextMap()["deadline"].(time.Time)))
+		})
+	}
+}
+
+func TestReconcileNodeAlsoPollIMDS(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	vals := config.ContextValues{Logger: logger.Sugar()}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	preempt := imds.ScheduledEvent{
+		EventId:      "preempt",
+		Type:         imds.Preempt,
+		ResourceType: "VirtualMachine",
+		Resources:    []string{"test-vmss_1"},
+		EventStatus:  imds.Scheduled,
+		NotBefore:    time.Now().Add(1 * time.Hour),
+		EventSource:  imds.Platform,
+	}
+
+	tests := []struct {
+		name           string
+		alsoPoll       bool
+		imdsErr        error
+		expectDrained  bool
+		expectQueries  int
+		expectedEvents []string
+	}{
+		{
+			name:          "an event only IMDS reports is drained for",
+			alsoPoll:      true,
+			expectDrained: true,
+			expectQueries: 2,
+			expectedEvents: []string{
+				"Normal CordonNode Node test-vmss000001 cordoned by mechanic (event: Preempt)",
+				"Normal DrainNode Node test-vmss000001 drained by mechanic (event: Preempt)",
+			},
+		},
+		{
+			name:          "IMDS isn't checked without a condition by default",
+			expectQueries: 0,
+		},
+		{
+			name:          "a failed poll leaves the node alone",
+			alsoPoll:      true,
+			imdsErr:       errors.New("connection refused"),
+			expectQueries: 1,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := config.Config{
+				DrainConditions: config.DrainConditions{DrainOnPreempt: true},
+				AlsoPollIMDS:    tc.alsoPoll,
+			}
+			// no node condition reports the event, as on a cluster without Node Problem Detector
+			node := &v1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-vmss000001", UID: "uid-1", Labels: map[string]string{}},
+			}
+			clientset := fake.NewClientset(node)
+			ic := &fakeIMDS{resp: imds.ScheduledEventsResponse{IncarnationID: 1, Events: []imds.ScheduledEvent{preempt}}, err: tc.imdsErr}
+			state := &appstate.State{NodeUID: node.UID}
+			recorder := &MockRecorder{}
+
+			require.NoError(t, ReconcileNode(ctx, clientset, ic, cfg, state, recorder, node))
+			updated, err := clientset.CoreV1().Nodes().Get(ctx, node.Name, metav1.GetOptions{})
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectDrained, updated.Spec.Unschedulable)
+			assert.Equal(t, tc.expectDrained, state.Drained())
+			assert.Equal(t, tc.expectQueries, ic.queries)
+			assert.Equal(t, tc.expectedEvents, recorder.Events)
+			if !tc.expectDrained {
+				return
+			}
+
+			// once IMDS no longer has the event, the cordon is released like when a condition clears
+			ic.resp = imds.ScheduledEventsResponse{IncarnationID: 2}
+			recorder.Events = nil
+			require.NoError(t, ReconcileNode(ctx, clientset, ic, cfg, state, recorder, updated))
+			updated, err = clientset.CoreV1().Nodes().Get(ctx, node.Name, metav1.GetOptions{})
+			require.NoError(t, err)
+			assert.False(t, updated.Spec.Unschedulable)
+			assert.Equal(t, []string{"Normal UncordonNode Node test-vmss000001 uncordoned by mechanic"}, recorder.Events)
+		})
+	}
+}
+
+func TestReconcileNodePDBBlocked(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	vals := config.ContextValues{Logger: logger.Sugar()}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	one := intstr.FromInt32(1)
+	zero := intstr.FromInt32(0)
+	pdbErr := apierrors.NewTooManyRequests("Cannot evict pod as it would violate the pod's disruption budget.", 0)
+
+	tests := []struct {
+		name          string
+		respectPDBs   bool
+		pdbSpec       policyv1.PodDisruptionBudgetSpec
+		expectedEvent string
+	}{
+		{
+			name:          "budget that will allow a disruption later",
+			respectPDBs:   true,
+			pdbSpec:       policyv1.PodDisruptionBudgetSpec{MinAvailable: &one},
+			expectedEvent: "Warning DrainBlockedByPDB Drain of node test-vmss000001 blocked by PodDisruptionBudgets default/web (pods: web-1), the node was left cordoned (event: Preempt)",
+		},
+		{
+			name:          "budget that can never be satisfied",
+			respectPDBs:   true,
+			pdbSpec:       policyv1.PodDisruptionBudgetSpec{MaxUnavailable: &zero},
+			expectedEvent: "Warning DrainBlockedByUnsatisfiablePDB Drain of node test-vmss000001 blocked by PodDisruptionBudgets default/web (pods: web-1), the node was left cordoned (event: Preempt)",
+		},
+		{
+			name:          "not respecting PDBs reports the block without looking up the budgets",
+			respectPDBs:   false,
+			pdbSpec:       policyv1.PodDisruptionBudgetSpec{MaxUnavailable: &zero},
+			expectedEvent: "Warning DrainBlockedByPDB Drain of node test-vmss000001 blocked by PodDisruptionBudgets, the node was left cordoned (event: Preempt)",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// the drain helper sleeps 5 seconds after a PDB rejects an eviction, so run the cases side by side
+			t.Parallel()
+
+			cfg := config.Config{
+				DrainConditions: config.DrainConditions{DrainOnPreempt: true},
+				Drain:           config.DrainConfig{Timeout: time.Second, Force: true, RespectPDBs: tc.respectPDBs},
+			}
+			node := &v1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-vmss000001", UID: "uid-1", Labels: map[string]string{}},
+				Status:     v1.NodeStatus{Conditions: []v1.NodeCondition{{Type: "PreemptScheduled", Status: v1.ConditionTrue}}},
+			}
+			pod := &v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "web-1", Namespace: "default", Labels: map[string]string{"app": "web"}},
+				Spec:       v1.PodSpec{NodeName: node.Name},
+			}
+			pdb := newTestPDB("web", map[string]string{"app": "web"}, tc.pdbSpec, 0, 2)
+			clientset := newDrainResultClientset(pdbErr, node, pod, pdb)
+			ic := &fakeIMDS{resp: imds.ScheduledEventsResponse{IncarnationID: 1, Events: []imds.ScheduledEvent{{
+				EventId:      "preempt",
+				Type:         imds.Preempt,
+				ResourceType: "VirtualMachine",
+				Resources:    []string{"test-vmss_1"},
+				EventStatus:  imds.Scheduled,
+				NotBefore:    time.Now().Add(1 * time.Hour),
+				EventSource:  imds.Platform,
+			}}}}
+			state := &appstate.State{NodeUID: node.UID}
+			recorder := &MockRecorder{}
+
+			require.NoError(t, ReconcileNode(ctx, clientset, ic, cfg, state, recorder, node))
+
+			updated, err := clientset.CoreV1().Nodes().Get(ctx, node.Name, metav1.GetOptions{})
+			require.NoError(t, err)
+			assert.True(t, updated.Spec.Unschedulable)
+			assert.False(t, state.Drained())
+			assert.Contains(t, recorder.Events, tc.expectedEvent)
+		})
+	}
+}
+
+func TestReconcileNodeSafeMode(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	vals := config.ContextValues{Logger: logger.Sugar()}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	cfg := config.Config{
+		DrainConditions: config.DrainConditions{DrainOnPreempt: true},
+		SafeMode:        true,
+	}
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-vmss000001", UID: "uid-1", Labels: map[string]string{}},
+		Status:     v1.NodeStatus{Conditions: []v1.NodeCondition{{Type: "PreemptScheduled", Status: v1.ConditionTrue}}},
+	}
+	clientset := fake.NewClientset(node)
+	ic := &fakeIMDS{resp: imds.ScheduledEventsResponse{IncarnationID: 1, Events: []imds.ScheduledEvent{{
+		EventId:      "preempt",
+		Type:         imds.Preempt,
+		ResourceType: "VirtualMachine",
+		Resources:    []string{"test-vmss_1"},
+		EventStatus:  imds.Scheduled,
+		NotBefore:    time.Now().Add(1 * time.Hour),
+		EventSource:  imds.Platform,
+	}}}}
+	state := &appstate.State{NodeUID: node.UID}
+	recorder := &MockRecorder{}
+
+	require.NoError(t, ReconcileNode(ctx, clientset, ic, cfg, state, recorder, node))
+	updated, err := clientset.CoreV1().Nodes().Get(ctx, node.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.True(t, updated.Spec.Unschedulable, "safe mode still cordons the node")
+	assert.True(t, state.Cordoned())
+	assert.False(t, state.Drained())
+	assert.Equal(t, []string{
+		"Normal CordonNode Node test-vmss000001 cordoned by mechanic (event: Preempt)",
+		"Normal DrainSkippedSafeMode Node test-vmss000001 would be drained but mechanic is in safe mode, the node was left cordoned (event: Preempt)",
+	}, recorder.Events)
+
+	// once promoted, the next reconcile drains the node
+	require.NoError(t, PromoteSafeMode(ctx, clientset, node.Name, cfg))
+	updated, err = clientset.CoreV1().Nodes().Get(ctx, node.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "true", updated.Annotations[safeModePromotedAnnotation], "the promotion is kept on the node")
+	recorder.Events = nil
+	require.NoError(t, ReconcileNode(ctx, clientset, ic, cfg, state, recorder, updated))
+	assert.True(t, state.Drained())
+	assert.Contains(t, recorder.Events, "Normal DrainNode Node test-vmss000001 drained by mechanic (event: Preempt)")
+}
+
+func TestReconcileNodeUpgradeInProgress(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	vals := config.ContextValues{Logger: logger.Sugar()}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	cfg := config.Config{
+		DrainConditions: config.DrainConditions{DrainOnPreempt: true},
+		UpgradeSignal:   config.UpgradeSignalConfig{ConfigMapName: "cluster-upgrade", ConfigMapNamespace: "kube-system"},
+	}
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-vmss000001", UID: "uid-1", Labels: map[string]string{}},
+		Status:     v1.NodeStatus{Conditions: []v1.NodeCondition{{Type: "PreemptScheduled", Status: v1.ConditionTrue}}},
+	}
+	upgrade := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "cluster-upgrade", Namespace: "kube-system"}}
+	clientset := fake.NewClientset(node, upgrade)
+	ic := &fakeIMDS{resp: imds.ScheduledEventsResponse{IncarnationID: 1, Events: []imds.ScheduledEvent{{
+		EventId:      "preempt",
+		Type:         imds.Preempt,
+		ResourceType: "VirtualMachine",
+		Resources:    []string{"test-vmss_1"},
+		EventStatus:  imds.Scheduled,
+		NotBefore:    time.Now().Add(1 * time.Hour),
+		EventSource:  imds.Platform,
+	}}}}
+	state := &appstate.State{NodeUID: node.UID}
+	recorder := &MockRecorder{}
+
+	require.NoError(t, ReconcileNode(ctx, clientset, ic, cfg, state, recorder, node))
+	updated, err := clientset.CoreV1().Nodes().Get(ctx, node.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.True(t, updated.Spec.Unschedulable, "the node is still cordoned during an upgrade")
+	assert.False(t, state.Drained())
+	assert.Contains(t, recorder.Events, "Normal DrainSuppressedUpgrade Drain of node test-vmss000001 suppressed while a cluster upgrade is in progress (ConfigMap kube-system/cluster-upgrade), the node was left cordoned (event: Preempt)")
+
+	// once the upgrade signal is removed, the next reconcile drains the node
+	require.NoError(t, clientset.CoreV1().ConfigMaps("kube-system").Delete(ctx, "cluster-upgrade", metav1.DeleteOptions{}))
+	recorder.Events = nil
+	require.NoError(t, ReconcileNode(ctx, clientset, ic, cfg, state, recorder, updated))
+	assert.True(t, state.Drained())
+	assert.Contains(t, recorder.Events, "Normal DrainNode Node test-vmss000001 drained by mechanic (event: Preempt)")
+}
+
+func TestReconcileNodeMaintenanceDescription(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	vals := config.ContextValues{Logger: logger.Sugar()}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	const description = "Virtual machine is going to be restarted as requested by authorized user."
+
+	for _, enabled := range []bool{true, false} {
+		t.Run(fmt.Sprintf("enabled=%t", enabled), func(t *testing.T) {
+			cfg := config.Config{
+				DrainConditions:                config.DrainConditions{DrainOnReboot: true},
+				AnnotateMaintenanceDescription: enabled,
+			}
+			node := &v1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-vmss000001", UID: "uid-1", Labels: map[string]string{}},
+				Status:     v1.NodeStatus{Conditions: []v1.NodeCondition{{Type: "RebootScheduled", Status: v1.ConditionTrue}}},
+			}
+			clientset := fake.NewClientset(node)
+			ic := &fakeIMDS{resp: imds.ScheduledEventsResponse{IncarnationID: 1, Events: []imds.ScheduledEvent{{
+				EventId:      "reboot",
+				Type:         imds.Reboot,
+				ResourceType: "VirtualMachine",
+				Resources:    []string{"test-vmss_1"},
+				EventStatus:  imds.Scheduled,
+				NotBefore:    time.Now().Add(1 * time.Hour),
+				Description:  description,
+				EventSource:  imds.User,
+			}}}}
+			state := &appstate.State{NodeUID: node.UID}
+
+			require.NoError(t, ReconcileNode(ctx, clientset, ic, cfg, state, &MockRecorder{}, node))
+			updated, err := clientset.CoreV1().Nodes().Get(ctx, node.Name, metav1.GetOptions{})
+			require.NoError(t, err)
+			require.True(t, updated.Spec.Unschedulable)
+			if enabled {
+				assert.Equal(t, description, updated.Annotations[maintenanceDescriptionAnnotation])
+			} else {
+				assert.NotContains(t, updated.Annotations, maintenanceDescriptionAnnotation)
+			}
+
+			// once the event resolves, the cordon and its description are removed
+			updated.Status.Conditions = nil
+			_, err = clientset.CoreV1().Nodes().Update(ctx, updated, metav1.UpdateOptions{})
+			require.NoError(t, err)
+			ic.resp.Events = nil
+			require.NoError(t, ReconcileNode(ctx, clientset, ic, cfg, state, &MockRecorder{}, updated))
+			resolved, err := clientset.CoreV1().Nodes().Get(ctx, node.Name, metav1.GetOptions{})
+			require.NoError(t, err)
+			assert.False(t, resolved.Spec.Unschedulable)
+			assert.NotContains(t, resolved.Annotations, maintenanceDescriptionAnnotation)
+		})
+	}
+}
+
+func TestReconcileNodeDrainReasonAnnotations(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	vals := config.ContextValues{Logger: logger.Sugar()}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	cfg := config.Config{DrainConditions: config.DrainConditions{DrainOnPreempt: true}}
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-vmss000001", UID: "uid-1", Labels: map[string]string{}},
+		Status: v1.NodeStatus{Conditions: []v1.NodeCondition{
+			{Type: "FreezeScheduled", Status: v1.ConditionFalse},
+			{Type: "PreemptScheduled", Status: v1.ConditionTrue},
+		}},
+	}
+	clientset := fake.NewClientset(node)
+	ic := &fakeIMDS{resp: imds.ScheduledEventsResponse{IncarnationID: 1, Events: []imds.ScheduledEvent{{
+		EventId:      "5d3a7c1e-preempt",
+		Type:         imds.Preempt,
+		ResourceType: "VirtualMachine",
+		Resources:    []string{"test-vmss_1"},
+		EventStatus:  imds.Scheduled,
+		NotBefore:    time.Now().Add(1 * time.Hour),
+		EventSource:  imds.Platform,
+	}}}}
+	state := &appstate.State{NodeUID: node.UID}
+	recorder := &MockRecorder{}
+
+	require.NoError(t, ReconcileNode(ctx, clientset, ic, cfg, state, recorder, node))
+	require.True(t, state.Drained())
+	assert.Contains(t, recorder.Events, "Normal DrainNode Node test-vmss000001 drained by mechanic (event: Preempt)")
+
+	// the node records the event that caused the drain and the condition that flagged it
+	drained, err := clientset.CoreV1().Nodes().Get(ctx, node.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, TriggerCategoryEvent, drained.Annotations[triggerCategoryAnnotation])
+	assert.Equal(t, "Preempt", drained.Annotations[triggerReasonAnnotation])
+	assert.Equal(t, "5d3a7c1e-preempt", drained.Annotations[triggerEventIDAnnotation])
+	assert.Equal(t, "PreemptScheduled", drained.Annotations[triggerConditionAnnotation])
+	assert.Equal(t, "PreemptScheduled", triggerFromNode(drained).Condition)
+
+	// releasing the cordon removes them
+	drained.Status.Conditions = nil
+	_, err = clientset.CoreV1().Nodes().Update(ctx, drained, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	ic.resp.Events = nil
+	require.NoError(t, ReconcileNode(ctx, clientset, ic, cfg, state, &MockRecorder{}, drained))
+	resolved, err := clientset.CoreV1().Nodes().Get(ctx, node.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.False(t, resolved.Spec.Unschedulable)
+	for _, key := range triggerAnnotationKeys {
+		assert.NotContains(t, resolved.Annotations, key)
+	}
+}
+
+func TestReconcileNodeStaleStartupCondition(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	vals := config.ContextValues{Logger: logger.Sugar()}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	preempt := imds.ScheduledEvent{
+		EventId:      "preempt",
+		Type:         imds.Preempt,
+		ResourceType: "VirtualMachine",
+		Resources:    []string{"test-vmss_1"},
+		EventStatus:  imds.Scheduled,
+		NotBefore:    time.Now().Add(1 * time.Hour),
+		EventSource:  imds.Platform,
+	}
+
+	tests := []struct {
+		name           string
+		events         []imds.ScheduledEvent
+		expectCordoned bool
+		expectQueries  int
+	}{
+		{name: "a condition IMDS doesn't confirm is ignored", expectQueries: 1},
+		{name: "a condition IMDS confirms is acted on", events: []imds.ScheduledEvent{preempt}, expectCordoned: true, expectQueries: 2},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := config.Config{
+				DrainConditions:           config.DrainConditions{DrainOnPreempt: true},
+				ValidateStartupConditions: true,
+			}
+			// NPD set the condition for an event that resolved while mechanic wasn't running
+			node := &v1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-vmss000001", UID: "uid-1", Labels: map[string]string{}},
+				Status: v1.NodeStatus{Conditions: []v1.NodeCondition{{
+					Type:               "PreemptScheduled",
+					Status:             v1.ConditionTrue,
+					LastTransitionTime: metav1.NewTime(time.Now().Add(-2 * time.Hour)),
+				}}},
+			}
+			clientset := fake.NewClientset(node)
+
+			// mechanic starts and reconciles the node as its informer first lists it
+			factory := informers.NewSharedInformerFactoryWithOptions(clientset, 0,
+				informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+					options.FieldSelector = fields.OneTermEqualSelector("metadata.name", node.Name).String()
+				}))
+			ni := factory.Core().V1().Nodes().Informer()
+			stop := make(chan struct{})
+			defer close(stop)
+			factory.Start(stop)
+			require.True(t, cache.WaitForCacheSync(stop, ni.HasSynced))
+			obj, exists, err := ni.GetStore().GetByKey(node.Name)
+			require.NoError(t, err)
+			require.True(t, exists)
+
+			ic := &fakeIMDS{resp: imds.ScheduledEventsResponse{IncarnationID: 1, Events: tc.events}}
+			state := &appstate.State{}
+			recorder := &MockRecorder{}
+
+			require.NoError(t, ReconcileNode(ctx, clientset, ic, cfg, state, recorder, obj.(*v1.Node)))
+			updated, err := clientset.CoreV1().Nodes().Get(ctx, node.Name, metav1.GetOptions{})
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectCordoned, updated.Spec.Unschedulable)
+			assert.Equal(t, tc.expectCordoned, state.Cordoned())
+			assert.Equal(t, tc.expectQueries, ic.queries)
+			assert.Equal(t, tc.expectCordoned, state.EventScheduled(), "a stale condition shouldn't leave an event scheduled")
+			assert.True(t, state.StartupConditionsValidated())
+		})
+	}
+}
+
+func TestReconcileNodeLeadTimeOpensAt(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	vals := config.ContextValues{Logger: logger.Sugar()}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	cfg := config.Config{DrainConditions: config.DrainConditions{DrainOnPreempt: true, LeadTime: 15 * time.Minute}}
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-vmss000001", UID: "uid-1", Labels: map[string]string{}},
+		Status:     v1.NodeStatus{Conditions: []v1.NodeCondition{{Type: "PreemptScheduled", Status: v1.ConditionTrue}}},
+	}
+	notBefore := time.Now().Add(time.Hour).Truncate(time.Second)
+	ic := &fakeIMDS{resp: imds.ScheduledEventsResponse{IncarnationID: 1, Events: []imds.ScheduledEvent{{
+		EventId:      "preempt",
+		Type:         imds.Preempt,
+		ResourceType: "VirtualMachine",
+		Resources:    []string{"test-vmss_1"},
+		EventStatus:  imds.Scheduled,
+		NotBefore:    notBefore,
+		EventSource:  imds.Platform,
+	}}}}
+	clientset := fake.NewClientset(node)
+	state := &appstate.State{NodeUID: node.UID}
+
+	// the event is outside the lead time, so the node is left alone until the window opens
+	require.NoError(t, ReconcileNode(ctx, clientset, ic, cfg, state, &MockRecorder{}, node))
+	assert.False(t, state.Cordoned())
+	assert.Equal(t, notBefore.Add(-15*time.Minute), state.LeadTimeOpensAt())
+
+	// once the event is gone there's nothing to come back for
+	ic.resp = imds.ScheduledEventsResponse{IncarnationID: 2}
+	require.NoError(t, ReconcileNode(ctx, clientset, ic, cfg, state, &MockRecorder{}, node))
+	assert.True(t, state.LeadTimeOpensAt().IsZero())
+}
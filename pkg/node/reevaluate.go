@@ -0,0 +1,49 @@
+package node
+
+import (
+	"context"
+
+	"github.com/amargherio/mechanic/internal/config"
+	"go.opentelemetry.io/otel"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+)
+
+// ReevaluateCordon checks a cordon mechanic placed for a scheduled event against the drain conditions in cfg and
+// releases it when the event type is no longer one we drain for. It's meant to be called after the configuration
+// changes at runtime, so a node cordoned while waiting to drain for an event type that was since disabled isn't left
+// cordoned until the event passes. Cordons we don't own and cordons for conditions or taints are left alone, since
+// those are re-checked on every reconcile. It returns true when the cordon was released. The caller must hold the
+// state lock.
+func ReevaluateCordon(ctx context.Context, clientset kubernetes.Interface, node *v1.Node, cfg config.Config, recorder record.EventRecorder) (bool, error) {
+	tracer := otel.Tracer("github.com/amargherio/mechanic/pkg/node")
+	ctx, span := tracer.Start(ctx, "ReevaluateCordon")
+	defer span.End()
+
+	vals := ctx.Value("values").(*config.ContextValues)
+	log := vals.Logger
+
+	if !ownsCordon(node, cfg.Cordon) || !node.Spec.Unschedulable {
+		return false, nil
+	}
+
+	trigger := triggerFromNode(node)
+	if trigger.Category != TriggerCategoryEvent || cfg.DrainConditions.DrainsFor(trigger.Reason) {
+		return false, nil
+	}
+
+	log.Infow("Node is cordoned for an event type mechanic no longer drains for. Releasing the cordon.", "node", node.Name, "eventType", trigger.Reason, "eventID", trigger.EventID, "traceCtx", ctx)
+	if err := UncordonNode(ctx, clientset, node, cfg); err != nil {
+		log.Errorw("Failed to uncordon node", "node", node.Name, "error", err, "traceCtx", ctx)
+		Eventf(recorder, node, v1.EventTypeWarning, "UncordonNode", "Failed to uncordon node %s", node.Name)
+		return false, err
+	}
+	Eventf(recorder, node, v1.EventTypeNormal, "UncordonNode", "Node %s uncordoned by mechanic, %s events are no longer drained for", node.Name, trigger.Reason)
+
+	// the event is still scheduled but we're no longer acting on it, so clear the state it left behind
+	vals.State.SetEventScheduled(false)
+	vals.State.SetDrainRequired(false)
+	vals.State.SetDrained(false)
+	return true, nil
+}
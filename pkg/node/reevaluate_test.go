@@ -0,0 +1,99 @@
+package node
+
+import (
+	"context"
+	"testing"
+
+	"github.com/amargherio/mechanic/internal/appstate"
+	"github.com/amargherio/mechanic/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestReevaluateCordon(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	log := logger.Sugar()
+
+	mechanicLabels := map[string]string{"mechanic.cordoned": "true"}
+	rebootAnnotations := map[string]string{
+		triggerCategoryAnnotation: TriggerCategoryEvent,
+		triggerReasonAnnotation:   "Reboot",
+		triggerEventIDAnnotation:  "event-1",
+	}
+
+	tests := []struct {
+		name            string
+		labels          map[string]string
+		annotations     map[string]string
+		drainConditions config.DrainConditions
+		expectReleased  bool
+	}{
+		{
+			name:            "event type disabled by reload releases the cordon",
+			labels:          mechanicLabels,
+			annotations:     rebootAnnotations,
+			drainConditions: config.DrainConditions{DrainOnRedeploy: true},
+			expectReleased:  true,
+		},
+		{
+			name:            "event type still enabled keeps the cordon",
+			labels:          mechanicLabels,
+			annotations:     rebootAnnotations,
+			drainConditions: config.DrainConditions{DrainOnReboot: true},
+		},
+		{
+			name:   "condition cordon is left alone",
+			labels: mechanicLabels,
+			annotations: map[string]string{
+				triggerCategoryAnnotation: TriggerCategoryCondition,
+				triggerReasonAnnotation:   "GpuUnhealthy",
+			},
+		},
+		{
+			name:        "cordon we don't own is left alone",
+			labels:      map[string]string{},
+			annotations: rebootAnnotations,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			state := &appstate.State{HasEventScheduled: true, IsCordoned: true, ShouldDrain: true}
+			vals := config.ContextValues{
+				Logger: log,
+				State:  state,
+			}
+			ctx := context.WithValue(context.Background(), "values", &vals)
+
+			node := &v1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-node", Labels: tc.labels, Annotations: tc.annotations},
+				Spec:       v1.NodeSpec{Unschedulable: true},
+			}
+			clientset := fake.NewClientset(node)
+			recorder := &MockRecorder{}
+
+			released, err := ReevaluateCordon(ctx, clientset, node, config.Config{DrainConditions: tc.drainConditions}, recorder)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectReleased, released)
+
+			stored, err := clientset.CoreV1().Nodes().Get(ctx, node.Name, metav1.GetOptions{})
+			require.NoError(t, err)
+			assert.Equal(t, !tc.expectReleased, stored.Spec.Unschedulable)
+			assert.Equal(t, !tc.expectReleased, state.Cordoned())
+			assert.Equal(t, !tc.expectReleased, state.EventScheduled())
+			assert.Equal(t, !tc.expectReleased, state.DrainRequired())
+			if tc.expectReleased {
+				assert.NotContains(t, stored.Labels, "mechanic.cordoned")
+				assert.NotContains(t, stored.Annotations, triggerReasonAnnotation)
+				assert.Equal(t, []string{"Normal UncordonNode Node test-node uncordoned by mechanic, Reboot events are no longer drained for"}, recorder.Events)
+			} else {
+				assert.Empty(t, recorder.Events)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package node
+
+import (
+	"context"
+
+	"github.com/amargherio/mechanic/internal/config"
+	"go.opentelemetry.io/otel"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// safeModePromotedAnnotation promotes safe mode for the node when set to true, so the drains it's holding back run.
+// It's kept on the node, so the promotion outlives restarts of the agent, and setting it takes the same permission as
+// cordoning the node.
+const safeModePromotedAnnotation = "mechanic.io/safe-mode-promoted"
+
+// safeModePromoted reports whether safe mode has been promoted for the node
+func safeModePromoted(node *v1.Node) bool {
+	return node.GetAnnotations()[safeModePromotedAnnotation] == "true"
+}
+
+// PromoteSafeMode annotates the node so the drains held back by safe mode run from the next reconcile on. Updating the
+// node also triggers that reconcile through the node informer.
+func PromoteSafeMode(ctx context.Context, clientset kubernetes.Interface, nodeName string, cfg config.Config) error {
+	tracer := otel.Tracer("github.com/amargherio/mechanic/pkg/node")
+	ctx, span := tracer.Start(ctx, "PromoteSafeMode")
+	defer span.End()
+
+	vals := ctx.Value("values").(*config.ContextValues)
+	log := vals.Logger
+
+	err := retryNodeUpdate(ctx, cfg.NodeUpdateRetry, func() error {
+		n, err := clientset.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if safeModePromoted(n) {
+			return nil
+		}
+
+		annotations := n.GetAnnotations()
+		if annotations == nil {
+			annotations = make(map[string]string)
+		}
+		annotations[safeModePromotedAnnotation] = "true"
+		n.SetAnnotations(annotations)
+
+		_, err = clientset.CoreV1().Nodes().Update(ctx, n, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		log.Errorw("Failed to promote safe mode for the node", "node", nodeName, "error", err, "traceCtx", ctx)
+		return err
+	}
+	log.Infow("Promoted safe mode for the node, held back drains will run", "node", nodeName, "traceCtx", ctx)
+	return nil
+}
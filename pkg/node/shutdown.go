@@ -0,0 +1,75 @@
+package node
+
+import (
+	"context"
+
+	"github.com/amargherio/mechanic/internal/config"
+	"github.com/amargherio/mechanic/pkg/imds"
+	"go.opentelemetry.io/otel"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+)
+
+// UncordonOnShutdown releases mechanic's cordon on the node as the agent shuts down, so a cordon for an event that
+// never happened isn't left behind when mechanic is removed. Like ValidateCordon, it only touches cordons carrying the
+// mechanic cordon label, and the cordon is kept while the node still has a condition or taint we'd drain for, or, with
+// AlsoPollIMDS set, while IMDS still has an event for it. It returns true when the node was uncordoned.
+func UncordonOnShutdown(ctx context.Context, clientset kubernetes.Interface, ic imds.IMDS, nodeName string, cfg config.Config, recorder record.EventRecorder) (bool, error) {
+	tracer := otel.Tracer("github.com/amargherio/mechanic/pkg/node")
+	ctx, span := tracer.Start(ctx, "UncordonOnShutdown")
+	defer span.End()
+
+	vals := ctx.Value("values").(*config.ContextValues)
+	log := vals.Logger
+
+	// the informer is stopped by now, so read the node from the API server
+	node, err := clientset.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+	if err != nil {
+		log.Warnw("Failed to get node to release the cordon on shutdown", "node", nodeName, "error", err, "traceCtx", ctx)
+		return false, err
+	}
+
+	if !node.Spec.Unschedulable {
+		return false, nil
+	}
+	if !ownsCordon(node, cfg.Cordon) {
+		log.Infow("Node is cordoned but not by mechanic, leaving the cordon on shutdown", "node", node.Name, "traceCtx", ctx)
+		return false, nil
+	}
+	// an event seen only in IMDS has no condition to find below, so without this check every restart in the middle of
+	// the maintenance would release the cordon
+	if cfg.AlsoPollIMDS {
+		impacted, err := imds.HasImpactingEvents(ctx, ic, node, &cfg.DrainConditions, cfg.IMDSRetry)
+		if err != nil {
+			log.Warnw("Failed to poll IMDS for scheduled events, keeping the cordon on shutdown", "node", node.Name, "error", err, "traceCtx", ctx)
+			return false, err
+		}
+		if impacted {
+			log.Infow("IMDS still has a scheduled event targeting the node, keeping the cordon on shutdown", "node", node.Name, "traceCtx", ctx)
+			return false, nil
+		}
+	}
+	if conditions := CheckNodeConditions(ctx, node, cfg.DrainConditions); len(conditions) > 0 {
+		log.Infow("Node still has a scheduled event, keeping the cordon on shutdown", "node", node.Name, "conditions", conditions, "traceCtx", ctx)
+		return false, nil
+	}
+	if condition := CheckGPUHealthConditions(ctx, node, cfg.GPUHealth); condition != "" {
+		log.Infow("Node still has a GPU health condition, keeping the cordon on shutdown", "node", node.Name, "condition", condition, "traceCtx", ctx)
+		return false, nil
+	}
+	if taint := CheckMaintenanceTaints(ctx, node, cfg.MaintenanceTaints); taint != "" {
+		log.Infow("Node still has a maintenance taint, keeping the cordon on shutdown", "node", node.Name, "taint", taint, "traceCtx", ctx)
+		return false, nil
+	}
+
+	log.Infow("Node is cordoned by mechanic with nothing left to drain for, uncordoning on shutdown", "node", node.Name, "traceCtx", ctx)
+	if err := UncordonNode(ctx, clientset, node, cfg); err != nil {
+		log.Errorw("Failed to uncordon node on shutdown", "node", node.Name, "error", err, "traceCtx", ctx)
+		Eventf(recorder, node, v1.EventTypeWarning, "UncordonNode", "Failed to uncordon node %s on shutdown", node.Name)
+		return false, err
+	}
+	Eventf(recorder, node, v1.EventTypeNormal, "UncordonNode", "Node %s uncordoned by mechanic on shutdown", node.Name)
+	return true, nil
+}
@@ -0,0 +1,157 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/amargherio/mechanic/internal/appstate"
+	"github.com/amargherio/mechanic/internal/config"
+	"github.com/amargherio/mechanic/pkg/imds"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestUncordonOnShutdown(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+
+	tests := []struct {
+		name                string
+		unschedulable       bool
+		labels              map[string]string
+		conditions          []v1.NodeCondition
+		taints              []v1.Taint
+		expectUncordoned    bool
+		expectUnschedulable bool
+		expectEvents        []string
+	}{
+		{
+			name:             "mechanic cordon with nothing left to drain for is released",
+			unschedulable:    true,
+			labels:           map[string]string{"mechanic.cordoned": "true"},
+			conditions:       []v1.NodeCondition{{Type: "RebootScheduled", Status: v1.ConditionFalse}},
+			expectUncordoned: true,
+			expectEvents:     []string{"Normal UncordonNode Node test-node uncordoned by mechanic on shutdown"},
+		},
+		{
+			name:                "cordon we don't own is left alone",
+			unschedulable:       true,
+			labels:              map[string]string{},
+			expectUnschedulable: true,
+		},
+		{
+			name:                "mechanic cordon with a scheduled event is kept",
+			unschedulable:       true,
+			labels:              map[string]string{"mechanic.cordoned": "true"},
+			conditions:          []v1.NodeCondition{{Type: "RebootScheduled", Status: v1.ConditionTrue}},
+			expectUnschedulable: true,
+		},
+		{
+			name:                "mechanic cordon with a maintenance taint is kept",
+			unschedulable:       true,
+			labels:              map[string]string{"mechanic.cordoned": "true"},
+			taints:              []v1.Taint{{Key: "maintenance", Effect: v1.TaintEffectNoSchedule}},
+			expectUnschedulable: true,
+		},
+		{
+			name:   "schedulable node is left alone",
+			labels: map[string]string{},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			state := &appstate.State{IsCordoned: tc.unschedulable}
+			vals := config.ContextValues{Logger: logger.Sugar(), State: state}
+			ctx := context.WithValue(context.Background(), "values", &vals)
+
+			node := &v1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-node", Labels: tc.labels},
+				Spec:       v1.NodeSpec{Unschedulable: tc.unschedulable, Taints: tc.taints},
+				Status:     v1.NodeStatus{Conditions: tc.conditions},
+			}
+			clientset := fake.NewClientset(node)
+			recorder := &MockRecorder{}
+			cfg := config.Config{
+				DrainConditions:   config.DrainConditions{DrainOnReboot: true},
+				MaintenanceTaints: []config.MaintenanceTaint{{Key: "maintenance"}},
+			}
+
+			uncordoned, err := UncordonOnShutdown(ctx, clientset, &fakeIMDS{}, node.Name, cfg, recorder)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectUncordoned, uncordoned)
+			assert.Equal(t, tc.expectEvents, recorder.Events)
+
+			stored, err := clientset.CoreV1().Nodes().Get(ctx, node.Name, metav1.GetOptions{})
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectUnschedulable, stored.Spec.Unschedulable)
+			if tc.expectUncordoned {
+				assert.NotContains(t, stored.Labels, "mechanic.cordoned")
+				assert.False(t, state.Cordoned())
+			}
+		})
+	}
+}
+
+func TestUncordonOnShutdownAlsoPollIMDS(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+
+	reboot := imds.ScheduledEvent{
+		EventId:      "reboot",
+		Type:         imds.Reboot,
+		ResourceType: "VirtualMachine",
+		Resources:    []string{"test-vmss_1"},
+		EventStatus:  imds.Scheduled,
+		NotBefore:    time.Now().Add(time.Hour),
+		EventSource:  imds.Platform,
+	}
+
+	tests := []struct {
+		name             string
+		alsoPoll         bool
+		ic               *fakeIMDS
+		expectUncordoned bool
+		expectErr        bool
+	}{
+		{name: "event only in IMDS keeps the cordon", alsoPoll: true, ic: &fakeIMDS{resp: imds.ScheduledEventsResponse{IncarnationID: 1, Events: []imds.ScheduledEvent{reboot}}}},
+		{name: "IMDS failure keeps the cordon", alsoPoll: true, ic: &fakeIMDS{err: errors.New("connection refused")}, expectErr: true},
+		{name: "no events in IMDS releases the cordon", alsoPoll: true, ic: &fakeIMDS{}, expectUncordoned: true},
+		{name: "IMDS isn't checked without polling", ic: &fakeIMDS{resp: imds.ScheduledEventsResponse{IncarnationID: 1, Events: []imds.ScheduledEvent{reboot}}}, expectUncordoned: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			vals := config.ContextValues{Logger: logger.Sugar(), State: &appstate.State{IsCordoned: true}}
+			ctx := context.WithValue(context.Background(), "values", &vals)
+
+			node := &v1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-vmss000001", Labels: map[string]string{"mechanic.cordoned": "true"}},
+				Spec:       v1.NodeSpec{Unschedulable: true},
+			}
+			clientset := fake.NewClientset(node)
+			cfg := config.Config{DrainConditions: config.DrainConditions{DrainOnReboot: true}, AlsoPollIMDS: tc.alsoPoll}
+
+			uncordoned, err := UncordonOnShutdown(ctx, clientset, tc.ic, node.Name, cfg, &MockRecorder{})
+			if tc.expectErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Equal(t, tc.expectUncordoned, uncordoned)
+			if !tc.alsoPoll {
+				assert.Zero(t, tc.ic.queries)
+			}
+
+			stored, err := clientset.CoreV1().Nodes().Get(ctx, node.Name, metav1.GetOptions{})
+			require.NoError(t, err)
+			assert.Equal(t, !tc.expectUncordoned, stored.Spec.Unschedulable)
+		})
+	}
+}
@@ -0,0 +1,292 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/amargherio/mechanic/internal/appstate"
+	"github.com/amargherio/mechanic/internal/config"
+	"github.com/amargherio/mechanic/pkg/imds"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
+)
+
+// soakDuration gates TestReconcileSoak. It's meant to be run with the race detector, e.g.
+// go test -race ./pkg/node -run TestReconcileSoak -soak=5m
+var soakDuration = flag.Duration("soak", 0, "run the reconcile soak test for this long")
+
+// soakScenario is a node condition and IMDS response pair the soak test cycles through
+type soakScenario struct {
+	name       string
+	conditions []v1.NodeCondition
+	events     []imds.ScheduledEvent
+	err        error
+}
+
+func soakScenarios() []soakScenario {
+	event := imds.ScheduledEvent{
+		EventId:      "preempt",
+		Type:         imds.Preempt,
+		ResourceType: "VirtualMachine",
+		Resources:    []string{"test-vmss_1"},
+		EventStatus:  imds.Scheduled,
+		NotBefore:    time.Now().Add(1 * time.Hour),
+		EventSource:  imds.Platform,
+	}
+	reboot := event
+	reboot.EventId = "reboot"
+	reboot.Type = imds.Reboot
+	host := event
+	host.EventId = "host"
+	host.ResourceType = "Host"
+
+	return []soakScenario{
+		{name: "idle"},
+		{
+			name:       "preempt",
+			conditions: []v1.NodeCondition{{Type: "PreemptScheduled", Status: v1.ConditionTrue}},
+			events:     []imds.ScheduledEvent{event},
+		},
+		{
+			name:       "reboot not drained",
+			conditions: []v1.NodeCondition{{Type: "VMEventScheduled", Status: v1.ConditionTrue}},
+			events:     []imds.ScheduledEvent{reboot},
+		},
+		{
+			name:       "host level event",
+			conditions: []v1.NodeCondition{{Type: "VMEventScheduled", Status: v1.ConditionTrue}},
+			events:     []imds.ScheduledEvent{host},
+		},
+		{
+			name:       "condition cleared before IMDS",
+			conditions: nil,
+			events:     []imds.ScheduledEvent{event},
+		},
+		{
+			name:       "IMDS unavailable",
+			conditions: []v1.NodeCondition{{Type: "PreemptScheduled", Status: v1.ConditionTrue}},
+			err:        errors.New("connection refused"),
+		},
+	}
+}
+
+// cyclingIMDS serves the response for whichever scenario the soak test last switched to
+type cyclingIMDS struct {
+	lock     sync.Mutex
+	scenario soakScenario
+}
+
+func (c *cyclingIMDS) QueryIMDS(ctx context.Context) (imds.ScheduledEventsResponse, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return imds.ScheduledEventsResponse{IncarnationID: 1, Events: c.scenario.events}, c.scenario.err
+}
+
+func (c *cyclingIMDS) AckEvent(ctx context.Context, eventID string) error {
+	return nil
+}
+
+func (c *cyclingIMDS) set(s soakScenario) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.scenario = s
+}
+
+// TestReconcileSoak drives ReconcileNode continuously from several goroutines, the way the node informer does, while
+// the node's conditions and the IMDS response cycle through scenarios underneath it. After every reconcile, mechanic's
+// state has to agree with the node.
+func TestReconcileSoak(t *testing.T) {
+	if *soakDuration == 0 {
+		t.Skip("soak test disabled, set -soak to run it")
+	}
+
+	vals := config.ContextValues{Logger: zap.NewNop().Sugar()}
+	ctx, cancel := context.WithTimeout(context.WithValue(context.Background(), "values", &vals), *soakDuration)
+	defer cancel()
+
+	cfg := config.Config{
+		DrainConditions:        config.DrainConditions{DrainOnPreempt: true},
+		ReconcileCordonMarkers: true,
+	}
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "test-vmss000001",
+			UID:    "uid-1",
+			Labels: map[string]string{"kubernetes.io/hostname": "test-vmss000001"},
+		},
+	}
+	clientset := fake.NewClientset(node)
+	enforceResourceVersion(clientset)
+	ic := &cyclingIMDS{}
+	state := &appstate.State{}
+	// a recorder without a channel drops events, so a long soak doesn't hold on to them
+	recorder := &record.FakeRecorder{}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 1)
+	fail := func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+		cancel()
+	}
+
+	// switch scenarios at random intervals, updating the node before the IMDS response so reconciles see both halves
+	// of a change in flight
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		scenarios := soakScenarios()
+		for ctx.Err() == nil {
+			s := scenarios[rand.Intn(len(scenarios))]
+			if err := setSoakConditions(ctx, clientset, node.Name, s.conditions); err != nil && ctx.Err() == nil {
+				fail(err)
+				return
+			}
+			ic.set(s)
+			time.Sleep(time.Duration(rand.Intn(20)) * time.Millisecond)
+		}
+	}()
+
+	// read state the way the admin endpoint does while reconciles are running
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for ctx.Err() == nil {
+			state.LastIMDSResponse()
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	reconciles := make([]int, 4)
+	for i := range reconciles {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				n, err := clientset.CoreV1().Nodes().Get(ctx, node.Name, metav1.GetOptions{})
+				if err != nil {
+					if ctx.Err() == nil {
+						fail(err)
+					}
+					return
+				}
+
+				// only one update is processed at a time, the rest are skipped like they are in main
+				if !state.Lock.TryLock() {
+					continue
+				}
+				err = ReconcileNode(ctx, clientset, ic, cfg, state, recorder, n)
+				if err == nil {
+					err = checkSoakInvariants(ctx, clientset, node.Name, state)
+				}
+				state.Lock.Unlock()
+				// the IMDS unavailable scenario ends reconciles early on purpose
+				if err != nil && ctx.Err() == nil && !isSoakIMDSError(err) {
+					fail(err)
+					return
+				}
+				reconciles[worker]++
+			}
+		}(i)
+	}
+
+	wg.Wait()
+	select {
+	case err := <-errs:
+		require.NoError(t, err)
+	default:
+	}
+
+	total := 0
+	for _, r := range reconciles {
+		total += r
+	}
+	t.Logf("completed %d reconciles in %s", total, *soakDuration)
+	require.NotZero(t, total)
+}
+
+// isSoakIMDSError reports whether err is the error served by the IMDS unavailable scenario
+func isSoakIMDSError(err error) bool {
+	return err != nil && err.Error() == "connection refused"
+}
+
+// enforceResourceVersion makes node updates on the fake clientset fail with a conflict when they were made against a
+// stale copy of the node, like the API server does. Without it, the scenario updates and mechanic's updates silently
+// overwrite each other. Reactions run one at a time, so the check and update are atomic.
+func enforceResourceVersion(clientset *fake.Clientset) {
+	clientset.PrependReactor("update", "nodes", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		update := action.(k8stesting.UpdateAction)
+		n := update.GetObject().(*v1.Node).DeepCopy()
+
+		current, err := clientset.Tracker().Get(update.GetResource(), update.GetNamespace(), n.Name)
+		if err != nil {
+			return true, nil, err
+		}
+		currentVersion := current.(*v1.Node).ResourceVersion
+		if n.ResourceVersion != currentVersion {
+			return true, nil, apierrors.NewConflict(update.GetResource().GroupResource(), n.Name, errors.New("the node has been modified"))
+		}
+
+		version, _ := strconv.Atoi(currentVersion)
+		n.ResourceVersion = strconv.Itoa(version + 1)
+		if update.GetSubresource() == "status" {
+			// status updates don't touch the rest of the node
+			status := n.Status
+			n = current.(*v1.Node).DeepCopy()
+			n.Status = status
+			n.ResourceVersion = strconv.Itoa(version + 1)
+		}
+		return true, n, clientset.Tracker().Update(update.GetResource(), n, update.GetNamespace())
+	})
+}
+
+// setSoakConditions replaces the node's conditions, standing in for NPD updating the node status
+func setSoakConditions(ctx context.Context, clientset kubernetes.Interface, name string, conditions []v1.NodeCondition) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		n, err := clientset.CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		n.Status.Conditions = conditions
+		_, err = clientset.CoreV1().Nodes().UpdateStatus(ctx, n, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// checkSoakInvariants verifies mechanic's state agrees with the node once a reconcile finishes. It must be called with
+// the state lock held.
+func checkSoakInvariants(ctx context.Context, clientset kubernetes.Interface, name string, state *appstate.State) error {
+	n, err := clientset.CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	_, labeled := n.Labels["mechanic.cordoned"]
+	switch {
+	case state.Cordoned() != n.Spec.Unschedulable:
+		return fmt.Errorf("state cordoned %t doesn't match node unschedulable %t", state.Cordoned(), n.Spec.Unschedulable)
+	case labeled != n.Spec.Unschedulable:
+		return fmt.Errorf("mechanic cordon label %t doesn't match node unschedulable %t", labeled, n.Spec.Unschedulable)
+	case !state.EventScheduled() && !state.DetectedAt().IsZero():
+		return errors.New("event detection time is set without a scheduled event")
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package node
+
+import (
+	"context"
+	"time"
+
+	"github.com/amargherio/mechanic/internal/appstate"
+	"github.com/amargherio/mechanic/internal/config"
+	"github.com/amargherio/mechanic/pkg/imds"
+	"go.opentelemetry.io/otel"
+	v1 "k8s.io/api/core/v1"
+)
+
+// confirmFreshEvent makes sure the IMDS data behind an event trigger is no older than cfg.MaxIMDSDataAge before the
+// node is cordoned and drained for it. Stale data is refreshed with a new query, and the trigger's event has to still
+// call for a drain in the refreshed data. It reports false when the action should be deferred to the next reconcile,
+// along with the error when the refresh failed. Triggers that aren't scheduled events, and a MaxIMDSDataAge of zero, are
+// always fresh.
+func confirmFreshEvent(ctx context.Context, ic imds.IMDS, node *v1.Node, cfg config.Config, state *appstate.State, trigger Trigger) (bool, error) {
+	tracer := otel.Tracer("github.com/amargherio/mechanic/pkg/node")
+	ctx, span := tracer.Start(ctx, "confirmFreshEvent")
+	defer span.End()
+
+	vals := ctx.Value("values").(*config.ContextValues)
+	log := vals.Logger
+
+	maxAge := cfg.MaxIMDSDataAge
+	if maxAge <= 0 || trigger.Category != TriggerCategoryEvent {
+		return true, nil
+	}
+	snapshot, ok := state.LastIMDSResponse()
+	if ok && time.Since(snapshot.FetchedAt) <= maxAge {
+		return true, nil
+	}
+
+	log.Infow("IMDS data behind the scheduled event is stale, refreshing before acting on it", "node", node.Name, "eventId", trigger.EventID, "fetchedAt", snapshot.FetchedAt, "maxAge", maxAge, "traceCtx", ctx)
+	required, event, err := imds.CheckIfDrainRequired(ctx, ic, node, &cfg.DrainConditions, cfg.IMDSRetry)
+	if err != nil {
+		log.Warnw("Failed to refresh stale IMDS data", "node", node.Name, "error", err, "traceCtx", ctx)
+		return false, err
+	}
+	if !required || event == nil || event.EventId != trigger.EventID {
+		log.Infow("The refreshed IMDS data no longer has the scheduled event calling for a drain", "node", node.Name, "eventId", trigger.EventID, "traceCtx", ctx)
+		return false, nil
+	}
+	return true, nil
+}
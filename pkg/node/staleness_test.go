@@ -0,0 +1,223 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/amargherio/mechanic/internal/appstate"
+	"github.com/amargherio/mechanic/internal/config"
+	"github.com/amargherio/mechanic/pkg/imds"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// sequenceIMDS returns each response in turn, repeating the last one once they run out
+type sequenceIMDS struct {
+	responses []imds.ScheduledEventsResponse
+	errs      []error
+	queries   int
+}
+
+func (s *sequenceIMDS) QueryIMDS(ctx context.Context) (imds.ScheduledEventsResponse, error) {
+	i := min(s.queries, len(s.responses)-1)
+	s.queries++
+	return s.responses[i], s.errs[i]
+}
+
+func (s *sequenceIMDS) AckEvent(ctx context.Context, eventID string) error {
+	return nil
+}
+
+func stalenessPreempt() imds.ScheduledEvent {
+	return imds.ScheduledEvent{
+		EventId:      "preempt",
+		Type:         imds.Preempt,
+		ResourceType: "VirtualMachine",
+		Resources:    []string{"test-vmss_1"},
+		EventStatus:  imds.Scheduled,
+		NotBefore:    time.Now().Add(1 * time.Hour),
+		EventSource:  imds.Platform,
+	}
+}
+
+func TestConfirmFreshEvent(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-vmss000001"}}
+	dc := &config.DrainConditions{DrainOnPreempt: true}
+	preempt := stalenessPreempt()
+	trigger := EventTrigger(&preempt)
+
+	tests := []struct {
+		name          string
+		trigger       Trigger
+		fetchedAgo    time.Duration
+		maxAge        time.Duration
+		resp          imds.ScheduledEventsResponse
+		imdsErr       error
+		expectFresh   bool
+		expectError   bool
+		expectQueries int
+	}{
+		{
+			name:        "fresh data is used as is",
+			trigger:     trigger,
+			fetchedAgo:  10 * time.Second,
+			maxAge:      time.Minute,
+			expectFresh: true,
+		},
+		{
+			name:        "the check is disabled",
+			trigger:     trigger,
+			fetchedAgo:  time.Hour,
+			expectFresh: true,
+		},
+		{
+			name:        "triggers without IMDS data aren't checked",
+			trigger:     ConditionTrigger("GPUUncorrectableECCError"),
+			fetchedAgo:  time.Hour,
+			maxAge:      time.Minute,
+			expectFresh: true,
+		},
+		{
+			name:          "stale data is refreshed and the event confirmed",
+			trigger:       trigger,
+			fetchedAgo:    5 * time.Minute,
+			maxAge:        time.Minute,
+			resp:          imds.ScheduledEventsResponse{IncarnationID: 2, Events: []imds.ScheduledEvent{preempt}},
+			expectFresh:   true,
+			expectQueries: 1,
+		},
+		{
+			name:          "stale data whose event is gone defers",
+			trigger:       trigger,
+			fetchedAgo:    5 * time.Minute,
+			maxAge:        time.Minute,
+			resp:          imds.ScheduledEventsResponse{IncarnationID: 2},
+			expectQueries: 1,
+		},
+		{
+			name:          "stale data that can't be refreshed defers",
+			trigger:       trigger,
+			fetchedAgo:    5 * time.Minute,
+			maxAge:        time.Minute,
+			imdsErr:       errors.New("bad request"),
+			expectError:   true,
+			expectQueries: 1,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			state := &appstate.State{}
+			state.RecordIMDSResponse(imds.ScheduledEventsResponse{IncarnationID: 1, Events: []imds.ScheduledEvent{preempt}}, time.Now().Add(-tc.fetchedAgo))
+			vals := config.ContextValues{Logger: logger.Sugar(), State: state}
+			ctx := context.WithValue(context.Background(), "values", &vals)
+			ic := &fakeIMDS{resp: tc.resp, err: tc.imdsErr}
+
+			fresh, err := confirmFreshEvent(ctx, ic, node, config.Config{DrainConditions: *dc, MaxIMDSDataAge: tc.maxAge}, state, tc.trigger)
+			assert.Equal(t, tc.expectFresh, fresh)
+			if tc.expectError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.expectQueries, ic.queries)
+			if tc.expectFresh && tc.expectQueries > 0 {
+				snapshot, ok := state.LastIMDSResponse()
+				require.True(t, ok)
+				assert.WithinDuration(t, time.Now(), snapshot.FetchedAt, 5*time.Second, "the refreshed response is recorded")
+			}
+		})
+	}
+}
+
+func TestReconcileNodeStaleIMDSData(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	vals := config.ContextValues{Logger: logger.Sugar()}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	preempt := stalenessPreempt()
+	withEvent := imds.ScheduledEventsResponse{IncarnationID: 1, Events: []imds.ScheduledEvent{preempt}}
+
+	tests := []struct {
+		name           string
+		responses      []imds.ScheduledEventsResponse
+		errs           []error
+		expectError    bool
+		expectCordoned bool
+		expectQueries  int
+		expectedEvents []string
+	}{
+		{
+			name:           "the refresh confirms the event and the node is drained",
+			responses:      []imds.ScheduledEventsResponse{withEvent},
+			errs:           []error{nil},
+			expectCordoned: true,
+			expectQueries:  2,
+			expectedEvents: []string{
+				"Normal CordonNode Node test-vmss000001 cordoned by mechanic (event: Preempt)",
+				"Normal DrainNode Node test-vmss000001 drained by mechanic (event: Preempt)",
+			},
+		},
+		{
+			name:          "the event is gone from the refresh",
+			responses:     []imds.ScheduledEventsResponse{withEvent, {IncarnationID: 2}},
+			errs:          []error{nil, nil},
+			expectQueries: 2,
+			expectedEvents: []string{
+				"Warning ActionDeferred Cordon and drain of node test-vmss000001 deferred, the scheduled event could not be confirmed with fresh IMDS data (event: Preempt)",
+			},
+		},
+		{
+			name:          "the refresh fails",
+			responses:     []imds.ScheduledEventsResponse{withEvent, {}},
+			errs:          []error{nil, errors.New("bad request")},
+			expectError:   true,
+			expectQueries: 2,
+			expectedEvents: []string{
+				"Warning ActionDeferred Cordon and drain of node test-vmss000001 deferred, the scheduled event could not be confirmed with fresh IMDS data (event: Preempt)",
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// any time between the query and the cordon makes the data stale
+			cfg := config.Config{
+				DrainConditions: config.DrainConditions{DrainOnPreempt: true},
+				MaxIMDSDataAge:  time.Nanosecond,
+			}
+			node := &v1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-vmss000001", UID: "uid-1", Labels: map[string]string{}},
+				Status:     v1.NodeStatus{Conditions: []v1.NodeCondition{{Type: "PreemptScheduled", Status: v1.ConditionTrue}}},
+			}
+			clientset := fake.NewClientset(node)
+			ic := &sequenceIMDS{responses: tc.responses, errs: tc.errs}
+			state := &appstate.State{NodeUID: node.UID}
+			recorder := &MockRecorder{}
+
+			err := ReconcileNode(ctx, clientset, ic, cfg, state, recorder, node)
+			if tc.expectError {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+
+			updated, err := clientset.CoreV1().Nodes().Get(ctx, node.Name, metav1.GetOptions{})
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectCordoned, updated.Spec.Unschedulable)
+			assert.Equal(t, tc.expectCordoned, state.Drained())
+			assert.Equal(t, tc.expectQueries, ic.queries)
+			assert.Equal(t, tc.expectedEvents, recorder.Events)
+		})
+	}
+}
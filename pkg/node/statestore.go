@@ -0,0 +1,291 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/amargherio/mechanic/internal/config"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// stateDrainedAnnotation and stateShouldDrainAnnotation hold the state flags that have to survive a restart, so an
+	// agent restarted mid-maintenance doesn't drain the node again
+	stateDrainedAnnotation     = "mechanic.io/state-drained"
+	stateShouldDrainAnnotation = "mechanic.io/state-should-drain"
+	// stateEventIDAnnotation holds the scheduled event the state was saved for
+	stateEventIDAnnotation = "mechanic.io/state-event-id"
+
+	// stateConfigMapPrefix is prepended to the node name to get the name of the ConfigMap the configmap store uses
+	stateConfigMapPrefix = "mechanic-state-"
+)
+
+// stateAnnotationKeys are all of the annotations the node store adds, removed together when the cordon is released
+var stateAnnotationKeys = []string{
+	stateDrainedAnnotation,
+	stateShouldDrainAnnotation,
+	stateEventIDAnnotation,
+}
+
+// PersistedState is the part of the state that's persisted so it survives a restart
+type PersistedState struct {
+	// NodeUID is the UID of the node the state was saved for, so state saved for a node that's since been recreated
+	// under the same name is ignored. Stores that are removed along with the node leave it empty.
+	NodeUID     types.UID `json:"nodeUID,omitempty"`
+	Drained     bool      `json:"drained"`
+	ShouldDrain bool      `json:"shouldDrain"`
+	// EventID is the scheduled event that triggered the drain, so the state isn't restored once the event is over. It's
+	// empty when a node condition or taint triggered it.
+	EventID string `json:"eventID,omitempty"`
+}
+
+// StateStore persists the drain state for a node
+type StateStore interface {
+	// Save records the state for the node
+	Save(ctx context.Context, node *v1.Node, state PersistedState) error
+	// Load returns the state recorded for the node. The second return value is false when nothing was recorded.
+	Load(ctx context.Context, node *v1.Node) (PersistedState, bool, error)
+	// Clear removes the state recorded for the node
+	Clear(ctx context.Context, node *v1.Node) error
+}
+
+// NewStateStore returns the store for the configured backend. The clientset is used by the node and configmap stores,
+// which retry their writes under the retry policy.
+func NewStateStore(cfg config.StateStoreConfig, retry config.NodeUpdateRetryConfig, clientset kubernetes.Interface) (StateStore, error) {
+	switch cfg.Backend {
+	case config.StateStoreNode, "":
+		return &NodeStateStore{clientset: clientset, retry: retry}, nil
+	case config.StateStoreFile:
+		if cfg.FilePath == "" {
+			return nil, errors.New("the file state store needs a file path")
+		}
+		return &FileStateStore{path: cfg.FilePath}, nil
+	case config.StateStoreConfigMap:
+		if cfg.ConfigMapNamespace == "" {
+			return nil, errors.New("the configmap state store needs a namespace")
+		}
+		return &ConfigMapStateStore{clientset: clientset, namespace: cfg.ConfigMapNamespace, retry: retry}, nil
+	case config.StateStoreNone:
+		return NoopStateStore{}, nil
+	default:
+		return nil, fmt.Errorf("unknown state store %q, expected node, file, configmap, or none", cfg.Backend)
+	}
+}
+
+// NodeStateStore keeps the state in annotations on the node, so it's removed along with the node
+type NodeStateStore struct {
+	clientset kubernetes.Interface
+	retry     config.NodeUpdateRetryConfig
+}
+
+func (s *NodeStateStore) Save(ctx context.Context, node *v1.Node, state PersistedState) error {
+	return retryNodeUpdate(ctx, s.retry, func() error {
+		n, err := s.clientset.CoreV1().Nodes().Get(ctx, node.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		annotations := n.GetAnnotations()
+		if annotations == nil {
+			annotations = make(map[string]string)
+		}
+		annotations[stateDrainedAnnotation] = strconv.FormatBool(state.Drained)
+		annotations[stateShouldDrainAnnotation] = strconv.FormatBool(state.ShouldDrain)
+		if state.EventID != "" {
+			annotations[stateEventIDAnnotation] = state.EventID
+		} else {
+			delete(annotations, stateEventIDAnnotation)
+		}
+		n.SetAnnotations(annotations)
+
+		_, err = s.clientset.CoreV1().Nodes().Update(ctx, n, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// Load reads the annotations from the node passed in, so it should be freshly read from the API server
+func (s *NodeStateStore) Load(_ context.Context, node *v1.Node) (PersistedState, bool, error) {
+	annotations := node.GetAnnotations()
+	drained, drainedErr := strconv.ParseBool(annotations[stateDrainedAnnotation])
+	shouldDrain, shouldDrainErr := strconv.ParseBool(annotations[stateShouldDrainAnnotation])
+	if drainedErr != nil || shouldDrainErr != nil {
+		return PersistedState{}, false, nil
+	}
+	return PersistedState{Drained: drained, ShouldDrain: shouldDrain, EventID: annotations[stateEventIDAnnotation]}, true, nil
+}
+
+// Clear removes the state annotations. UncordonNode already removes them with the cordon, so the node is only updated
+// when they're still there.
+func (s *NodeStateStore) Clear(ctx context.Context, node *v1.Node) error {
+	return retryNodeUpdate(ctx, s.retry, func() error {
+		n, err := s.clientset.CoreV1().Nodes().Get(ctx, node.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		annotations := n.GetAnnotations()
+		found := false
+		for _, key := range stateAnnotationKeys {
+			if _, ok := annotations[key]; ok {
+				delete(annotations, key)
+				found = true
+			}
+		}
+		if !found {
+			return nil
+		}
+		n.SetAnnotations(annotations)
+
+		_, err = s.clientset.CoreV1().Nodes().Update(ctx, n, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// FileStateStore keeps the state in a local JSON file. The file should be on a hostPath volume so it outlives the pod,
+// which ties the state to the node the agent runs on.
+type FileStateStore struct {
+	path string
+}
+
+// Save writes the state to a temporary file and renames it over the old one, so a crash mid-write doesn't leave a
+// truncated file behind
+func (s *FileStateStore) Save(_ context.Context, node *v1.Node, state PersistedState) error {
+	state.NodeUID = node.UID
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(s.path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, filepath.Base(s.path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+func (s *FileStateStore) Load(_ context.Context, _ *v1.Node) (PersistedState, bool, error) {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return PersistedState{}, false, nil
+	} else if err != nil {
+		return PersistedState{}, false, err
+	}
+
+	var state PersistedState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return PersistedState{}, false, fmt.Errorf("failed to parse the state file %s: %w", s.path, err)
+	}
+	return state, true, nil
+}
+
+func (s *FileStateStore) Clear(_ context.Context, _ *v1.Node) error {
+	if err := os.Remove(s.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// ConfigMapStateStore keeps the state in a ConfigMap per node, so it's readable from wherever the agent for the node
+// is scheduled. Updates are made against the resourceVersion that was read, so a conflicting write is retried on the
+// latest copy instead of overwriting it.
+type ConfigMapStateStore struct {
+	clientset kubernetes.Interface
+	namespace string
+	retry     config.NodeUpdateRetryConfig
+}
+
+func (s *ConfigMapStateStore) Save(ctx context.Context, node *v1.Node, state PersistedState) error {
+	name := stateConfigMapPrefix + node.Name
+	data := map[string]string{
+		"nodeUID":     string(node.UID),
+		"drained":     strconv.FormatBool(state.Drained),
+		"shouldDrain": strconv.FormatBool(state.ShouldDrain),
+	}
+	if state.EventID != "" {
+		data["eventID"] = state.EventID
+	}
+	configMaps := s.clientset.CoreV1().ConfigMaps(s.namespace)
+
+	return retryNodeUpdate(ctx, s.retry, func() error {
+		cm, err := configMaps.Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			_, err = configMaps.Create(ctx, &v1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name,
+					Namespace: s.namespace,
+					Labels:    map[string]string{"mechanic.io/node": node.Name},
+				},
+				Data: data,
+			}, metav1.CreateOptions{})
+			if !apierrors.IsAlreadyExists(err) {
+				return err
+			}
+			// another writer created it first, so update their copy instead
+			cm, err = configMaps.Get(ctx, name, metav1.GetOptions{})
+		}
+		if err != nil {
+			return err
+		}
+
+		cm.Data = data
+		_, err = configMaps.Update(ctx, cm, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+func (s *ConfigMapStateStore) Load(ctx context.Context, node *v1.Node) (PersistedState, bool, error) {
+	cm, err := s.clientset.CoreV1().ConfigMaps(s.namespace).Get(ctx, stateConfigMapPrefix+node.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return PersistedState{}, false, nil
+	} else if err != nil {
+		return PersistedState{}, false, err
+	}
+
+	drained, drainedErr := strconv.ParseBool(cm.Data["drained"])
+	shouldDrain, shouldDrainErr := strconv.ParseBool(cm.Data["shouldDrain"])
+	if drainedErr != nil || shouldDrainErr != nil {
+		return PersistedState{}, false, nil
+	}
+	return PersistedState{NodeUID: types.UID(cm.Data["nodeUID"]), Drained: drained, ShouldDrain: shouldDrain, EventID: cm.Data["eventID"]}, true, nil
+}
+
+func (s *ConfigMapStateStore) Clear(ctx context.Context, node *v1.Node) error {
+	err := s.clientset.CoreV1().ConfigMaps(s.namespace).Delete(ctx, stateConfigMapPrefix+node.Name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// NoopStateStore doesn't persist anything, so a restarted agent starts from the node's cordon alone
+type NoopStateStore struct{}
+
+func (NoopStateStore) Save(context.Context, *v1.Node, PersistedState) error { return nil }
+
+func (NoopStateStore) Load(context.Context, *v1.Node) (PersistedState, bool, error) {
+	return PersistedState{}, false, nil
+}
+
+func (NoopStateStore) Clear(context.Context, *v1.Node) error { return nil }
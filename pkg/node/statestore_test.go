@@ -0,0 +1,138 @@
+package node
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/amargherio/mechanic/internal/appstate"
+	"github.com/amargherio/mechanic/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestStateStoreRoundTrip(t *testing.T) {
+	tests := []struct {
+		name          string
+		cfg           config.StateStoreConfig
+		expectPersist bool
+	}{
+		{name: "node", cfg: config.StateStoreConfig{Backend: config.StateStoreNode}, expectPersist: true},
+		{name: "file", cfg: config.StateStoreConfig{Backend: config.StateStoreFile}, expectPersist: true},
+		{name: "configmap", cfg: config.StateStoreConfig{Backend: config.StateStoreConfigMap, ConfigMapNamespace: "mechanic"}, expectPersist: true},
+		{name: "none", cfg: config.StateStoreConfig{Backend: config.StateStoreNone}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tc.cfg.Backend == config.StateStoreFile {
+				tc.cfg.FilePath = filepath.Join(t.TempDir(), "state", "state.json")
+			}
+
+			node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-node", UID: "11111111-1111-1111-1111-111111111111"}}
+			clientset := fake.NewClientset(node)
+			store, err := NewStateStore(tc.cfg, config.NodeUpdateRetryConfig{}, clientset)
+			require.NoError(t, err)
+
+			_, ok, err := store.Load(ctx, node)
+			require.NoError(t, err)
+			assert.False(t, ok, "nothing should be loaded before the state is saved")
+
+			require.NoError(t, store.Save(ctx, node, PersistedState{Drained: true, ShouldDrain: true}))
+			// saving again replaces the stored state
+			require.NoError(t, store.Save(ctx, node, PersistedState{Drained: true, ShouldDrain: false, EventID: "event-1"}))
+
+			// the node store reads the annotations off the node it's given
+			stored, err := clientset.CoreV1().Nodes().Get(ctx, node.Name, metav1.GetOptions{})
+			require.NoError(t, err)
+			loaded, ok, err := store.Load(ctx, stored)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectPersist, ok)
+			if tc.expectPersist {
+				assert.True(t, loaded.Drained)
+				assert.False(t, loaded.ShouldDrain)
+				assert.Equal(t, "event-1", loaded.EventID)
+			}
+
+			require.NoError(t, store.Clear(ctx, node))
+			require.NoError(t, store.Clear(ctx, node), "clearing twice should be a no-op")
+			stored, err = clientset.CoreV1().Nodes().Get(ctx, node.Name, metav1.GetOptions{})
+			require.NoError(t, err)
+			_, ok, err = store.Load(ctx, stored)
+			require.NoError(t, err)
+			assert.False(t, ok, "nothing should be loaded once the state is cleared")
+		})
+	}
+}
+
+func TestNewStateStoreInvalidConfig(t *testing.T) {
+	_, err := NewStateStore(config.StateStoreConfig{Backend: "etcd"}, config.NodeUpdateRetryConfig{}, nil)
+	assert.Error(t, err)
+	_, err = NewStateStore(config.StateStoreConfig{Backend: config.StateStoreFile}, config.NodeUpdateRetryConfig{}, nil)
+	assert.Error(t, err)
+	_, err = NewStateStore(config.StateStoreConfig{Backend: config.StateStoreConfigMap}, config.NodeUpdateRetryConfig{}, nil)
+	assert.Error(t, err)
+}
+
+func TestConfigMapStateStoreRetriesConflicts(t *testing.T) {
+	ctx := context.Background()
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-node", UID: "11111111-1111-1111-1111-111111111111"}}
+	clientset := fake.NewClientset()
+
+	// another writer updates the ConfigMap between our read and write the first time around
+	conflicts := 0
+	clientset.PrependReactor("update", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if conflicts == 0 {
+			conflicts++
+			return true, nil, apierrors.NewConflict(schema.GroupResource{Resource: "configmaps"}, stateConfigMapPrefix+node.Name, nil)
+		}
+		return false, nil, nil
+	})
+
+	retry := config.NodeUpdateRetryConfig{Attempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+	store, err := NewStateStore(config.StateStoreConfig{Backend: config.StateStoreConfigMap, ConfigMapNamespace: "mechanic"}, retry, clientset)
+	require.NoError(t, err)
+	require.NoError(t, store.Save(ctx, node, PersistedState{ShouldDrain: true}))
+	require.NoError(t, store.Save(ctx, node, PersistedState{Drained: true, ShouldDrain: true}))
+	assert.Equal(t, 1, conflicts)
+
+	loaded, ok, err := store.Load(ctx, node)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, PersistedState{NodeUID: node.UID, Drained: true, ShouldDrain: true}, loaded)
+}
+
+func TestRestoreStateIgnoresRecreatedNode(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	vals := config.ContextValues{Logger: logger.Sugar()}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	cfg := config.Config{StateStore: config.StateStoreConfig{Backend: config.StateStoreFile, FilePath: filepath.Join(t.TempDir(), "state.json")}}
+
+	original := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-node", UID: "11111111-1111-1111-1111-111111111111", Labels: map[string]string{"mechanic.cordoned": "true"}},
+		Spec:       v1.NodeSpec{Unschedulable: true},
+	}
+	clientset := fake.NewClientset(original)
+	require.NoError(t, PersistState(ctx, clientset, original, cfg, &appstate.State{IsDrained: true, ShouldDrain: true}, ""))
+
+	recreated := original.DeepCopy()
+	recreated.UID = "22222222-2222-2222-2222-222222222222"
+	state := &appstate.State{}
+	assert.False(t, RestoreState(ctx, clientset, &fakeIMDS{}, recreated, cfg, state))
+	assert.False(t, state.Drained())
+
+	assert.True(t, RestoreState(ctx, clientset, &fakeIMDS{}, original, cfg, state))
+	assert.True(t, state.Drained())
+}
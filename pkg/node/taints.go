@@ -0,0 +1,38 @@
+package node
+
+import (
+	"context"
+
+	"github.com/amargherio/mechanic/internal/config"
+	"go.opentelemetry.io/otel"
+	v1 "k8s.io/api/core/v1"
+)
+
+// CheckMaintenanceTaints checks the node for a taint matching one of the configured maintenance taints, the way some
+// maintenance operators signal upcoming work, and returns the matching taint's key. An empty string means no
+// maintenance taint was found.
+func CheckMaintenanceTaints(ctx context.Context, node *v1.Node, maintenanceTaints []config.MaintenanceTaint) string {
+	tracer := otel.Tracer("github.com/amargherio/mechanic/pkg/node")
+	ctx, span := tracer.Start(ctx, "CheckMaintenanceTaints")
+	defer span.End()
+
+	vals := ctx.Value("values").(*config.ContextValues)
+	log := vals.Logger
+
+	for _, taint := range node.Spec.Taints {
+		for _, mt := range maintenanceTaints {
+			if !mt.Matches(taint.Key, taint.Value, string(taint.Effect)) {
+				continue
+			}
+
+			log.Infow("Node has a maintenance taint. Flagging for drain.",
+				"node", node.Name,
+				"taint", taint.ToString(),
+				"matched", mt.String(),
+				"traceCtx", ctx)
+			return taint.Key
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,73 @@
+package node
+
+import (
+	"context"
+	"testing"
+
+	"github.com/amargherio/mechanic/internal/appstate"
+	"github.com/amargherio/mechanic/internal/config"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap/zaptest"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCheckMaintenanceTaints(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+
+	vals := config.ContextValues{
+		Logger: logger.Sugar(),
+		State:  &appstate.State{},
+	}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	maintenanceTaints := []config.MaintenanceTaint{
+		{Key: "maintenance", Value: "true", Effect: "NoSchedule"},
+		{Key: "example.com/upgrade"},
+	}
+
+	tests := []struct {
+		name     string
+		taints   []v1.Taint
+		expected string
+	}{
+		{
+			name:     "no taints",
+			expected: "",
+		},
+		{
+			name:     "matching taint",
+			taints:   []v1.Taint{{Key: "maintenance", Value: "true", Effect: v1.TaintEffectNoSchedule}},
+			expected: "maintenance",
+		},
+		{
+			name:     "matching key with a different effect",
+			taints:   []v1.Taint{{Key: "maintenance", Value: "true", Effect: v1.TaintEffectNoExecute}},
+			expected: "",
+		},
+		{
+			name:     "key only match",
+			taints:   []v1.Taint{{Key: "example.com/upgrade", Value: "1.31", Effect: v1.TaintEffectNoExecute}},
+			expected: "example.com/upgrade",
+		},
+		{
+			name: "unrelated taints",
+			taints: []v1.Taint{
+				{Key: "node.kubernetes.io/unschedulable", Effect: v1.TaintEffectNoSchedule},
+				{Key: "sku", Value: "gpu", Effect: v1.TaintEffectNoSchedule},
+			},
+			expected: "",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			node := &v1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-node"},
+				Spec:       v1.NodeSpec{Taints: tc.taints},
+			}
+			assert.Equal(t, tc.expected, CheckMaintenanceTaints(ctx, node, maintenanceTaints))
+		})
+	}
+}
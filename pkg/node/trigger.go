@@ -0,0 +1,200 @@
+package node
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/amargherio/mechanic/internal/config"
+	"github.com/amargherio/mechanic/pkg/imds"
+	v1 "k8s.io/api/core/v1"
+)
+
+// trigger categories separate cordons and drains caused by scheduled events from those caused by node conditions that
+// have no scheduled event behind them, like GPU health conditions, and by taints added by maintenance operators
+const (
+	TriggerCategoryEvent     = "event"
+	TriggerCategoryCondition = "condition"
+	TriggerCategoryTaint     = "taint"
+)
+
+const (
+	// triggerCategoryAnnotation and triggerReasonAnnotation record on the node why mechanic cordoned it, and the rest
+	// record the details that are only known for some triggers
+	triggerCategoryAnnotation   = "mechanic.io/trigger-category"
+	triggerReasonAnnotation     = "mechanic.io/trigger-reason"
+	triggerEventIDAnnotation    = "mechanic.io/trigger-event-id"
+	triggerConditionAnnotation  = "mechanic.io/trigger-condition"
+	triggerSourceAnnotation     = "mechanic.io/trigger-source"
+	triggerDetectedAtAnnotation = "mechanic.io/trigger-detected-at"
+	triggerAlsoAnnotation       = "mechanic.io/trigger-also"
+
+	// maintenanceDescriptionAnnotation holds the platform's description of the scheduled event behind the cordon
+	maintenanceDescriptionAnnotation = "mechanic.io/maintenance-description"
+)
+
+// triggerAnnotationKeys are all of the annotations a trigger can add, removed together when the cordon is released
+var triggerAnnotationKeys = []string{
+	triggerCategoryAnnotation,
+	triggerReasonAnnotation,
+	triggerEventIDAnnotation,
+	triggerConditionAnnotation,
+	triggerSourceAnnotation,
+	triggerDetectedAtAnnotation,
+	triggerAlsoAnnotation,
+	maintenanceDescriptionAnnotation,
+}
+
+// Trigger describes what caused mechanic to cordon and drain a node. It's built once per reconcile and the same value is
+// handed to everything that reports the reason: node and event annotations, event messages, metrics, and the decision
+// log.
+type Trigger struct {
+	// Category is TriggerCategoryEvent or TriggerCategoryCondition
+	Category string
+	// Reason is the scheduled event type or the node condition type
+	Reason string
+	// Deadline is when the maintenance proceeds regardless of the drain. It's zero when there isn't one.
+	Deadline time.Time
+	// EventID is the ID of the scheduled event behind an event trigger
+	EventID string
+	// Condition is the node condition that flagged the scheduled event behind an event trigger
+	Condition string
+	// Source is who initiated the scheduled event behind an event trigger, Platform or User
+	Source string
+	// DetectedAt is when mechanic first saw the event, condition, or taint on the node. It's zero when unknown.
+	DetectedAt time.Time
+	// Description is the platform's description of the scheduled event behind an event trigger
+	Description string
+	// Also lists the other triggers present on the node at the same time, as "category: reason", when more than one
+	// called for the drain
+	Also []string
+}
+
+// EventTrigger returns the trigger for a drain caused by a scheduled event
+func EventTrigger(event *imds.ScheduledEvent) Trigger {
+	return Trigger{
+		Category:    TriggerCategoryEvent,
+		Reason:      string(event.Type),
+		Deadline:    event.NotBefore,
+		EventID:     event.EventId,
+		Source:      string(event.EventSource),
+		Description: event.Description,
+	}
+}
+
+// eventCondition picks which of the true drainable conditions flagged the event: the one for the event's type, or the
+// first one when that isn't among them, like when only VMEventScheduled is set
+func eventCondition(conditions []string, dc *config.DrainConditions, event *imds.ScheduledEvent) string {
+	if len(conditions) == 0 {
+		return ""
+	}
+	if condition := dc.ConditionFor(string(event.Type)); slices.Contains(conditions, condition) {
+		return condition
+	}
+	return conditions[0]
+}
+
+// ConditionTrigger returns the trigger for a drain caused by a node condition
+func ConditionTrigger(conditionType string) Trigger {
+	return Trigger{
+		Category: TriggerCategoryCondition,
+		Reason:   conditionType,
+	}
+}
+
+// TaintTrigger returns the trigger for a drain caused by a maintenance taint
+func TaintTrigger(taintKey string) Trigger {
+	return Trigger{
+		Category: TriggerCategoryTaint,
+		Reason:   taintKey,
+	}
+}
+
+// String returns the trigger as "category: reason"
+func (t Trigger) String() string {
+	return t.Category + ": " + t.Reason
+}
+
+// moreUrgent reports whether t should drive the drain over other. An urgent trigger wins, then one with a deadline, then
+// the one with the earlier deadline. Otherwise neither is more urgent.
+func (t Trigger) moreUrgent(other Trigger) bool {
+	if t.IsUrgent() != other.IsUrgent() {
+		return t.IsUrgent()
+	}
+	if t.Deadline.IsZero() != other.Deadline.IsZero() {
+		return !t.Deadline.IsZero()
+	}
+	return t.Deadline.Before(other.Deadline)
+}
+
+// combineTriggers picks the most urgent of the triggers present on the node to drive the drain and records the rest on
+// it. Triggers that are equally urgent keep their order, so the first one is picked. It's empty when there are none.
+func combineTriggers(triggers []Trigger) Trigger {
+	if len(triggers) == 0 {
+		return Trigger{}
+	}
+	primary := 0
+	for i := 1; i < len(triggers); i++ {
+		if triggers[i].moreUrgent(triggers[primary]) {
+			primary = i
+		}
+	}
+	trigger := triggers[primary]
+	for i, other := range triggers {
+		if i != primary {
+			trigger.Also = append(trigger.Also, other.String())
+		}
+	}
+	return trigger
+}
+
+// triggerFromNode reads the trigger recorded on the node when mechanic cordoned it. It's empty if the node doesn't
+// have the trigger annotations.
+func triggerFromNode(node *v1.Node) Trigger {
+	annotations := node.GetAnnotations()
+	trigger := Trigger{
+		Category:    annotations[triggerCategoryAnnotation],
+		Reason:      annotations[triggerReasonAnnotation],
+		EventID:     annotations[triggerEventIDAnnotation],
+		Condition:   annotations[triggerConditionAnnotation],
+		Source:      annotations[triggerSourceAnnotation],
+		Description: annotations[maintenanceDescriptionAnnotation],
+	}
+	if detectedAt, err := time.Parse(time.RFC3339, annotations[triggerDetectedAtAnnotation]); err == nil {
+		trigger.DetectedAt = detectedAt
+	}
+	if also := annotations[triggerAlsoAnnotation]; also != "" {
+		trigger.Also = strings.Split(also, ",")
+	}
+	return trigger
+}
+
+// annotations returns the node and event annotations describing the trigger
+func (t Trigger) annotations() map[string]string {
+	if t.Category == "" {
+		return nil
+	}
+	annotations := map[string]string{
+		triggerCategoryAnnotation: t.Category,
+		triggerReasonAnnotation:   t.Reason,
+	}
+	if t.EventID != "" {
+		annotations[triggerEventIDAnnotation] = t.EventID
+	}
+	if t.Condition != "" {
+		annotations[triggerConditionAnnotation] = t.Condition
+	}
+	if t.Source != "" {
+		annotations[triggerSourceAnnotation] = t.Source
+	}
+	if !t.DetectedAt.IsZero() {
+		annotations[triggerDetectedAtAnnotation] = t.DetectedAt.UTC().Format(time.RFC3339)
+	}
+	if len(t.Also) > 0 {
+		annotations[triggerAlsoAnnotation] = strings.Join(t.Also, ",")
+	}
+	if t.Description != "" {
+		annotations[maintenanceDescriptionAnnotation] = t.Description
+	}
+	return annotations
+}
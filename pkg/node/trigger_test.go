@@ -0,0 +1,168 @@
+package node
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/amargherio/mechanic/internal/appstate"
+	"github.com/amargherio/mechanic/internal/config"
+	"github.com/amargherio/mechanic/pkg/imds"
+	"github.com/amargherio/mechanic/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestTriggerCategory(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	log := logger.Sugar()
+
+	notBefore := time.Now().Add(10 * time.Minute)
+
+	tests := []struct {
+		name             string
+		trigger          Trigger
+		expectedCategory string
+		expectedReason   string
+		expectedEvent    string
+	}{
+		{
+			name:             "scheduled event",
+			trigger:          EventTrigger(&imds.ScheduledEvent{Type: imds.Reboot, NotBefore: notBefore}),
+			expectedCategory: TriggerCategoryEvent,
+			expectedReason:   "Reboot",
+			expectedEvent:    "Normal CordonNode Node test-node cordoned by mechanic (event: Reboot)",
+		},
+		{
+			name:             "node condition",
+			trigger:          ConditionTrigger("GPUUncorrectableECCError"),
+			expectedCategory: TriggerCategoryCondition,
+			expectedReason:   "GPUUncorrectableECCError",
+			expectedEvent:    "Normal CordonNode Node test-node cordoned by mechanic (condition: GPUUncorrectableECCError)",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			vals := config.ContextValues{
+				Logger: log,
+				State:  &appstate.State{},
+			}
+			ctx := context.WithValue(context.Background(), "values", &vals)
+
+			node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-node", Labels: map[string]string{}}}
+			clientset := fake.NewClientset(node)
+
+			// the cordon records the trigger in the node annotations and the metric labels
+			before := testutil.ToFloat64(metrics.Cordons.WithLabelValues("", "", tc.expectedCategory))
+			_, err := CordonNode(ctx, clientset, node, config.Config{}, tc.trigger)
+			require.NoError(t, err)
+			assert.Equal(t, float64(1), testutil.ToFloat64(metrics.Cordons.WithLabelValues("", "", tc.expectedCategory))-before)
+
+			cordoned, err := clientset.CoreV1().Nodes().Get(ctx, node.Name, metav1.GetOptions{})
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedCategory, cordoned.Annotations[triggerCategoryAnnotation])
+			assert.Equal(t, tc.expectedReason, cordoned.Annotations[triggerReasonAnnotation])
+			assert.Equal(t, tc.trigger.Category, triggerFromNode(cordoned).Category)
+
+			// events carry the trigger in the message and annotations
+			recorder := &MockRecorder{}
+			TriggerEventf(recorder, node, tc.trigger, v1.EventTypeNormal, "CordonNode", "Node %s cordoned by mechanic", node.Name)
+			assert.Equal(t, []string{tc.expectedEvent}, recorder.Events)
+			assert.Equal(t, []map[string]string{{
+				triggerCategoryAnnotation: tc.expectedCategory,
+				triggerReasonAnnotation:   tc.expectedReason,
+			}}, recorder.Annotations)
+
+			// releasing the cordon removes the trigger annotations
+			require.NoError(t, UncordonNode(ctx, clientset, cordoned, config.Config{}))
+			uncordoned, err := clientset.CoreV1().Nodes().Get(ctx, node.Name, metav1.GetOptions{})
+			require.NoError(t, err)
+			assert.NotContains(t, uncordoned.Annotations, triggerCategoryAnnotation)
+			assert.NotContains(t, uncordoned.Annotations, triggerReasonAnnotation)
+		})
+	}
+}
+
+func TestEventCondition(t *testing.T) {
+	dc := &config.DrainConditions{ConditionOverrides: map[string]string{"preempt": "SpotEvictionScheduled"}}
+	reboot := &imds.ScheduledEvent{Type: imds.Reboot}
+	preempt := &imds.ScheduledEvent{Type: imds.Preempt}
+
+	assert.Equal(t, "RebootScheduled", eventCondition([]string{"VMEventScheduled", "RebootScheduled"}, dc, reboot))
+	assert.Equal(t, "SpotEvictionScheduled", eventCondition([]string{"VMEventScheduled", "SpotEvictionScheduled"}, dc, preempt))
+	assert.Equal(t, "VMEventScheduled", eventCondition([]string{"VMEventScheduled"}, dc, reboot), "the generic condition is used when the event's own isn't set")
+	assert.Empty(t, eventCondition(nil, dc, reboot))
+}
+
+func TestCombineTriggers(t *testing.T) {
+	gpu := ConditionTrigger("GPUUncorrectableECCError")
+	taint := TaintTrigger("maintenance")
+	reboot := Trigger{Category: TriggerCategoryEvent, Reason: "Reboot", Deadline: time.Now().Add(time.Hour)}
+	soonerReboot := Trigger{Category: TriggerCategoryEvent, Reason: "Redeploy", Deadline: time.Now().Add(10 * time.Minute)}
+	preempt := Trigger{Category: TriggerCategoryEvent, Reason: "Preempt", Deadline: time.Now().Add(time.Hour)}
+
+	tests := []struct {
+		name         string
+		triggers     []Trigger
+		expectReason string
+		expectAlso   []string
+	}{
+		{
+			name: "no triggers",
+		},
+		{
+			name:         "a single trigger is used as is",
+			triggers:     []Trigger{gpu},
+			expectReason: "GPUUncorrectableECCError",
+		},
+		{
+			name:         "triggers without deadlines keep their order",
+			triggers:     []Trigger{gpu, taint},
+			expectReason: "GPUUncorrectableECCError",
+			expectAlso:   []string{"taint: maintenance"},
+		},
+		{
+			name:         "a trigger with a deadline wins over one without",
+			triggers:     []Trigger{gpu, reboot},
+			expectReason: "Reboot",
+			expectAlso:   []string{"condition: GPUUncorrectableECCError"},
+		},
+		{
+			name:         "the earlier deadline wins",
+			triggers:     []Trigger{gpu, reboot, soonerReboot},
+			expectReason: "Redeploy",
+			expectAlso:   []string{"condition: GPUUncorrectableECCError", "event: Reboot"},
+		},
+		{
+			name:         "an urgent trigger wins over an earlier deadline",
+			triggers:     []Trigger{soonerReboot, preempt},
+			expectReason: "Preempt",
+			expectAlso:   []string{"event: Redeploy"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			trigger := combineTriggers(tc.triggers)
+			assert.Equal(t, tc.expectReason, trigger.Reason)
+			assert.Equal(t, tc.expectAlso, trigger.Also)
+		})
+	}
+}
+
+func TestTriggerAlsoAnnotation(t *testing.T) {
+	trigger := combineTriggers([]Trigger{ConditionTrigger("GPUUncorrectableECCError"), TaintTrigger("maintenance")})
+	annotations := trigger.annotations()
+	assert.Equal(t, "taint: maintenance", annotations[triggerAlsoAnnotation])
+
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Annotations: annotations}}
+	assert.Equal(t, trigger.Also, triggerFromNode(node).Also)
+	assert.NotContains(t, ConditionTrigger("GPUUncorrectableECCError").annotations(), triggerAlsoAnnotation)
+}
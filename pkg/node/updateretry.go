@@ -0,0 +1,43 @@
+package node
+
+import (
+	"context"
+	"time"
+
+	"github.com/amargherio/mechanic/internal/config"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// nodeUpdateJitter is the fraction each retry's wait is randomly lengthened by, so agents on many nodes retrying
+// through the same API server outage don't retry in lockstep
+const nodeUpdateJitter = 0.1
+
+// retryNodeUpdate runs fn, which reads and updates the node, retrying it with exponential backoff and jitter under the
+// policy while it fails on a conflict or on an error the API server returns when it's overloaded or briefly
+// unavailable, like during a control plane upgrade. Fewer than one attempt is treated as one. The last error is
+// returned once the attempts run out or ctx is done.
+func retryNodeUpdate(ctx context.Context, policy config.NodeUpdateRetryConfig, fn func() error) error {
+	delay := policy.BaseDelay
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= policy.Attempts || !isRetriableNodeUpdateError(err) {
+			return err
+		}
+
+		select {
+		case <-time.After(wait.Jitter(delay, nodeUpdateJitter)):
+		case <-ctx.Done():
+			return err
+		}
+		delay *= 2
+		if policy.MaxDelay > 0 && delay > policy.MaxDelay {
+			delay = policy.MaxDelay
+		}
+	}
+}
+
+// isRetriableNodeUpdateError reports whether a failed node update is worth trying again
+func isRetriableNodeUpdateError(err error) bool {
+	return apierrors.IsConflict(err) || apierrors.IsServerTimeout(err) || apierrors.IsTooManyRequests(err)
+}
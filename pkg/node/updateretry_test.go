@@ -0,0 +1,97 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/amargherio/mechanic/internal/appstate"
+	"github.com/amargherio/mechanic/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// failNodeUpdates makes the clientset fail node updates with errs, in order, before letting them through
+func failNodeUpdates(clientset *fake.Clientset, errs ...error) *int {
+	updates := 0
+	clientset.PrependReactor("update", "nodes", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		updates++
+		if updates <= len(errs) {
+			return true, nil, errs[updates-1]
+		}
+		return false, nil, nil
+	})
+	return &updates
+}
+
+func TestRetryNodeUpdate(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+
+	cfg := config.Config{NodeUpdateRetry: config.NodeUpdateRetryConfig{Attempts: 4, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}}
+
+	nodes := schema.GroupResource{Resource: "nodes"}
+	conflict := apierrors.NewConflict(nodes, "test-node", errors.New("the object has been modified"))
+	serverTimeout := apierrors.NewServerTimeout(nodes, "update", 1)
+	tooManyRequests := apierrors.NewTooManyRequests("the server has received too many requests", 1)
+	forbidden := apierrors.NewForbidden(nodes, "test-node", errors.New("not allowed"))
+
+	tests := []struct {
+		name            string
+		errs            []error
+		expectedUpdates int
+		expectSuccess   bool
+	}{
+		{name: "conflict and transient server errors are retried", errs: []error{conflict, serverTimeout, tooManyRequests}, expectedUpdates: 4, expectSuccess: true},
+		{name: "gives up when the attempts run out", errs: []error{serverTimeout, serverTimeout, serverTimeout, serverTimeout}, expectedUpdates: 4},
+		{name: "other errors aren't retried", errs: []error{forbidden}, expectedUpdates: 1},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			vals := config.ContextValues{Logger: logger.Sugar(), State: &appstate.State{}}
+			ctx := context.WithValue(context.Background(), "values", &vals)
+
+			node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-node", Labels: map[string]string{}}}
+			clientset := fake.NewClientset(node)
+			updates := failNodeUpdates(clientset, tc.errs...)
+
+			cordoned, err := CordonNode(ctx, clientset, node, cfg, ConditionTrigger("GpuUnhealthy"))
+			assert.Equal(t, tc.expectedUpdates, *updates)
+			if tc.expectSuccess {
+				require.NoError(t, err)
+				assert.True(t, cordoned)
+			} else {
+				assert.Error(t, err)
+				assert.False(t, cordoned)
+			}
+
+			// uncordons retry the same way
+			cordonedNode := node.DeepCopy()
+			cordonedNode.Spec.Unschedulable = true
+			cordonedNode.Labels["mechanic.cordoned"] = "true"
+			clientset = fake.NewClientset(cordonedNode)
+			updates = failNodeUpdates(clientset, tc.errs...)
+
+			err = UncordonNode(ctx, clientset, cordonedNode, cfg)
+			assert.Equal(t, tc.expectedUpdates, *updates)
+			if tc.expectSuccess {
+				require.NoError(t, err)
+				stored, err := clientset.CoreV1().Nodes().Get(ctx, node.Name, metav1.GetOptions{})
+				require.NoError(t, err)
+				assert.False(t, stored.Spec.Unschedulable)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
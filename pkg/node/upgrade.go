@@ -0,0 +1,60 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/amargherio/mechanic/internal/config"
+	"go.opentelemetry.io/otel"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// UpgradeInProgress checks the node and cluster for the configured upgrade signals. It returns a description of the
+// first signal found, or an empty string when there's no upgrade in progress. An error is returned when the upgrade
+// ConfigMap can't be read.
+func UpgradeInProgress(ctx context.Context, clientset kubernetes.Interface, node *v1.Node, signals config.UpgradeSignalConfig) (string, error) {
+	tracer := otel.Tracer("github.com/amargherio/mechanic/pkg/node")
+	ctx, span := tracer.Start(ctx, "UpgradeInProgress")
+	defer span.End()
+
+	vals := ctx.Value("values").(*config.ContextValues)
+	log := vals.Logger
+
+	for _, signal := range signals.NodeLabels {
+		if matchesMarker(node.Labels, signal) {
+			return fmt.Sprintf("node label %s", signal), nil
+		}
+	}
+	for _, signal := range signals.NodeAnnotations {
+		if matchesMarker(node.Annotations, signal) {
+			return fmt.Sprintf("node annotation %s", signal), nil
+		}
+	}
+
+	if signals.ConfigMapName == "" {
+		return "", nil
+	}
+	_, err := clientset.CoreV1().ConfigMaps(signals.ConfigMapNamespace).Get(ctx, signals.ConfigMapName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return "", nil
+	} else if err != nil {
+		log.Errorw("Failed to get the upgrade ConfigMap", "namespace", signals.ConfigMapNamespace, "name", signals.ConfigMapName, "error", err, "traceCtx", ctx)
+		return "", err
+	}
+	return fmt.Sprintf("ConfigMap %s/%s", signals.ConfigMapNamespace, signals.ConfigMapName), nil
+}
+
+// matchesMarker reports whether the labels or annotations have the marker, written as key or key=value. A marker
+// without a value matches any value.
+func matchesMarker(markers map[string]string, marker string) bool {
+	key, value, hasValue := strings.Cut(strings.TrimSpace(marker), "=")
+	if key == "" {
+		return false
+	}
+	actual, ok := markers[key]
+	return ok && (!hasValue || actual == value)
+}
@@ -0,0 +1,94 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/amargherio/mechanic/internal/config"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap/zaptest"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestUpgradeInProgress(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	vals := config.ContextValues{Logger: logger.Sugar()}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	upgrade := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "cluster-upgrade", Namespace: "kube-system"}}
+
+	tests := []struct {
+		name        string
+		labels      map[string]string
+		annotations map[string]string
+		objects     []runtime.Object
+		signals     config.UpgradeSignalConfig
+		expected    string
+	}{
+		{
+			name:     "no signals configured",
+			labels:   map[string]string{"upgrade.example.com/in-progress": "true"},
+			expected: "",
+		},
+		{
+			name:     "node label with any value",
+			labels:   map[string]string{"upgrade.example.com/in-progress": "true"},
+			signals:  config.UpgradeSignalConfig{NodeLabels: []string{"upgrade.example.com/in-progress"}},
+			expected: "node label upgrade.example.com/in-progress",
+		},
+		{
+			name:     "node label with a different value",
+			labels:   map[string]string{"upgrade.example.com/state": "done"},
+			signals:  config.UpgradeSignalConfig{NodeLabels: []string{"upgrade.example.com/state=upgrading"}},
+			expected: "",
+		},
+		{
+			name:        "node annotation with a matching value",
+			annotations: map[string]string{"upgrade.example.com/state": "upgrading"},
+			signals:     config.UpgradeSignalConfig{NodeAnnotations: []string{"upgrade.example.com/state=upgrading"}},
+			expected:    "node annotation upgrade.example.com/state=upgrading",
+		},
+		{
+			name:     "upgrade ConfigMap exists",
+			objects:  []runtime.Object{upgrade},
+			signals:  config.UpgradeSignalConfig{ConfigMapName: "cluster-upgrade", ConfigMapNamespace: "kube-system"},
+			expected: "ConfigMap kube-system/cluster-upgrade",
+		},
+		{
+			name:     "upgrade ConfigMap missing",
+			signals:  config.UpgradeSignalConfig{ConfigMapName: "cluster-upgrade", ConfigMapNamespace: "kube-system"},
+			expected: "",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-node", Labels: tc.labels, Annotations: tc.annotations}}
+			signal, err := UpgradeInProgress(ctx, fake.NewClientset(tc.objects...), node, tc.signals)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, signal)
+		})
+	}
+}
+
+func TestUpgradeInProgressConfigMapError(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+	vals := config.ContextValues{Logger: logger.Sugar()}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	clientset := fake.NewClientset()
+	clientset.PrependReactor("get", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("apiserver unavailable")
+	})
+
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-node"}}
+	_, err := UpgradeInProgress(ctx, clientset, node, config.UpgradeSignalConfig{ConfigMapName: "cluster-upgrade", ConfigMapNamespace: "kube-system"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,116 @@
+package pause
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/amargherio/mechanic/internal/config"
+	"go.opentelemetry.io/otel"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Watcher tracks a ConfigMap that pauses mechanic cluster-wide. While the ConfigMap exists mechanic is paused, unless
+// it has the configured key set to something other than true.
+type Watcher struct {
+	cfg    config.PauseConfig
+	paused atomic.Bool
+}
+
+// NewWatcher returns a Watcher for the pause ConfigMap described by the config
+func NewWatcher(cfg config.PauseConfig) *Watcher {
+	return &Watcher{cfg: cfg}
+}
+
+// Paused reports whether the pause ConfigMap currently pauses mechanic
+func (w *Watcher) Paused() bool {
+	return w.paused.Load()
+}
+
+// Start runs an informer on the pause ConfigMap until the stop channel is closed and waits for its cache to sync so
+// Paused reflects the cluster state when Start returns.
+func (w *Watcher) Start(ctx context.Context, clientset kubernetes.Interface, stop <-chan struct{}) error {
+	tracer := otel.Tracer("github.com/amargherio/mechanic/pkg/pause")
+	ctx, span := tracer.Start(ctx, "Start")
+	defer span.End()
+
+	vals := ctx.Value("values").(*config.ContextValues)
+	log := vals.Logger
+
+	factory := informers.NewSharedInformerFactoryWithOptions(
+		clientset,
+		0,
+		informers.WithNamespace(w.cfg.Namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", w.cfg.Name).String()
+		}),
+	)
+
+	ci := factory.Core().V1().ConfigMaps().Informer()
+	// the field selector should only ever deliver the pause ConfigMap, but the handlers check the name too so no other
+	// ConfigMap in the namespace can toggle the pause
+	_, err := ci.AddEventHandler(cache.FilteringResourceEventHandler{
+		FilterFunc: w.isPauseConfigMap,
+		Handler: cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				w.update(ctx, obj.(*v1.ConfigMap))
+			},
+			UpdateFunc: func(old, new interface{}) {
+				w.update(ctx, new.(*v1.ConfigMap))
+			},
+			DeleteFunc: func(obj interface{}) {
+				if w.paused.Swap(false) {
+					log.Infow("Pause ConfigMap deleted, resuming mechanic", "namespace", w.cfg.Namespace, "name", w.cfg.Name, "traceCtx", ctx)
+				}
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Infow("Starting the pause ConfigMap informer", "namespace", w.cfg.Namespace, "name", w.cfg.Name, "traceCtx", ctx)
+	factory.Start(stop)
+	if !cache.WaitForCacheSync(stop, ci.HasSynced) {
+		return fmt.Errorf("failed to sync the pause ConfigMap informer cache")
+	}
+	return nil
+}
+
+// isPauseConfigMap reports whether the informer object is the pause ConfigMap. Deletes the informer missed arrive as
+// a tombstone holding the last known object.
+func (w *Watcher) isPauseConfigMap(obj interface{}) bool {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	cm, ok := obj.(*v1.ConfigMap)
+	return ok && cm.Namespace == w.cfg.Namespace && cm.Name == w.cfg.Name
+}
+
+// update sets the pause state from the ConfigMap, logging when it changes
+func (w *Watcher) update(ctx context.Context, cm *v1.ConfigMap) {
+	vals := ctx.Value("values").(*config.ContextValues)
+	log := vals.Logger
+
+	paused := true
+	if value, ok := cm.Data[w.cfg.Key]; ok {
+		// an unparseable value leaves us paused. being paused by mistake is safer than acting by mistake.
+		if b, err := strconv.ParseBool(value); err == nil {
+			paused = b
+		}
+	}
+
+	if w.paused.Swap(paused) != paused {
+		if paused {
+			log.Infow("Pause ConfigMap is set, pausing mechanic", "namespace", cm.Namespace, "name", cm.Name, "traceCtx", ctx)
+		} else {
+			log.Infow("Pause ConfigMap no longer pauses mechanic, resuming", "namespace", cm.Namespace, "name", cm.Name, "traceCtx", ctx)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package pause
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/amargherio/mechanic/internal/appstate"
+	"github.com/amargherio/mechanic/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestWatcher(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	defer logger.Sync() // flushes buffer, if any
+
+	vals := config.ContextValues{
+		Logger: logger.Sugar(),
+		State:  &appstate.State{},
+	}
+	ctx := context.WithValue(context.Background(), "values", &vals)
+
+	cfg := config.PauseConfig{Name: "mechanic-pause", Namespace: "mechanic", Key: "paused"}
+	clientset := fake.NewClientset()
+	configMaps := clientset.CoreV1().ConfigMaps(cfg.Namespace)
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	w := NewWatcher(cfg)
+	require.NoError(t, w.Start(ctx, clientset, stop))
+	assert.False(t, w.Paused(), "no ConfigMap should mean not paused")
+
+	eventuallyPaused := func(expected bool, msg string) {
+		assert.Eventually(t, func() bool { return w.Paused() == expected }, 5*time.Second, 10*time.Millisecond, msg)
+	}
+
+	// neverChanges checks the pause state stays as expected, for changes that shouldn't toggle it
+	neverChanges := func(expected bool, msg string) {
+		assert.Never(t, func() bool { return w.Paused() != expected }, 200*time.Millisecond, 10*time.Millisecond, msg)
+	}
+
+	// another ConfigMap in the namespace doesn't pause. the fake clientset ignores field selectors, so the informer
+	// sees it like it would if the selector were missing.
+	other, err := configMaps.Create(ctx, &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: cfg.Namespace}}, metav1.CreateOptions{})
+	require.NoError(t, err)
+	other.Data = map[string]string{cfg.Key: "true"}
+	other, err = configMaps.Update(ctx, other, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	neverChanges(false, "another ConfigMap shouldn't pause")
+
+	cm := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: cfg.Name, Namespace: cfg.Namespace}}
+	cm, err = configMaps.Create(ctx, cm, metav1.CreateOptions{})
+	require.NoError(t, err)
+	eventuallyPaused(true, "the ConfigMap's presence should pause")
+
+	// updating or deleting another ConfigMap doesn't resume
+	other.Data = map[string]string{cfg.Key: "false"}
+	_, err = configMaps.Update(ctx, other, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	require.NoError(t, configMaps.Delete(ctx, other.Name, metav1.DeleteOptions{}))
+	neverChanges(true, "another ConfigMap shouldn't resume")
+
+	cm.Data = map[string]string{cfg.Key: "false"}
+	cm, err = configMaps.Update(ctx, cm, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	eventuallyPaused(false, "the key set to false should resume")
+
+	cm.Data = map[string]string{cfg.Key: "true"}
+	_, err = configMaps.Update(ctx, cm, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	eventuallyPaused(true, "the key set to true should pause")
+
+	require.NoError(t, configMaps.Delete(ctx, cfg.Name, metav1.DeleteOptions{}))
+	eventuallyPaused(false, "deleting the ConfigMap should resume")
+}